@@ -2,15 +2,23 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"html/template"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cfm/decision"
 	"github.com/castisdev/cfm/fmfm"
 	"github.com/castisdev/cfm/heartbeater"
+	"github.com/castisdev/cfm/leader"
+	"github.com/castisdev/cfm/membership"
+	"github.com/castisdev/cfm/metrics"
 	"github.com/castisdev/cfm/tasker"
 	"github.com/castisdev/cilog"
 	"github.com/gorilla/mux"
@@ -25,11 +33,63 @@ func init() {
 }
 
 type APIHandler struct {
-	manager *fmfm.Manager
+	manager   *fmfm.Manager
+	reloader  Reloader
+	leader    Leader
+	auth      Auth
+	closing   chan struct{}
+	closeOnce *sync.Once
 }
 
 func NewAPIHandler(m *fmfm.Manager) *APIHandler {
-	return &APIHandler{manager: m}
+	return &APIHandler{
+		manager:   m,
+		closing:   make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}
+}
+
+// CloseStreams : GET /tasks/stream, GET /tasks/events 요청을 모두 끝냄
+//
+// http server 를 shutdown 할 때 stream 이 끝나기를 기다리지 않도록 RegisterOnShutdown 으로 등록해서 사용
+func (h *APIHandler) CloseStreams() {
+	h.closeOnce.Do(func() {
+		close(h.closing)
+	})
+}
+
+// ReloadReport : POST /admin/reload 응답
+//
+// Applied : 바뀌어서 재시작하지 않고 반영한 설정
+//
+// NotApplied : 바뀌었지만 재시작해야 반영되는 설정
+//
+// Error : 설정을 읽지 못했거나, 잘못된 설정이라서 반영하지 않은 이유
+type ReloadReport struct {
+	Applied    []string `json:"applied"`
+	NotApplied []string `json:"not_applied"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Reloader : 설정 파일을 다시 읽어서 반영하는 함수
+type Reloader func() (ReloadReport, error)
+
+// SetReloader : POST /admin/reload 에서 사용할 reloader 설정
+func (h *APIHandler) SetReloader(r Reloader) {
+	h.reloader = r
+}
+
+// Leader : active-standby 모드의 leader 선출 상태
+type Leader interface {
+	IsLeader() bool
+	Status() leader.Status
+}
+
+// SetLeader : active-standby 모드에서 사용할 leader 설정
+//
+// standby 이면 조회 API 만 처리함
+func (h *APIHandler) SetLeader(l Leader) {
+	h.leader = l
 }
 
 type Route struct {
@@ -44,15 +104,90 @@ func NewRouter(h *APIHandler) *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
 
 	router.HandleFunc("/tasks", h.GetTasks).Methods("GET")
+	router.HandleFunc("/tasks", h.CreateTask).Methods("POST")
+	router.HandleFunc("/tasks/stream", h.StreamTaskEvents).Methods("GET")
+	router.HandleFunc("/tasks/events", h.GetTaskEvents).Methods("GET")
+	router.HandleFunc("/tasks/history", h.GetTaskHistory).Methods("GET")
+	router.HandleFunc("/tasks/manual", h.GetManualTasks).Methods("GET")
 	router.HandleFunc("/tasks/{taskId}", h.DeleteTask).Methods("DELETE")
 	router.HandleFunc("/tasks/{taskId}", h.UpdateTask).Methods("PATCH")
 	router.HandleFunc("/dashboard", h.GetDashBoard).Methods("GET")
 	router.HandleFunc("/dashboard/hb", h.GetHostStateDashBoard).Methods("GET")
 	router.HandleFunc("/dashboard/filemetas", h.GetFileMetas).Methods("GET")
+	router.HandleFunc("/plan/remover", h.GetRemoverPlan).Methods("GET")
+	router.HandleFunc("/plan/tasker", h.GetTaskerPlan).Methods("GET")
+	router.HandleFunc("/decisions", h.GetDecisions).Methods("GET")
+	router.HandleFunc("/metrics", h.GetMetrics).Methods("GET")
+	router.HandleFunc("/quarantine", h.GetQuarantine).Methods("GET")
+	router.HandleFunc("/quarantine", h.DeleteQuarantine).Methods("DELETE")
+	router.HandleFunc("/servers", h.GetServers).Methods("GET")
+	router.HandleFunc("/servers/{role:sources|destinations}", h.AddServer).Methods("POST")
+	router.HandleFunc("/servers/{role:sources|destinations}/{addr}", h.RemoveServer).Methods("DELETE")
+	router.HandleFunc("/servers/destinations/{addr}", h.UpdateServer).Methods("PATCH")
+	router.HandleFunc("/servers/{addr}/files/{fileName}", h.DeleteServerFile).Methods("DELETE")
+	router.HandleFunc("/pins", h.GetPins).Methods("GET")
+	router.HandleFunc("/pins/{fileName}", h.PutPin).Methods("PUT")
+	router.HandleFunc("/pins/{fileName}", h.DeletePin).Methods("DELETE")
+	router.HandleFunc("/admin/reload", h.Reload).Methods("POST")
+	router.HandleFunc("/admin/leader", h.GetLeader).Methods("GET")
+	router.HandleFunc("/admin/tasks/export", h.ExportTasks).Methods("GET")
+	router.Use(h.authenticate)
+	router.Use(h.readOnlyOnStandby)
 
 	return router
 }
 
+// readOnlyOnStandby :
+//
+// active-standby 모드에서 standby 이면 조회가 아닌 요청은 503 으로 응답,
+// POST /admin/reload 는 standby 에서도 처리함
+func (h *APIHandler) readOnlyOnStandby(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.leader == nil || h.leader.IsLeader() ||
+			r.Method == "GET" || r.Method == "HEAD" || r.URL.Path == "/admin/reload" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		apilogger.Infof("[%s] rejected %s %s request, not leader", r.RemoteAddr, r.Method, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if err := json.NewEncoder(w).Encode(h.leader.Status()); err != nil {
+			apilogger.Errorf("encode json fail : %s", err)
+		}
+	})
+}
+
+// ExportTasks is http handler for GET /admin/tasks/export route
+//
+// task 목록을 ID 순서로 한 줄에 task 하나씩 JSON lines 로 반환,
+// cfm tasks import 로 다른 cfm 의 repository 에 넣을 수 있음
+func (h *APIHandler) ExportTasks(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received exportTasks request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] responsed exportTasks request", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/x-ndjson;charset=UTF-8")
+	if _, err := h.manager.Tasks().Export(w); err != nil {
+		apilogger.Errorf("export tasks fail : %s", err)
+	}
+}
+
+// GetLeader is http handler for GET /admin/leader route
+//
+// leader 선출 상태 반환, active-standby 모드가 아니면 항상 leader
+func (h *APIHandler) GetLeader(w http.ResponseWriter, r *http.Request) {
+	apilogger.Debugf("[%s] received getLeader request", r.RemoteAddr)
+	defer apilogger.Debugf("[%s] responsed getLeader request", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	s := leader.Status{Leader: true}
+	if h.leader != nil {
+		s = h.leader.Status()
+	}
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		apilogger.Errorf("encode json fail : %s", err)
+	}
+}
+
 // GetHostStateDashBoard
 func (h *APIHandler) GetHostStateDashBoard(w http.ResponseWriter, r *http.Request) {
 	apilogger.Infof("[%s] received getHostStateDashBoard request", r.RemoteAddr)
@@ -76,21 +211,438 @@ func (h *APIHandler) GetFileMetas(w http.ResponseWriter, r *http.Request) {
 	apilogger.Infof("[%s] received getFileMetas request", r.RemoteAddr)
 	defer apilogger.Infof("[%s] responsed getFileMetas request", r.RemoteAddr)
 
-	req := fmfm.GetFileMetas{RespCh: make(chan fmfm.FileMetas)}
-	h.manager.GetFileMetasCh <- req
-	res := <-req.RespCh
+	res, err := h.manager.GetFileMetas()
+	if err != nil {
+		apilogger.Errorf("[%s] failed to get file metas, error(%s)", r.RemoteAddr, err.Error())
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
 
 	tpl := template.Must(template.ParseFiles("dashboard/filemetas.html"))
 	tpl.Execute(w, res)
 }
 
+// GetRemoverPlan is http handler for GET /plan/remover route
+//
+// 서버에 삭제 요청을 하지 않고, 삭제 요청할 파일 목록과 이유를 반환
+func (h *APIHandler) GetRemoverPlan(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received getRemoverPlan request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] responsed getRemoverPlan request", r.RemoteAddr)
+
+	res, err := h.manager.GetRemoverPlan()
+	if err != nil {
+		apilogger.Errorf("[%s] failed to get remover plan, error(%s)", r.RemoteAddr, err.Error())
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		apilogger.Errorf("encode json fail : %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetTaskerPlan is http handler for GET /plan/tasker route
+//
+// task 를 만들거나 지우지 않고, 만들어질 task 목록과 이유를 반환
+func (h *APIHandler) GetTaskerPlan(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received getTaskerPlan request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] responsed getTaskerPlan request", r.RemoteAddr)
+
+	res, err := h.manager.GetTaskerPlan()
+	if err != nil {
+		apilogger.Errorf("[%s] failed to get tasker plan, error(%s)", r.RemoteAddr, err.Error())
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		apilogger.Errorf("encode json fail : %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetDecisions is http handler for GET /decisions?file=NAME route
+//
+// tasker, remover 가 file 에 대해 결정한 기록을 오래된 순서로 반환
+func (h *APIHandler) GetDecisions(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received getDecisions request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] responsed getDecisions request", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	file := r.URL.Query().Get("file")
+	if file == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	res, err := decision.Find(file)
+	if err != nil {
+		apilogger.Errorf("failed to find decisions, file(%s), error(%s)", file, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		apilogger.Errorf("encode json fail : %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetQuarantine is http handler for GET /quarantine route
+//
+// quarantine 된 (file, dst) 목록 반환,
+// all=true 이면 backoff 중인 (file, dst) 도 반환
+func (h *APIHandler) GetQuarantine(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received getQuarantine request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] responsed getQuarantine request", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	all := r.URL.Query().Get("all") == "true"
+	if err := json.NewEncoder(w).Encode(h.manager.Failures().GetList(!all)); err != nil {
+		apilogger.Errorf("encode json fail : %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// DeleteQuarantine is http handler for DELETE /quarantine route
+//
+// quarantine 된 (file, dst) 중 query 의 file, dst 가 같은 것을 지움,
+// query 가 없으면 모두 지움
+func (h *APIHandler) DeleteQuarantine(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received deleteQuarantine request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] responsed deleteQuarantine request", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	q := r.URL.Query()
+	if h.manager.Failures().Clear(q.Get("file"), q.Get("dst")) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Servers : GET /servers 응답
+type Servers struct {
+	Sources      []membership.Server `json:"sources"`
+	Destinations []membership.Server `json:"destinations"`
+}
+
+// GetServers is http handler for GET /servers route
+//
+// 설정 파일의 서버 목록에 API 로 바꾼 서버 구성을 반영한 서버 목록 반환
+func (h *APIHandler) GetServers(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received getServers request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] responsed getServers request", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	res := Servers{
+		Sources:      membership.Sources(),
+		Destinations: membership.Destinations(),
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		apilogger.Errorf("encode json fail : %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// AddServer is http handler for POST /servers/{sources|destinations} route
+//
+// 서버를 추가하고 heartbeater, remover, tasker 에 반영,
+// slots 가 없으면 source 는 1, destination 은 0,
+// 이미 있는 서버면 409
+func (h *APIHandler) AddServer(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received addServer request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] responsed addServer request", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	role := mux.Vars(r)["role"]
+	var req struct {
+		Addr  string `json:"addr"`
+		Slots int    `json:"slots"`
+	}
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&req); err != nil {
+		apilogger.Errorf("failed to add server, decode json fail : %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if dec.More() {
+		io.Copy(ioutil.Discard, r.Body)
+	}
+	defer r.Body.Close()
+	if req.Slots == 0 && role == membership.SourceRole {
+		req.Slots = 1
+	}
+
+	sv, err := membership.Add(role, req.Addr, req.Slots)
+	if err != nil {
+		apilogger.Errorf("failed to add %s server(%s), error(%s)", role, req.Addr, err.Error())
+		if err == membership.ErrExist {
+			w.WriteHeader(http.StatusConflict)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		return
+	}
+	if err := h.manager.ApplyServers(); err != nil {
+		apilogger.Errorf("failed to apply servers, error(%s)", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(sv); err != nil {
+		apilogger.Errorf("encode json fail : %s", err)
+	}
+}
+
+// RemoveServer is http handler for DELETE /servers/{sources|destinations}/{addr} route
+//
+// 서버를 빼고 heartbeater, remover, tasker 에 반영, 없는 서버면 404
+//
+// 빠진 서버의 task 는 tasker 가 다음 주기에 지움,
+// 진행 중인 task 를 끝내려면 drain 모드로 바꾼 후 빼야 함
+func (h *APIHandler) RemoveServer(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received removeServer request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] responsed removeServer request", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	vars := mux.Vars(r)
+	if err := membership.Remove(vars["role"], vars["addr"]); err != nil {
+		apilogger.Errorf("failed to remove %s server(%s), error(%s)",
+			vars["role"], vars["addr"], err.Error())
+		if err == membership.ErrNotExist {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := h.manager.ApplyServers(); err != nil {
+		apilogger.Errorf("failed to apply servers, error(%s)", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// UpdateServer is http handler for PATCH /servers/destinations/{addr} route
+//
+// destination 의 drain 모드를 바꾸고 remover, tasker 에 반영, 없는 서버면 404
+//
+// drain 모드인 destination 은 새 task 를 받지 않고, remover 의 삭제 대상에서 빠짐,
+// 진행 중인 task 는 그대로 끝남
+func (h *APIHandler) UpdateServer(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received updateServer request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] responsed updateServer request", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	addr := mux.Vars(r)["addr"]
+	var req struct {
+		Drain *bool `json:"drain"`
+	}
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&req); err != nil || req.Drain == nil {
+		apilogger.Errorf("failed to update server(%s), invalid body", addr)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if dec.More() {
+		io.Copy(ioutil.Discard, r.Body)
+	}
+	defer r.Body.Close()
+
+	sv, err := membership.SetDrain(addr, *req.Drain)
+	if err != nil {
+		apilogger.Errorf("failed to set drain(%t), server(%s), error(%s)",
+			*req.Drain, addr, err.Error())
+		if err == membership.ErrNotExist {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := h.manager.ApplyServers(); err != nil {
+		apilogger.Errorf("failed to apply servers, error(%s)", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(sv); err != nil {
+		apilogger.Errorf("encode json fail : %s", err)
+	}
+}
+
+// DeletedFile : DELETE /servers/{addr}/files/{fileName} 응답
+//
+// Reason : 삭제 요청했으면 manual, 아니면 삭제하지 못한 이유
+type DeletedFile struct {
+	Server   string `json:"server"`
+	FileName string `json:"file_name"`
+	Reason   string `json:"reason"`
+}
+
+// DeleteServerFile is http handler for DELETE /servers/{addr}/files/{fileName} route
+//
+// remover 의 삭제와 같은 안전 검사를 한 후 서버에 파일 삭제 요청
+func (h *APIHandler) DeleteServerFile(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received deleteServerFile request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] responsed deleteServerFile request", r.RemoteAddr)
+
+	vars := mux.Vars(r)
+	df := DeletedFile{Server: vars["addr"], FileName: vars["fileName"]}
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+
+	reason, err := h.manager.RemoveFile(df.Server, df.FileName)
+	df.Reason = reason
+	if err != nil {
+		apilogger.Errorf("failed to delete file(%s) in server(%s), reason(%s), error(%s)",
+			df.FileName, df.Server, reason, err.Error())
+	}
+	switch {
+	case err == fmfm.ErrStopped:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	case reason == decision.Manual:
+		w.WriteHeader(http.StatusOK)
+	case reason == decision.NotFoundInTheServer:
+		w.WriteHeader(http.StatusNotFound)
+	case reason == decision.RequestFailed:
+		w.WriteHeader(http.StatusBadGateway)
+	default:
+		w.WriteHeader(http.StatusConflict)
+	}
+	if err := json.NewEncoder(w).Encode(df); err != nil {
+		apilogger.Errorf("encode json fail : %s", err)
+	}
+}
+
+// GetPins is http handler for GET /pins route
+//
+// 삭제하지 않도록 고정한 파일 목록을 파일 이름 순으로 반환
+func (h *APIHandler) GetPins(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received getPins request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] responsed getPins request", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(h.manager.Pins().GetList()); err != nil {
+		apilogger.Errorf("encode json fail : %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// PutPin is http handler for PUT /pins/{fileName} route
+//
+// 파일을 삭제하지 않도록 고정
+func (h *APIHandler) PutPin(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received putPin request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] responsed putPin request", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	p, err := h.manager.Pins().Add(mux.Vars(r)["fileName"])
+	if err != nil {
+		apilogger.Errorf("failed to pin file(%s), error(%s)",
+			mux.Vars(r)["fileName"], err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		apilogger.Errorf("encode json fail : %s", err)
+	}
+}
+
+// DeletePin is http handler for DELETE /pins/{fileName} route
+//
+// 파일 고정 해제, 고정한 파일이 아니면 404
+func (h *APIHandler) DeletePin(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received deletePin request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] responsed deletePin request", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	fileName := mux.Vars(r)["fileName"]
+	removed, err := h.manager.Pins().Remove(fileName)
+	if err != nil {
+		apilogger.Errorf("failed to unpin file(%s), error(%s)", fileName, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Reload is http handler for POST /admin/reload route
+//
+// 설정 파일을 다시 읽어서 재시작하지 않고 반영할 수 있는 설정을 반영하고,
+// 반영한 설정과 재시작해야 반영되는 설정을 반환
+func (h *APIHandler) Reload(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received reload request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] responsed reload request", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	if h.reloader == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	res, err := h.reloader()
+	if err != nil {
+		apilogger.Errorf("failed to reload config, error(%s)", err.Error())
+		res.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		apilogger.Errorf("encode json fail : %s", err)
+	}
+}
+
+// GetMetrics is http handler for GET /metrics route
+//
+// task, heartbeat, disk 사용량, run 실행 시간, 파일 mtime 을
+// prometheus text format 으로 반환
+func (h *APIHandler) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	apilogger.Debugf("[%s] received getMetrics request", r.RemoteAddr)
+	defer apilogger.Debugf("[%s] responsed getMetrics request", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	metrics.Write(w, h.manager.Tasks().GetTaskList(), heartbeater.GetList(), time.Now())
+}
+
 // GetTasks is http handler for GET /tasks route
+//
+// query 의 조건에 맞는 task 목록 반환, 조건이 없으면 전체 목록
+//
+// 조건에 맞는 전체 task 개수는 X-Total-Count header 로 반환
+//
+// task 목록이 바뀌지 않았으면, If-None-Match 가 ETag 와 같을 때 304 반환
 func (h *APIHandler) GetTasks(w http.ResponseWriter, r *http.Request) {
 	apilogger.Infof("[%s] received getTasks request", r.RemoteAddr)
 	defer apilogger.Infof("[%s] responsed getTasks request", r.RemoteAddr)
 
 	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
-	t := h.manager.Tasks().GetTaskList()
+	f, err := taskFilterFrom(r)
+	if err != nil {
+		apilogger.Errorf("failed to get tasks, invalid query(%s), error(%s)",
+			r.URL.RawQuery, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	etag := h.manager.Tasks().ETag()
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	t, total, etag := h.manager.Tasks().Find(f)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
 	if err := json.NewEncoder(w).Encode(t); err != nil {
 		apilogger.Errorf("decode json fail : %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -98,6 +650,355 @@ func (h *APIHandler) GetTasks(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// taskFilterFrom :
+//
+// status 는 쉼표로 여러 개를 지정할 수 있음, since 는 unix time
+func taskFilterFrom(r *http.Request) (tasker.TaskFilter, error) {
+	q := r.URL.Query()
+	f := tasker.TaskFilter{
+		FileName: q.Get("file_name"),
+		SrcAddr:  q.Get("src_addr"),
+		DstAddr:  q.Get("dst_addr"),
+		Sort:     q.Get("sort"),
+	}
+	if s := q.Get("status"); s != "" {
+		for _, ss := range strings.Split(s, ",") {
+			var st tasker.Status
+			if err := st.UnmarshalJSON([]byte(`"` + ss + `"`)); err != nil {
+				return f, err
+			}
+			f.Statuses = append(f.Statuses, st)
+		}
+	}
+	if s := q.Get("since"); s != "" {
+		t, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return f, err
+		}
+		f.Since = tasker.TaskTime(t)
+	}
+	if s := q.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return f, err
+		}
+		f.Offset = n
+	}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return f, err
+		}
+		f.Limit = n
+	}
+	return f, f.Validate()
+}
+
+// etagMatch : If-None-Match 의 ETag 중 etag 와 같은 것이 있거나 * 이면 true
+func etagMatch(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, e := range strings.Split(ifNoneMatch, ",") {
+		e = strings.TrimPrefix(strings.TrimSpace(e), "W/")
+		if e == etag || e == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	// streamKeepAlive : GET /tasks/stream 에서 event 가 없을 때 keepalive comment 를 보내는 주기
+	streamKeepAlive = 15 * time.Second
+	// defaultPollTimeout, maxPollTimeout : GET /tasks/events 에서 event 를 기다리는 시간
+	defaultPollTimeout = 30 * time.Second
+	maxPollTimeout     = 60 * time.Second
+)
+
+// TaskEvents : GET /tasks/events 응답
+//
+// Next : 다음 요청의 after 로 사용할 값
+//
+// Reset : after 이후의 event 를 알 수 없음, GET /tasks 로 다시 조회한 후 Next 부터 받아야 함
+type TaskEvents struct {
+	Next   string             `json:"next"`
+	Reset  bool               `json:"reset"`
+	Events []tasker.TaskEvent `json:"events"`
+}
+
+// filterTaskEvents : dst 의 task event 만 남김, reset event 는 항상 남김
+func filterTaskEvents(el []tasker.TaskEvent, dst string) []tasker.TaskEvent {
+	if dst == "" {
+		return el
+	}
+	fl := make([]tasker.TaskEvent, 0)
+	for _, ev := range el {
+		if ev.Task == nil || ev.Task.DstAddr == dst {
+			fl = append(fl, ev)
+		}
+	}
+	return fl
+}
+
+// GetTaskEvents is http handler for GET /tasks/events route
+//
+// long-poll, after 이후의 task 변경 event 가 있으면 바로 반환하고,
+// 없으면 생길 때까지 timeout 동안 기다린 후 반환
+//
+// dst_addr 가 있으면 그 destination 서버의 task event 만 반환
+func (h *APIHandler) GetTaskEvents(w http.ResponseWriter, r *http.Request) {
+	apilogger.Debugf("[%s] received getTaskEvents request", r.RemoteAddr)
+	defer apilogger.Debugf("[%s] responsed getTaskEvents request", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	q := r.URL.Query()
+	timeout := defaultPollTimeout
+	if s := q.Get("timeout"); s != "" {
+		sec, err := strconv.Atoi(s)
+		if err != nil || sec < 0 || time.Duration(sec)*time.Second > maxPollTimeout {
+			apilogger.Errorf("failed to get task events, invalid timeout(%s)", s)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		timeout = time.Duration(sec) * time.Second
+	}
+	// server 의 WriteTimeout 보다 오래 기다릴 수 있도록 늘림
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + 5*time.Second))
+
+	dst := q.Get("dst_addr")
+	after := q.Get("after")
+	expired := time.NewTimer(timeout)
+	defer expired.Stop()
+
+	var res TaskEvents
+poll:
+	for {
+		el, next, ok, wait := h.manager.Tasks().Events(after)
+		res = TaskEvents{Next: next, Reset: !ok, Events: filterTaskEvents(el, dst)}
+		if !ok || len(res.Events) > 0 {
+			break
+		}
+		after = next
+		select {
+		case <-wait:
+		case <-expired.C:
+			break poll
+		case <-h.closing:
+			break poll
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		apilogger.Errorf("encode json fail : %s", err)
+	}
+}
+
+// StreamTaskEvents is http handler for GET /tasks/stream route
+//
+// Server-Sent Events 로 task 변경 event 를 계속 보냄,
+// event 의 id 는 tasker.TaskEvent.ID, event 이름은 created, updated, deleted, reset
+//
+// Last-Event-ID header 나 after query 가 있으면 그 이후의 event 부터 보내고,
+// 없으면 지금부터 생기는 event 를 보냄
+//
+// dst_addr 가 있으면 그 destination 서버의 task event 만 보냄
+func (h *APIHandler) StreamTaskEvents(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received streamTaskEvents request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] ended streamTaskEvents request", r.RemoteAddr)
+
+	rc := http.NewResponseController(w)
+	// stream 은 끝나지 않으므로 server 의 WriteTimeout 을 사용하지 않음
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		apilogger.Errorf("[%s] failed to stream task events, error(%s)", r.RemoteAddr, err.Error())
+		return
+	}
+
+	dst := r.URL.Query().Get("dst_addr")
+	after := r.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = r.URL.Query().Get("after")
+	}
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		el, next, ok, wait := h.manager.Tasks().Events(after)
+		if !ok {
+			el = []tasker.TaskEvent{{ID: next, Type: tasker.TaskReset}}
+		}
+		for _, ev := range filterTaskEvents(el, dst) {
+			if err := writeTaskEvent(w, ev); err != nil {
+				apilogger.Errorf("[%s] failed to stream task events, error(%s)", r.RemoteAddr, err.Error())
+				return
+			}
+		}
+		after = next
+		if len(el) > 0 {
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+
+		for waiting := true; waiting; {
+			select {
+			case <-wait:
+				waiting = false
+			case <-keepAlive.C:
+				if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			case <-h.closing:
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// writeTaskEvent : Server-Sent Events 형식으로 event 하나를 씀
+func writeTaskEvent(w io.Writer, ev tasker.TaskEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+	return err
+}
+
+// CreateTask is http handler for POST /tasks route
+//
+// 요청받은 manual task 를 검사한 후 목록에 추가,
+// tasker 가 다음 주기에 다른 파일보다 먼저 task 를 만듬
+func (h *APIHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received createTask request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] responsed createTask request", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+
+	var mt tasker.ManualTask
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&mt); err != nil {
+		apilogger.Errorf("failed to create task, decode json fail : %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if dec.More() {
+		io.Copy(ioutil.Discard, r.Body)
+	}
+	defer r.Body.Close()
+
+	mt, err := h.manager.AddManualTask(mt)
+	if err != nil {
+		apilogger.Errorf("failed to create task, manual task(%s), error(%s)",
+			mt, err.Error())
+		if err == fmfm.ErrStopped {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(mt); err != nil {
+		apilogger.Errorf("encode json fail : %s", err)
+	}
+}
+
+// GetManualTasks is http handler for GET /tasks/manual route
+//
+// task 를 만들기 전의 manual task 목록을 높은 priority 순서로 반환
+func (h *APIHandler) GetManualTasks(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received getManualTasks request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] responsed getManualTasks request", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(h.manager.ManualTasks().GetList()); err != nil {
+		apilogger.Errorf("encode json fail : %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// TaskHistory : GET /tasks/history 응답
+//
+// Tasks : 조건에 맞는 끝난 task 기록, 끝난 시간 순
+//
+// Destinations : 조건에 맞는 기록의 destination 서버별 통계
+type TaskHistory struct {
+	Tasks        []tasker.TaskRecord `json:"tasks"`
+	Destinations []tasker.DstStat    `json:"destinations"`
+}
+
+// GetTaskHistory is http handler for GET /tasks/history route
+//
+// query : file, src, dst, status, from, to(unix time)
+func (h *APIHandler) GetTaskHistory(w http.ResponseWriter, r *http.Request) {
+	apilogger.Infof("[%s] received getTaskHistory request", r.RemoteAddr)
+	defer apilogger.Infof("[%s] responsed getTaskHistory request", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	f, err := historyFilterFrom(r)
+	if err != nil {
+		apilogger.Errorf("failed to get task history, invalid query(%s), error(%s)",
+			r.URL.RawQuery, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rl, err := h.manager.History().Find(f)
+	if err != nil {
+		apilogger.Errorf("failed to get task history, error(%s)", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res := TaskHistory{Tasks: rl, Destinations: tasker.DstStats(rl)}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		apilogger.Errorf("encode json fail : %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func historyFilterFrom(r *http.Request) (tasker.HistoryFilter, error) {
+	q := r.URL.Query()
+	f := tasker.HistoryFilter{
+		FileName: q.Get("file"),
+		SrcAddr:  q.Get("src"),
+		DstAddr:  q.Get("dst"),
+	}
+	if s := q.Get("status"); s != "" {
+		if err := f.Status.UnmarshalJSON([]byte(`"` + s + `"`)); err != nil {
+			return f, err
+		}
+	}
+	if s := q.Get("from"); s != "" {
+		t, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return f, err
+		}
+		f.From = tasker.TaskTime(t)
+	}
+	if s := q.Get("to"); s != "" {
+		t, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return f, err
+		}
+		f.To = tasker.TaskTime(t)
+	}
+	return f, nil
+}
+
 // DeleteTask is http handler for DELETE /tasks/<taskID> route
 func (h *APIHandler) DeleteTask(w http.ResponseWriter, r *http.Request) {
 	apilogger.Infof("[%s] received deleteTask request", r.RemoteAddr)
@@ -14,12 +14,17 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cfm/decision"
 	"github.com/castisdev/cfm/fmfm"
 	"github.com/castisdev/cfm/heartbeater"
+	"github.com/castisdev/cfm/leader"
+	"github.com/castisdev/cfm/membership"
+	"github.com/castisdev/cfm/metrics"
 	"github.com/castisdev/cfm/remover"
 	"github.com/castisdev/cfm/tailer"
 	"github.com/castisdev/cfm/tasker"
@@ -1089,3 +1094,941 @@ func TestGetFilemetasWithCFW(t *testing.T) {
 		assert.Equal(t, false, open)
 	}
 }
+
+// plan 모드 test
+// 초기 event 발생 시 MakeFMM, MakeRisingHit 만 실행하고,
+// runner 가 가지고 있는 file meta, rising hit 로 구한 remover, tasker plan test
+func TestGetPlan(t *testing.T) {
+	dir := "testwatcher"
+	gradefile := "grade"
+	makeGradeInfoFile(dir, gradefile)
+	gradepath := filepath.Join(dir, gradefile)
+	hcfile := "hitcount"
+	makeHitcourntHistoryFile(dir, hcfile)
+	hitcountpath := filepath.Join(dir, hcfile)
+	defer deletefile(dir, "")
+
+	// test를 위해서 poll 모드 제일처음 event 만 발생시키고, 다른 event는 막음
+	fmfm.TestInotifyFunc = func() bool { return false }
+	watcher := fmfm.NewWatcher(gradepath, hitcountpath, true, 0, 0)
+
+	rmr := remover.NewRemover()
+	s1 := "127.0.0.1:18881"
+	files1 := []string{"A.mpg", "B.mpg", "C.mpg", "D.mpg"}
+	d1 := common.DiskUsage{
+		TotalSize: 1000, UsedSize: 750,
+		FreeSize: 250, AvailSize: 250, UsedPercent: 75,
+	}
+	s2 := "127.0.0.2:18882"
+	files2 := []string{"B.mpg", "C.mpg", "E.mpg", "F.mpg"}
+	d2 := common.DiskUsage{
+		TotalSize: 1000, UsedSize: 600,
+		FreeSize: 400, AvailSize: 400, UsedPercent: 60,
+	}
+	base := "testsourcefolder"
+	rmr.SourcePath.Add(base)
+	for _, f1 := range files1 {
+		createfile(base, f1)
+	}
+	for _, f2 := range files2 {
+		createfile(base, f2)
+	}
+	deletefile(base, "C.mpg")
+	defer deletefile(base, "")
+
+	rmr.Servers.Add(s1)
+	rmr.Servers.Add(s2)
+	rmr.SetGradeInfoFile(gradepath)
+	rmr.SetHitcountHistoryFile(hitcountpath)
+
+	cfw1 := cfw(s1, d1, files1)
+	cfw1.Start()
+	defer cfw1.Close()
+	cfw2 := cfw(s2, d2, files2)
+	cfw2.Start()
+	defer cfw2.Close()
+
+	rmr.SetDiskUsageLimitPercent(100)
+
+	// 최대 copy 수 2, 급 hit 상승 파일 F.mpg 만 s1 에 배포될 예정
+	tskr := tasker.NewTasker()
+	tskr.SetMaxCopyCount(2)
+	tskr.SourcePath.Add(base)
+	tskr.SrcServers.Add(s1)
+	tskr.DstServers.Add(s1)
+	tskr.DstServers.Add(s2)
+	defer tskr.Tasks().DeleteAllTask()
+
+	// heartbeat ok 처리
+	heartbeater.Add(s1)
+	heartbeater.Add(s2)
+	heartbeater.Heartbeat()
+	defer heartbeater.Release()
+
+	taildir := "taildir"
+	tailip := "255.255.255.255"
+	watchmin := 10
+	hitbase := 5
+	tlr := tailer.NewTailer()
+	tlr.SetWatchDir(taildir)
+	tlr.SetWatchIPString(tailip)
+	tlr.SetWatchTermMin(watchmin)
+	tlr.SetWatchHitBase(hitbase)
+
+	basetm := time.Now()
+	makeRisingHitFile(taildir, tailip, "F.mpg", basetm, watchmin)
+	defer deletefile(taildir, "")
+
+	serverAddr := "127.0.0.1:28881"
+
+	//  다른 event run 은 막고, 최초 event run만 fmm, rising hit 만드는 것으로 설정
+	fmfm.DefaultEventRuns = []fmfm.RUN{fmfm.MakeFMM, fmfm.MakeRisingHit}
+	fmfm.DefaultEventTimeoutRuns = []fmfm.RUN{}
+	fmfm.DefaultBetweenEventsRuns = []fmfm.RUN{}
+	fmfm.DefaultPeriodicRuns = []fmfm.RUN{}
+	r := fmfm.NewRunner(0, 0, rmr, tskr, tlr)
+	m := fmfm.NewManager(watcher, r)
+	h := NewAPIHandler(m)
+	router := NewRouter(h)
+	s := &http.Server{
+		Addr:         serverAddr,
+		Handler:      router,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+	ts1 := httptest.NewUnstartedServer(router)
+	l1, _ := net.Listen("tcp", serverAddr)
+	ts1.Listener.Close()
+
+	ts1.Listener = l1
+	ts1.Config = s
+	ts1.Start()
+	defer ts1.Close()
+
+	go m.Manage()
+	// 최초 event run 이 끝날 때까지 기다림
+	doSomething(1)
+
+	client := &http.Client{Timeout: time.Second * 10}
+
+	// remover plan
+	// B.mpg 는 s1, s2 에 중복되어 있어서 한 서버에서 삭제될 예정
+	// C.mpg 는 source 에 없어서 제외
+	resp, err := client.Get(fmt.Sprintf("http://%s/plan/remover", serverAddr))
+	if err != nil {
+		t.Errorf("failed to get remover plan, error(%s)", err.Error())
+		return
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	deletes := make([]remover.PlannedDelete, 0)
+	err = json.NewDecoder(resp.Body).Decode(&deletes)
+	resp.Body.Close()
+	if err != nil {
+		t.Errorf("failed to decode remover plan, error(%s)", err.Error())
+		return
+	}
+	assert.Equal(t, 1, len(deletes))
+	assert.Equal(t, "B.mpg", deletes[0].FileName)
+	assert.Equal(t, "duplicated, copies(2) > target.copies(1)", deletes[0].Reason)
+
+	// tasker plan
+	resp, err = client.Get(fmt.Sprintf("http://%s/plan/tasker", serverAddr))
+	if err != nil {
+		t.Errorf("failed to get tasker plan, error(%s)", err.Error())
+		return
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	tasks := make([]tasker.PlannedTask, 0)
+	err = json.NewDecoder(resp.Body).Decode(&tasks)
+	resp.Body.Close()
+	if err != nil {
+		t.Errorf("failed to decode tasker plan, error(%s)", err.Error())
+		return
+	}
+	assert.Equal(t, 1, len(tasks))
+	assert.Equal(t, "F.mpg", tasks[0].FileName)
+	assert.Equal(t, s1, tasks[0].SrcAddr)
+	assert.Equal(t, s1, tasks[0].DstAddr)
+	assert.Equal(t, "risingHit(9), copies(1/2)", tasks[0].Reason)
+
+	// plan 모드에서는 task 가 만들어지지 않음
+	assert.Equal(t, 0, len(tskr.Tasks().GetTaskList()))
+
+	select {
+	case m.CMDCh <- fmfm.STOP:
+		<-m.ErrCh
+		_, open := <-m.CMDCh
+		assert.Equal(t, false, open)
+	}
+}
+
+func TestGetDecisions(t *testing.T) {
+	dir := "testdecision"
+	defer deletefile(dir, "")
+	assert.Nil(t, decision.Open(dir, 1024*1024, 1))
+	defer decision.Close()
+
+	decision.Record(decision.New("tasker",
+		common.NewFileMetaWith("A.mpg", 1), "127.0.0.1:18881",
+		decision.TASK, decision.Grade))
+	decision.Record(decision.New("remover",
+		common.NewFileMetaWith("B.mpg", 2), "127.0.0.1:18881",
+		decision.DELETE, decision.Duplicated))
+	decision.Record(decision.New("tasker",
+		common.NewFileMetaWith("A.mpg", 1), "",
+		decision.SKIP, decision.FoundInTheTasks))
+
+	router := NewRouter(NewAPIHandler(nil))
+
+	// file 이 없으면 bad request
+	req := httptest.NewRequest("GET", "/decisions", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req = httptest.NewRequest("GET", "/decisions?file=A.mpg", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	dl := make([]decision.Decision, 0)
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&dl))
+	assert.Equal(t, 2, len(dl))
+	assert.Equal(t, decision.TASK, dl[0].Action)
+	assert.Equal(t, decision.Grade, dl[0].Reason)
+	assert.Equal(t, "127.0.0.1:18881", dl[0].Server)
+	assert.Equal(t, decision.SKIP, dl[1].Action)
+	assert.Equal(t, decision.FoundInTheTasks, dl[1].Reason)
+
+	// 기록이 없는 파일은 빈 list
+	req = httptest.NewRequest("GET", "/decisions?file=C.mpg", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	dl = make([]decision.Decision, 0)
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&dl))
+	assert.Equal(t, 0, len(dl))
+}
+
+func TestGetMetrics(t *testing.T) {
+	metrics.Release()
+	defer metrics.Release()
+
+	tskr := tasker.NewTasker()
+	defer tskr.Tasks().DeleteAllTask()
+	tskr.Tasks().CreateTask(&tasker.Task{FilePath: "/data2/A.mpg", FileName: "A.mpg",
+		SrcAddr: "127.0.0.1:18881", DstAddr: "127.0.0.2:18882"})
+
+	heartbeater.Add("127.0.0.1:18881")
+	defer heartbeater.Release()
+
+	metrics.SetDiskUsage("127.0.0.2:18882", common.DiskUsage{
+		TotalSize: 1000, UsedSize: 600, AvailSize: 400, FreeSize: 400, UsedPercent: 60})
+
+	r := fmfm.NewRunner(0, 0, remover.NewRemover(), tskr, tailer.NewTailer())
+	m := fmfm.NewManager(nil, r)
+	router := NewRouter(NewAPIHandler(m))
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, metrics.ContentType, w.Header().Get("Content-Type"))
+
+	body := w.Body.String()
+	assert.Contains(t, body, `cfm_tasks{status="ready"} 1`+"\n")
+	assert.Contains(t, body, "cfm_task_age_seconds_count 1\n")
+	assert.Contains(t, body, `cfm_heartbeat_up{server="127.0.0.1:18881"} 0`+"\n")
+	assert.Contains(t, body, `cfm_disk_used_bytes{server="127.0.0.2:18882"} 600`+"\n")
+}
+
+func TestGetTaskHistory(t *testing.T) {
+	tskr := tasker.NewTasker()
+	defer tskr.History().Release()
+
+	now := time.Now()
+	base := tasker.TaskTime(now.Unix())
+	tskr.History().Add([]tasker.TaskRecord{
+		{ID: 1, Status: tasker.DONE, FileName: "A.mpg", SrcAddr: "127.0.0.1:18881",
+			DstAddr: "127.0.0.2:18882", Size: 1000, WorkSec: 10, Dtime: base - 30},
+		{ID: 2, Status: tasker.TIMEOUT, FileName: "B.mpg", SrcAddr: "127.0.0.1:18881",
+			DstAddr: "127.0.0.2:18882", Size: 2000, WorkSec: 60, Dtime: base - 20},
+		{ID: 3, Status: tasker.DONE, FileName: "A.mpg", SrcAddr: "127.0.0.1:18881",
+			DstAddr: "127.0.0.3:18883", Size: 3000, WorkSec: 30, Dtime: base - 10},
+	}, now)
+
+	r := fmfm.NewRunner(0, 0, remover.NewRemover(), tskr, tailer.NewTailer())
+	m := fmfm.NewManager(nil, r)
+	router := NewRouter(NewAPIHandler(m))
+
+	get := func(url string) (int, TaskHistory) {
+		req := httptest.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var th TaskHistory
+		if w.Code == http.StatusOK {
+			assert.Nil(t, json.NewDecoder(w.Body).Decode(&th))
+		}
+		return w.Code, th
+	}
+
+	code, th := get("/tasks/history")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 3, len(th.Tasks))
+	assert.Equal(t, 2, len(th.Destinations))
+	assert.Equal(t, tasker.DstStat{DstAddr: "127.0.0.2:18882", Done: 1, Timeout: 1,
+		Bytes: 1000, WorkSec: 10, ThroughputBPS: 800}, th.Destinations[0])
+
+	code, th = get("/tasks/history?file=A.mpg&status=done")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, len(th.Tasks))
+
+	code, th = get("/tasks/history?dst=127.0.0.2:18882&status=timeout")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, len(th.Tasks))
+	assert.Equal(t, "B.mpg", th.Tasks[0].FileName)
+
+	code, th = get(fmt.Sprintf("/tasks/history?from=%d&to=%d", base-25, base-15))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, len(th.Tasks))
+	assert.Equal(t, int64(2), th.Tasks[0].ID)
+
+	code, th = get("/tasks/history?src=127.0.0.9:18889")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 0, len(th.Tasks))
+	assert.Equal(t, 0, len(th.Destinations))
+
+	code, _ = get("/tasks/history?status=unknown")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = get("/tasks/history?from=yesterday")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestQuarantine(t *testing.T) {
+	tskr := tasker.NewTasker()
+	tasks := tskr.Tasks()
+	defer tasks.DeleteAllTask()
+	defer tskr.History().Release()
+	tskr.Failures().SetQuarantineLimit(2)
+
+	// A.mpg 는 두 번 timeout 되어 quarantine, B.mpg 는 한 번 timeout 되어 backoff
+	timeout := func(fileName string) {
+		task := tasks.CreateTask(&tasker.Task{SrcIP: "127.0.0.1", FilePath: "/data2/" + fileName,
+			FileName: fileName, SrcAddr: "127.0.0.1:8080", DstAddr: "127.0.0.1:8081"})
+		tasks.TaskMap[task.ID].Status = tasker.TIMEOUT
+		tasks.TaskMap[task.ID].Mtime = tasker.TaskTime(time.Now().Add(-time.Hour).Unix())
+		tskr.RunWithInfo(tasker.FileMetaPtrMap{}, map[string]int{})
+	}
+	timeout("A.mpg")
+	timeout("A.mpg")
+	timeout("B.mpg")
+
+	r := fmfm.NewRunner(0, 0, remover.NewRemover(), tskr, tailer.NewTailer())
+	m := fmfm.NewManager(nil, r)
+	router := NewRouter(NewAPIHandler(m))
+
+	get := func(url string) []tasker.Failure {
+		req := httptest.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var fl []tasker.Failure
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&fl))
+		return fl
+	}
+	del := func(url string) int {
+		req := httptest.NewRequest("DELETE", url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	fl := get("/quarantine")
+	assert.Equal(t, 1, len(fl))
+	assert.Equal(t, "A.mpg", fl[0].FileName)
+	assert.Equal(t, "127.0.0.1:8081", fl[0].DstAddr)
+	assert.Equal(t, 2, fl[0].Count)
+	assert.True(t, fl[0].Quarantined)
+
+	fl = get("/quarantine?all=true")
+	assert.Equal(t, 2, len(fl))
+	assert.Equal(t, "B.mpg", fl[1].FileName)
+	assert.False(t, fl[1].Quarantined)
+
+	// backoff 중인 기록은 지우지 않음
+	assert.Equal(t, http.StatusNotFound, del("/quarantine?file=B.mpg"))
+	assert.Equal(t, http.StatusNotFound, del("/quarantine?file=A.mpg&dst=127.0.0.2:8081"))
+	assert.Equal(t, http.StatusOK, del("/quarantine?file=A.mpg&dst=127.0.0.1:8081"))
+	assert.Equal(t, 0, len(get("/quarantine")))
+	assert.Equal(t, 1, len(get("/quarantine?all=true")))
+	assert.Equal(t, http.StatusNotFound, del("/quarantine"))
+}
+
+// serveReload : manager 대신 runner 에 반영 요청 처리
+func serveReload(m *fmfm.Manager, r *fmfm.Runner) {
+	go func() {
+		for req := range m.ReloadCh {
+			req.RespCh <- req.Apply(r)
+			close(req.RespCh)
+		}
+	}()
+}
+
+func TestCreateTask(t *testing.T) {
+	tskr := tasker.NewTasker()
+	base := "testsourcefolder"
+	tskr.SourcePath.Add(base)
+	createfile(base, "A.mpg")
+	createfile(base, "B.mpg")
+	defer deletefile(base, "")
+
+	r := fmfm.NewRunner(0, 0, remover.NewRemover(), tskr, tailer.NewTailer())
+	m := fmfm.NewManager(nil, r)
+	serveReload(m, r)
+	defer close(m.ReloadCh)
+	router := NewRouter(NewAPIHandler(m))
+
+	post := func(body string) (int, tasker.ManualTask) {
+		req := httptest.NewRequest("POST", "/tasks", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var mt tasker.ManualTask
+		if w.Code == http.StatusAccepted {
+			assert.Nil(t, json.NewDecoder(w.Body).Decode(&mt))
+		}
+		return w.Code, mt
+	}
+
+	code, ma := post(`{"file_name":"A.mpg","priority":1}`)
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, "A.mpg", ma.FileName)
+	assert.NotEqual(t, int64(0), ma.ID)
+	code, mb := post(`{"file_name":"B.mpg","priority":10}`)
+	assert.Equal(t, http.StatusAccepted, code)
+
+	// 잘못된 json, source path 에 없는 파일, 없는 dst 서버
+	for _, body := range []string{
+		`{"file_name":`,
+		`{"file_name":"C.mpg"}`,
+		`{"file_name":"A.mpg","dst_addr":"127.0.0.1:18881"}`,
+	} {
+		code, _ = post(body)
+		assert.Equal(t, http.StatusBadRequest, code, body)
+	}
+
+	req := httptest.NewRequest("GET", "/tasks/manual", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var ml []tasker.ManualTask
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&ml))
+	assert.Equal(t, []tasker.ManualTask{mb, ma}, ml)
+}
+
+func TestPins(t *testing.T) {
+	rmr := remover.NewRemover()
+	defer rmr.Pins().Release()
+	rmr.Servers.Add("127.0.0.1:18881")
+
+	r := fmfm.NewRunner(0, 0, rmr, tasker.NewTasker(), tailer.NewTailer())
+	m := fmfm.NewManager(nil, r)
+	serveReload(m, r)
+	defer close(m.ReloadCh)
+	router := NewRouter(NewAPIHandler(m))
+
+	do := func(method, url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("PUT", "/pins/A.mpg")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var p remover.Pin
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&p))
+	assert.Equal(t, "A.mpg", p.FileName)
+
+	w = do("GET", "/pins")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var pl []remover.Pin
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&pl))
+	assert.Equal(t, []remover.Pin{p}, pl)
+
+	// 고정한 파일은 삭제 요청하지 않음
+	w = do("DELETE", "/servers/127.0.0.1:18881/files/A.mpg")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var df DeletedFile
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&df))
+	assert.Equal(t, DeletedFile{Server: "127.0.0.1:18881", FileName: "A.mpg",
+		Reason: decision.Pinned}, df)
+
+	w = do("DELETE", "/servers/127.0.0.9:18889/files/A.mpg")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	assert.Equal(t, http.StatusOK, do("DELETE", "/pins/A.mpg").Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/pins/A.mpg").Code)
+}
+
+func TestReload(t *testing.T) {
+	r := fmfm.NewRunner(0, 0, remover.NewRemover(), tasker.NewTasker(), tailer.NewTailer())
+	h := NewAPIHandler(fmfm.NewManager(nil, r))
+	router := NewRouter(h)
+
+	post := func() (int, ReloadReport) {
+		req := httptest.NewRequest("POST", "/admin/reload", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var rr ReloadReport
+		if w.Body.Len() > 0 {
+			assert.Nil(t, json.NewDecoder(w.Body).Decode(&rr))
+		}
+		return w.Code, rr
+	}
+
+	code, _ := post()
+	assert.Equal(t, http.StatusNotImplemented, code)
+
+	h.SetReloader(func() (ReloadReport, error) {
+		return ReloadReport{Applied: []string{"ignore.prefixes"},
+			NotApplied: []string{"listen_addr"}}, nil
+	})
+	code, rr := post()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, ReloadReport{Applied: []string{"ignore.prefixes"},
+		NotApplied: []string{"listen_addr"}}, rr)
+
+	h.SetReloader(func() (ReloadReport, error) {
+		return ReloadReport{Applied: []string{}, NotApplied: []string{}},
+			errors.New("invalid max_copy_count")
+	})
+	code, rr = post()
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalid max_copy_count", rr.Error)
+}
+
+func TestServers(t *testing.T) {
+	assert.Nil(t, membership.Open(""))
+	defer membership.Release()
+	defer heartbeater.Release()
+	membership.SetConfigured(
+		[]membership.Server{{Addr: "127.0.0.1:8081", Slots: 1}},
+		[]membership.Server{{Addr: "127.0.0.3:18083", Slots: 1}})
+
+	r := fmfm.NewRunner(0, 0, remover.NewRemover(), tasker.NewTasker(), tailer.NewTailer())
+	m := fmfm.NewManager(nil, r)
+	router := NewRouter(NewAPIHandler(m))
+
+	serveReload(m, r)
+	defer close(m.ReloadCh)
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/servers/destinations", `{"addr":"127.0.0.4:18084","slots":2}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var sv membership.Server
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&sv))
+	assert.Equal(t, membership.Server{Addr: "127.0.0.4:18084", Slots: 2, Added: true}, sv)
+	assert.Equal(t, 2, len(*r.Tasker().DstServers))
+	assert.Equal(t, 2, len(*r.Remover().Servers))
+	_, ok := heartbeater.Get("127.0.0.4:18084")
+	assert.True(t, ok)
+
+	assert.Equal(t, http.StatusConflict,
+		do("POST", "/servers/destinations", `{"addr":"127.0.0.4:18084"}`).Code)
+	assert.Equal(t, http.StatusBadRequest,
+		do("POST", "/servers/sources", `{"addr":"127.0.0.2"}`).Code)
+	assert.Equal(t, http.StatusNotFound,
+		do("POST", "/servers/others", `{"addr":"127.0.0.2:8082"}`).Code)
+
+	w = do("PATCH", "/servers/destinations/127.0.0.3:18083", `{"drain":true}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&sv))
+	assert.True(t, sv.Drain)
+	assert.Equal(t, http.StatusBadRequest,
+		do("PATCH", "/servers/destinations/127.0.0.3:18083", `{}`).Code)
+	assert.Equal(t, http.StatusNotFound,
+		do("PATCH", "/servers/destinations/127.0.0.9:18089", `{"drain":true}`).Code)
+
+	// drain 모드인 destination 은 삭제 요청하지 않음
+	w = do("DELETE", "/servers/127.0.0.3:18083/files/A.mpg", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var df DeletedFile
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&df))
+	assert.Equal(t, decision.Draining, df.Reason)
+
+	assert.Equal(t, http.StatusOK, do("DELETE", "/servers/sources/127.0.0.1:8081", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/servers/sources/127.0.0.1:8081", "").Code)
+	assert.Equal(t, 0, len(*r.Tasker().SrcServers))
+	_, ok = heartbeater.Get("127.0.0.1:8081")
+	assert.False(t, ok)
+
+	w = do("GET", "/servers", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var svs Servers
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&svs))
+	assert.Equal(t, Servers{
+		Sources: []membership.Server{},
+		Destinations: []membership.Server{
+			{Addr: "127.0.0.4:18084", Slots: 2, Added: true},
+			{Addr: "127.0.0.3:18083", Slots: 1, Drain: true},
+		},
+	}, svs)
+}
+
+type fakeLeader struct {
+	leader bool
+}
+
+func (l *fakeLeader) IsLeader() bool {
+	return l.leader
+}
+
+func (l *fakeLeader) Status() leader.Status {
+	return leader.Status{Leader: l.leader, ID: "cfm2", Owner: "cfm1", Mtime: 1}
+}
+
+func TestLeader(t *testing.T) {
+	r := fmfm.NewRunner(0, 0, remover.NewRemover(), tasker.NewTasker(), tailer.NewTailer())
+	h := NewAPIHandler(fmfm.NewManager(nil, r))
+	h.SetReloader(func() (ReloadReport, error) {
+		return ReloadReport{Applied: []string{}, NotApplied: []string{}}, nil
+	})
+	router := NewRouter(h)
+
+	serve := func(method, url string) (int, leader.Status) {
+		req := httptest.NewRequest(method, url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var s leader.Status
+		if w.Code == http.StatusOK && url == "/admin/leader" ||
+			w.Code == http.StatusServiceUnavailable {
+			assert.Nil(t, json.NewDecoder(w.Body).Decode(&s))
+		}
+		return w.Code, s
+	}
+
+	// active-standby 모드가 아니면 항상 leader
+	code, s := serve("GET", "/admin/leader")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, leader.Status{Leader: true}, s)
+
+	l := &fakeLeader{leader: false}
+	h.SetLeader(l)
+	code, s = serve("GET", "/admin/leader")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, leader.Status{Leader: false, ID: "cfm2", Owner: "cfm1", Mtime: 1}, s)
+
+	// standby 는 조회 API 와 reload 만 처리함
+	code, _ = serve("GET", "/quarantine")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = serve("POST", "/admin/reload")
+	assert.Equal(t, http.StatusOK, code)
+	code, s = serve("DELETE", "/quarantine")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "cfm1", s.Owner)
+	code, _ = serve("PUT", "/pins/A.mpg")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	// leader 는 handler 가 처리함, quarantine 된 것이 없어서 404
+	l.leader = true
+	code, _ = serve("DELETE", "/quarantine")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestExportTasks(t *testing.T) {
+	tskr := tasker.NewTasker()
+	tasks := tskr.Tasks()
+	defer tasks.DeleteAllTask()
+
+	t1 := tasks.CreateTask(&tasker.Task{FilePath: "/data2/A.mpg", FileName: "A.mpg",
+		SrcAddr: "127.0.0.1:8081", DstAddr: "127.0.0.2:8082"})
+	t2 := tasks.CreateTask(&tasker.Task{FilePath: "/data2/B.mpg", FileName: "B.mpg",
+		SrcAddr: "127.0.0.1:8081", DstAddr: "127.0.0.3:8083"})
+
+	r := fmfm.NewRunner(0, 0, nil, tskr, nil)
+	router := NewRouter(NewAPIHandler(fmfm.NewManager(nil, r)))
+
+	req := httptest.NewRequest("GET", "/admin/tasks/export", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson;charset=UTF-8", w.Header().Get("Content-Type"))
+
+	tl := make([]tasker.Task, 0)
+	dec := json.NewDecoder(w.Body)
+	for dec.More() {
+		task := tasker.Task{}
+		assert.Nil(t, dec.Decode(&task))
+		tl = append(tl, task)
+	}
+	assert.Equal(t, []tasker.Task{t1, t2}, tl)
+}
+
+func TestGetTasksQuery(t *testing.T) {
+	tskr := tasker.NewTasker()
+	tasks := tskr.Tasks()
+	tasks.SetStore(tasker.NewMemoryStore())
+
+	d1, d2 := "127.0.0.1:8081", "127.0.0.2:8082"
+	t1 := tasks.CreateTask(&tasker.Task{FileName: "A.mpg", DstAddr: d1})
+	t2 := tasks.CreateTask(&tasker.Task{FileName: "B.mpg", DstAddr: d2})
+	t3 := tasks.CreateTask(&tasker.Task{FileName: "C.mpg", DstAddr: d1})
+	assert.Nil(t, tasks.UpdateStatus(t3.ID, tasker.WORKING))
+
+	r := fmfm.NewRunner(0, 0, nil, tskr, nil)
+	router := NewRouter(NewAPIHandler(fmfm.NewManager(nil, r)))
+
+	get := func(url, etag string) (*httptest.ResponseRecorder, []tasker.Task) {
+		req := httptest.NewRequest("GET", url, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		tl := make([]tasker.Task, 0)
+		if w.Code == http.StatusOK {
+			assert.Nil(t, json.NewDecoder(w.Body).Decode(&tl))
+		}
+		return w, tl
+	}
+	idsOf := func(tl []tasker.Task) []int64 {
+		il := make([]int64, 0)
+		for _, t := range tl {
+			il = append(il, t.ID)
+		}
+		return il
+	}
+
+	w, tl := get("/tasks", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "3", w.Header().Get("X-Total-Count"))
+	assert.Equal(t, []int64{t1.ID, t2.ID, t3.ID}, idsOf(tl))
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	// destination 서버의 ready task 만 조회
+	w, tl = get("/tasks?status=ready&dst_addr="+d1, "")
+	assert.Equal(t, "1", w.Header().Get("X-Total-Count"))
+	assert.Equal(t, []int64{t1.ID}, idsOf(tl))
+
+	_, tl = get("/tasks?status=ready,working&sort=-id&limit=2", "")
+	assert.Equal(t, []int64{t3.ID, t2.ID}, idsOf(tl))
+	w, tl = get("/tasks?sort=file_name&offset=2&limit=5", "")
+	assert.Equal(t, "3", w.Header().Get("X-Total-Count"))
+	assert.Equal(t, []int64{t3.ID}, idsOf(tl))
+	_, tl = get("/tasks?file_name=B.mpg&src_addr=&since=1", "")
+	assert.Equal(t, []int64{t2.ID}, idsOf(tl))
+
+	for _, q := range []string{"status=unknown", "since=a", "limit=-1", "offset=x", "sort=size"} {
+		w, _ = get("/tasks?"+q, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code, q)
+	}
+
+	// 바뀐 것이 없으면 304
+	w, _ = get("/tasks?status=ready", etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	assert.Equal(t, 0, w.Body.Len())
+	w, _ = get("/tasks", `"other", `+etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	assert.Nil(t, tasks.UpdateStatus(t1.ID, tasker.DONE))
+	w, tl = get("/tasks?status=ready", etag)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+	assert.Equal(t, []int64{t2.ID}, idsOf(tl))
+}
+
+func TestGetTaskEvents(t *testing.T) {
+	tskr := tasker.NewTasker()
+	tasks := tskr.Tasks()
+	tasks.SetStore(tasker.NewMemoryStore())
+
+	r := fmfm.NewRunner(0, 0, nil, tskr, nil)
+	h := NewAPIHandler(fmfm.NewManager(nil, r))
+	svr := httptest.NewServer(NewRouter(h))
+	defer svr.Close()
+
+	get := func(query string) (int, TaskEvents) {
+		resp, err := http.Get(svr.URL + "/tasks/events?" + query)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		res := TaskEvents{}
+		if resp.StatusCode == http.StatusOK {
+			assert.Nil(t, json.NewDecoder(resp.Body).Decode(&res))
+		}
+		return resp.StatusCode, res
+	}
+
+	d1, d2 := "127.0.0.1:8081", "127.0.0.2:8082"
+	etag := strings.Trim(tasks.ETag(), `"`)
+	t1 := tasks.CreateTask(&tasker.Task{FileName: "A.mpg", DstAddr: d1})
+	tasks.CreateTask(&tasker.Task{FileName: "B.mpg", DstAddr: d2})
+
+	// 이미 있는 event 는 바로 반환
+	code, res := get("after=" + etag + "&dst_addr=" + d1)
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, res.Reset)
+	assert.Equal(t, 1, len(res.Events))
+	assert.Equal(t, tasker.TaskCreated, res.Events[0].Type)
+	assert.Equal(t, t1.ID, res.Events[0].Task.ID)
+	assert.Equal(t, tasks.ETag(), `"`+res.Next+`"`)
+
+	// event 가 없으면 생길 때까지 기다림
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		tasks.UpdateStatus(t1.ID, tasker.WORKING)
+	}()
+	start := time.Now()
+	_, res = get("timeout=5&after=" + res.Next)
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, 1, len(res.Events))
+	assert.Equal(t, tasker.TaskUpdated, res.Events[0].Type)
+	assert.Equal(t, tasker.WORKING, res.Events[0].Task.Status)
+
+	// 다른 destination 의 event 만 있으면 timeout 까지 기다린 후 빈 목록
+	next := res.Next
+	tasks.DeleteTasks([]int64{t1.ID})
+	_, res = get("timeout=1&dst_addr=" + d2 + "&after=" + next)
+	assert.Empty(t, res.Events)
+	assert.Equal(t, tasks.ETag(), `"`+res.Next+`"`)
+
+	// 알 수 없는 after 는 reset
+	_, res = get("after=unknown")
+	assert.True(t, res.Reset)
+	assert.Empty(t, res.Events)
+
+	for _, q := range []string{"timeout=a", "timeout=-1", "timeout=61"} {
+		code, _ = get(q)
+		assert.Equal(t, http.StatusBadRequest, code, q)
+	}
+
+	// shutdown 하면 기다리지 않음
+	h.CloseStreams()
+	start = time.Now()
+	_, res = get("timeout=5")
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Empty(t, res.Events)
+}
+
+func TestStreamTaskEvents(t *testing.T) {
+	tskr := tasker.NewTasker()
+	tasks := tskr.Tasks()
+	tasks.SetStore(tasker.NewMemoryStore())
+
+	r := fmfm.NewRunner(0, 0, nil, tskr, nil)
+	h := NewAPIHandler(fmfm.NewManager(nil, r))
+	svr := httptest.NewServer(NewRouter(h))
+	defer svr.Close()
+
+	d1, d2 := "127.0.0.1:8081", "127.0.0.2:8082"
+	t1 := tasks.CreateTask(&tasker.Task{FileName: "A.mpg", DstAddr: d1})
+	last := strings.Trim(tasks.ETag(), `"`)
+	tasks.CreateTask(&tasker.Task{FileName: "B.mpg", DstAddr: d2})
+
+	req, _ := http.NewRequest("GET", svr.URL+"/tasks/stream?dst_addr="+d1, nil)
+	req.Header.Set("Last-Event-ID", last)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream;charset=UTF-8", resp.Header.Get("Content-Type"))
+
+	// id, event, data 와 빈 줄로 된 event 하나를 읽음
+	br := bufio.NewReader(resp.Body)
+	read := func() (id, typ string, ev tasker.TaskEvent) {
+		for {
+			line, err := br.ReadString('\n')
+			if !assert.Nil(t, err) {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "":
+				return
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				typ = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				assert.Nil(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev))
+			}
+		}
+	}
+
+	// Last-Event-ID 이후의 d1 event 부터 받음
+	tasks.UpdateStatus(t1.ID, tasker.WORKING)
+	id, typ, ev := read()
+	assert.Equal(t, tasker.TaskUpdated, typ)
+	assert.Equal(t, ev.ID, id)
+	assert.Equal(t, t1.ID, ev.Task.ID)
+	assert.Equal(t, tasker.WORKING, ev.Task.Status)
+
+	tasks.DeleteTask(t1.ID)
+	_, typ, ev = read()
+	assert.Equal(t, tasker.TaskDeleted, typ)
+	assert.Equal(t, t1.ID, ev.Task.ID)
+
+	tasks.DeleteAllTask()
+	id, typ, ev = read()
+	assert.Equal(t, tasker.TaskReset, typ)
+	assert.Nil(t, ev.Task)
+	assert.Equal(t, tasks.ETag(), `"`+id+`"`)
+
+	// shutdown 하면 stream 이 끝남
+	h.CloseStreams()
+	_, err = ioutil.ReadAll(br)
+	assert.Nil(t, err)
+}
+
+func TestAuth(t *testing.T) {
+	h := NewAPIHandler(nil)
+	h.SetReloader(func() (ReloadReport, error) {
+		return ReloadReport{Applied: []string{}, NotApplied: []string{}}, nil
+	})
+	router := NewRouter(h)
+
+	serve := func(method, url, body, authz string) int {
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		if authz != "" {
+			req.Header.Set("Authorization", authz)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 설정하지 않으면 인증하지 않음
+	assert.Equal(t, http.StatusOK, serve("POST", "/admin/reload", "", ""))
+
+	h.SetAuth(Auth{Token: "secret", HMACSecret: "key", MaxSkew: time.Minute})
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/admin/reload", "", ""))
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/admin/reload", "", "Bearer wrong"))
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/admin/reload", "", "Basic c2VjcmV0"))
+	assert.Equal(t, http.StatusOK, serve("POST", "/admin/reload", "", "Bearer secret"))
+
+	// 조회는 인증하지 않음
+	assert.Equal(t, http.StatusOK, serve("GET", "/admin/leader", "", ""))
+
+	// HMAC : method, uri, timestamp, body 를 서명
+	now := time.Now()
+	body := `{"dry_run":true}`
+	authz := SignHMAC("key", "POST", "/admin/reload?x=1", []byte(body), now)
+	assert.Equal(t, http.StatusOK, serve("POST", "/admin/reload?x=1", body, authz))
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/admin/reload?x=1", body+" ", authz))
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/admin/reload?x=2", body, authz))
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/admin/reload?x=1", body,
+		SignHMAC("wrong", "POST", "/admin/reload?x=1", []byte(body), now)))
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/admin/reload?x=1", body,
+		SignHMAC("key", "POST", "/admin/reload?x=1", []byte(body), now.Add(-2*time.Minute))))
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/admin/reload", "", "HMAC 123"))
+
+	// token 만 설정하면 HMAC 은 사용할 수 없음
+	h.SetAuth(Auth{Token: "secret"})
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/admin/reload?x=1", body, authz))
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HMAC 인증할 때 서명하는 body 의 최대 크기
+const maxSignedBodySize = 10 * 1024 * 1024
+
+// Auth : 조회가 아닌(GET, HEAD 가 아닌) 요청의 인증 방법
+//
+// 설정한 방법 중 하나로 인증하면 처리하고, 아무것도 설정하지 않으면 인증하지 않음
+//
+// Token : Authorization: Bearer token
+//
+// HMACSecret : Authorization: HMAC timestamp:signature,
+// timestamp 는 unix time(초), signature 는 SignHMAC 참고
+//
+// MaxSkew : HMAC 의 timestamp 와 현재 시간의 차이가 넘으면 거부
+type Auth struct {
+	Token      string
+	HMACSecret string
+	MaxSkew    time.Duration
+}
+
+func (a Auth) enabled() bool {
+	return a.Token != "" || a.HMACSecret != ""
+}
+
+// SignHMAC :
+//
+// HMAC 인증에 사용하는 Authorization header 값,
+// secret 으로 "method\nrequest uri\ntimestamp\nhex(sha256(body))" 를 서명한 HMAC-SHA256 의 hex
+func SignHMAC(secret, method, requestURI string, body []byte, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "HMAC " + ts + ":" + hmacSignature(secret, method, requestURI, ts, body)
+}
+
+func hmacSignature(secret, method, requestURI, ts string, body []byte) string {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, requestURI, ts, hex.EncodeToString(sum[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify : 인증하지 못하면 이유를 error 로 반환
+//
+// HMAC 으로 인증하면 body 를 읽은 후 다시 읽을 수 있게 바꿈
+func (a Auth) verify(r *http.Request, now time.Time) error {
+	authz := r.Header.Get("Authorization")
+	if authz == "" {
+		return errors.New("no authorization")
+	}
+	scheme, cred := authz, ""
+	if i := strings.IndexByte(authz, ' '); i >= 0 {
+		scheme, cred = authz[:i], strings.TrimSpace(authz[i+1:])
+	}
+
+	switch {
+	case strings.EqualFold(scheme, "Bearer") && a.Token != "":
+		if subtle.ConstantTimeCompare([]byte(cred), []byte(a.Token)) != 1 {
+			return errors.New("invalid token")
+		}
+		return nil
+	case strings.EqualFold(scheme, "HMAC") && a.HMACSecret != "":
+		return a.verifyHMAC(r, cred, now)
+	}
+	return errors.New(fmt.Sprintf("unsupported authorization scheme(%s)", scheme))
+}
+
+func (a Auth) verifyHMAC(r *http.Request, cred string, now time.Time) error {
+	i := strings.IndexByte(cred, ':')
+	if i < 0 {
+		return errors.New("invalid hmac, must be timestamp:signature")
+	}
+	ts, sig := cred[:i], cred[i+1:]
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New(fmt.Sprintf("invalid hmac timestamp(%s)", ts))
+	}
+	skew := now.Sub(time.Unix(sec, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > a.MaxSkew {
+		return errors.New(fmt.Sprintf("hmac timestamp(%s) skewed by %s", ts, skew))
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, maxSignedBodySize+1))
+		if err != nil {
+			return err
+		}
+		if len(body) > maxSignedBodySize {
+			return errors.New("body too large to verify hmac")
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	want := hmacSignature(a.HMACSecret, r.Method, r.URL.RequestURI(), ts, body)
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		return errors.New("invalid hmac signature")
+	}
+	return nil
+}
+
+// SetAuth : 조회가 아닌 요청의 인증 방법 설정
+func (h *APIHandler) SetAuth(a Auth) {
+	h.auth = a
+}
+
+// authenticate :
+//
+// Auth 를 설정했으면 조회가 아닌 요청은 인증한 후 처리하고, 인증하지 못하면 401 로 응답
+func (h *APIHandler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.auth.enabled() || r.Method == "GET" || r.Method == "HEAD" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := h.auth.verify(r, time.Now()); err != nil {
+			apilogger.Infof("[%s] rejected %s %s request, unauthorized, error(%s)",
+				r.RemoteAddr, r.Method, r.URL.Path, err.Error())
+			if h.auth.Token != "" {
+				w.Header().Add("WWW-Authenticate", "Bearer")
+			}
+			if h.auth.HMACSecret != "" {
+				w.Header().Add("WWW-Authenticate", "HMAC")
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,177 +2,459 @@ package common
 
 import (
 	"bufio"
-	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 )
 
-func defaultHttpClient() http.Client {
-	return httpClient(600, 5)
+// AgentUnreachableError : CiMonitoringAgent 에 연결하지 못했거나 응답을 다 받지 못함
+type AgentUnreachableError struct {
+	Addr string
+	Err  error
 }
 
-func httpTimoutClient(timeoutSec uint) http.Client {
-	return httpClient(600, timeoutSec)
+func (e *AgentUnreachableError) Error() string {
+	return e.Err.Error()
 }
 
-func httpClient(keepaliveTOsec, httpTOsec uint) http.Client {
-	keepAliveTimeout := time.Duration(keepaliveTOsec) * time.Second
-	timeout := time.Duration(httpTOsec) * time.Second
-	defaultTransport := &http.Transport{
-		Dial: (&net.Dialer{
-			KeepAlive: keepAliveTimeout,
-		}).Dial,
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 100,
+func (e *AgentUnreachableError) Unwrap() error {
+	return e.Err
+}
+
+// AgentStatusError : CiMonitoringAgent 가 200 OK 가 아닌 응답을 함
+type AgentStatusError struct {
+	Addr       string
+	StatusCode int
+	Status     string
+}
+
+func (e *AgentStatusError) Error() string {
+	return e.Status
+}
+
+// AgentPayloadError : CiMonitoringAgent 의 응답 body 를 해석하지 못함
+type AgentPayloadError struct {
+	Addr string
+	Err  error
+}
+
+func (e *AgentPayloadError) Error() string {
+	return fmt.Sprintf("invalid payload, %s", e.Err.Error())
+}
+
+func (e *AgentPayloadError) Unwrap() error {
+	return e.Err
+}
+
+// AgentRetry : CiMonitoringAgent 요청이 실패했을 때 다시 요청하는 방법
+//
+// Count : 다시 요청하는 최대 횟수, 0 이면 다시 요청하지 않음
+//
+// Backoff : 처음 다시 요청하기 전에 기다리는 시간, 다시 요청할 때마다 두 배로 늘어남,
+// 여러 서버에 한꺼번에 다시 요청하지 않도록 기다리는 시간을 ±50% 안에서 무작위로 바꿈
+//
+// 연결하지 못했거나(AgentUnreachableError) 5xx 응답(AgentStatusError)일 때만 다시 요청함
+type AgentRetry struct {
+	Count   int
+	Backoff time.Duration
+}
+
+// AgentRetry 기본값
+const (
+	DefaultAgentRetryCount   = 2
+	DefaultAgentRetryBackoff = 100 * time.Millisecond
+)
+
+// Validate : Count, Backoff 검사
+func (r AgentRetry) Validate() error {
+	if r.Count < 0 {
+		return errors.New(fmt.Sprintf("invalid retry count(%d), must not be negative", r.Count))
 	}
-	return http.Client{
-		Transport: defaultTransport,
-		Timeout:   timeout,
+	if r.Backoff < 0 {
+		return errors.New(fmt.Sprintf("invalid retry backoff(%s), must not be negative", r.Backoff))
 	}
+	return nil
 }
 
-// Heartbeat : host에 heartbeat 요청, 응답받기
-// URL : hostip(ipv4):port/hb
-// timeoutSec : timeout 값
-func Heartbeat(host *Host, timeoutSec uint) (bool, error) {
-	serverURL := fmt.Sprintf("http://%s/hb", host.Addr)
-	_, urlErr := url.Parse(serverURL)
-	if urlErr != nil {
-		return false, urlErr
+// wait : attempt 번째 실패한 후 기다리는 시간
+func (r AgentRetry) wait(attempt int) time.Duration {
+	d := r.Backoff << uint(attempt)
+	if d <= 0 {
+		return 0
 	}
-	httpClient := httpTimoutClient(timeoutSec)
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
 
-	req, reqErr := http.NewRequest(http.MethodHead, serverURL, nil)
-	if reqErr != nil {
-		return false, reqErr
-	}
-	res, resErr := httpClient.Do(req)
-	if res != nil {
-		defer res.Body.Close()
+func retryable(err error) bool {
+	var ue *AgentUnreachableError
+	if errors.As(err, &ue) {
+		return true
 	}
-	if resErr != nil {
-		return false, resErr
+	var se *AgentStatusError
+	if errors.As(err, &se) {
+		return se.StatusCode >= 500
 	}
-	// HTTP 커넥션을 재사용할때 메모리 누수를 피하기 위해선
-	// 데이터가 필요없더라도 응답 바디를 읽어야함
-	// 응답 바디를 읽음. (데이터가 필요 없으므로 devnull에 write)
-	// https: //stackoverflow.com/questions/17959732/why-is-go-https-client-not-reusing-connections
-	io.Copy(ioutil.Discard, res.Body)
+	return false
+}
 
-	if res.StatusCode != 200 {
-		return false, errors.New(res.Status)
-	}
-	return true, nil
+// AgentClientConfig : AgentClient 설정
+//
+// Timeout : ctx 에 deadline 이 없을 때 사용하는 요청별 제한 시간(다시 요청하는 시간 포함),
+// 0 이면 제한 없음
+//
+// TLS : nil 이 아니면 https 로 요청
+//
+// Token : 비어있지 않으면 모든 요청에 Authorization: Bearer token 을 보냄
+type AgentClientConfig struct {
+	Timeout time.Duration
+	Retry   AgentRetry
+	TLS     *tls.Config
+	Token   string
 }
 
-// GetRemoteFileList is to get file list on remote server via CiMonitoringAgent
-// URL : hostip(ipv4):port/files
-func GetRemoteFileList(host *Host, fileList *[]string) error {
+// AgentClient : CiMonitoringAgent client
+//
+// 모든 요청이 하나의 transport 를 같이 사용해서 연결을 재사용함,
+// 모든 method 는 ctx 가 취소되면 요청을 멈추고 error 반환
+//
+// 실패하면 AgentUnreachableError, AgentStatusError, AgentPayloadError 중 하나를 반환
+type AgentClient struct {
+	client    *http.Client
+	transport *http.Transport
+	scheme    string
+	token     string
+	timeout   time.Duration
+	retry     AgentRetry
+}
 
-	serverURL := fmt.Sprintf("http://%s/files", host.Addr)
-	_, urlErr := url.Parse(serverURL)
-	if urlErr != nil {
-		return urlErr
+// NewAgentClient : AgentClient 생성
+func NewAgentClient(cfg AgentClientConfig) (*AgentClient, error) {
+	if cfg.Timeout < 0 {
+		return nil, errors.New(fmt.Sprintf("invalid timeout(%s), must not be negative", cfg.Timeout))
 	}
-
-	httpClient := defaultHttpClient()
-
-	req, reqErr := http.NewRequest(http.MethodGet, serverURL, nil)
-	if reqErr != nil {
-		return reqErr
+	if err := cfg.Retry.Validate(); err != nil {
+		return nil, err
 	}
-
-	res, resErr := httpClient.Do(req)
-	if resErr != nil {
-		return resErr
+	transport := &http.Transport{
+		DialContext: (&net.Dialer{
+			KeepAlive: 600 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100,
+		TLSClientConfig:     cfg.TLS,
 	}
-	if res != nil {
-		defer res.Body.Close()
+	scheme := "http"
+	if cfg.TLS != nil {
+		scheme = "https"
 	}
+	return &AgentClient{
+		client:    &http.Client{Transport: transport},
+		transport: transport,
+		scheme:    scheme,
+		token:     cfg.Token,
+		timeout:   cfg.Timeout,
+		retry:     cfg.Retry,
+	}, nil
+}
 
-	if res.StatusCode != 200 {
-		io.Copy(ioutil.Discard, res.Body)
-		return errors.New(res.Status)
-	}
+// Close : 사용하지 않는 연결을 닫음
+func (c *AgentClient) Close() {
+	c.transport.CloseIdleConnections()
+}
 
-	body, _ := ioutil.ReadAll(res.Body)
+var (
+	agentClientMutex sync.RWMutex
+	agentClient, _   = NewAgentClient(AgentClientConfig{
+		Timeout: DefaultFanOutTimeout,
+		Retry: AgentRetry{
+			Count:   DefaultAgentRetryCount,
+			Backoff: DefaultAgentRetryBackoff,
+		},
+	})
+)
 
-	scanner := bufio.NewScanner(bytes.NewReader(body))
-	for scanner.Scan() {
-		*fileList = append(*fileList, scanner.Text())
+// SetAgentClient : remover, tasker, heartbeater 가 사용할 AgentClient 설정,
+// 이전 AgentClient 는 Close 함
+func SetAgentClient(c *AgentClient) {
+	agentClientMutex.Lock()
+	old := agentClient
+	agentClient = c
+	agentClientMutex.Unlock()
+	if old != nil && old != c {
+		old.Close()
 	}
+}
 
-	return nil
+// GetAgentClient : SetAgentClient 로 설정한 AgentClient
+func GetAgentClient() *AgentClient {
+	agentClientMutex.RLock()
+	defer agentClientMutex.RUnlock()
+	return agentClient
 }
 
-// GetRemoteDiskUsage is to get disk usage on remote server via CiMonitoringAgent
-// URL : hostip(ipv4):port/df
-func GetRemoteDiskUsage(host *Host, du *DiskUsage) error {
+// do :
+//
+// host 에 요청하고 200 OK 응답이면 handle 로 응답 처리,
+// 다시 요청할 수 있는 error 면 Retry 만큼 다시 요청함
+func (c *AgentClient) do(ctx context.Context, method string, host *Host, path string,
+	handle func(res *http.Response) error) error {
+
+	serverURL := fmt.Sprintf("%s://%s%s", c.scheme, host.Addr, path)
+	if _, err := url.Parse(serverURL); err != nil {
+		return err
+	}
+	if _, ok := ctx.Deadline(); !ok && c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
 
-	serverURL := fmt.Sprintf("http://%s/df", host.Addr)
-	_, urlErr := url.Parse(serverURL)
-	if urlErr != nil {
-		return urlErr
+	for attempt := 0; ; attempt++ {
+		err := c.once(ctx, method, host, serverURL, handle)
+		if err == nil || attempt >= c.retry.Count || !retryable(err) {
+			return err
+		}
+		t := time.NewTimer(c.retry.wait(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
 	}
+}
 
-	httpClient := defaultHttpClient()
+func (c *AgentClient) once(ctx context.Context, method string, host *Host, serverURL string,
+	handle func(res *http.Response) error) error {
 
-	req, err := http.NewRequest(http.MethodGet, serverURL, nil)
+	req, err := http.NewRequestWithContext(ctx, method, serverURL, nil)
 	if err != nil {
 		return err
 	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return &AgentUnreachableError{Addr: host.Addr, Err: err}
+	}
+	defer func() {
+		// HTTP 커넥션을 재사용할때 메모리 누수를 피하기 위해선
+		// 데이터가 필요없더라도 응답 바디를 읽어야함
+		// https: //stackoverflow.com/questions/17959732/why-is-go-https-client-not-reusing-connections
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+	}()
 
-	res, getErr := httpClient.Do(req)
-	if getErr != nil {
-		return getErr
+	if res.StatusCode != http.StatusOK {
+		return &AgentStatusError{Addr: host.Addr, StatusCode: res.StatusCode, Status: res.Status}
 	}
-	if res != nil {
-		defer res.Body.Close()
+	if handle == nil {
+		return nil
 	}
+	return handle(res)
+}
 
-	body, err := ioutil.ReadAll(res.Body)
+// Heartbeat : host에 heartbeat 요청, 응답받기
+// URL : hostip(ipv4):port/hb
+func (c *AgentClient) Heartbeat(ctx context.Context, host *Host) error {
+	return c.do(ctx, http.MethodHead, host, "/hb", nil)
+}
 
-	json.Unmarshal(body, du)
+// FileList : 서버의 파일 목록 요청
+// URL : hostip(ipv4):port/files
+func (c *AgentClient) FileList(ctx context.Context, host *Host) ([]string, error) {
+	var fl []string
+	err := c.do(ctx, http.MethodGet, host, "/files", func(res *http.Response) error {
+		fl = make([]string, 0, 10000)
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			fl = append(fl, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return &AgentUnreachableError{Addr: host.Addr, Err: err}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fl, nil
+}
 
-	return nil
+// DiskUsage : 서버의 disk 사용량 요청
+// URL : hostip(ipv4):port/df
+func (c *AgentClient) DiskUsage(ctx context.Context, host *Host) (DiskUsage, error) {
+	var du DiskUsage
+	err := c.do(ctx, http.MethodGet, host, "/df", func(res *http.Response) error {
+		du = DiskUsage{}
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return &AgentUnreachableError{Addr: host.Addr, Err: err}
+		}
+		if err := json.Unmarshal(body, &du); err != nil {
+			return &AgentPayloadError{Addr: host.Addr, Err: err}
+		}
+		return nil
+	})
+	return du, err
 }
 
-// DeleteFileOnRemote is to delete file on remote server via CiMonitoringAgent
+// DeleteFile : 서버에 파일 삭제 요청
 // URL : hostip(ipv4):port/files/${name}
-func DeleteFileOnRemote(host *Host, fileName string) error {
+func (c *AgentClient) DeleteFile(ctx context.Context, host *Host, fileName string) error {
+	return c.do(ctx, http.MethodDelete, host, "/files/"+fileName, nil)
+}
 
-	serverURL := fmt.Sprintf("http://%s/files/%s", host.Addr, fileName)
-	_, urlErr := url.Parse(serverURL)
-	if urlErr != nil {
-		return urlErr
-	}
+// 파일 목록 변경분(delta) 요청에 사용하는 header
+//
+// InventoryTokenHeader : 응답한 파일 목록의 version, 다음 요청의 since 로 사용,
+// 없으면 CiMonitoringAgent 가 변경분 요청을 지원하지 않는 것으로 보고 매번 전체 목록을 요청함
+//
+// InventoryDeltaHeader : true 이면 응답이 since 이후의 변경분,
+// 없으면 전체 목록(since 를 모르는 CiMonitoringAgent 는 query 를 무시하고 전체 목록을 응답함)
+const (
+	InventoryTokenHeader = "X-Inventory-Token"
+	InventoryDeltaHeader = "X-Inventory-Delta"
+)
 
-	httpClient := defaultHttpClient()
+// ErrInventoryTokenExpired : CiMonitoringAgent 가 since 이후의 변경분을 알지 못함(410 Gone),
+// 전체 목록을 다시 요청해야 함
+var ErrInventoryTokenExpired = errors.New("inventory token expired")
 
-	req, err := http.NewRequest(http.MethodDelete, serverURL, nil)
-	if err != nil {
-		return err
+// FileListDelta : GET /files?since=token 응답
+//
+// Full 이면 Files 가 전체 목록, 아니면 Added, Removed 가 since 이후의 변경분
+//
+// Token : 응답한 파일 목록의 version, 비어있으면 변경분 요청을 지원하지 않음
+type FileListDelta struct {
+	Full    bool
+	Files   []string
+	Added   []string
+	Removed []string
+	Token   string
+}
+
+// FileListDelta :
+//
+// since 이후의 파일 목록 변경분 요청, since 가 비어있으면 전체 목록 요청
+// URL : hostip(ipv4):port/files?since=token
+//
+// 변경분 응답은 한 줄에 파일 하나씩, 추가된 파일은 +, 지워진 파일은 - 로 시작함,
+// 응답에는 X-Inventory-Delta: true 와 다음 요청에 쓸 X-Inventory-Token 이 있어야 함
+//
+// CiMonitoringAgent 가 since 를 모르면(410 Gone) ErrInventoryTokenExpired 반환
+func (c *AgentClient) FileListDelta(ctx context.Context, host *Host,
+	since string) (FileListDelta, error) {
+
+	path := "/files"
+	if since != "" {
+		path += "?since=" + url.QueryEscape(since)
 	}
+	var delta FileListDelta
+	err := c.do(ctx, http.MethodGet, host, path, func(res *http.Response) error {
+		delta = FileListDelta{}
+		delta.Token = res.Header.Get(InventoryTokenHeader)
+		delta.Full = since == "" || res.Header.Get(InventoryDeltaHeader) != "true"
 
-	res, getErr := httpClient.Do(req)
-	if getErr != nil {
-		return getErr
+		// 같은 파일이 여러 번 바뀌었으면 마지막 변경만 반영
+		var order []string
+		last := make(map[string]bool)
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if delta.Full {
+				delta.Files = append(delta.Files, line)
+				continue
+			}
+			var added bool
+			switch {
+			case strings.HasPrefix(line, "+"):
+				added = true
+			case strings.HasPrefix(line, "-"):
+				added = false
+			case line == "":
+				continue
+			default:
+				return &AgentPayloadError{Addr: host.Addr,
+					Err: errors.New(fmt.Sprintf("invalid delta line(%s)", line))}
+			}
+			name := line[1:]
+			if _, ok := last[name]; !ok {
+				order = append(order, name)
+			}
+			last[name] = added
+		}
+		if err := scanner.Err(); err != nil {
+			return &AgentUnreachableError{Addr: host.Addr, Err: err}
+		}
+		for _, name := range order {
+			if last[name] {
+				delta.Added = append(delta.Added, name)
+			} else {
+				delta.Removed = append(delta.Removed, name)
+			}
+		}
+		return nil
+	})
+	var se *AgentStatusError
+	if errors.As(err, &se) && se.StatusCode == http.StatusGone {
+		return FileListDelta{}, ErrInventoryTokenExpired
 	}
-	if res != nil {
-		defer res.Body.Close()
+	return delta, err
+}
+
+// Heartbeat : host에 heartbeat 요청, 응답받기
+// URL : hostip(ipv4):port/hb
+// timeoutSec : timeout 값
+func Heartbeat(host *Host, timeoutSec uint) (bool, error) {
+	ctx := context.Background()
+	if timeoutSec > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeoutSec)*time.Second)
+		defer cancel()
 	}
-	io.Copy(ioutil.Discard, res.Body)
+	if err := GetAgentClient().Heartbeat(ctx, host); err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
-	if res.StatusCode != 200 {
-		return errors.New(res.Status)
+// GetRemoteFileList is to get file list on remote server via CiMonitoringAgent
+// URL : hostip(ipv4):port/files
+func GetRemoteFileList(host *Host, fileList *[]string) error {
+	fl, err := GetAgentClient().FileList(context.Background(), host)
+	if err != nil {
+		return err
 	}
+	*fileList = append(*fileList, fl...)
+	return nil
+}
 
+// GetRemoteDiskUsage is to get disk usage on remote server via CiMonitoringAgent
+// URL : hostip(ipv4):port/df
+func GetRemoteDiskUsage(host *Host, du *DiskUsage) error {
+	d, err := GetAgentClient().DiskUsage(context.Background(), host)
+	if err != nil {
+		return err
+	}
+	*du = d
 	return nil
 }
+
+// DeleteFileOnRemote is to delete file on remote server via CiMonitoringAgent
+// URL : hostip(ipv4):port/files/${name}
+func DeleteFileOnRemote(host *Host, fileName string) error {
+	return GetAgentClient().DeleteFile(context.Background(), host, fileName)
+}
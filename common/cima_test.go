@@ -1,13 +1,21 @@
 package common_test
 
 import (
+	"context"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -145,3 +153,152 @@ func TestGetHeartbeat(t *testing.T) {
 	assert.Equal(t, true, rc)
 	assert.Equal(t, nil, err)
 }
+
+func TestAgentClient_Errors(t *testing.T) {
+	c, err := common.NewAgentClient(common.AgentClientConfig{Timeout: 2 * time.Second})
+	require.Nil(t, err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/df":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/files":
+			fmt.Fprintln(w, "A.mpg")
+		}
+	}))
+	h := &common.Host{Addr: strings.TrimPrefix(ts.URL, "http://")}
+
+	// 500 응답은 disk 사용량 0 이 아니라 error
+	_, err = c.DiskUsage(context.Background(), h)
+	var se *common.AgentStatusError
+	require.True(t, errors.As(err, &se))
+	assert.Equal(t, http.StatusInternalServerError, se.StatusCode)
+	assert.Equal(t, h.Addr, se.Addr)
+
+	fl, err := c.FileList(context.Background(), h)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"A.mpg"}, fl)
+
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "not json")
+	}))
+	defer bad.Close()
+	_, err = c.DiskUsage(context.Background(), &common.Host{Addr: strings.TrimPrefix(bad.URL, "http://")})
+	var pe *common.AgentPayloadError
+	assert.True(t, errors.As(err, &pe))
+
+	ts.Close()
+	err = c.DeleteFile(context.Background(), h, "A.mpg")
+	var ue *common.AgentUnreachableError
+	assert.True(t, errors.As(err, &ue))
+}
+
+func TestAgentClient_Retry(t *testing.T) {
+	var requests int32
+	status := int32(http.StatusServiceUnavailable)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 두 번째 요청부터 성공
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(int(atomic.LoadInt32(&status)))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	h := &common.Host{Addr: strings.TrimPrefix(ts.URL, "http://")}
+
+	c, err := common.NewAgentClient(common.AgentClientConfig{
+		Timeout: 2 * time.Second,
+		Retry:   common.AgentRetry{Count: 2, Backoff: time.Millisecond},
+	})
+	require.Nil(t, err)
+
+	// 5xx 는 다시 요청
+	assert.Nil(t, c.DeleteFile(context.Background(), h, "A.mpg"))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	// 4xx 는 다시 요청하지 않음
+	atomic.StoreInt32(&requests, 0)
+	atomic.StoreInt32(&status, http.StatusNotFound)
+	assert.EqualError(t, c.DeleteFile(context.Background(), h, "A.mpg"), "404 Not Found")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// 다시 요청하지 않는 설정
+	c, err = common.NewAgentClient(common.AgentClientConfig{})
+	require.Nil(t, err)
+	atomic.StoreInt32(&requests, 0)
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	assert.NotNil(t, c.Heartbeat(context.Background(), h))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	_, err = common.NewAgentClient(common.AgentClientConfig{Retry: common.AgentRetry{Count: -1}})
+	assert.NotNil(t, err)
+}
+
+func TestAgentClient_Cancel(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	h := &common.Host{Addr: strings.TrimPrefix(ts.URL, "http://")}
+
+	c, err := common.NewAgentClient(common.AgentClientConfig{
+		Retry: common.AgentRetry{Count: 10, Backoff: time.Second},
+	})
+	require.Nil(t, err)
+
+	// 다시 요청하기 전에 ctx 가 취소되면 바로 반환
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = c.FileList(ctx, h)
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestAgentClient_TLSToken(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintln(w, "A.mpg")
+	}))
+	defer ts.Close()
+	h := &common.Host{Addr: strings.TrimPrefix(ts.URL, "https://")}
+
+	// test server 인증서를 CA 로 사용
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	require.Nil(t, ioutil.WriteFile(ca,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0644))
+	tc, err := common.TLSFiles{CAFile: ca}.ClientConfig()
+	require.Nil(t, err)
+
+	c, err := common.NewAgentClient(common.AgentClientConfig{TLS: tc, Token: "secret"})
+	require.Nil(t, err)
+	fl, err := c.FileList(context.Background(), h)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"A.mpg"}, fl)
+
+	c, err = common.NewAgentClient(common.AgentClientConfig{TLS: tc})
+	require.Nil(t, err)
+	_, err = c.FileList(context.Background(), h)
+	assert.EqualError(t, err, "401 Unauthorized")
+
+	// system CA 로는 검증하지 못함
+	tc, err = common.TLSFiles{}.ClientConfig()
+	require.Nil(t, err)
+	c, err = common.NewAgentClient(common.AgentClientConfig{TLS: tc, Token: "secret"})
+	require.Nil(t, err)
+	_, err = c.FileList(context.Background(), h)
+	var ue *common.AgentUnreachableError
+	assert.True(t, errors.As(err, &ue))
+
+	_, err = common.TLSFiles{CertFile: "cert.pem"}.ClientConfig()
+	assert.NotNil(t, err)
+	_, err = common.TLSFiles{CAFile: "notexist.pem"}.ClientConfig()
+	assert.NotNil(t, err)
+}
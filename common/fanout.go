@@ -0,0 +1,172 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FanOut : 여러 서버에 동시에 요청하는 방법
+//
+// Parallelism : 동시에 요청하는 최대 서버 수
+//
+// Timeout : 서버별 제한 시간, 넘으면 그 서버만 실패로 처리하고 나머지 서버의 결과는 그대로 사용
+type FanOut struct {
+	Parallelism int
+	Timeout     time.Duration
+}
+
+// FanOut 기본값
+const (
+	DefaultFanOutParallelism = 16
+	DefaultFanOutTimeout     = 5 * time.Second
+)
+
+var (
+	fanOutMutex sync.RWMutex
+	fanOut      = FanOut{
+		Parallelism: DefaultFanOutParallelism,
+		Timeout:     DefaultFanOutTimeout,
+	}
+)
+
+// Validate : Parallelism, Timeout 검사
+func (f FanOut) Validate() error {
+	if f.Parallelism < 1 {
+		return errors.New(fmt.Sprintf("invalid parallelism(%d), must be greater than 0", f.Parallelism))
+	}
+	if f.Timeout <= 0 {
+		return errors.New(fmt.Sprintf("invalid timeout(%s), must be greater than 0", f.Timeout))
+	}
+	return nil
+}
+
+// SetFanOut : GetRemoteFileLists, GetRemoteDiskUsages 에서 사용할 FanOut 설정
+func SetFanOut(f FanOut) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	fanOutMutex.Lock()
+	defer fanOutMutex.Unlock()
+	fanOut = f
+	return nil
+}
+
+// GetFanOut : SetFanOut 으로 설정한 FanOut
+func GetFanOut() FanOut {
+	fanOutMutex.RLock()
+	defer fanOutMutex.RUnlock()
+	return fanOut
+}
+
+// FanOutReport : 여러 서버에 요청한 결과
+//
+// Failed : 실패한 서버(addr)별 error
+type FanOutReport struct {
+	Total   int
+	Failed  map[string]error
+	Elapsed time.Duration
+}
+
+// Succeeded : 성공한 서버 수
+func (r FanOutReport) Succeeded() int {
+	return r.Total - len(r.Failed)
+}
+
+func (r FanOutReport) String() string {
+	return fmt.Sprintf("total(%d), succeeded(%d), failed(%d), elapsed(%s)",
+		r.Total, r.Succeeded(), len(r.Failed), r.Elapsed)
+}
+
+// Run :
+//
+// 서버마다 fn 을 호출, 동시에 Parallelism 개까지 호출하고 모두 끝날 때까지 기다림
+//
+// fn 의 ctx 는 Timeout 이 지나면 취소됨, fn 이 error 를 반환하면 Failed 에 넣음
+func (f FanOut) Run(hosts []*Host, fn func(ctx context.Context, host *Host) error) FanOutReport {
+	start := time.Now()
+	rep := FanOutReport{Total: len(hosts), Failed: make(map[string]error)}
+	parallelism := f.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	sem := make(chan struct{}, parallelism)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, h := range hosts {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(h *Host) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			ctx, cancel := context.WithTimeout(context.Background(), f.Timeout)
+			defer cancel()
+			if err := fn(ctx, h); err != nil {
+				mutex.Lock()
+				rep.Failed[h.Addr] = err
+				mutex.Unlock()
+			}
+		}(h)
+	}
+	wg.Wait()
+	rep.Elapsed = time.Since(start)
+	return rep
+}
+
+// GetRemoteFileLists :
+//
+// SetAgentClient 로 설정한 AgentClient 로
+// 서버들의 파일 목록을 동시에 구함, 결과는 서버(addr)별 파일 목록,
+// 실패한 서버는 결과에 없고 FanOutReport.Failed 에 있음
+func (f FanOut) GetRemoteFileLists(hosts []*Host) (map[string][]string, FanOutReport) {
+	client := GetAgentClient()
+	var mutex sync.Mutex
+	lists := make(map[string][]string, len(hosts))
+	rep := f.Run(hosts, func(ctx context.Context, h *Host) error {
+		fl, err := client.FileList(ctx, h)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		lists[h.Addr] = fl
+		mutex.Unlock()
+		return nil
+	})
+	return lists, rep
+}
+
+// GetRemoteDiskUsages :
+//
+// SetAgentClient 로 설정한 AgentClient 로
+// 서버들의 disk 사용량을 동시에 구함, 결과는 서버(addr)별 disk 사용량,
+// 실패한 서버는 결과에 없고 FanOutReport.Failed 에 있음
+func (f FanOut) GetRemoteDiskUsages(hosts []*Host) (map[string]DiskUsage, FanOutReport) {
+	client := GetAgentClient()
+	var mutex sync.Mutex
+	dus := make(map[string]DiskUsage, len(hosts))
+	rep := f.Run(hosts, func(ctx context.Context, h *Host) error {
+		du, err := client.DiskUsage(ctx, h)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		dus[h.Addr] = du
+		mutex.Unlock()
+		return nil
+	})
+	return dus, rep
+}
+
+// GetRemoteFileLists : SetFanOut 으로 설정한 FanOut 으로 서버들의 파일 목록을 구함
+func GetRemoteFileLists(hosts []*Host) (map[string][]string, FanOutReport) {
+	return GetFanOut().GetRemoteFileLists(hosts)
+}
+
+// GetRemoteDiskUsages : SetFanOut 으로 설정한 FanOut 으로 서버들의 disk 사용량을 구함
+func GetRemoteDiskUsages(hosts []*Host) (map[string]DiskUsage, FanOutReport) {
+	return GetFanOut().GetRemoteDiskUsages(hosts)
+}
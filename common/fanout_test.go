@@ -0,0 +1,115 @@
+package common_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/castisdev/cfm/common"
+)
+
+func TestFanOut_Run(t *testing.T) {
+	hosts := make([]*common.Host, 0)
+	for i := 0; i < 10; i++ {
+		addr := fmt.Sprintf("127.0.0.%d:8080", i+1)
+		hosts = append(hosts, &common.Host{Addr: addr})
+	}
+
+	// 동시에 Parallelism 개까지만 호출
+	var mutex sync.Mutex
+	running, maxRunning := 0, 0
+	f := common.FanOut{Parallelism: 3, Timeout: time.Second}
+	rep := f.Run(hosts, func(ctx context.Context, h *common.Host) error {
+		mutex.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		if h.Addr == "127.0.0.3:8080" {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	assert.Equal(t, 3, maxRunning)
+	assert.Equal(t, 10, rep.Total)
+	assert.Equal(t, 9, rep.Succeeded())
+	assert.EqualError(t, rep.Failed["127.0.0.3:8080"], "failed")
+
+	// 서버별 제한 시간이 지나면 ctx 가 취소됨
+	f = common.FanOut{Parallelism: 10, Timeout: 50 * time.Millisecond}
+	start := time.Now()
+	rep = f.Run(hosts, func(ctx context.Context, h *common.Host) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, 0, rep.Succeeded())
+
+	assert.NotNil(t, common.SetFanOut(common.FanOut{Parallelism: 0, Timeout: time.Second}))
+	assert.NotNil(t, common.SetFanOut(common.FanOut{Parallelism: 1}))
+}
+
+func TestGetRemoteFileLists(t *testing.T) {
+	release := make(chan struct{})
+	handler := func(files ...string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/files":
+				fmt.Fprint(w, strings.Join(files, "\n"))
+			case "/df":
+				fmt.Fprintf(w, `{"total_size":"100","used_size":"%d"}`, len(files))
+			}
+		}
+	}
+	s1 := httptest.NewServer(handler("A.mpg", "B.mpg"))
+	defer s1.Close()
+	s2 := httptest.NewServer(handler("C.mpg"))
+	defer s2.Close()
+	// 응답하지 않는 서버
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	hosts := make([]*common.Host, 0)
+	for _, s := range []*httptest.Server{s1, slow, s2, broken} {
+		hosts = append(hosts, &common.Host{Addr: strings.TrimPrefix(s.URL, "http://")})
+	}
+
+	f := common.FanOut{Parallelism: 2, Timeout: 200 * time.Millisecond}
+	start := time.Now()
+	lists, rep := f.GetRemoteFileLists(hosts)
+	assert.True(t, time.Since(start) < 2*time.Second)
+	assert.Equal(t, map[string][]string{
+		hosts[0].Addr: {"A.mpg", "B.mpg"},
+		hosts[2].Addr: {"C.mpg"},
+	}, lists)
+	assert.Equal(t, 4, rep.Total)
+	assert.Equal(t, 2, len(rep.Failed))
+	assert.NotNil(t, rep.Failed[hosts[1].Addr])
+	assert.NotNil(t, rep.Failed[hosts[3].Addr])
+
+	dus, rep := f.GetRemoteDiskUsages(hosts[:3])
+	assert.Equal(t, 2, len(dus))
+	assert.Equal(t, common.Disksize(2), dus[hosts[0].Addr].UsedSize)
+	assert.Equal(t, common.Disksize(1), dus[hosts[2].Addr].UsedSize)
+	assert.Equal(t, 1, len(rep.Failed))
+}
@@ -19,6 +19,8 @@ type FileMeta struct {
 	ServerIPs    map[string]int // 이 파일을 가지고 있는 [서버 IP]개수
 	SrcFilePath  string         // source file full path : cfm이 구함
 	ServerIPList string         // 이 파일을 가지고 있는 서버 IP list string
+	// grade.info 파일의 TargetCopyCount 값, 배포되어야하는 서버 개수
+	TargetCopyCount int
 }
 
 // NewFileMeta :
@@ -50,6 +52,31 @@ func (fm FileMeta) String() string {
 	return s
 }
 
+// TargetCopyCountWithMax :
+//
+// grade.info 파일의 TargetCopyCount 값을 max 로 제한한 copy 수
+//
+// TargetCopyCount 값이 없거나 max 가 1 보다 작으면 1
+func (fm FileMeta) TargetCopyCountWithMax(max int) int {
+	if fm.TargetCopyCount < 1 || max < 1 {
+		return 1
+	}
+	if fm.TargetCopyCount > max {
+		return max
+	}
+	return fm.TargetCopyCount
+}
+
+// Clone : ServerIPs map 까지 복사한 새로운 FileMeta 반환
+func (fm FileMeta) Clone() *FileMeta {
+	c := fm
+	c.ServerIPs = make(map[string]int, len(fm.ServerIPs))
+	for ip, n := range fm.ServerIPs {
+		c.ServerIPs[ip] = n
+	}
+	return &c
+}
+
 // parseHitcountFileAndUpdateFileMetas
 //
 // grade.info 파일을 parsing 한 후에 만들어지는 FileMeta map 정보에
@@ -138,6 +165,10 @@ func parseHitcountFileAndUpdateFileMetas(hitcountfileName string, fmm map[string
 //
 // 파일 상의 순서값이 저장됨. 이 순서값은 1부터 시작하고, 1씩 증가함
 //
+// FileMeta의 TargetCopyCount값에는 grade.info 파일의 TargetCopyCount column 값이 저장됨
+//
+// - column 이 없거나 숫자가 아니면 0
+//
 // 파일 이름, 등급 값 이외의 필드 값에는 초기값이 들어감
 func parseGradeFileAndNewFileMetas(fileName string, fmm map[string]*FileMeta) error {
 
@@ -158,7 +189,13 @@ func parseGradeFileAndNewFileMetas(fileName string, fmm map[string]*FileMeta) er
 		fileName := cols[0]
 		// 등급 파일 처러할 때, file meta가 처음으로 만들어진다고 가정
 		// - 등급 파일에는 file 이름이 unique 하다고 가정
-		fmm[fileName] = NewFileMetaWith(fileName, i)
+		fm := NewFileMetaWith(fileName, i)
+		if len(cols) > 6 {
+			if n, err := strconv.Atoi(strings.TrimSpace(cols[6])); err == nil {
+				fm.TargetCopyCount = n
+			}
+		}
+		fmm[fileName] = fm
 		i++
 	}
 
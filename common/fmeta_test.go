@@ -194,6 +194,7 @@ func Test_parseGradeFile(t *testing.T) {
 	assert.Equal(t, int32(2), fmm["BBBBBBBBBBBBBBBBBB_K20140501000000.mpg"].Grade)
 	assert.Equal(t, int32(9), fmm["JJJJJJJJJJJJJJJJJJ_K20180501000000.mpg"].Grade)
 	assert.Equal(t, int32(18), fmm["vod3-3.mpg"].Grade)
+	assert.Equal(t, 5, fmm["vod3-3.mpg"].TargetCopyCount)
 
 }
 
@@ -229,6 +230,61 @@ func Test_parseGradeFileWithDuplicatedFileNames(t *testing.T) {
 	assert.Equal(t, int32(5), fmm["A.mpg"].Grade)
 }
 
+func Test_parseGradeFileWithoutTargetCopyCount(t *testing.T) {
+
+	tmpFile := "grade.info"
+
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		f.Close()
+		t.Errorf("cannot create %s", tmpFile)
+	}
+	defer os.Remove(tmpFile)
+
+	fmt.Fprintf(f, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", "filename", "weightcount", "bitrate", "grade", "sumHitCount", "historyCount", "TargetCopyCount")
+	fmt.Fprintf(f, "%s\t%d\t%d\t%d\t%d\t%d\t%d\n", "A.mpg", 3225, 6443017, 1, 1210, 24, 3)
+	fmt.Fprintf(f, "%s\t%d\t%d\t%d\t%d\t%d\t%s\n", "B.mpg", 3225, 6443017, 1, 1210, 24, "x")
+	fmt.Fprintf(f, "%s\t%d\t%d\t%d\t%d\t%d\n", "C.mpg", 3225, 6443017, 1, 1210, 24)
+	f.Close()
+
+	fmm := make(map[string]*FileMeta)
+	assert.Nil(t, parseGradeFileAndNewFileMetas(tmpFile, fmm))
+
+	assert.Equal(t, 3, fmm["A.mpg"].TargetCopyCount)
+	// 숫자가 아니거나 column 이 없으면 0
+	assert.Equal(t, 0, fmm["B.mpg"].TargetCopyCount)
+	assert.Equal(t, 0, fmm["C.mpg"].TargetCopyCount)
+	assert.Equal(t, int32(3), fmm["C.mpg"].Grade)
+}
+
+func TestTargetCopyCountWithMax(t *testing.T) {
+	fm := NewFileMetaWith("A.mpg", 1)
+
+	// TargetCopyCount 값이 없으면 1
+	assert.Equal(t, 1, fm.TargetCopyCountWithMax(3))
+
+	fm.TargetCopyCount = 5
+	assert.Equal(t, 3, fm.TargetCopyCountWithMax(3))
+	assert.Equal(t, 5, fm.TargetCopyCountWithMax(10))
+	// max 가 1 보다 작으면 1
+	assert.Equal(t, 1, fm.TargetCopyCountWithMax(0))
+}
+
+func TestFileMetaClone(t *testing.T) {
+	fm := NewFileMetaWith("A.mpg", 1)
+	fm.ServerIPs["127.0.0.1"] = 1
+	fm.ServerCount = 1
+
+	c := fm.Clone()
+	assert.Equal(t, *fm, *c)
+
+	// 복사본을 바꿔도 원본은 바뀌지 않음
+	c.ServerIPs["127.0.0.1"]--
+	c.ServerCount--
+	assert.Equal(t, 1, fm.ServerIPs["127.0.0.1"])
+	assert.Equal(t, 1, fm.ServerCount)
+}
+
 func TestIsPrefix(t *testing.T) {
 
 	prefixes := []string{"M64", "MN1"}
@@ -0,0 +1,282 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FileList : 서버의 파일 목록과 목록을 구한 시간
+//
+// Delta : 전체 목록 대신 변경분(GET /files?since=token)으로 갱신한 목록인 지 여부
+type FileList struct {
+	Files   []string
+	Fetched time.Time
+	Delta   bool
+}
+
+// inventoryEntry : 서버 하나의 파일 목록
+//
+// files, set : CiMonitoringAgent 가 응답한 전체 목록에 변경분을 반영한 목록
+//
+// token : 다음 변경분 요청의 since, 비어있으면 전체 목록을 요청함
+//
+// local : 다음에 목록을 구할 때까지 반영할 삭제(false), 배포(true)된 파일,
+// 목록을 다시 구하면 CiMonitoringAgent 의 목록이 맞으므로 지움
+type inventoryEntry struct {
+	files   []string
+	set     map[string]struct{}
+	token   string
+	fetched time.Time
+	delta   bool
+	local   map[string]bool
+}
+
+func newInventoryEntry(files []string) *inventoryEntry {
+	e := &inventoryEntry{
+		files: make([]string, 0, len(files)),
+		set:   make(map[string]struct{}, len(files)),
+	}
+	for _, f := range files {
+		if _, ok := e.set[f]; ok {
+			continue
+		}
+		e.set[f] = struct{}{}
+		e.files = append(e.files, f)
+	}
+	return e
+}
+
+// apply : 변경분 반영, 이미 반영된 변경은 무시함
+//
+// 반환한 목록을 바꾸지 않도록 지운 파일이 있으면 새 목록을 만듬
+func (e *inventoryEntry) apply(d FileListDelta) {
+	removed := make(map[string]struct{}, len(d.Removed))
+	for _, f := range d.Removed {
+		if _, ok := e.set[f]; ok {
+			delete(e.set, f)
+			removed[f] = struct{}{}
+		}
+	}
+	if len(removed) > 0 {
+		files := make([]string, 0, len(e.files)-len(removed)+len(d.Added))
+		for _, f := range e.files {
+			if _, ok := removed[f]; !ok {
+				files = append(files, f)
+			}
+		}
+		e.files = files
+	}
+	for _, f := range d.Added {
+		if _, ok := e.set[f]; ok {
+			continue
+		}
+		e.set[f] = struct{}{}
+		e.files = append(e.files, f)
+	}
+}
+
+// view : local 을 반영한 파일 목록
+func (e *inventoryEntry) view() FileList {
+	l := FileList{Files: e.files, Fetched: e.fetched, Delta: e.delta}
+	if len(e.local) == 0 {
+		return l
+	}
+	files := make([]string, 0, len(e.files)+len(e.local))
+	for _, f := range e.files {
+		if added, ok := e.local[f]; ok && !added {
+			continue
+		}
+		files = append(files, f)
+	}
+	for f, added := range e.local {
+		if _, ok := e.set[f]; added && !ok {
+			files = append(files, f)
+		}
+	}
+	l.Files = files
+	return l
+}
+
+// Inventory : destination 서버별 파일 목록
+//
+// 한 번 구한 목록은 ttl 동안 다시 요청하지 않고 사용,
+// ttl 이 0 이면 매번 요청함
+//
+// CiMonitoringAgent 가 변경분 요청(GET /files?since=token)을 지원하면,
+// 처음에만 전체 목록을 요청하고 그 다음부터는 변경분을 요청해서 목록에 반영함,
+// 지원하지 않으면 매번 전체 목록을 요청함
+//
+// 삭제 요청에 성공한 파일, 배포가 끝난 파일은 Removed, Added 로 목록에 반영해서
+// 다시 요청하기 전까지 목록이 서버와 크게 다르지 않게 함
+//
+// FileLists 로 반환한 목록은 바꾸지 않음
+type Inventory struct {
+	mutex   *sync.Mutex
+	ttl     time.Duration
+	entries map[string]*inventoryEntry
+	now     func() time.Time
+}
+
+// NewInventory : ttl 동안 파일 목록을 cache 하는 Inventory 생성
+func NewInventory(ttl time.Duration) *Inventory {
+	return &Inventory{
+		mutex:   &sync.Mutex{},
+		ttl:     ttl,
+		entries: make(map[string]*inventoryEntry),
+		now:     time.Now,
+	}
+}
+
+// SetTTL : 파일 목록을 cache 하는 시간
+func (inv *Inventory) SetTTL(ttl time.Duration) {
+	inv.mutex.Lock()
+	defer inv.mutex.Unlock()
+	inv.ttl = ttl
+}
+
+// FileLists :
+//
+// 서버들의 파일 목록 반환, ttl 안에 구한 목록은 그대로 사용하고,
+// 나머지 서버는 SetFanOut 으로 설정한 FanOut 으로 동시에 요청함
+//
+// 변경분을 요청했는데 CiMonitoringAgent 가 since 를 모르면 전체 목록을 다시 요청함,
+// 같은 since 로 요청한 다른 변경분이 먼저 반영되었을 때도 전체 목록을 다시 요청함
+//
+// 목록을 구하지 못한 서버는 결과에 없고 FanOutReport.Failed 에 있음,
+// FanOutReport 는 요청한 서버에 대한 결과
+func (inv *Inventory) FileLists(hosts []*Host) (map[string]FileList, FanOutReport) {
+	lists := make(map[string]FileList, len(hosts))
+	stale := make([]*Host, 0, len(hosts))
+	tokens := make(map[string]string, len(hosts))
+
+	inv.mutex.Lock()
+	now := inv.now()
+	for _, h := range hosts {
+		e, ok := inv.entries[h.Addr]
+		if ok && inv.ttl > 0 && now.Sub(e.fetched) < inv.ttl {
+			lists[h.Addr] = e.view()
+			continue
+		}
+		if ok {
+			tokens[h.Addr] = e.token
+		}
+		stale = append(stale, h)
+	}
+	inv.mutex.Unlock()
+
+	if len(stale) == 0 {
+		return lists, FanOutReport{Failed: make(map[string]error)}
+	}
+
+	deltas, rep := fetchFileListDeltas(stale, tokens)
+	conflicted := inv.update(stale, deltas, tokens, lists)
+	if len(conflicted) == 0 {
+		return lists, rep
+	}
+
+	deltas, rrep := fetchFileListDeltas(conflicted, nil)
+	for addr, err := range rrep.Failed {
+		rep.Failed[addr] = err
+	}
+	for _, h := range inv.update(conflicted, deltas, nil, lists) {
+		rep.Failed[h.Addr] = errors.New(
+			fmt.Sprintf("conflicted file list of %s", h.Addr))
+	}
+	return lists, rep
+}
+
+// fetchFileListDeltas : 서버들에 tokens 의 since 로 변경분을 동시에 요청함
+//
+// since 가 없는 서버는 전체 목록을 요청함
+func fetchFileListDeltas(hosts []*Host,
+	tokens map[string]string) (map[string]FileListDelta, FanOutReport) {
+	client := GetAgentClient()
+	var mutex sync.Mutex
+	deltas := make(map[string]FileListDelta, len(hosts))
+	rep := GetFanOut().Run(hosts, func(ctx context.Context, h *Host) error {
+		d, err := client.FileListDelta(ctx, h, tokens[h.Addr])
+		if err == ErrInventoryTokenExpired {
+			d, err = client.FileListDelta(ctx, h, "")
+		}
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		deltas[h.Addr] = d
+		mutex.Unlock()
+		return nil
+	})
+	return deltas, rep
+}
+
+// update : 구한 변경분을 반영하고 lists 에 목록을 넣음
+//
+// 같은 since 로 요청한 다른 변경분이 먼저 반영된 서버는 목록을 지우고 반환함
+func (inv *Inventory) update(hosts []*Host, deltas map[string]FileListDelta,
+	tokens map[string]string, lists map[string]FileList) (conflicted []*Host) {
+	inv.mutex.Lock()
+	defer inv.mutex.Unlock()
+	now := inv.now()
+	for _, h := range hosts {
+		addr := h.Addr
+		d, found := deltas[addr]
+		if !found {
+			continue
+		}
+		e, ok := inv.entries[addr]
+		switch {
+		case d.Full:
+			e = newInventoryEntry(d.Files)
+		case !ok || e.token != tokens[addr]:
+			delete(inv.entries, addr)
+			conflicted = append(conflicted, h)
+			continue
+		default:
+			e.apply(d)
+		}
+		e.token = d.Token
+		e.fetched = now
+		e.delta = !d.Full
+		e.local = nil
+		lists[addr] = e.view()
+		if inv.ttl > 0 || e.token != "" {
+			inv.entries[addr] = e
+		} else {
+			delete(inv.entries, addr)
+		}
+	}
+	return conflicted
+}
+
+// Removed : 서버에서 파일이 지워짐, 다시 목록을 구할 때까지 목록에서 뺌
+func (inv *Inventory) Removed(addr, fileName string) {
+	inv.setLocal(addr, fileName, false)
+}
+
+// Added : 서버에 파일이 배포됨, 다시 목록을 구할 때까지 목록에 추가
+func (inv *Inventory) Added(addr, fileName string) {
+	inv.setLocal(addr, fileName, true)
+}
+
+func (inv *Inventory) setLocal(addr, fileName string, added bool) {
+	inv.mutex.Lock()
+	defer inv.mutex.Unlock()
+	e, ok := inv.entries[addr]
+	if !ok {
+		return
+	}
+	if e.local == nil {
+		e.local = make(map[string]bool)
+	}
+	e.local[fileName] = added
+}
+
+// Invalidate : 목록을 모두 지움, 다음 FileLists 에서 전체 목록을 다시 요청함
+func (inv *Inventory) Invalidate() {
+	inv.mutex.Lock()
+	defer inv.mutex.Unlock()
+	inv.entries = make(map[string]*inventoryEntry)
+}
@@ -0,0 +1,301 @@
+package common_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/castisdev/cfm/common"
+)
+
+// noRetry : 요청 수를 세는 test 에서 실패한 요청을 다시 하지 않도록 AgentClient 를 바꿈
+func noRetry(t *testing.T) {
+	old := common.GetAgentClient()
+	c, err := common.NewAgentClient(common.AgentClientConfig{Timeout: 5 * time.Second})
+	assert.Nil(t, err)
+	common.SetAgentClient(c)
+	t.Cleanup(func() { common.SetAgentClient(old) })
+}
+
+func TestInventory(t *testing.T) {
+	noRetry(t)
+	var requests int32
+	s1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, "A.mpg\nB.mpg\n")
+	}))
+	defer s1.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	h1 := &common.Host{Addr: strings.TrimPrefix(s1.URL, "http://")}
+	h2 := &common.Host{Addr: strings.TrimPrefix(broken.URL, "http://")}
+	hosts := []*common.Host{h1, h2}
+
+	inv := common.NewInventory(time.Minute)
+	before := time.Now()
+	lists, rep := inv.FileLists(hosts)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	assert.Equal(t, []string{"A.mpg", "B.mpg"}, lists[h1.Addr].Files)
+	assert.False(t, lists[h1.Addr].Fetched.Before(before))
+	assert.Equal(t, 1, len(rep.Failed))
+	_, ok := lists[h2.Addr]
+	assert.False(t, ok)
+
+	// ttl 안에는 다시 요청하지 않음, 실패한 서버는 다시 요청함
+	inv.Removed(h1.Addr, "A.mpg")
+	inv.Added(h1.Addr, "C.mpg")
+	inv.Added(h1.Addr, "C.mpg")
+	inv.Added(h2.Addr, "C.mpg")
+	cached, rep := inv.FileLists(hosts)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	assert.Equal(t, 1, rep.Total)
+	assert.Equal(t, []string{"B.mpg", "C.mpg"}, cached[h1.Addr].Files)
+	assert.Equal(t, lists[h1.Addr].Fetched, cached[h1.Addr].Fetched)
+	// 이전에 반환한 목록은 바뀌지 않음
+	assert.Equal(t, []string{"A.mpg", "B.mpg"}, lists[h1.Addr].Files)
+
+	inv.Invalidate()
+	lists, _ = inv.FileLists(hosts[:1])
+	assert.Equal(t, int32(4), atomic.LoadInt32(&requests))
+	assert.Equal(t, []string{"A.mpg", "B.mpg"}, lists[h1.Addr].Files)
+
+	// ttl 이 0 이면 매번 요청
+	inv.SetTTL(0)
+	inv.FileLists(hosts[:1])
+	inv.FileLists(hosts[:1])
+	assert.Equal(t, int32(6), atomic.LoadInt32(&requests))
+}
+
+// fakeAgent : 파일 목록 변경분 요청을 지원하거나(delta) 지원하지 않는 CiMonitoringAgent
+//
+// 파일이 바뀔 때마다 version 이 커지고, version 별 변경을 log 에 남김,
+// forget 하면 이전 version 의 변경분을 알 수 없게 됨(재시작)
+//
+// before 는 요청을 처리하기 전에 한 번 호출됨
+type fakeAgent struct {
+	before  func()
+	mutex   sync.Mutex
+	delta   bool
+	epoch   int
+	version int
+	files   map[string]bool
+	log     []fakeChange
+	full    int
+	deltas  int
+}
+
+type fakeChange struct {
+	version int
+	file    string
+	added   bool
+}
+
+func newFakeAgent(delta bool, files ...string) *fakeAgent {
+	a := &fakeAgent{delta: delta, files: make(map[string]bool)}
+	for _, f := range files {
+		a.files[f] = true
+	}
+	return a
+}
+
+func (a *fakeAgent) change(file string, added bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.version++
+	if added {
+		a.files[file] = true
+	} else {
+		delete(a.files, file)
+	}
+	a.log = append(a.log, fakeChange{a.version, file, added})
+}
+
+func (a *fakeAgent) forget() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.epoch++
+	a.log = nil
+}
+
+func (a *fakeAgent) token() string {
+	return fmt.Sprintf("%d.%d", a.epoch, a.version)
+}
+
+func (a *fakeAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if before := a.before; before != nil {
+		a.before = nil
+		before()
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	since := r.URL.Query().Get("since")
+	if !a.delta || since == "" {
+		a.full++
+		if a.delta {
+			w.Header().Set(common.InventoryTokenHeader, a.token())
+		}
+		names := make([]string, 0, len(a.files))
+		for f := range a.files {
+			names = append(names, f)
+		}
+		sort.Strings(names)
+		for _, f := range names {
+			fmt.Fprintln(w, f)
+		}
+		return
+	}
+
+	var epoch, version int
+	fmt.Sscanf(since, "%d.%d", &epoch, &version)
+	if epoch != a.epoch || version > a.version ||
+		(version < a.version && (len(a.log) == 0 || a.log[0].version > version+1)) {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	a.deltas++
+	w.Header().Set(common.InventoryTokenHeader, a.token())
+	w.Header().Set(common.InventoryDeltaHeader, "true")
+	for _, c := range a.log {
+		if c.version <= version {
+			continue
+		}
+		if c.added {
+			fmt.Fprintln(w, "+"+c.file)
+		} else {
+			fmt.Fprintln(w, "-"+c.file)
+		}
+	}
+}
+
+func sortedFiles(l common.FileList) []string {
+	fl := append([]string{}, l.Files...)
+	sort.Strings(fl)
+	return fl
+}
+
+func TestInventory_Delta(t *testing.T) {
+	agent := newFakeAgent(true, "A.mpg", "B.mpg")
+	s := httptest.NewServer(agent)
+	defer s.Close()
+	h := &common.Host{Addr: strings.TrimPrefix(s.URL, "http://")}
+	hosts := []*common.Host{h}
+
+	// 처음에는 전체 목록
+	inv := common.NewInventory(0)
+	lists, rep := inv.FileLists(hosts)
+	assert.Equal(t, 1, rep.Succeeded())
+	assert.False(t, lists[h.Addr].Delta)
+	assert.Equal(t, []string{"A.mpg", "B.mpg"}, sortedFiles(lists[h.Addr]))
+	assert.Equal(t, 1, agent.full)
+
+	// 그 다음부터는 변경분
+	agent.change("C.mpg", true)
+	agent.change("A.mpg", false)
+	agent.change("D.mpg", true)
+	agent.change("D.mpg", false)
+	lists, _ = inv.FileLists(hosts)
+	assert.True(t, lists[h.Addr].Delta)
+	assert.Equal(t, []string{"B.mpg", "C.mpg"}, sortedFiles(lists[h.Addr]))
+	assert.Equal(t, 1, agent.deltas)
+
+	// 변경이 없으면 빈 변경분
+	inv.Removed(h.Addr, "B.mpg")
+	lists, _ = inv.FileLists(hosts)
+	assert.Equal(t, []string{"B.mpg", "C.mpg"}, sortedFiles(lists[h.Addr]))
+	assert.Equal(t, 2, agent.deltas)
+
+	// agent 가 재시작해서 since 를 모르면 전체 목록을 다시 요청
+	agent.forget()
+	agent.change("E.mpg", true)
+	lists, _ = inv.FileLists(hosts)
+	assert.False(t, lists[h.Addr].Delta)
+	assert.Equal(t, []string{"B.mpg", "C.mpg", "E.mpg"}, sortedFiles(lists[h.Addr]))
+	assert.Equal(t, 2, agent.full)
+
+	agent.change("B.mpg", false)
+	lists, _ = inv.FileLists(hosts)
+	assert.True(t, lists[h.Addr].Delta)
+	assert.Equal(t, []string{"C.mpg", "E.mpg"}, sortedFiles(lists[h.Addr]))
+	assert.Equal(t, 2, agent.full)
+	assert.Equal(t, 3, agent.deltas)
+}
+
+func TestInventory_Full(t *testing.T) {
+	// 변경분 요청을 지원하지 않는 agent 는 since 를 무시하고 전체 목록을 응답함
+	agent := newFakeAgent(false, "A.mpg", "B.mpg")
+	s := httptest.NewServer(agent)
+	defer s.Close()
+	h := &common.Host{Addr: strings.TrimPrefix(s.URL, "http://")}
+	hosts := []*common.Host{h}
+
+	inv := common.NewInventory(0)
+	inv.FileLists(hosts)
+	agent.change("C.mpg", true)
+	agent.change("A.mpg", false)
+	lists, _ := inv.FileLists(hosts)
+	assert.False(t, lists[h.Addr].Delta)
+	assert.Equal(t, []string{"B.mpg", "C.mpg"}, sortedFiles(lists[h.Addr]))
+	assert.Equal(t, 2, agent.full)
+	assert.Equal(t, 0, agent.deltas)
+
+	// 잘못된 변경분은 실패로 처리
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(common.InventoryTokenHeader, "1")
+		if r.URL.Query().Get("since") != "" {
+			w.Header().Set(common.InventoryDeltaHeader, "true")
+			fmt.Fprintln(w, "A.mpg")
+			return
+		}
+		fmt.Fprintln(w, "A.mpg")
+	}))
+	defer bad.Close()
+	bh := &common.Host{Addr: strings.TrimPrefix(bad.URL, "http://")}
+	lists, rep := inv.FileLists([]*common.Host{bh})
+	assert.Equal(t, []string{"A.mpg"}, lists[bh.Addr].Files)
+	lists, rep = inv.FileLists([]*common.Host{bh})
+	assert.Empty(t, lists)
+	assert.Equal(t, 1, len(rep.Failed))
+}
+
+func TestInventory_Conflict(t *testing.T) {
+	agent := newFakeAgent(true, "A.mpg", "B.mpg")
+	s := httptest.NewServer(agent)
+	defer s.Close()
+	h := &common.Host{Addr: strings.TrimPrefix(s.URL, "http://")}
+	hosts := []*common.Host{h}
+
+	inv := common.NewInventory(0)
+	inv.FileLists(hosts)
+
+	// 같은 since 로 요청한 다른 변경분이 먼저 반영되면, 전체 목록을 다시 요청함
+	agent.change("C.mpg", true)
+	agent.before = func() {
+		lists, _ := inv.FileLists(hosts)
+		assert.True(t, lists[h.Addr].Delta)
+		agent.change("A.mpg", false)
+	}
+	lists, rep := inv.FileLists(hosts)
+	assert.Equal(t, 0, len(rep.Failed))
+	assert.False(t, lists[h.Addr].Delta)
+	assert.Equal(t, []string{"B.mpg", "C.mpg"}, sortedFiles(lists[h.Addr]))
+	assert.Equal(t, 2, agent.full)
+	assert.Equal(t, 2, agent.deltas)
+
+	// 다시 구한 전체 목록 다음부터는 변경분
+	agent.change("D.mpg", true)
+	lists, _ = inv.FileLists(hosts)
+	assert.True(t, lists[h.Addr].Delta)
+	assert.Equal(t, []string{"B.mpg", "C.mpg", "D.mpg"}, sortedFiles(lists[h.Addr]))
+}
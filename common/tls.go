@@ -0,0 +1,82 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSFiles : TLS 인증서 파일
+//
+// CertFile, KeyFile : 자신의 인증서와 key(PEM),
+// server 는 반드시 있어야 하고, client 는 server 가 client 인증서를 요구(mTLS)할 때 사용
+//
+// CAFile : 상대방 인증서를 검증하는 CA 인증서(PEM),
+// server 는 이 CA 가 발급한 client 인증서를 요구(mTLS)하고,
+// client 는 server 인증서를 이 CA 로 검증, 비어있으면 system CA 로 검증
+type TLSFiles struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+// ServerConfig : http.Server 에서 사용할 tls.Config
+func (f TLSFiles) ServerConfig() (*tls.Config, error) {
+	if f.CertFile == "" || f.KeyFile == "" {
+		return nil, errors.New("cert file and key file are required")
+	}
+	cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	c := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if f.CAFile != "" {
+		pool, err := loadCertPool(f.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.ClientCAs = pool
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return c, nil
+}
+
+// ClientConfig : http.Client 에서 사용할 tls.Config
+func (f TLSFiles) ClientConfig() (*tls.Config, error) {
+	if (f.CertFile == "") != (f.KeyFile == "") {
+		return nil, errors.New("cert file and key file must be set together")
+	}
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+	if f.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	if f.CAFile != "" {
+		pool, err := loadCertPool(f.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = pool
+	}
+	return c, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New(fmt.Sprintf("no certificate in ca file(%s)", file))
+	}
+	return pool, nil
+}
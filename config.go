@@ -1,33 +1,163 @@
 package main
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"regexp"
+	"time"
 
+	"github.com/castisdev/cfm/api"
+	"github.com/castisdev/cfm/common"
 	"github.com/castisdev/cfm/fmfm"
+	"github.com/castisdev/cfm/membership"
+	"github.com/castisdev/cfm/tasker"
 	"github.com/castisdev/cilog"
 	"github.com/spf13/viper"
 )
 
+// ServerSlots : 서버별 동시 task 개수
+type ServerSlots struct {
+	Addr  string `mapstructure:"addr"`
+	Slots int    `mapstructure:"slots"`
+}
+
 // Server :
 type Server struct {
-	Sources             []string `mapstructure:"sources"`
-	Destinations        []string `mapstructure:"destinations"`
-	HeartbeatTimeoutSec uint     `mapstructure:"heartbeat_timeout_sec"`
-	HeartbeatSec        uint     `mapstructure:"heartbeat_interval_sec"`
+	Sources                 []string      `mapstructure:"sources"`
+	Destinations            []string      `mapstructure:"destinations"`
+	HeartbeatTimeoutSec     uint          `mapstructure:"heartbeat_timeout_sec"`
+	HeartbeatSec            uint          `mapstructure:"heartbeat_interval_sec"`
+	DefaultSourceSlots      int           `mapstructure:"default_source_slots"`
+	DefaultDestinationSlots int           `mapstructure:"default_destination_slots"`
+	SourceSlots             []ServerSlots `mapstructure:"source_slots"`
+	DestinationSlots        []ServerSlots `mapstructure:"destination_slots"`
+	StorePath               string        `mapstructure:"store_path"`
+}
+
+func (s *Server) validate() error {
+	if s.DefaultSourceSlots < 1 {
+		return errors.New(
+			fmt.Sprintf("%d in servers.default_source_slots:, must be greater than 0",
+				s.DefaultSourceSlots))
+	}
+	if s.DefaultDestinationSlots < 0 {
+		return errors.New(
+			fmt.Sprintf("%d in servers.default_destination_slots:, must not be negative",
+				s.DefaultDestinationSlots))
+	}
+	for _, ss := range s.SourceSlots {
+		if ss.Slots < 1 {
+			return errors.New(
+				fmt.Sprintf("%d of %s in servers.source_slots:, must be greater than 0",
+					ss.Slots, ss.Addr))
+		}
+	}
+	for _, ss := range s.DestinationSlots {
+		if ss.Slots < 0 {
+			return errors.New(
+				fmt.Sprintf("%d of %s in servers.destination_slots:, must not be negative",
+					ss.Slots, ss.Addr))
+		}
+	}
+	return nil
+}
+
+// SourceSlotsOf : source 서버의 slot 개수,
+// servers.source_slots 에 없으면 servers.default_source_slots
+func (s *Server) SourceSlotsOf(addr string) int {
+	for _, ss := range s.SourceSlots {
+		if ss.Addr == addr {
+			return ss.Slots
+		}
+	}
+	return s.DefaultSourceSlots
+}
+
+// DestinationSlotsOf : destination 서버의 slot 개수,
+// servers.destination_slots 에 없으면 servers.default_destination_slots
+func (s *Server) DestinationSlotsOf(addr string) int {
+	for _, ss := range s.DestinationSlots {
+		if ss.Addr == addr {
+			return ss.Slots
+		}
+	}
+	return s.DefaultDestinationSlots
+}
+
+// members : 설정 파일의 source, destination 서버 목록을 slot 개수와 함께 반환
+func (s *Server) members() (srcs, dsts []membership.Server) {
+	srcs = make([]membership.Server, 0, len(s.Sources))
+	for _, h := range s.Sources {
+		srcs = append(srcs, membership.Server{Addr: h, Slots: s.SourceSlotsOf(h)})
+	}
+	dsts = make([]membership.Server, 0, len(s.Destinations))
+	for _, h := range s.Destinations {
+		dsts = append(dsts, membership.Server{Addr: h, Slots: s.DestinationSlotsOf(h)})
+	}
+	return srcs, dsts
 }
 
 type Remover struct {
-	RemoverSleepSec          uint `mapstructure:"remover_sleep_sec"`
-	StorageUsageLimitPercent uint `mapstructure:"storage_usage_limit_percent"`
+	RemoverSleepSec          uint   `mapstructure:"remover_sleep_sec"`
+	StorageUsageLimitPercent uint   `mapstructure:"storage_usage_limit_percent"`
+	PinsPath                 string `mapstructure:"pins_path"`
+}
+
+func (r *Remover) validate() error {
+	if r.StorageUsageLimitPercent > 100 {
+		return errors.New(
+			fmt.Sprintf("%d in remover.storage_usage_limit_percent:, must not be greater than 100",
+				r.StorageUsageLimitPercent))
+	}
+	return nil
 }
 
 type Tasker struct {
-	TaskerSleepSec   uint   `mapstructure:"tasker_sleep_sec"`
-	TaskTimeout      int64  `mapstructure:"task_timeout_sec"`
-	TaskCopySpeedBPS string `mapstructure:"task_copy_speed_bps"`
+	TaskerSleepSec    uint   `mapstructure:"tasker_sleep_sec"`
+	TaskTimeout       int64  `mapstructure:"task_timeout_sec"`
+	TaskCopySpeedBPS  string `mapstructure:"task_copy_speed_bps"`
+	PlacementStrategy string `mapstructure:"placement_strategy"`
+	PlacementHotGrade int32  `mapstructure:"placement_hot_grade"`
+	HistoryRetention  uint   `mapstructure:"history_retention_hours"`
+	RetryBackoffBase  uint   `mapstructure:"retry_backoff_base_sec"`
+	RetryBackoffMax   uint   `mapstructure:"retry_backoff_max_sec"`
+	QuarantineLimit   int    `mapstructure:"quarantine_timeouts"`
+	StoreBackend      string `mapstructure:"store_backend"`
+	StorePath         string `mapstructure:"store_path"`
+	HistoryPath       string `mapstructure:"history_path"`
+}
+
+func (t *Tasker) validate() error {
+	if tasker.ToPlacement(t.PlacementStrategy) == 0 {
+		return errors.New(
+			fmt.Sprintf("%s in tasker.placement_strategy:, invalid placement", t.PlacementStrategy))
+	}
+	if tasker.ToStoreBackend(t.StoreBackend) == 0 {
+		return errors.New(
+			fmt.Sprintf("%s in tasker.store_backend:, invalid store backend", t.StoreBackend))
+	}
+	if t.HistoryRetention < 1 {
+		return errors.New(
+			fmt.Sprintf("%d in tasker.history_retention_hours:, must be greater than 0", t.HistoryRetention))
+	}
+	if t.RetryBackoffBase < 1 {
+		return errors.New(
+			fmt.Sprintf("%d in tasker.retry_backoff_base_sec:, must be greater than 0", t.RetryBackoffBase))
+	}
+	if t.RetryBackoffMax < t.RetryBackoffBase {
+		return errors.New(
+			fmt.Sprintf("%d in tasker.retry_backoff_max_sec:, must not be less than retry_backoff_base_sec(%d)",
+				t.RetryBackoffMax, t.RetryBackoffBase))
+	}
+	if t.QuarantineLimit < 1 {
+		return errors.New(
+			fmt.Sprintf("%d in tasker.quarantine_timeouts:, must be greater than 0", t.QuarantineLimit))
+	}
+	return nil
 }
 
 type Ignore struct {
@@ -40,10 +170,15 @@ type Watcher struct {
 	PollingSec       uint32 `mapstructure:"poll_interval_sec"`
 }
 
+// Runner :
+//
+// InventoryTTLSec : remover, tasker 가 함께 사용하는 destination 서버 파일 목록을
+// 다시 요청하지 않고 사용하는 시간(초), 0 이면 매번 요청함
 type Runner struct {
 	BetweenEventsRunSec uint32              `mapstructure:"between_events_run_interval_sec"`
 	PeriodicRunSec      uint32              `mapstructure:"periodic_run_interval_sec"`
 	SetupRuns           map[string][]string `mapstructure:"setup_runs"`
+	InventoryTTLSec     uint                `mapstructure:"inventory_ttl_sec"`
 }
 
 func (r *Runner) validate() error {
@@ -62,25 +197,223 @@ func (r *Runner) validate() error {
 	return nil
 }
 
+// DecisionLog : tasker, remover 의 파일별 결정 기록
+//
+// Dir : 기록 파일 directory, 비어있으면 log_dir/decision
+//
+// MaxSize : 기록 파일 최대 크기(byte), 넘으면 rotate
+//
+// MaxBackups : rotate 된 기록 파일을 남기는 개수
+type DecisionLog struct {
+	Dir        string `mapstructure:"dir"`
+	MaxSize    int64  `mapstructure:"max_size"`
+	MaxBackups int    `mapstructure:"max_backups"`
+}
+
+func (d *DecisionLog) validate() error {
+	if d.MaxSize < 1 {
+		return errors.New(
+			fmt.Sprintf("%d in decision_log.max_size:, must be greater than 0", d.MaxSize))
+	}
+	if d.MaxBackups < 0 {
+		return errors.New(
+			fmt.Sprintf("%d in decision_log.max_backups:, must not be negative", d.MaxBackups))
+	}
+	return nil
+}
+
+// Leader : active-standby 모드 설정
+//
+// LockFile : 두 cfm 이 공유하는 lock 파일 경로, 비어있으면 항상 leader
+//
+// LeaseSec : leader 가 lease 를 갱신하지 않으면 standby 가 leader 가 되기까지 기다리는 시간(초)
+//
+// RenewSec : leader 가 lease 를 갱신하고, standby 가 leader 가 될 수 있는 지 검사하는 주기(초)
+//
+// ID : lock 파일에 기록하는 id, 비어있으면 hostname/pid
+type Leader struct {
+	LockFile string `mapstructure:"lock_file"`
+	LeaseSec uint   `mapstructure:"lease_sec"`
+	RenewSec uint   `mapstructure:"renew_interval_sec"`
+	ID       string `mapstructure:"id"`
+}
+
+func (l *Leader) validate() error {
+	if l.LockFile == "" {
+		return nil
+	}
+	if l.RenewSec < 1 {
+		return errors.New(
+			fmt.Sprintf("%d in leader.renew_interval_sec:, must be greater than 0", l.RenewSec))
+	}
+	if l.LeaseSec <= l.RenewSec {
+		return errors.New(
+			fmt.Sprintf("%d in leader.lease_sec:, must be greater than renew_interval_sec", l.LeaseSec))
+	}
+	return nil
+}
+
+// Agent : destination 서버의 CiMonitoringAgent 에 요청하는 설정
+//
+// Parallelism : 파일 목록, disk 사용량을 동시에 요청하는 최대 서버 수
+//
+// TimeoutSec : 서버별 파일 목록, disk 사용량 요청 제한 시간(초),
+// 넘으면 그 서버만 실패로 처리하고 나머지 서버의 결과로 remover, tasker 를 실행함,
+// 파일 삭제 요청에도 사용함
+//
+// RetryCount : 연결하지 못했거나 5xx 응답일 때 다시 요청하는 최대 횟수
+//
+// RetryBackoffMs : 처음 다시 요청하기 전에 기다리는 시간(ms), 다시 요청할 때마다 두 배로 늘어남
+type Agent struct {
+	Parallelism    int    `mapstructure:"parallelism"`
+	TimeoutSec     uint   `mapstructure:"timeout_sec"`
+	RetryCount     int    `mapstructure:"retry_count"`
+	RetryBackoffMs uint   `mapstructure:"retry_backoff_ms"`
+	TLS            TLS    `mapstructure:"tls"`
+	Token          string `mapstructure:"token"`
+}
+
+func (a *Agent) validate() error {
+	if a.Parallelism < 1 {
+		return errors.New(
+			fmt.Sprintf("%d in agent.parallelism:, must be greater than 0", a.Parallelism))
+	}
+	if a.TimeoutSec < 1 {
+		return errors.New(
+			fmt.Sprintf("%d in agent.timeout_sec:, must be greater than 0", a.TimeoutSec))
+	}
+	if a.RetryCount < 0 {
+		return errors.New(
+			fmt.Sprintf("%d in agent.retry_count:, must not be negative", a.RetryCount))
+	}
+	if _, err := a.agentClient(); err != nil {
+		return errors.New(fmt.Sprintf("agent.tls:, %s", err))
+	}
+	return nil
+}
+
+// fanOut : 파일 목록, disk 사용량을 요청할 때 사용하는 common.FanOut
+func (a *Agent) fanOut() common.FanOut {
+	return common.FanOut{
+		Parallelism: a.Parallelism,
+		Timeout:     time.Duration(a.TimeoutSec) * time.Second,
+	}
+}
+
+// agentClient : remover, tasker, heartbeater 가 사용할 common.AgentClient 설정
+//
+// tls.enabled 이면 인증서 파일을 읽어서 https 로 요청하도록 설정
+func (a *Agent) agentClient() (common.AgentClientConfig, error) {
+	cfg := common.AgentClientConfig{
+		Timeout: time.Duration(a.TimeoutSec) * time.Second,
+		Retry: common.AgentRetry{
+			Count:   a.RetryCount,
+			Backoff: time.Duration(a.RetryBackoffMs) * time.Millisecond,
+		},
+		Token: a.Token,
+	}
+	if a.TLS.Enabled {
+		tc, err := a.TLS.files().ClientConfig()
+		if err != nil {
+			return cfg, err
+		}
+		cfg.TLS = tc
+	}
+	return cfg, nil
+}
+
+// TLS : TLS 설정
+//
+// Enabled : true 이면 TLS 사용
+//
+// CertFile, KeyFile, CAFile : common.TLSFiles 참고
+type TLS struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	CAFile   string `mapstructure:"ca_file"`
+}
+
+func (t *TLS) files() common.TLSFiles {
+	return common.TLSFiles{CertFile: t.CertFile, KeyFile: t.KeyFile, CAFile: t.CAFile}
+}
+
+// API : cfm API server 설정
+//
+// TLS : enabled 이면 cert_file, key_file 로 https 로 응답,
+// ca_file 이 있으면 이 CA 가 발급한 client 인증서를 요구함(mTLS)
+//
+// Token, HMACSecret : 조회가 아닌 요청의 인증 방법, api.Auth 참고
+//
+// HMACMaxSkewSec : HMAC 인증의 timestamp 와 현재 시간의 최대 차이(초)
+type API struct {
+	TLS            TLS    `mapstructure:"tls"`
+	Token          string `mapstructure:"token"`
+	HMACSecret     string `mapstructure:"hmac_secret"`
+	HMACMaxSkewSec uint   `mapstructure:"hmac_max_skew_sec"`
+}
+
+func (a *API) validate() error {
+	if _, err := a.tlsConfig(); err != nil {
+		return errors.New(fmt.Sprintf("api.tls:, %s", err))
+	}
+	if a.HMACSecret != "" && a.HMACMaxSkewSec < 1 {
+		return errors.New(
+			fmt.Sprintf("%d in api.hmac_max_skew_sec:, must be greater than 0", a.HMACMaxSkewSec))
+	}
+	return nil
+}
+
+// tlsConfig : http.Server 에서 사용할 tls.Config, tls.enabled 가 아니면 nil
+func (a *API) tlsConfig() (*tls.Config, error) {
+	if !a.TLS.Enabled {
+		return nil, nil
+	}
+	return a.TLS.files().ServerConfig()
+}
+
+// auth : 조회가 아닌 요청의 인증 방법
+func (a *API) auth() api.Auth {
+	return api.Auth{
+		Token:      a.Token,
+		HMACSecret: a.HMACSecret,
+		MaxSkew:    time.Duration(a.HMACMaxSkewSec) * time.Second,
+	}
+}
+
 // Config :
 type Config struct {
-	SourceDirs          []string `mapstructure:"source_dirs"`
-	HitcountHistoryFile string   `mapstructure:"hitcount_history_file"`
-	GradeInfoFile       string   `mapstructure:"grade_info_file"`
-	LogDir              string   `mapstructure:"log_dir"`
-	LogLevel            string   `mapstructure:"log_level"`
-	Servers             Server   `mapstructure:"servers"`
-	WatchDir            string   `mapstructure:"watch_dir"`
-	WatchIPString       string   `mapstructure:"watch_ip_string"`
-	WatchTermMin        int      `mapstructure:"watch_term_min"`
-	WatchHitBase        int      `mapstructure:"watch_hit_base"`
-	EnableCoreDump      bool     `mapstructure:"enable_coredump"`
-	ListenAddr          string   `mapstructure:"listen_addr"`
-	Remover             Remover  `mapstructure:"remover"`
-	Tasker              Tasker   `mapstructure:"tasker"`
-	Ignore              Ignore   `mapstructure:"ignore"`
-	Watcher             Watcher  `mapstructure:"watcher"`
-	Runner              Runner   `mapstructure:"runner"`
+	SourceDirs          []string    `mapstructure:"source_dirs"`
+	HitcountHistoryFile string      `mapstructure:"hitcount_history_file"`
+	GradeInfoFile       string      `mapstructure:"grade_info_file"`
+	LogDir              string      `mapstructure:"log_dir"`
+	LogLevel            string      `mapstructure:"log_level"`
+	Servers             Server      `mapstructure:"servers"`
+	WatchDir            string      `mapstructure:"watch_dir"`
+	WatchIPString       string      `mapstructure:"watch_ip_string"`
+	WatchTermMin        int         `mapstructure:"watch_term_min"`
+	WatchHitBase        int         `mapstructure:"watch_hit_base"`
+	MaxCopyCount        int         `mapstructure:"max_copy_count"`
+	EnableCoreDump      bool        `mapstructure:"enable_coredump"`
+	ListenAddr          string      `mapstructure:"listen_addr"`
+	ShutdownTimeoutSec  uint        `mapstructure:"shutdown_timeout_sec"`
+	Remover             Remover     `mapstructure:"remover"`
+	Tasker              Tasker      `mapstructure:"tasker"`
+	Ignore              Ignore      `mapstructure:"ignore"`
+	Watcher             Watcher     `mapstructure:"watcher"`
+	Runner              Runner      `mapstructure:"runner"`
+	DecisionLog         DecisionLog `mapstructure:"decision_log"`
+	Leader              Leader      `mapstructure:"leader"`
+	Agent               Agent       `mapstructure:"agent"`
+	API                 API         `mapstructure:"api"`
+}
+
+// DecisionLogDir : decision_log.dir, 비어있으면 log_dir/decision
+func (c *Config) DecisionLogDir() string {
+	if c.DecisionLog.Dir != "" {
+		return c.DecisionLog.Dir
+	}
+	return filepath.Join(c.LogDir, "decision")
 }
 
 // ReadConfig :
@@ -89,18 +422,39 @@ func ReadConfig(configFile string) (*Config, error) {
 	viper.SetDefault("log_level", "info")
 	viper.SetDefault("servers.heartbeat_timeout_sec", uint(5))
 	viper.SetDefault("servers.heartbeat_interval_sec", uint(30))
+	viper.SetDefault("servers.default_source_slots", 1)
+	viper.SetDefault("servers.default_destination_slots", 0)
 	viper.SetDefault("enable_coredump", true)
 	viper.SetDefault("listen_addr", "127.0.0.1:8080")
+	viper.SetDefault("shutdown_timeout_sec", uint(30))
+	viper.SetDefault("max_copy_count", 1)
 	viper.SetDefault("remover.remover_sleep_sec", uint(30))
 	viper.SetDefault("remover.storage_usage_limit_percent", uint(90))
 	viper.SetDefault("tasker.tasker_sleep_sec", 60)
 	viper.SetDefault("tasker.task_timeout_sec", 3600)
 	viper.SetDefault("tasker.task_copy_speed_bps", "10000000")
+	viper.SetDefault("tasker.placement_strategy", "roundrobin")
+	viper.SetDefault("tasker.placement_hot_grade", int32(1000))
+	viper.SetDefault("tasker.history_retention_hours", uint(168))
+	viper.SetDefault("tasker.retry_backoff_base_sec", uint(60))
+	viper.SetDefault("tasker.retry_backoff_max_sec", uint(3600))
+	viper.SetDefault("tasker.quarantine_timeouts", 5)
+	viper.SetDefault("tasker.store_backend", "leveldb")
 	viper.SetDefault("watcher.fire_initial_event", true)
 	viper.SetDefault("watcher.event_timeout_sec", uint32(3600))
 	viper.SetDefault("watcher.poll_interval_sec", uint32(60))
 	viper.SetDefault("runner.between_events_run_interval_sec", uint32(60))
 	viper.SetDefault("runner.periodic_run_interval_sec", uint32(0))
+	viper.SetDefault("runner.inventory_ttl_sec", uint(60))
+	viper.SetDefault("decision_log.max_size", int64(100*1024*1024))
+	viper.SetDefault("decision_log.max_backups", 10)
+	viper.SetDefault("leader.lease_sec", uint(30))
+	viper.SetDefault("leader.renew_interval_sec", uint(10))
+	viper.SetDefault("agent.parallelism", common.DefaultFanOutParallelism)
+	viper.SetDefault("agent.timeout_sec", uint(common.DefaultFanOutTimeout/time.Second))
+	viper.SetDefault("agent.retry_count", common.DefaultAgentRetryCount)
+	viper.SetDefault("agent.retry_backoff_ms", uint(common.DefaultAgentRetryBackoff/time.Millisecond))
+	viper.SetDefault("api.hmac_max_skew_sec", uint(300))
 
 	var c Config
 	viper.SetConfigFile(configFile)
@@ -134,9 +488,112 @@ func ValidationConfig(c Config) error {
 		return errors.New(fmt.Sprintf("invalid listen_addr : error(%s)", err))
 	}
 
+	if c.ShutdownTimeoutSec < 1 {
+		return errors.New(fmt.Sprintf("invalid shutdown_timeout_sec : error(%d, must be greater than 0)",
+			c.ShutdownTimeoutSec))
+	}
+
+	if err := c.Servers.validate(); err != nil {
+		return errors.New(fmt.Sprintf("invalid servers : error(%s)", err))
+	}
+
+	if c.MaxCopyCount < 1 {
+		return errors.New(fmt.Sprintf("invalid max_copy_count : error(%d, must be greater than 0)",
+			c.MaxCopyCount))
+	}
+
+	if err := c.Remover.validate(); err != nil {
+		return errors.New(fmt.Sprintf("invalid remover : error(%s)", err))
+	}
+
+	if err := c.Tasker.validate(); err != nil {
+		return errors.New(fmt.Sprintf("invalid tasker : error(%s)", err))
+	}
+
 	if err := c.Runner.validate(); err != nil {
 		return errors.New(fmt.Sprintf("invalid runner : error(%s)", err))
 	}
 
+	if err := c.DecisionLog.validate(); err != nil {
+		return errors.New(fmt.Sprintf("invalid decision_log : error(%s)", err))
+	}
+
+	if err := c.Leader.validate(); err != nil {
+		return errors.New(fmt.Sprintf("invalid leader : error(%s)", err))
+	}
+
+	if err := c.Agent.validate(); err != nil {
+		return errors.New(fmt.Sprintf("invalid agent : error(%s)", err))
+	}
+
+	if err := c.API.validate(); err != nil {
+		return errors.New(fmt.Sprintf("invalid api : error(%s)", err))
+	}
+
 	return nil
 }
+
+// CheckConfig :
+//
+// ValidationConfig 보다 자세한 검사, -check-config 에서 사용
+//
+// 	- grade_info_file, hitcount_history_file 을 읽을 수 있어야 함
+//
+// 	- watch_dir 이 있어야 함
+//
+// 	- watch_ip_string 이 정규식이어야 함
+//
+// 	- servers.sources, servers.destinations 의 서버가 ip:port 형식이고 중복되지 않아야 함
+//
+// 찾은 문제를 모두 반환
+func CheckConfig(c Config) []error {
+	errs := make([]error, 0)
+	for _, f := range []struct {
+		key  string
+		path string
+	}{
+		{"grade_info_file", c.GradeInfoFile},
+		{"hitcount_history_file", c.HitcountHistoryFile},
+	} {
+		fd, err := os.Open(f.path)
+		if err != nil {
+			errs = append(errs, errors.New(
+				fmt.Sprintf("invalid %s : error(%s)", f.key, err)))
+			continue
+		}
+		fd.Close()
+	}
+
+	if fi, err := os.Stat(c.WatchDir); err != nil {
+		errs = append(errs, errors.New(fmt.Sprintf("invalid watch_dir : error(%s)", err)))
+	} else if !fi.IsDir() {
+		errs = append(errs, errors.New(
+			fmt.Sprintf("invalid watch_dir : error(%s is not a directory)", c.WatchDir)))
+	}
+
+	if _, err := regexp.Compile(c.WatchIPString); err != nil {
+		errs = append(errs, errors.New(fmt.Sprintf("invalid watch_ip_string : error(%s)", err)))
+	}
+
+	for _, l := range []struct {
+		key   string
+		hosts []string
+	}{
+		{"servers.sources", c.Servers.Sources},
+		{"servers.destinations", c.Servers.Destinations},
+	} {
+		hs := make(map[string]bool)
+		for _, h := range l.hosts {
+			if _, err := common.SplitHostPort(h); err != nil {
+				errs = append(errs, errors.New(
+					fmt.Sprintf("invalid %s : error(%s, %s)", l.key, h, err)))
+			}
+			if hs[h] {
+				errs = append(errs, errors.New(
+					fmt.Sprintf("invalid %s : error(duplicated host %s)", l.key, h)))
+			}
+			hs[h] = true
+		}
+	}
+	return errs
+}
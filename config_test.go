@@ -8,7 +8,9 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/castisdev/cfm/common"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
@@ -216,6 +218,184 @@ func TestConfigRunner(t *testing.T) {
 	}
 }
 
+func TestConfigServerSlots(t *testing.T) {
+	s := Server{
+		DefaultSourceSlots:      2,
+		DefaultDestinationSlots: 1,
+		SourceSlots:             []ServerSlots{{Addr: "127.0.0.1:8888", Slots: 8}},
+		DestinationSlots:        []ServerSlots{{Addr: "127.0.0.1:9888", Slots: 3}},
+	}
+	assert.Nil(t, s.validate())
+	assert.Equal(t, 8, s.SourceSlotsOf("127.0.0.1:8888"))
+	assert.Equal(t, 2, s.SourceSlotsOf("127.0.0.1:8889"))
+	assert.Equal(t, 3, s.DestinationSlotsOf("127.0.0.1:9888"))
+	assert.Equal(t, 1, s.DestinationSlotsOf("127.0.0.1:9889"))
+
+	// destination 은 0 이면 제한 없음
+	s.DestinationSlots[0].Slots = 0
+	assert.Nil(t, s.validate())
+	s.DestinationSlots[0].Slots = -1
+	assert.NotNil(t, s.validate())
+	s.DestinationSlots[0].Slots = 3
+	s.DefaultSourceSlots = 0
+	assert.NotNil(t, s.validate())
+}
+
+func TestConfigTaskerHistoryRetention(t *testing.T) {
+	tc := Tasker{PlacementStrategy: "roundrobin", HistoryRetention: 1,
+		RetryBackoffBase: 1, RetryBackoffMax: 1, QuarantineLimit: 1, StoreBackend: "leveldb"}
+	assert.Nil(t, tc.validate())
+	tc.HistoryRetention = 0
+	assert.NotNil(t, tc.validate())
+}
+
+func TestConfigTaskerRetryBackoff(t *testing.T) {
+	tc := Tasker{PlacementStrategy: "roundrobin", HistoryRetention: 1,
+		RetryBackoffBase: 60, RetryBackoffMax: 3600, QuarantineLimit: 5, StoreBackend: "leveldb"}
+	assert.Nil(t, tc.validate())
+
+	tc.RetryBackoffBase = 0
+	assert.NotNil(t, tc.validate())
+	tc.RetryBackoffBase = 3601
+	assert.NotNil(t, tc.validate())
+	tc.RetryBackoffBase = 60
+	tc.QuarantineLimit = 0
+	assert.NotNil(t, tc.validate())
+}
+
+func TestConfigTaskerStore(t *testing.T) {
+	tc := Tasker{PlacementStrategy: "roundrobin", HistoryRetention: 1,
+		RetryBackoffBase: 60, RetryBackoffMax: 3600, QuarantineLimit: 5, StoreBackend: "file"}
+	assert.Nil(t, tc.validate())
+	tc.StoreBackend = "Memory"
+	assert.Nil(t, tc.validate())
+	tc.StoreBackend = "bolt"
+	assert.NotNil(t, tc.validate())
+}
+
+func TestStorePath(t *testing.T) {
+	c := &Config{Tasker: Tasker{StoreBackend: "leveldb"}}
+	assert.Equal(t, ".pins/pins.db", storePath(c, "", ".pins/pins.db"))
+	assert.Equal(t, "/data2/pins.db", storePath(c, "/data2/pins.db", ".pins/pins.db"))
+
+	// memory 여도 경로를 설정했으면 저장함
+	c.Tasker.StoreBackend = "memory"
+	assert.Equal(t, "", storePath(c, "", ".pins/pins.db"))
+	assert.Equal(t, "/data2/pins.db", storePath(c, "/data2/pins.db", ".pins/pins.db"))
+}
+
+func TestConfigDecisionLog(t *testing.T) {
+	c := Config{LogDir: "log",
+		DecisionLog: DecisionLog{MaxSize: 1024, MaxBackups: 0}}
+	assert.Nil(t, c.DecisionLog.validate())
+	assert.Equal(t, filepath.Join("log", "decision"), c.DecisionLogDir())
+
+	c.DecisionLog.Dir = "decisions"
+	assert.Equal(t, "decisions", c.DecisionLogDir())
+
+	c.DecisionLog.MaxBackups = -1
+	assert.NotNil(t, c.DecisionLog.validate())
+	c.DecisionLog.MaxBackups = 1
+	c.DecisionLog.MaxSize = 0
+	assert.NotNil(t, c.DecisionLog.validate())
+}
+
+func TestConfigLeader(t *testing.T) {
+	l := Leader{LeaseSec: 0, RenewSec: 0}
+	assert.Nil(t, l.validate())
+
+	l.LockFile = "/san/cfm.lock"
+	assert.NotNil(t, l.validate())
+	l.RenewSec = 10
+	assert.NotNil(t, l.validate())
+	l.LeaseSec = 10
+	assert.NotNil(t, l.validate())
+	l.LeaseSec = 30
+	assert.Nil(t, l.validate())
+}
+
+func TestConfigAgent(t *testing.T) {
+	a := Agent{Parallelism: 16, TimeoutSec: 5, RetryCount: 2, RetryBackoffMs: 100}
+	assert.Nil(t, a.validate())
+	assert.Equal(t, common.FanOut{Parallelism: 16, Timeout: 5 * time.Second}, a.fanOut())
+
+	a.Parallelism = 0
+	assert.NotNil(t, a.validate())
+	a.Parallelism = 1
+	a.TimeoutSec = 0
+	assert.NotNil(t, a.validate())
+	a.TimeoutSec = 5
+	a.RetryCount = -1
+	assert.NotNil(t, a.validate())
+
+	a.RetryCount = 3
+	a.Token = "secret"
+	cfg, err := a.agentClient()
+	assert.Nil(t, err)
+	assert.Equal(t, common.AgentClientConfig{
+		Timeout: 5 * time.Second,
+		Retry:   common.AgentRetry{Count: 3, Backoff: 100 * time.Millisecond},
+		Token:   "secret",
+	}, cfg)
+
+	// 인증서 없이 system CA 로 검증
+	a.TLS.Enabled = true
+	cfg, err = a.agentClient()
+	assert.Nil(t, err)
+	assert.NotNil(t, cfg.TLS)
+	a.TLS.CAFile = "notexist.pem"
+	assert.NotNil(t, a.validate())
+}
+
+func TestConfigAPI(t *testing.T) {
+	a := API{HMACMaxSkewSec: 300}
+	assert.Nil(t, a.validate())
+	tc, err := a.tlsConfig()
+	assert.Nil(t, err)
+	assert.Nil(t, tc)
+
+	a.TLS.Enabled = true
+	assert.NotNil(t, a.validate())
+	a.TLS = TLS{}
+
+	a.HMACSecret = "key"
+	a.HMACMaxSkewSec = 0
+	assert.NotNil(t, a.validate())
+	a.HMACMaxSkewSec = 60
+	assert.Nil(t, a.validate())
+	assert.Equal(t, time.Minute, a.auth().MaxSkew)
+}
+
+func TestCheckConfig(t *testing.T) {
+	dir := "testconfig"
+	writeconfigfile(dir, ".grade.info", []byte(""))
+	writeconfigfile(dir, ".hitcount.history", []byte(""))
+	defer deletefile(dir, "")
+
+	c := Config{
+		GradeInfoFile:       filepath.Join(dir, ".grade.info"),
+		HitcountHistoryFile: filepath.Join(dir, ".hitcount.history"),
+		WatchDir:            dir,
+		WatchIPString:       "125.159.40.3",
+		Servers: Server{
+			Sources:      []string{"127.0.0.1:8081"},
+			Destinations: []string{"127.0.0.1:8081", "127.0.0.3:18083"},
+		},
+	}
+	assert.Equal(t, 0, len(CheckConfig(c)))
+
+	c.GradeInfoFile = filepath.Join(dir, "nofile")
+	c.WatchDir = filepath.Join(dir, ".hitcount.history")
+	c.WatchIPString = "125.159.40.(3"
+	c.Servers.Sources = []string{"127.0.0.1"}
+	c.Servers.Destinations = []string{"127.0.0.3:18083", "127.0.0.3:18083"}
+	errs := CheckConfig(c)
+	assert.Equal(t, 5, len(errs))
+	for _, err := range errs {
+		t.Log(err)
+	}
+}
+
 func TestReadConfigValidationConfig(t *testing.T) {
 	viper.SetConfigType("yaml")
 	var tctbl = []struct {
@@ -345,6 +525,10 @@ func TestReadConfigValidationConfig(t *testing.T) {
           - 127.0.0.1:9888
           - 127.0.0.1:9889
       #    - 172.16.33.52:8889
+        default_source_slots: 4
+        source_slots:
+          - addr: 127.0.0.1:8889
+            slots: 8
 
       # LB EventLog 가 존재하는 경로
       watch_dir: lb_log
@@ -366,26 +550,39 @@ func TestReadConfigValidationConfig(t *testing.T) {
 				LogDir:              "log",
 				LogLevel:            "debug",
 				Servers: Server{Sources: []string{"127.0.0.1:8888", "127.0.0.1:8889"},
-					Destinations:        []string{"127.0.0.1:9888", "127.0.0.1:9889"},
-					HeartbeatTimeoutSec: 5,
-					HeartbeatSec:        30,
+					Destinations:            []string{"127.0.0.1:9888", "127.0.0.1:9889"},
+					HeartbeatTimeoutSec:     5,
+					HeartbeatSec:            30,
+					DefaultSourceSlots:      4,
+					DefaultDestinationSlots: 0,
+					SourceSlots:             []ServerSlots{{Addr: "127.0.0.1:8889", Slots: 8}},
 				},
-				WatchDir:       "lb_log",
-				WatchIPString:  "125.159.40.3",
-				WatchTermMin:   10,
-				WatchHitBase:   5,
-				EnableCoreDump: true,
-				ListenAddr:     "127.0.0.1:7888",
-				Remover:        Remover{RemoverSleepSec: 30, StorageUsageLimitPercent: 99},
-				Tasker:         Tasker{TaskerSleepSec: 60, TaskTimeout: 30, TaskCopySpeedBPS: "10000000"},
-				Ignore:         Ignore{Prefixes: []string{"M64", "MN1"}},
-				Watcher:        Watcher{FireInitialEvent: true, EventTimeoutSec: 30, PollingSec: 60},
+				WatchDir:           "lb_log",
+				WatchIPString:      "125.159.40.3",
+				WatchTermMin:       10,
+				WatchHitBase:       5,
+				MaxCopyCount:       1,
+				EnableCoreDump:     true,
+				ListenAddr:         "127.0.0.1:7888",
+				ShutdownTimeoutSec: 30,
+				Remover:            Remover{RemoverSleepSec: 30, StorageUsageLimitPercent: 99},
+				Tasker: Tasker{TaskerSleepSec: 60, TaskTimeout: 30, TaskCopySpeedBPS: "10000000",
+					PlacementStrategy: "roundrobin", PlacementHotGrade: 1000, HistoryRetention: 168,
+					RetryBackoffBase: 60, RetryBackoffMax: 3600, QuarantineLimit: 5,
+					StoreBackend: "leveldb"},
+				Ignore:  Ignore{Prefixes: []string{"M64", "MN1"}},
+				Watcher: Watcher{FireInitialEvent: true, EventTimeoutSec: 30, PollingSec: 60},
 				Runner: Runner{BetweenEventsRunSec: 10, PeriodicRunSec: 40,
 					SetupRuns: map[string][]string{"eventruns": []string{"nop"},
 						"eventtimeoutruns":  []string{"nop"},
 						"betweeneventsruns": []string{"nop"},
 						"periodicruns":      []string{"nop"}},
+					InventoryTTLSec: 60,
 				},
+				DecisionLog: DecisionLog{MaxSize: 104857600, MaxBackups: 10},
+				Leader:      Leader{LeaseSec: 30, RenewSec: 10},
+				Agent:       Agent{Parallelism: 16, TimeoutSec: 5, RetryCount: 2, RetryBackoffMs: 100},
+				API:         API{HMACMaxSkewSec: 300},
 			},
 			wvalid: true,
 		},
@@ -394,15 +591,26 @@ func TestReadConfigValidationConfig(t *testing.T) {
 			wc: Config{LogDir: "log",
 				LogLevel: "info",
 				Servers: Server{
-					HeartbeatTimeoutSec: 5,
-					HeartbeatSec:        30,
+					HeartbeatTimeoutSec:     5,
+					HeartbeatSec:            30,
+					DefaultSourceSlots:      1,
+					DefaultDestinationSlots: 0,
 				},
-				EnableCoreDump: true,
-				ListenAddr:     "127.0.0.1:8080",
-				Remover:        Remover{RemoverSleepSec: 30, StorageUsageLimitPercent: 90},
-				Tasker:         Tasker{TaskerSleepSec: 60, TaskTimeout: 3600, TaskCopySpeedBPS: "10000000"},
-				Watcher:        Watcher{FireInitialEvent: true, EventTimeoutSec: 3600, PollingSec: 60},
-				Runner:         Runner{BetweenEventsRunSec: 60, PeriodicRunSec: 0},
+				MaxCopyCount:       1,
+				EnableCoreDump:     true,
+				ListenAddr:         "127.0.0.1:8080",
+				ShutdownTimeoutSec: 30,
+				Remover:            Remover{RemoverSleepSec: 30, StorageUsageLimitPercent: 90},
+				Tasker: Tasker{TaskerSleepSec: 60, TaskTimeout: 3600, TaskCopySpeedBPS: "10000000",
+					PlacementStrategy: "roundrobin", PlacementHotGrade: 1000, HistoryRetention: 168,
+					RetryBackoffBase: 60, RetryBackoffMax: 3600, QuarantineLimit: 5,
+					StoreBackend: "leveldb"},
+				Watcher:     Watcher{FireInitialEvent: true, EventTimeoutSec: 3600, PollingSec: 60},
+				Runner:      Runner{BetweenEventsRunSec: 60, PeriodicRunSec: 0, InventoryTTLSec: 60},
+				DecisionLog: DecisionLog{MaxSize: 104857600, MaxBackups: 10},
+				Leader:      Leader{LeaseSec: 30, RenewSec: 10},
+				Agent:       Agent{Parallelism: 16, TimeoutSec: 5, RetryCount: 2, RetryBackoffMs: 100},
+				API:         API{HMACMaxSkewSec: 300},
 			},
 			wvalid: true,
 		},
@@ -413,15 +621,26 @@ func TestReadConfigValidationConfig(t *testing.T) {
 			wc: Config{LogDir: "log",
 				LogLevel: "invalidlevel",
 				Servers: Server{
-					HeartbeatTimeoutSec: 5,
-					HeartbeatSec:        30,
+					HeartbeatTimeoutSec:     5,
+					HeartbeatSec:            30,
+					DefaultSourceSlots:      1,
+					DefaultDestinationSlots: 0,
 				},
-				EnableCoreDump: true,
-				ListenAddr:     "127.0.0.1:8080",
-				Remover:        Remover{RemoverSleepSec: 30, StorageUsageLimitPercent: 90},
-				Tasker:         Tasker{TaskerSleepSec: 60, TaskTimeout: 3600, TaskCopySpeedBPS: "10000000"},
-				Watcher:        Watcher{FireInitialEvent: true, EventTimeoutSec: 3600, PollingSec: 60},
-				Runner:         Runner{BetweenEventsRunSec: 60, PeriodicRunSec: 0},
+				MaxCopyCount:       1,
+				EnableCoreDump:     true,
+				ListenAddr:         "127.0.0.1:8080",
+				ShutdownTimeoutSec: 30,
+				Remover:            Remover{RemoverSleepSec: 30, StorageUsageLimitPercent: 90},
+				Tasker: Tasker{TaskerSleepSec: 60, TaskTimeout: 3600, TaskCopySpeedBPS: "10000000",
+					PlacementStrategy: "roundrobin", PlacementHotGrade: 1000, HistoryRetention: 168,
+					RetryBackoffBase: 60, RetryBackoffMax: 3600, QuarantineLimit: 5,
+					StoreBackend: "leveldb"},
+				Watcher:     Watcher{FireInitialEvent: true, EventTimeoutSec: 3600, PollingSec: 60},
+				Runner:      Runner{BetweenEventsRunSec: 60, PeriodicRunSec: 0, InventoryTTLSec: 60},
+				DecisionLog: DecisionLog{MaxSize: 104857600, MaxBackups: 10},
+				Leader:      Leader{LeaseSec: 30, RenewSec: 10},
+				Agent:       Agent{Parallelism: 16, TimeoutSec: 5, RetryCount: 2, RetryBackoffMs: 100},
+				API:         API{HMACMaxSkewSec: 300},
 			},
 			wvalid: false, werror: errors.New("invalid log_level : error(invalid level string [invalidlevel])"),
 		},
@@ -433,15 +652,26 @@ func TestReadConfigValidationConfig(t *testing.T) {
 			wc: Config{LogDir: "log",
 				LogLevel: "info",
 				Servers: Server{
-					HeartbeatTimeoutSec: 5,
-					HeartbeatSec:        30,
+					HeartbeatTimeoutSec:     5,
+					HeartbeatSec:            30,
+					DefaultSourceSlots:      1,
+					DefaultDestinationSlots: 0,
 				},
-				EnableCoreDump: true,
-				ListenAddr:     "127.0.0.1",
-				Remover:        Remover{RemoverSleepSec: 30, StorageUsageLimitPercent: 90},
-				Tasker:         Tasker{TaskerSleepSec: 60, TaskTimeout: 3600, TaskCopySpeedBPS: "10000000"},
-				Watcher:        Watcher{FireInitialEvent: true, EventTimeoutSec: 3600, PollingSec: 60},
-				Runner:         Runner{BetweenEventsRunSec: 60, PeriodicRunSec: 0},
+				MaxCopyCount:       1,
+				EnableCoreDump:     true,
+				ListenAddr:         "127.0.0.1",
+				ShutdownTimeoutSec: 30,
+				Remover:            Remover{RemoverSleepSec: 30, StorageUsageLimitPercent: 90},
+				Tasker: Tasker{TaskerSleepSec: 60, TaskTimeout: 3600, TaskCopySpeedBPS: "10000000",
+					PlacementStrategy: "roundrobin", PlacementHotGrade: 1000, HistoryRetention: 168,
+					RetryBackoffBase: 60, RetryBackoffMax: 3600, QuarantineLimit: 5,
+					StoreBackend: "leveldb"},
+				Watcher:     Watcher{FireInitialEvent: true, EventTimeoutSec: 3600, PollingSec: 60},
+				Runner:      Runner{BetweenEventsRunSec: 60, PeriodicRunSec: 0, InventoryTTLSec: 60},
+				DecisionLog: DecisionLog{MaxSize: 104857600, MaxBackups: 10},
+				Leader:      Leader{LeaseSec: 30, RenewSec: 10},
+				Agent:       Agent{Parallelism: 16, TimeoutSec: 5, RetryCount: 2, RetryBackoffMs: 100},
+				API:         API{HMACMaxSkewSec: 300},
 			},
 			wvalid: false, werror: errors.New("invalid listen_addr : error(address 127.0.0.1: missing port in address)"),
 		},
@@ -457,18 +687,59 @@ func TestReadConfigValidationConfig(t *testing.T) {
 				LogDir:     "log",
 				LogLevel:   "info",
 				Servers: Server{
-					HeartbeatTimeoutSec: 5,
-					HeartbeatSec:        30,
+					HeartbeatTimeoutSec:     5,
+					HeartbeatSec:            30,
+					DefaultSourceSlots:      1,
+					DefaultDestinationSlots: 0,
 				},
-				EnableCoreDump: true,
-				ListenAddr:     "127.0.0.1",
-				Remover:        Remover{RemoverSleepSec: 30, StorageUsageLimitPercent: 90},
-				Tasker:         Tasker{TaskerSleepSec: 60, TaskTimeout: 3600, TaskCopySpeedBPS: "10000000"},
-				Watcher:        Watcher{FireInitialEvent: true, EventTimeoutSec: 3600, PollingSec: 60},
-				Runner:         Runner{BetweenEventsRunSec: 60, PeriodicRunSec: 0},
+				MaxCopyCount:       1,
+				EnableCoreDump:     true,
+				ListenAddr:         "127.0.0.1",
+				ShutdownTimeoutSec: 30,
+				Remover:            Remover{RemoverSleepSec: 30, StorageUsageLimitPercent: 90},
+				Tasker: Tasker{TaskerSleepSec: 60, TaskTimeout: 3600, TaskCopySpeedBPS: "10000000",
+					PlacementStrategy: "roundrobin", PlacementHotGrade: 1000, HistoryRetention: 168,
+					RetryBackoffBase: 60, RetryBackoffMax: 3600, QuarantineLimit: 5,
+					StoreBackend: "leveldb"},
+				Watcher:     Watcher{FireInitialEvent: true, EventTimeoutSec: 3600, PollingSec: 60},
+				Runner:      Runner{BetweenEventsRunSec: 60, PeriodicRunSec: 0, InventoryTTLSec: 60},
+				DecisionLog: DecisionLog{MaxSize: 104857600, MaxBackups: 10},
+				Leader:      Leader{LeaseSec: 30, RenewSec: 10},
+				Agent:       Agent{Parallelism: 16, TimeoutSec: 5, RetryCount: 2, RetryBackoffMs: 100},
+				API:         API{HMACMaxSkewSec: 300},
 			},
 			wvalid: false, werror: errors.New("invalid source_dirs : error(stat hello: no such file or directory)"),
 		},
+		{ // invalid max_copy_count setting
+			yml: []byte(`
+      max_copy_count: 0
+      `),
+			wc: Config{LogDir: "log",
+				LogLevel: "info",
+				Servers: Server{
+					HeartbeatTimeoutSec:     5,
+					HeartbeatSec:            30,
+					DefaultSourceSlots:      1,
+					DefaultDestinationSlots: 0,
+				},
+				MaxCopyCount:       0,
+				EnableCoreDump:     true,
+				ListenAddr:         "127.0.0.1:8080",
+				ShutdownTimeoutSec: 30,
+				Remover:            Remover{RemoverSleepSec: 30, StorageUsageLimitPercent: 90},
+				Tasker: Tasker{TaskerSleepSec: 60, TaskTimeout: 3600, TaskCopySpeedBPS: "10000000",
+					PlacementStrategy: "roundrobin", PlacementHotGrade: 1000, HistoryRetention: 168,
+					RetryBackoffBase: 60, RetryBackoffMax: 3600, QuarantineLimit: 5,
+					StoreBackend: "leveldb"},
+				Watcher:     Watcher{FireInitialEvent: true, EventTimeoutSec: 3600, PollingSec: 60},
+				Runner:      Runner{BetweenEventsRunSec: 60, PeriodicRunSec: 0, InventoryTTLSec: 60},
+				DecisionLog: DecisionLog{MaxSize: 104857600, MaxBackups: 10},
+				Leader:      Leader{LeaseSec: 30, RenewSec: 10},
+				Agent:       Agent{Parallelism: 16, TimeoutSec: 5, RetryCount: 2, RetryBackoffMs: 100},
+				API:         API{HMACMaxSkewSec: 300},
+			},
+			wvalid: false, werror: errors.New("invalid max_copy_count : error(0, must be greater than 0)"),
+		},
 	}
 	dir := "testconfig"
 	file := "config.yml"
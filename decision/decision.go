@@ -0,0 +1,333 @@
+package decision
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cilog"
+)
+
+// Action : 파일에 대해 tasker, remover 가 결정한 내용
+//
+// TASK : 배포 task 생성
+//
+// DELETE : 서버에 삭제 요청
+//
+// SKIP : 배포, 삭제 대상에서 제외
+type Action int
+
+// Action const
+const (
+	_           = iota
+	TASK Action = iota
+	DELETE
+	SKIP
+)
+
+// MarshalJSON :
+func (a Action) MarshalJSON() ([]byte, error) {
+	switch a {
+	case TASK:
+		return []byte(`"task"`), nil
+	case DELETE:
+		return []byte(`"delete"`), nil
+	case SKIP:
+		return []byte(`"skip"`), nil
+	default:
+		return nil, errors.New("Action.MarshalJSON: unknown value")
+	}
+}
+
+// UnmarshalJSON :
+func (a *Action) UnmarshalJSON(b []byte) error {
+	switch string(b) {
+	case `"task"`:
+		*a = TASK
+	case `"delete"`:
+		*a = DELETE
+	case `"skip"`:
+		*a = SKIP
+	default:
+		return fmt.Errorf("unknown Action : (%s)", string(b))
+	}
+
+	return nil
+}
+
+func (a Action) String() string {
+	m := map[Action]string{
+		TASK:   "task",
+		DELETE: "delete",
+		SKIP:   "skip",
+	}
+	return m[a]
+}
+
+// reason code
+//
+// log 에 남기는 ignored by ... 의 값과 같음
+const (
+	Grade                        = "grade"
+	RisingHit                    = "rising.hit"
+	Duplicated                   = "duplicated"
+	FreeDiskSpace                = "free.disk.space"
+	FoundInTheServers            = "found.in.the.servers"
+	FoundInTheTasks              = "found.in.the.tasks"
+	NotFoundInTheServer          = "not.found.in.the.server"
+	NotFoundInTheSourcePaths     = "not.found.in.the.source.paths"
+	IgnorePrefix                 = "ignore.prefix"
+	RisingHitFile                = "rising.hit.file"
+	TargetCopiesLeftInTheServers = "target.copies.left.in.the.servers"
+	NoDstServerForPlacement      = "no.dst.server.for.placement"
+	NoSrcServer                  = "no.src.server"
+	NoDstServer                  = "no.dst.server"
+	RequestFailed                = "request.failed"
+	Manual                       = "manual"
+	Pinned                       = "pinned"
+	LastCopy                     = "last.copy"
+	Draining                     = "draining"
+)
+
+// Decision : 파일 하나에 대한 결정 기록
+//
+// Time : 결정한 시간, unix time
+//
+// Module : 결정한 module, tasker 또는 remover
+//
+// Server : task 의 destination 서버 또는 삭제 요청 서버, 서버와 상관없으면 빈 값
+type Decision struct {
+	Time      int64  `json:"time"`
+	Module    string `json:"module"`
+	File      string `json:"file"`
+	Server    string `json:"server"`
+	Action    Action `json:"action"`
+	Reason    string `json:"reason"`
+	Grade     int32  `json:"grade"`
+	Size      int64  `json:"size"`
+	RisingHit int    `json:"rising_hit"`
+}
+
+// New : file meta 로 Decision 만들기
+func New(module string, fm *common.FileMeta, server string,
+	action Action, reason string) Decision {
+	return Decision{
+		Module:    module,
+		File:      fm.Name,
+		Server:    server,
+		Action:    action,
+		Reason:    reason,
+		Grade:     fm.Grade,
+		Size:      fm.Size,
+		RisingHit: fm.RisingHit,
+	}
+}
+
+func (d Decision) String() string {
+	return fmt.Sprintf(
+		"module(%s), file(%s), server(%s), action(%s), reason(%s)"+
+			", grade(%d), size(%d), risingHit(%d)",
+		d.Module, d.File, d.Server, d.Action, d.Reason,
+		d.Grade, d.Size, d.RisingHit)
+}
+
+// FileName : 기록 파일 이름, rotate 된 파일은 뒤에 .1, .2 ... 가 붙음
+const FileName = "decisions.log"
+
+var dir string
+var maxSize int64
+var maxBackups int
+var file *os.File
+var size int64
+var mutex *sync.Mutex
+var dlogger common.MLogger
+
+func init() {
+	mutex = &sync.Mutex{}
+
+	dlogger = common.MLogger{
+		Logger: cilog.StdLogger(),
+		Mod:    "decision"}
+}
+
+// Open :
+//
+// d directory 에 기록 파일을 열고 기록을 시작함,
+// directory 가 없으면 만듬
+//
+// 기록 파일 크기가 maxsize 보다 커지면 rotate 하고,
+// rotate 된 파일은 backups 개수만큼 남김
+func Open(d string, maxsize int64, backups int) error {
+	if maxsize <= 0 {
+		return errors.New(fmt.Sprintf("invalid max size(%d)", maxsize))
+	}
+	if backups < 0 {
+		return errors.New(fmt.Sprintf("invalid max backups(%d)", backups))
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if err := os.MkdirAll(d, os.FileMode(0755)); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(d, FileName),
+		os.O_CREATE|os.O_APPEND|os.O_WRONLY, os.FileMode(0644))
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if file != nil {
+		file.Close()
+	}
+	dir, maxSize, maxBackups = d, maxsize, backups
+	file, size = f, fi.Size()
+	dlogger.Infof("opened decision journal, dir(%s), maxSize(%d), maxBackups(%d)",
+		dir, maxSize, maxBackups)
+	return nil
+}
+
+// Close : 기록 중지, 이후 Record 는 무시됨
+func Close() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if file != nil {
+		file.Close()
+		file = nil
+	}
+}
+
+// Record :
+//
+// 기록 파일에 JSON 한 줄로 기록,
+// Time 값이 없으면 현재 시간으로 채움
+//
+// Open 하지 않았으면 무시됨
+func Record(d Decision) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if file == nil {
+		return
+	}
+	if d.Time == 0 {
+		d.Time = time.Now().Unix()
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		dlogger.Errorf("failed to marshal decision(%s), error(%s)", d, err.Error())
+		return
+	}
+	b = append(b, '\n')
+	if size > 0 && size+int64(len(b)) > maxSize {
+		if err := rotate(); err != nil {
+			dlogger.Errorf("failed to rotate decision journal, error(%s)", err.Error())
+			return
+		}
+	}
+	n, err := file.Write(b)
+	size += int64(n)
+	if err != nil {
+		dlogger.Errorf("failed to write decision(%s), error(%s)", d, err.Error())
+	}
+}
+
+// rotate :
+// decisions.log -> decisions.log.1 -> ... -> decisions.log.{maxBackups}
+//
+// maxBackups 보다 오래된 파일은 지워짐
+func rotate() error {
+	file.Close()
+	file = nil
+
+	p := filepath.Join(dir, FileName)
+	if maxBackups == 0 {
+		os.Remove(p)
+	} else {
+		os.Remove(backupPath(maxBackups))
+		for i := maxBackups - 1; i >= 1; i-- {
+			os.Rename(backupPath(i), backupPath(i+1))
+		}
+		if err := os.Rename(p, backupPath(1)); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_APPEND|os.O_WRONLY, os.FileMode(0644))
+	if err != nil {
+		return err
+	}
+	file, size = f, 0
+	return nil
+}
+
+func backupPath(n int) string {
+	return filepath.Join(dir, fmt.Sprintf("%s.%d", FileName, n))
+}
+
+// Find :
+//
+// 기록 파일과 rotate 된 파일에서 filename 파일의 결정 기록을 찾아서
+// 오래된 순서로 반환, Open 하지 않았으면 빈 list 반환
+//
+// 파일을 읽는 동안 Record 가 막히지 않도록, 읽을 파일 목록만 lock 안에서 구함
+func Find(filename string) ([]Decision, error) {
+	dl := make([]Decision, 0)
+	mutex.Lock()
+	if file == nil {
+		mutex.Unlock()
+		return dl, nil
+	}
+	paths := make([]string, 0, maxBackups+1)
+	for i := maxBackups; i >= 1; i-- {
+		paths = append(paths, backupPath(i))
+	}
+	paths = append(paths, filepath.Join(dir, FileName))
+	mutex.Unlock()
+
+	// JSON 으로 풀기 전에 파일 이름이 있는 줄만 골라냄
+	name, _ := json.Marshal(filename)
+	needle := append([]byte(`"file":`), name...)
+	for _, p := range paths {
+		if err := findInFile(p, filename, needle, &dl); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return dl, err
+		}
+	}
+	return dl, nil
+}
+
+func findInFile(p string, filename string, needle []byte, dl *[]Decision) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if !bytes.Contains(scanner.Bytes(), needle) {
+			continue
+		}
+		var d Decision
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			continue
+		}
+		if d.File == filename {
+			*dl = append(*dl, d)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,133 @@
+package decision
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/castisdev/cfm/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActionJSON(t *testing.T) {
+	for _, a := range []Action{TASK, DELETE, SKIP} {
+		b, err := json.Marshal(a)
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf(`"%s"`, a), string(b))
+
+		var ua Action
+		assert.Nil(t, json.Unmarshal(b, &ua))
+		assert.Equal(t, a, ua)
+	}
+	_, err := json.Marshal(Action(0))
+	assert.NotNil(t, err)
+	var ua Action
+	assert.NotNil(t, json.Unmarshal([]byte(`"unknown"`), &ua))
+}
+
+func TestOpen(t *testing.T) {
+	dir := "testdecision"
+	defer os.RemoveAll(dir)
+
+	assert.NotNil(t, Open(dir, 0, 1))
+	assert.NotNil(t, Open(dir, 100, -1))
+
+	assert.Nil(t, Open(dir, 100, 1))
+	defer Close()
+	_, err := os.Stat(filepath.Join(dir, FileName))
+	assert.Nil(t, err)
+}
+
+func TestRecordAndFind(t *testing.T) {
+	dir := "testdecision"
+	defer os.RemoveAll(dir)
+
+	// Open 하지 않으면 기록되지 않음
+	Record(Decision{File: "A.mpg", Action: SKIP})
+	dl, err := Find("A.mpg")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(dl))
+
+	assert.Nil(t, Open(dir, 1024*1024, 1))
+	defer Close()
+
+	fm := common.NewFileMetaWith("A.mpg", 1)
+	fm.Size = 100
+	fm.RisingHit = 3
+	Record(New("tasker", fm, "127.0.0.1:8081", TASK, RisingHit))
+	Record(New("tasker", common.NewFileMetaWith("B.mpg", 2), "", SKIP, FoundInTheServers))
+	Record(New("remover", fm, "127.0.0.2:8082", DELETE, Duplicated))
+
+	dl, err = Find("A.mpg")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(dl))
+	assert.Equal(t, "tasker", dl[0].Module)
+	assert.Equal(t, "A.mpg", dl[0].File)
+	assert.Equal(t, "127.0.0.1:8081", dl[0].Server)
+	assert.Equal(t, TASK, dl[0].Action)
+	assert.Equal(t, RisingHit, dl[0].Reason)
+	assert.Equal(t, int32(1), dl[0].Grade)
+	assert.Equal(t, int64(100), dl[0].Size)
+	assert.Equal(t, 3, dl[0].RisingHit)
+	assert.NotEqual(t, int64(0), dl[0].Time)
+	assert.Equal(t, "remover", dl[1].Module)
+	assert.Equal(t, DELETE, dl[1].Action)
+
+	dl, err = Find("B.mpg")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(dl))
+	assert.Equal(t, FoundInTheServers, dl[0].Reason)
+
+	// 이름 일부만 같은 파일은 찾지 않음
+	dl, err = Find("A")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(dl))
+
+	// Close 후에는 기록되지 않고, 찾을 수 없음
+	Close()
+	Record(New("tasker", fm, "", SKIP, IgnorePrefix))
+	dl, err = Find("A.mpg")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(dl))
+}
+
+func TestRotate(t *testing.T) {
+	dir := "testdecision"
+	defer os.RemoveAll(dir)
+
+	d := Decision{Time: 1, Module: "tasker", File: "A.mpg",
+		Action: SKIP, Reason: FoundInTheServers}
+	b, _ := json.Marshal(d)
+	linesize := int64(len(b) + 1)
+
+	// 한 파일에 2 줄씩, backup 2 개
+	assert.Nil(t, Open(dir, linesize*2, 2))
+	defer Close()
+
+	for i := 1; i <= 7; i++ {
+		d.Time = int64(i)
+		Record(d)
+	}
+
+	// 7 줄 중 5 줄만 남음 : .2(3,4), .1(5,6), decisions.log(7)
+	_, err := os.Stat(filepath.Join(dir, FileName+".3"))
+	assert.True(t, os.IsNotExist(err))
+	dl, err := Find("A.mpg")
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(dl))
+	for i, d := range dl {
+		assert.Equal(t, int64(i+3), d.Time)
+	}
+
+	// 다시 Open 하면 기존 파일에 이어서 기록
+	Close()
+	assert.Nil(t, Open(dir, linesize*2, 2))
+	d.Time = 8
+	Record(d)
+	dl, err = Find("A.mpg")
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(dl))
+	assert.Equal(t, int64(8), dl[5].Time)
+}
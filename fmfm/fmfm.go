@@ -4,6 +4,9 @@ import (
 	"time"
 
 	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cfm/heartbeater"
+	"github.com/castisdev/cfm/membership"
+	"github.com/castisdev/cfm/remover"
 	"github.com/castisdev/cfm/tasker"
 	"github.com/castisdev/cilog"
 )
@@ -40,32 +43,192 @@ type GetFileMetas struct {
 	RespCh chan FileMetas
 }
 
+// GetRemoverPlan : remover plan 모드 요청, 삭제 요청할 파일 목록을 받음
+type GetRemoverPlan struct {
+	RespCh chan []remover.PlannedDelete
+}
+
+// GetTaskerPlan : tasker plan 모드 요청, 만들어질 task 목록을 받음
+type GetTaskerPlan struct {
+	RespCh chan []tasker.PlannedTask
+}
+
+// Reload : 설정 다시 읽기 요청
+//
+// Apply : runner 가 run 사이에 실행하는 함수,
+// 다시 읽은 설정을 runner 의 remover, tasker, tailer 에 반영하고 error 를 반환
+type Reload struct {
+	Apply  func(r *Runner) error
+	RespCh chan error
+}
+
 type Manager struct {
-	watcher        *Watcher
-	runner         *Runner
-	CMDCh          chan CMD
-	ErrCh          chan error
-	GetFileMetasCh chan GetFileMetas // request channel
+	watcher          *Watcher
+	runner           *Runner
+	CMDCh            chan CMD
+	ErrCh            chan error
+	GetFileMetasCh   chan GetFileMetas   // request channel
+	GetRemoverPlanCh chan GetRemoverPlan // request channel
+	GetTaskerPlanCh  chan GetTaskerPlan  // request channel
+	ReloadCh         chan Reload         // request channel
+	done             chan struct{}
 }
 
 func NewManager(watcher *Watcher, runner *Runner) *Manager {
 	return &Manager{
-		watcher:        watcher,
-		runner:         runner,
-		CMDCh:          make(chan CMD, 1),
-		ErrCh:          make(chan error, 1),
-		GetFileMetasCh: make(chan GetFileMetas),
+		watcher:          watcher,
+		runner:           runner,
+		CMDCh:            make(chan CMD, 1),
+		ErrCh:            make(chan error, 1),
+		GetFileMetasCh:   make(chan GetFileMetas),
+		GetRemoverPlanCh: make(chan GetRemoverPlan),
+		GetTaskerPlanCh:  make(chan GetTaskerPlan),
+		ReloadCh:         make(chan Reload),
+		done:             make(chan struct{}),
+	}
+}
+
+// Done : manager 가 멈추면 닫히는 channel
+//
+// manager 가 멈춘 후에는 request channel 로 보낸 요청을 받지 않으므로,
+// request channel 로 보낼 때 같이 기다려야 함
+func (fm *Manager) Done() <-chan struct{} {
+	return fm.done
+}
+
+// GetFileMetas : 파일 등급, hitcount 정보 요청, manager 가 멈췄으면 ErrStopped 반환
+func (fm *Manager) GetFileMetas() (FileMetas, error) {
+	req := GetFileMetas{RespCh: make(chan FileMetas)}
+	select {
+	case fm.GetFileMetasCh <- req:
+	case <-fm.done:
+		return FileMetas{}, ErrStopped
+	}
+	return <-req.RespCh, nil
+}
+
+// GetRemoverPlan : remover plan 모드 요청, manager 가 멈췄으면 ErrStopped 반환
+func (fm *Manager) GetRemoverPlan() ([]remover.PlannedDelete, error) {
+	req := GetRemoverPlan{RespCh: make(chan []remover.PlannedDelete)}
+	select {
+	case fm.GetRemoverPlanCh <- req:
+	case <-fm.done:
+		return nil, ErrStopped
 	}
+	return <-req.RespCh, nil
+}
+
+// GetTaskerPlan : tasker plan 모드 요청, manager 가 멈췄으면 ErrStopped 반환
+func (fm *Manager) GetTaskerPlan() ([]tasker.PlannedTask, error) {
+	req := GetTaskerPlan{RespCh: make(chan []tasker.PlannedTask)}
+	select {
+	case fm.GetTaskerPlanCh <- req:
+	case <-fm.done:
+		return nil, ErrStopped
+	}
+	return <-req.RespCh, nil
+}
+
+// Reload :
+//
+// runner 가 run 사이에 apply 를 실행하도록 요청한 후 기다림,
+// manager 가 멈췄으면 ErrStopped 반환
+func (fm *Manager) Reload(apply func(r *Runner) error) error {
+	req := Reload{Apply: apply, RespCh: make(chan error)}
+	select {
+	case fm.ReloadCh <- req:
+	case <-fm.done:
+		return ErrStopped
+	}
+	return <-req.RespCh
 }
 
 func (fm *Manager) Tasks() *tasker.Tasks {
 	return fm.runner.tasker.Tasks()
 }
 
+func (fm *Manager) History() *tasker.History {
+	return fm.runner.tasker.History()
+}
+
+func (fm *Manager) Failures() *tasker.Failures {
+	return fm.runner.tasker.Failures()
+}
+
+func (fm *Manager) ManualTasks() *tasker.ManualTasks {
+	return fm.runner.tasker.ManualTasks()
+}
+
+// AddManualTask :
+//
+// runner 가 run 사이에 manual task 를 검사하고 추가하도록 요청한 후 기다림,
+// tasker 의 서버 목록, 상태는 runner 가 바꾸기 때문에 runner 에서 검사함
+func (fm *Manager) AddManualTask(mt tasker.ManualTask) (tasker.ManualTask, error) {
+	res := mt
+	err := fm.Reload(func(r *Runner) error {
+		var err error
+		res, err = r.tasker.AddManualTask(mt)
+		return err
+	})
+	return res, err
+}
+
+func (fm *Manager) Pins() *remover.Pins {
+	return fm.runner.remover.Pins()
+}
+
+// RemoveFile :
+//
+// runner 가 run 사이에 파일을 검사하고 서버에 삭제 요청하도록 요청한 후 기다림,
+// remover 의 서버 목록, drain 상태, ignore.prefixes 는 runner 가 바꾸기 때문에 runner 에서 검사함
+func (fm *Manager) RemoveFile(addr, fileName string) (string, error) {
+	var reason string
+	err := fm.Reload(func(r *Runner) error {
+		var err error
+		reason, err = r.remover.RemoveFile(addr, fileName)
+		return err
+	})
+	return reason, err
+}
+
+// Stop :
+//
+// STOP 을 보내고, runner 가 실행 중인 RUN 을 끝내고
+// manager 가 멈출 때까지 기다림
+func (fm *Manager) Stop() {
+	fm.CMDCh <- STOP
+	<-fm.ErrCh
+	// Manage 가 끝나면 CMDCh 가 닫힘
+	for range fm.CMDCh {
+	}
+	mgrlogger.Infof("stopped manager")
+}
+
+// Close : tasker 의 task, task 기록, remover 의 고정한 파일 목록 repository 닫기, Stop 한 후에 사용
+func (fm *Manager) Close() error {
+	fm.runner.remover.Pins().Close()
+	return fm.runner.tasker.Close()
+}
+
+// ApplyServers :
+//
+// membership 의 서버 구성을 heartbeater 에 반영하고,
+// runner 가 run 사이에 remover, tasker 에 반영하도록 요청한 후 기다림
+func (fm *Manager) ApplyServers() error {
+	if err := heartbeater.Sync(membership.Addrs()); err != nil {
+		return err
+	}
+	return fm.Reload(func(r *Runner) error {
+		r.applyServers()
+		return nil
+	})
+}
+
 func (fm *Manager) Manage() {
 	defer close(fm.CMDCh)
 	defer close(fm.ErrCh)
-	defer close(fm.GetFileMetasCh)
+	// request channel 은 보내는 쪽이 있을 수 있어서 닫지 않고, done 을 닫아서 알림
+	defer close(fm.done)
 	for {
 		go fm.watcher.Watch()
 		go fm.runner.Run(fm.watcher.NotiCh)
@@ -115,6 +278,12 @@ func (fm *Manager) waitWatcher() error {
 			}
 		case req := <-fm.GetFileMetasCh:
 			fm.getFileMetas(req)
+		case req := <-fm.GetRemoverPlanCh:
+			fm.getRemoverPlan(req)
+		case req := <-fm.GetTaskerPlanCh:
+			fm.getTaskerPlan(req)
+		case req := <-fm.ReloadCh:
+			fm.reload(req)
 		}
 	}
 }
@@ -128,6 +297,33 @@ func (fm *Manager) getFileMetas(req GetFileMetas) {
 	req.RespCh <- resFromRunner
 }
 
+func (fm *Manager) getRemoverPlan(req GetRemoverPlan) {
+	defer close(req.RespCh)
+
+	reqq := GetRemoverPlan{RespCh: make(chan []remover.PlannedDelete)}
+	fm.runner.GetRemoverPlanCh <- reqq
+	resFromRunner := <-reqq.RespCh
+	req.RespCh <- resFromRunner
+}
+
+func (fm *Manager) getTaskerPlan(req GetTaskerPlan) {
+	defer close(req.RespCh)
+
+	reqq := GetTaskerPlan{RespCh: make(chan []tasker.PlannedTask)}
+	fm.runner.GetTaskerPlanCh <- reqq
+	resFromRunner := <-reqq.RespCh
+	req.RespCh <- resFromRunner
+}
+
+func (fm *Manager) reload(req Reload) {
+	defer close(req.RespCh)
+
+	reqq := Reload{Apply: req.Apply, RespCh: make(chan error)}
+	fm.runner.ReloadCh <- reqq
+	resFromRunner := <-reqq.RespCh
+	req.RespCh <- resFromRunner
+}
+
 func (fm *Manager) restart() error {
 	err := fm.waitUntilFileExist()
 	if err != nil {
@@ -194,6 +390,12 @@ func (fm *Manager) waitUntilFileExist() error {
 				}
 			case req := <-fm.GetFileMetasCh:
 				fm.getFileMetas(req)
+			case req := <-fm.GetRemoverPlanCh:
+				fm.getRemoverPlan(req)
+			case req := <-fm.GetTaskerPlanCh:
+				fm.getTaskerPlan(req)
+			case req := <-fm.ReloadCh:
+				fm.reload(req)
 			}
 		}
 	}()
@@ -36,6 +36,40 @@ func TestManagePollModeStop(t *testing.T) {
 	}
 }
 
+func TestManagePollModeStopWaitsForRun(t *testing.T) {
+	createfile("testwatcher", "grade")
+	createfile("testwatcher", "hitcount")
+	defer deletefile("testwatcher", "")
+
+	poll := uint32(1)
+	eto := uint32(0)
+	TestInotifyFunc = func() bool { return false }
+	watcher := NewWatcher("testwatcher/grade", "testwatcher/hitcount", true, eto, poll)
+
+	DefaultEventRuns = []RUN{NOP}
+	runner := NewRunner(0, 0, nil, nil, nil)
+
+	started := make(chan struct{})
+	ended := false
+	runner.RUNFuncs = map[RUN]func(*Runner, FileMetaFilesEvent){
+		NOP: func(r *Runner, e FileMetaFilesEvent) {
+			close(started)
+			time.Sleep(time.Second)
+			ended = true
+		},
+	}
+
+	manager := NewManager(watcher, runner)
+	go manager.Manage()
+
+	// 실행 중인 RUN 이 끝난 후에 멈춤
+	<-started
+	manager.Stop()
+	assert.True(t, ended)
+	_, open := <-manager.CMDCh
+	assert.False(t, open)
+}
+
 func TestManagePollInitialEventWithoutFileChanged(t *testing.T) {
 	createfile("testwatcher", "grade")
 	createfile("testwatcher", "hitcount")
@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cfm/membership"
+	"github.com/castisdev/cfm/metrics"
 	"github.com/castisdev/cfm/remover"
 	"github.com/castisdev/cfm/tailer"
 	"github.com/castisdev/cfm/tasker"
@@ -25,11 +27,18 @@ type Runner struct {
 	remover             *remover.Remover
 	tasker              *tasker.Tasker
 	tailer              *tailer.Tailer
-	CMDCh               chan CMD // command input
+	inventory           *common.Inventory // remover, tasker 가 함께 사용하는 dest 서버 파일 목록 cache
+	CMDCh               chan CMD          // command input
 	ErrCh               chan error
 	RUNFuncs            map[RUN]func(*Runner, FileMetaFilesEvent)
 	SetupRuns           SetupRuns
-	GetFileMetasCh      chan GetFileMetas // request channel
+	IsLeader            func() bool         // nil 이 아니고 false 를 반환하면 RunRemover, RunTasker 를 실행하지 않음
+	leader              bool                // 마지막으로 검사한 IsLeader 결과
+	lastEvent           FileMetaFilesEvent  // 마지막 파일 event, leader 가 되었을 때 event run 에 사용
+	GetFileMetasCh      chan GetFileMetas   // request channel
+	GetRemoverPlanCh    chan GetRemoverPlan // request channel
+	GetTaskerPlanCh     chan GetTaskerPlan  // request channel
+	ReloadCh            chan Reload         // request channel
 	fmmMtime            time.Time
 	rhmMtime            time.Time
 }
@@ -167,7 +176,7 @@ func NewRunner(
 	tskr *tasker.Tasker,
 	tlr *tailer.Tailer,
 ) *Runner {
-	return &Runner{
+	fr := &Runner{
 		fmm:                 make(FileMetaPtrMap),
 		dupFmm:              make(FileMetaPtrMap),
 		rhm:                 make(map[string]int),
@@ -181,6 +190,22 @@ func NewRunner(
 		RUNFuncs:            newRunFuns(),
 		SetupRuns:           defaultSetupRuns(),
 		GetFileMetasCh:      make(chan GetFileMetas),
+		GetRemoverPlanCh:    make(chan GetRemoverPlan),
+		GetTaskerPlanCh:     make(chan GetTaskerPlan),
+		ReloadCh:            make(chan Reload),
+	}
+	fr.setInventory(common.NewInventory(0))
+	return fr
+}
+
+// setInventory : remover, tasker 가 같은 inventory 를 사용하도록 설정
+func (fr *Runner) setInventory(inv *common.Inventory) {
+	fr.inventory = inv
+	if fr.remover != nil {
+		fr.remover.SetInventory(inv)
+	}
+	if fr.tasker != nil {
+		fr.tasker.SetInventory(inv)
 	}
 }
 
@@ -194,6 +219,10 @@ func (fr *Runner) clone() *Runner {
 	)
 	nr.RUNFuncs = fr.RUNFuncs
 	nr.SetupRuns = fr.SetupRuns
+	nr.IsLeader = fr.IsLeader
+	nr.leader = fr.leader
+	nr.lastEvent = fr.lastEvent
+	nr.setInventory(fr.inventory)
 	return nr
 }
 
@@ -204,6 +233,9 @@ func (fr *Runner) Run(eventCh <-chan FileMetaFilesEvent) error {
 	defer close(fr.CMDCh)
 	defer close(fr.ErrCh)
 	defer close(fr.GetFileMetasCh)
+	defer close(fr.GetRemoverPlanCh)
+	defer close(fr.GetTaskerPlanCh)
+	defer close(fr.ReloadCh)
 	periodictm := fr.newPeriodicRunTimer()
 	btwperiodictm := fr.newBetweenEventsRunTimer()
 	for {
@@ -215,6 +247,7 @@ func (fr *Runner) Run(eventCh <-chan FileMetaFilesEvent) error {
 			}
 			if fme.Err != nil {
 				if fme.Err == ErrTimeout {
+					fr.takeOverRun()
 					fr.eventTimeoutRun(fme)
 					btwperiodictm = fr.newBetweenEventsRunTimer()
 				} else {
@@ -222,13 +255,19 @@ func (fr *Runner) Run(eventCh <-chan FileMetaFilesEvent) error {
 				}
 				continue
 			}
+			fr.lastEvent = fme
+			fr.becameLeader()
 			fr.eventRun(fme)
 			btwperiodictm = fr.newBetweenEventsRunTimer()
 		case <-btwperiodictm:
-			fr.betweenEventsRun(FileMetaFilesEvent{})
+			if !fr.takeOverRun() {
+				fr.betweenEventsRun(FileMetaFilesEvent{})
+			}
 			btwperiodictm = fr.newBetweenEventsRunTimer()
 		case <-periodictm:
-			fr.periodicRun(FileMetaFilesEvent{})
+			if !fr.takeOverRun() {
+				fr.periodicRun(FileMetaFilesEvent{})
+			}
 			periodictm = fr.newPeriodicRunTimer()
 		case cmd := <-fr.CMDCh:
 			runnerlogger.Debugf("[%s] received command", cmd)
@@ -239,6 +278,12 @@ func (fr *Runner) Run(eventCh <-chan FileMetaFilesEvent) error {
 			}
 		case req := <-fr.GetFileMetasCh:
 			fr.getFileMetas(req)
+		case req := <-fr.GetRemoverPlanCh:
+			fr.getRemoverPlan(req)
+		case req := <-fr.GetTaskerPlanCh:
+			fr.getTaskerPlan(req)
+		case req := <-fr.ReloadCh:
+			fr.reload(req)
 		}
 	}
 }
@@ -262,7 +307,7 @@ func (fr *Runner) eventTimeoutRun(fme FileMetaFilesEvent) {
 	defer runnerlogElapased("ended timeout run", common.Start())
 
 	for _, r := range fr.SetupRuns[EventTimeoutRuns] {
-		fr.RUNFuncs[r](fr, fme)
+		fr.run(r, fme)
 	}
 }
 
@@ -276,7 +321,7 @@ func (fr *Runner) eventRun(fme FileMetaFilesEvent) {
 	defer runnerlogElapased("ended event run", common.Start())
 
 	for _, r := range fr.SetupRuns[EventRuns] {
-		fr.RUNFuncs[r](fr, fme)
+		fr.run(r, fme)
 	}
 }
 
@@ -285,7 +330,7 @@ func (fr *Runner) betweenEventsRun(fme FileMetaFilesEvent) {
 	defer runnerlogElapased("ended between events run", common.Start())
 
 	for _, r := range fr.SetupRuns[BetweenEventsRuns] {
-		fr.RUNFuncs[r](fr, fme)
+		fr.run(r, fme)
 	}
 }
 
@@ -294,10 +339,57 @@ func (fr *Runner) periodicRun(fme FileMetaFilesEvent) {
 	defer runnerlogElapased("ended periodic run", common.Start())
 
 	for _, r := range fr.SetupRuns[PeriodicRuns] {
-		fr.RUNFuncs[r](fr, fme)
+		fr.run(r, fme)
 	}
 }
 
+// becameLeader :
+//
+// active-standby 모드에서 standby 였다가 leader 가 되었으면 true,
+// 이전 leader 가 저장한 task 목록을 다시 load 해서
+// 이전 leader 가 만든 task 를 다시 만들지 않도록 함
+func (fr *Runner) becameLeader() bool {
+	if fr.IsLeader == nil {
+		return false
+	}
+	leader := fr.IsLeader()
+	became := leader && !fr.leader
+	fr.leader = leader
+	if !became {
+		return false
+	}
+	runnerlogger.Infof("became leader, reload tasks")
+	if fr.tasker != nil {
+		fr.tasker.Tasks().LoadTasks()
+	}
+	return true
+}
+
+// takeOverRun :
+//
+// leader 가 되었으면 다음 파일 event 를 기다리지 않고
+// 마지막 파일 event 로 event run 을 실행하고 true 반환
+func (fr *Runner) takeOverRun() bool {
+	if !fr.becameLeader() || fr.lastEvent.Grade.FilePath == "" {
+		return false
+	}
+	fr.eventRun(fr.lastEvent)
+	return true
+}
+
+// run 하나를 실행하고, 실행 시간을 metrics 에 기록
+//
+// active-standby 모드에서 standby 이면 서버에 요청하는 RunRemover, RunTasker 는 실행하지 않고,
+// leader 가 되었을 때 바로 사용할 수 있도록 file meta, rising hit 는 계속 만듦
+func (fr *Runner) run(r RUN, fme FileMetaFilesEvent) {
+	if (r == RunRemover || r == RunTasker) && fr.IsLeader != nil && !fr.IsLeader() {
+		runnerlogger.Debugf("[%s] skipped run, not leader", r)
+		return
+	}
+	defer metrics.ObserveRun(r.String(), common.Start())
+	fr.RUNFuncs[r](fr, fme)
+}
+
 // runner가 수집해서 가지고 있는 file meta + risinghit 조합
 func (fr *Runner) getFileMetas(req GetFileMetas) {
 	defer close(req.RespCh)
@@ -326,6 +418,55 @@ func (fr *Runner) getFileMetas(req GetFileMetas) {
 	req.RespCh <- res
 }
 
+// runner가 수집해서 가지고 있는 file meta, risinghit 로 구한 remover plan
+func (fr *Runner) getRemoverPlan(req GetRemoverPlan) {
+	defer close(req.RespCh)
+	req.RespCh <- fr.remover.Plan(
+		remover.FileMetaPtrMap(fr.fmm), remover.FileMetaPtrMap(fr.dupFmm), fr.rhm)
+}
+
+// runner가 수집해서 가지고 있는 file meta, risinghit 로 구한 tasker plan
+func (fr *Runner) getTaskerPlan(req GetTaskerPlan) {
+	defer close(req.RespCh)
+	req.RespCh <- fr.tasker.Plan(tasker.FileMetaPtrMap(fr.fmm), fr.rhm)
+}
+
+// 다시 읽은 설정을 run 사이에 반영
+func (fr *Runner) reload(req Reload) {
+	defer close(req.RespCh)
+	req.RespCh <- req.Apply(fr)
+}
+
+// membership 의 서버 구성을 run 사이에 remover, tasker 에 반영
+func (fr *Runner) applyServers() {
+	fr.remover.SetServers(membership.Destinations())
+	fr.tasker.SetServers(membership.Sources(), membership.Destinations())
+}
+
+// Remover : runner 가 실행하는 remover
+func (fr *Runner) Remover() *remover.Remover {
+	return fr.remover
+}
+
+// Tasker : runner 가 실행하는 tasker
+func (fr *Runner) Tasker() *tasker.Tasker {
+	return fr.tasker
+}
+
+// Inventory :
+//
+// remover, tasker 가 함께 사용하는 dest 서버 파일 목록 cache,
+// 한 주기에서 remover 가 구한 목록을 tasker 가 다시 요청하지 않고 사용함,
+// TTL 이 0 이면 cache 하지 않음
+func (fr *Runner) Inventory() *common.Inventory {
+	return fr.inventory
+}
+
+// Tailer : runner 가 급 hit 상승 파일을 구할 때 사용하는 tailer
+func (fr *Runner) Tailer() *tailer.Tailer {
+	return fr.tailer
+}
+
 func (fr *Runner) makeFmm(fme FileMetaFilesEvent) {
 	fmm := make(FileMetaPtrMap)
 	dupfmm := make(FileMetaPtrMap)
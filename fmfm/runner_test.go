@@ -1,11 +1,14 @@
 package fmfm
 
 import (
+	"bytes"
 	"log"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cfm/metrics"
 	"github.com/castisdev/cfm/remover"
 	"github.com/castisdev/cfm/tailer"
 	"github.com/castisdev/cfm/tasker"
@@ -54,6 +57,83 @@ func TestStop(t *testing.T) {
 	}
 }
 
+func TestRunOnlyLeader(t *testing.T) {
+	runner := NewRunner(0, 0, nil, nil, nil)
+	runcount := make(map[RUN]int)
+	runner.RUNFuncs = make(map[RUN]func(*Runner, FileMetaFilesEvent))
+	for _, r := range []RUN{MakeFMM, MakeRisingHit, RunRemover, RunTasker} {
+		r := r
+		runner.RUNFuncs[r] = func(*Runner, FileMetaFilesEvent) { runcount[r]++ }
+	}
+
+	// standby 는 file meta, rising hit 만 만듦
+	leader := false
+	runner.IsLeader = func() bool { return leader }
+	for _, r := range []RUN{MakeFMM, MakeRisingHit, RunRemover, RunTasker} {
+		runner.run(r, FileMetaFilesEvent{})
+	}
+	assert.Equal(t, map[RUN]int{MakeFMM: 1, MakeRisingHit: 1}, runcount)
+
+	leader = true
+	runner.clone().run(RunTasker, FileMetaFilesEvent{})
+	assert.Equal(t, 1, runcount[RunTasker])
+}
+
+func TestTakeOver(t *testing.T) {
+	// 이전 leader 와 같은 store 를 사용
+	store := tasker.NewMemoryStore()
+	tskr := tasker.NewTasker()
+	tskr.Tasks().SetStore(store)
+	tskr.InitTasks()
+
+	runner := NewRunner(1, 0, nil, tskr, nil)
+	runner.SetupRuns = SetupRuns{
+		EventRuns:         []RUN{MakeFMM, RunTasker},
+		BetweenEventsRuns: []RUN{RunTasker},
+	}
+	type ran struct {
+		run   RUN
+		grade string
+	}
+	ranCh := make(chan ran, 10)
+	runner.RUNFuncs = make(map[RUN]func(*Runner, FileMetaFilesEvent))
+	for _, r := range []RUN{MakeFMM, RunTasker} {
+		r := r
+		runner.RUNFuncs[r] = func(_ *Runner, e FileMetaFilesEvent) {
+			ranCh <- ran{r, e.Grade.FilePath}
+		}
+	}
+	var leader int32
+	runner.IsLeader = func() bool { return atomic.LoadInt32(&leader) == 1 }
+
+	eventch := make(chan FileMetaFilesEvent)
+	go runner.Run(eventch)
+	defer waitRunnerStop(runner)
+
+	// standby 는 file meta 만 만듦
+	fme := FileMetaFilesEvent{Grade: FileMonitor{FilePath: "grade.info"}}
+	eventch <- fme
+	assert.Equal(t, ran{MakeFMM, "grade.info"}, <-ranCh)
+
+	// 이전 leader 가 task 를 만든 후, leader 가 됨
+	old := tasker.NewTasksWithStore(store)
+	old.CreateTask(&tasker.Task{SrcIP: "127.0.0.1", FilePath: "/data2/A.mpg",
+		FileName: "A.mpg", DstAddr: "127.0.0.2:8080"})
+	assert.Equal(t, 0, len(tskr.Tasks().GetTaskList()))
+	atomic.StoreInt32(&leader, 1)
+
+	// 다음 file event 를 기다리지 않고 마지막 file event 로 event run 실행,
+	// 이전 leader 가 만든 task 를 load 함
+	select {
+	case r := <-ranCh:
+		assert.Equal(t, ran{MakeFMM, "grade.info"}, r)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no event run after take over")
+	}
+	assert.Equal(t, ran{RunTasker, "grade.info"}, <-ranCh)
+	assert.Equal(t, 1, len(tskr.Tasks().GetTaskList()))
+}
+
 func TestEventTimeoutRun(t *testing.T) {
 	betweenEventsRunSec := uint32(0)
 	periodicRunSec := uint32(0)
@@ -98,11 +178,19 @@ func TestEventRun(t *testing.T) {
 	DefaultEventRuns = []RUN{NOP}
 	runner := NewRunner(betweenEventsRunSec, periodicRunSec, rmr, tskr, tlr)
 
+	metrics.Release()
+	defer metrics.Release()
+
 	eventch := make(chan FileMetaFilesEvent)
 	go runner.Run(eventch)
 
 	waitRunnerEventRun(t, runner, eventch)
 	waitRunnerStop(runner)
+
+	// 실행한 run 의 실행 시간이 metrics 에 기록됨
+	var b bytes.Buffer
+	metrics.Write(&b, nil, nil, time.Now())
+	assert.Contains(t, b.String(), `cfm_run_duration_seconds_count{run="NOP"} 1`)
 }
 
 func TestEventRuns(t *testing.T) {
@@ -1,6 +1,7 @@
 package heartbeater
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sort"
@@ -110,6 +111,29 @@ func Delete(s string) {
 	delete(hosts, s)
 }
 
+// Sync :
+// addrs 에 없는 host 정보는 제거하고, 없던 host 정보는 추가
+func Sync(addrs []string) error {
+	m := make(map[string]bool)
+	for _, s := range addrs {
+		m[s] = true
+	}
+	for _, h := range GetList() {
+		if !m[h.Addr] {
+			Delete(h.Addr)
+		}
+	}
+	for s := range m {
+		if _, ok := Get(s); ok {
+			continue
+		}
+		if err := Add(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // GetList :
 // sort된 host heartbeat status list를 반환
 func GetList() (hl []HBHost) {
@@ -173,25 +197,33 @@ func UpdateList(hl []HBHost) {
 }
 
 // Heartbeat :
+//
+// SetAgentClient 로 설정한 common.AgentClient 로 host 마다 heartbeat 요청,
+// timeoutSec 안에 응답받지 못하면 notok
 func Heartbeat() {
 	hl := GetList()
+	client := common.GetAgentClient()
 
 	newhl := make([]HBHost, len(hl))
 	for _, h := range hl {
-		rc, err := common.Heartbeat(&h.Host, timeoutSec)
-		if rc {
+		ctx, cancel := context.WithTimeout(context.Background(),
+			time.Duration(timeoutSec)*time.Second)
+		err := client.Heartbeat(ctx, &h.Host)
+		cancel()
+		if err == nil {
 			h.Status = OK
 			h.Mtime = HBTime(time.Now().Unix())
 			hber.Debugf("[%s] checked heartbeat ok, host(%s)", h.Host, h)
 		} else {
 			h.Status = NOTOK
 			h.Mtime = HBTime(time.Now().Unix())
-			if err != nil {
+			var se *common.AgentStatusError
+			if errors.As(err, &se) {
+				hber.Errorf("[%s] checked heartbeat not ok, host(%s), timeout(%d)"+
+					", status(%s)", h.Host, h, timeoutSec, se.Status)
+			} else {
 				hber.Errorf("[%s] failed to check heartbeat, host(%s), timeout(%d)"+
 					", error(%s)", h.Host, h, timeoutSec, err.Error())
-			} else {
-				hber.Errorf("[%s] checked heartbeat not ok, host(%s), timeout(%d)",
-					h.Host, h, timeoutSec)
 			}
 		}
 		newhl = append(newhl, h)
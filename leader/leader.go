@@ -0,0 +1,273 @@
+package leader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cilog"
+)
+
+var ldrlogger common.MLogger
+
+func init() {
+	ldrlogger = common.MLogger{
+		Logger: cilog.StdLogger(),
+		Mod:    "leader"}
+}
+
+// Status : leader 선출 상태
+//
+// Leader : 이 process 가 leader 인 지 여부
+//
+// ID : 이 process 의 id
+//
+// Owner : lock 파일에 기록된 leader 의 id
+//
+// Mtime : lock 파일의 mtime, leader 가 lease 를 마지막으로 갱신한 시간, unix time
+type Status struct {
+	Leader bool   `json:"leader"`
+	ID     string `json:"id"`
+	Owner  string `json:"owner"`
+	Mtime  int64  `json:"mtime"`
+}
+
+// Elector : 공유 SAN 의 lock 파일로 active-standby 중 leader 선출
+//
+// lock 파일에 flock 을 걸고, leader 의 id 를 기록하고,
+// renew 주기마다 lock 파일의 mtime 을 갱신해서 lease 를 유지함
+//
+// 	- flock 을 걸지 못하면 다른 process 가 leader 이므로 standby
+//
+// 	- flock 을 걸었지만 다른 leader 의 lease 가 남아있으면 flock 을 풀고 standby,
+// 	  다른 host 에서는 flock 이 보장되지 않아서 lease 로 한 번 더 검사함
+//
+// 	- leader 가 죽거나 lease 를 갱신하지 못하면, lease 가 끝난 후 standby 가 leader 가 됨
+//
+// 	- leader 는 lock 파일의 id 가 바뀌었으면 lease 를 뺏긴 것이므로 standby 가 됨
+//
+// IsLeader 는 lease 가 끝났으면 Elect 가 갱신하지 못하고 멈춰 있어도 false 를 반환해서,
+// 다른 process 가 leader 가 된 후에도 leader 로 동작하지 않도록 함
+//
+// lock 파일을 사용하는 동안에는 electMutex 만 잡고, 상태를 읽고 바꿀 때만 mutex 를 잡아서
+// lock 파일 I/O 가 멈춰도 IsLeader, Status 는 막히지 않음
+type Elector struct {
+	mutex      *sync.RWMutex
+	electMutex *sync.Mutex
+	path       string
+	id         string
+	lease      time.Duration
+	renew      time.Duration
+	now        func() time.Time
+	file       *os.File
+	leader     bool
+	owner      string
+	mtime      time.Time
+}
+
+// NewElector is constructor of Elector
+//
+// lease 는 renew 주기보다 길어야 함
+func NewElector(path, id string, lease, renew time.Duration) (*Elector, error) {
+	if path == "" {
+		return nil, errors.New("empty lock file path")
+	}
+	if id == "" {
+		return nil, errors.New("empty id")
+	}
+	if renew <= 0 || lease <= renew {
+		return nil, errors.New(fmt.Sprintf(
+			"invalid lease(%s), renew(%s), lease must be greater than renew", lease, renew))
+	}
+	return &Elector{
+		mutex:      &sync.RWMutex{},
+		electMutex: &sync.Mutex{},
+		path:       path,
+		id:         id,
+		lease:      lease,
+		renew:      renew,
+		now:        time.Now,
+	}, nil
+}
+
+// DefaultID : hostname/pid
+func DefaultID() string {
+	h, err := os.Hostname()
+	if err != nil {
+		h = "unknown"
+	}
+	return fmt.Sprintf("%s/%d", h, os.Getpid())
+}
+
+// IsLeader : 이 process 가 leader 이고, lease 가 남아있는 지 여부
+func (e *Elector) IsLeader() bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	return e.isLeader()
+}
+
+// isLeader : mutex 를 잡고 호출해야 함
+func (e *Elector) isLeader() bool {
+	return e.leader && e.now().Sub(e.mtime) < e.lease
+}
+
+// Status : leader 선출 상태 반환
+func (e *Elector) Status() Status {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	s := Status{Leader: e.isLeader(), ID: e.id, Owner: e.owner}
+	if !e.mtime.IsZero() {
+		s.Mtime = e.mtime.Unix()
+	}
+	return s
+}
+
+// RunForever : renew 주기마다 leader 선출, lease 갱신
+func (e *Elector) RunForever() {
+	for {
+		e.Elect(time.Now())
+		time.Sleep(e.renew)
+	}
+}
+
+// Elect :
+//
+// leader 이면 lease 를 갱신하고, standby 이면 leader 가 될 수 있는 지 검사
+//
+// now : lease 를 검사하고, 갱신하는 기준 시간
+func (e *Elector) Elect(now time.Time) {
+	e.electMutex.Lock()
+	defer e.electMutex.Unlock()
+
+	if e.file == nil {
+		f, err := os.OpenFile(e.path, os.O_RDWR|os.O_CREATE, os.FileMode(0644))
+		if err != nil {
+			ldrlogger.Errorf("failed to open lock file(%s), error(%s)", e.path, err.Error())
+			e.stepDown()
+			return
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			f.Close()
+			if owner, mtime, err := e.readLease(); err == nil {
+				e.setLease(owner, mtime)
+			}
+			e.stepDown()
+			return
+		}
+		e.file = f
+	}
+
+	owner, mtime, err := e.readLease()
+	if err != nil {
+		ldrlogger.Errorf("failed to read lock file(%s), error(%s)", e.path, err.Error())
+		e.stepDown()
+		return
+	}
+	e.setLease(owner, mtime)
+	if owner != "" && owner != e.id && now.Sub(mtime) < e.lease {
+		ldrlogger.Debugf("lease of leader(%s) is left, mtime(%s)",
+			owner, mtime.Format(time.RFC3339))
+		e.stepDown()
+		return
+	}
+	if err := e.writeLease(owner, now); err != nil {
+		ldrlogger.Errorf("failed to renew lease, lock file(%s), error(%s)", e.path, err.Error())
+		e.stepDown()
+		return
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.owner, e.mtime = e.id, now
+	if !e.leader {
+		ldrlogger.Infof("became leader, id(%s), lock file(%s)", e.id, e.path)
+	}
+	e.leader = true
+}
+
+// Resign :
+//
+// leader 이면 lock 파일의 id 를 지우고 flock 을 풀어서
+// standby 가 lease 가 끝나기를 기다리지 않고 leader 가 되도록 함
+func (e *Elector) Resign() {
+	e.electMutex.Lock()
+	defer e.electMutex.Unlock()
+
+	e.mutex.RLock()
+	leader := e.leader
+	e.mutex.RUnlock()
+	if leader && e.file != nil {
+		if err := e.file.Truncate(0); err != nil {
+			ldrlogger.Errorf("failed to clear lock file(%s), error(%s)", e.path, err.Error())
+		}
+		e.setLease("", e.mtime)
+	}
+	e.stepDown()
+}
+
+// setLease : lock 파일에서 읽은 leader 의 id 와 mtime 반영
+func (e *Elector) setLease(owner string, mtime time.Time) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.owner, e.mtime = owner, mtime
+}
+
+// readLease : lock 파일에 기록된 leader 의 id 와 mtime 읽기
+func (e *Elector) readLease() (string, time.Time, error) {
+	f, err := os.Open(e.path)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	b := make([]byte, 256)
+	n, err := f.Read(b)
+	if err != nil && err != io.EOF {
+		return "", time.Time{}, err
+	}
+	return string(b[:n]), fi.ModTime(), nil
+}
+
+// writeLease : owner 가 자신이 아니면 lock 파일에 id 를 기록하고, mtime 을 now 로 갱신
+func (e *Elector) writeLease(owner string, now time.Time) error {
+	if owner != e.id {
+		if err := e.file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := e.file.WriteAt([]byte(e.id), 0); err != nil {
+			return err
+		}
+		if err := e.file.Sync(); err != nil {
+			return err
+		}
+	}
+	return os.Chtimes(e.path, now, now)
+}
+
+// stepDown : flock 을 풀고 standby 가 됨, electMutex 를 잡고 호출해야 함
+func (e *Elector) stepDown() {
+	if e.file != nil {
+		syscall.Flock(int(e.file.Fd()), syscall.LOCK_UN)
+		e.file.Close()
+		e.file = nil
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.leader {
+		ldrlogger.Infof("became standby, id(%s), leader(%s)", e.id, e.owner)
+	}
+	e.leader = false
+}
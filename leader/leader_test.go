@@ -0,0 +1,89 @@
+package leader
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestElector(t *testing.T) {
+	path := "cfm.lock"
+	defer os.Remove(path)
+
+	_, err := NewElector(path, "cfm1", 10*time.Second, 10*time.Second)
+	assert.NotNil(t, err)
+
+	e1, err := NewElector(path, "cfm1", 30*time.Second, 10*time.Second)
+	assert.Nil(t, err)
+	e2, err := NewElector(path, "cfm2", 30*time.Second, 10*time.Second)
+	assert.Nil(t, err)
+
+	now := time.Unix(time.Now().Unix(), 0)
+	e1.Elect(now)
+	assert.True(t, e1.IsLeader())
+
+	// 다른 process 가 flock 을 갖고 있으면 standby
+	e2.Elect(now)
+	assert.False(t, e2.IsLeader())
+	assert.Equal(t, Status{Leader: false, ID: "cfm2", Owner: "cfm1", Mtime: now.Unix()},
+		e2.Status())
+
+	// leader 가 죽어서 flock 이 풀려도 lease 가 끝날 때까지 standby
+	e1.file.Close()
+	e1.file = nil
+	e2.Elect(now.Add(10 * time.Second))
+	assert.False(t, e2.IsLeader())
+	e2.Elect(now.Add(31 * time.Second))
+	assert.True(t, e2.IsLeader())
+
+	e1.Elect(now.Add(32 * time.Second))
+	assert.False(t, e1.IsLeader())
+
+	// 그만두면 lease 가 끝나기 전에 standby 가 leader 가 됨
+	e2.Resign()
+	assert.False(t, e2.IsLeader())
+	e1.Elect(now.Add(33 * time.Second))
+	assert.True(t, e1.IsLeader())
+
+	// 다른 host 의 process 가 lease 를 가져가면 standby 가 됨
+	assert.Nil(t, ioutil.WriteFile(path, []byte("cfm3"), os.FileMode(0644)))
+	assert.Nil(t, os.Chtimes(path, now.Add(40*time.Second), now.Add(40*time.Second)))
+	e1.Elect(now.Add(43 * time.Second))
+	assert.False(t, e1.IsLeader())
+	assert.Equal(t, "cfm3", e1.Status().Owner)
+}
+
+func TestElectorLeaseExpired(t *testing.T) {
+	path := "cfm.lock"
+	defer os.Remove(path)
+
+	e, err := NewElector(path, "cfm1", 30*time.Second, 10*time.Second)
+	assert.Nil(t, err)
+	defer e.Resign()
+	now := time.Unix(time.Now().Unix(), 0)
+	e.now = func() time.Time { return now }
+	e.Elect(now)
+	assert.True(t, e.IsLeader())
+
+	// lease 를 갱신하지 못하면 lease 가 끝난 후 leader 가 아님
+	e.now = func() time.Time { return now.Add(29 * time.Second) }
+	assert.True(t, e.IsLeader())
+	e.now = func() time.Time { return now.Add(30 * time.Second) }
+	assert.False(t, e.IsLeader())
+	assert.False(t, e.Status().Leader)
+
+	// lock 파일 I/O 가 멈춰 있어도 IsLeader 는 막히지 않음
+	e.electMutex.Lock()
+	done := make(chan bool)
+	go func() { done <- e.IsLeader() }()
+	select {
+	case leader := <-done:
+		assert.False(t, leader)
+	case <-time.After(time.Second):
+		t.Error("IsLeader is blocked by Elect")
+	}
+	e.electMutex.Unlock()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -12,8 +13,12 @@ import (
 
 	"github.com/castisdev/cfm/api"
 	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cfm/decision"
 	"github.com/castisdev/cfm/fmfm"
 	"github.com/castisdev/cfm/heartbeater"
+	"github.com/castisdev/cfm/leader"
+	"github.com/castisdev/cfm/membership"
+	"github.com/castisdev/cfm/metrics"
 	"github.com/castisdev/cfm/remover"
 	"github.com/castisdev/cfm/tailer"
 	"github.com/castisdev/cfm/tasker"
@@ -30,22 +35,37 @@ const (
 
 func main() {
 	debug.SetTraceback("crash")
-	doCli()
-	c := newConfig()
+	cfgFile, checkOnly := doCli()
+	if checkOnly {
+		os.Exit(checkConfig(cfgFile))
+	}
+	c := newConfig(cfgFile)
 	enableCoreDump(c)
 	configCiLogger(c)
 
 	cilog.Infof("started main process")
+	openMembership(c)
 	startHeartbeater(c)
+	applyAgent(c)
+	openDecisionJournal(c)
+	watchMetricFiles(c)
 
-	mgr := startManager(c)
+	el := startElector(c)
+	mgr := startManager(c, el)
+	rl := newReloader(cfgFile, c, mgr)
+	go rl.reloadOnSignal()
 
-	startHttpServer(c, mgr)
+	startHttpServer(c, mgr, rl, el)
 }
 
-func doCli() {
+// doCli : 설정 파일 경로와 설정 파일 검사만 할 지 여부 반환
+//
+// 설정 파일 경로를 지정하지 않으면 실행 파일과 같은 directory 의 cfm.yml
+func doCli() (cfgFile string, checkOnly bool) {
 	printSimpleVer := flag.Bool("v", false, "print version")
 	printVer := flag.Bool("version", false, "print version includes pre-release version")
+	config := flag.String("config", "", "config file path (default cfm.yml in the executable folder)")
+	checkConfig := flag.Bool("check-config", false, "check config file and exit")
 	flag.Parse()
 
 	if *printSimpleVer {
@@ -57,14 +77,28 @@ func doCli() {
 		fmt.Println(AppName + " " + AppVersion + "-" + AppPreRelVer)
 		os.Exit(0)
 	}
+
+	cfgFile = *config
+	if cfgFile == "" {
+		cfgFile = configFilePath()
+	}
+	if args := flag.Args(); len(args) > 0 && args[0] == "tasks" {
+		os.Exit(tasksCommand(cfgFile, args[1:]))
+	}
+	return cfgFile, *checkConfig
 }
 
-func newConfig() *Config {
+// configFilePath : 실행 파일과 같은 directory 의 cfm.yml
+func configFilePath() string {
 	execDir, err := osext.ExecutableFolder()
 	if err != nil {
 		log.Fatalf("failed to get executable folder, %s", err)
 	}
-	c, err := ReadConfig(path.Join(execDir, "cfm.yml"))
+	return path.Join(execDir, "cfm.yml")
+}
+
+func newConfig(cfgFile string) *Config {
+	c, err := ReadConfig(cfgFile)
 	if err != nil {
 		log.Fatalf("failed to read config, error(%s)", err.Error())
 	}
@@ -75,6 +109,34 @@ func newConfig() *Config {
 	return c
 }
 
+// checkConfig :
+//
+// 설정 파일을 읽고, 검사한 결과를 출력
+//
+// 문제가 없으면 0, 있으면 1 반환
+func checkConfig(cfgFile string) int {
+	fmt.Printf("config file(%s)\n", cfgFile)
+	c, err := ReadConfig(cfgFile)
+	if err != nil {
+		fmt.Printf("failed to read config, error(%s)\n", err.Error())
+		return 1
+	}
+	errs := make([]error, 0)
+	if err := ValidationConfig(*c); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, CheckConfig(*c)...)
+	for _, err := range errs {
+		fmt.Printf("- %s\n", err.Error())
+	}
+	if len(errs) > 0 {
+		fmt.Printf("config not ok, errors(%d)\n", len(errs))
+		return 1
+	}
+	fmt.Println("config ok")
+	return 0
+}
+
 func enableCoreDump(c *Config) {
 	if c.EnableCoreDump {
 		if err := common.EnableCoreDump(); err != nil {
@@ -95,13 +157,22 @@ func configCiLogger(c *Config) {
 	cilog.Set(mLogWriter, AppName, AppVersion, logLevel)
 }
 
-func startHeartbeater(c *Config) {
-	for _, s := range c.Servers.Sources {
-		heartbeater.Add(s)
-	}
-	for _, s := range c.Servers.Destinations {
-		heartbeater.Add(s)
+// openMembership :
+//
+// API 로 바꾼 서버 구성을 load 하고, 설정 파일의 서버 목록 설정
+//
+// remover, tasker, heartbeater 는 설정 파일의 서버 목록 대신
+// API 로 바꾼 서버 구성이 반영된 membership 의 서버 목록을 사용함
+func openMembership(c *Config) {
+	if err := membership.Open(
+		storePath(c, c.Servers.StorePath, membership.DefaultPath)); err != nil {
+		log.Fatalf("failed to open servers repository, error(%s)", err.Error())
 	}
+	membership.SetConfigured(c.Servers.members())
+}
+
+func startHeartbeater(c *Config) {
+	heartbeater.Sync(membership.Addrs())
 	heartbeater.SetTimoutSec(c.Servers.HeartbeatTimeoutSec)
 	heartbeater.SetHeartbeatSec(c.Servers.HeartbeatSec)
 
@@ -111,7 +182,44 @@ func startHeartbeater(c *Config) {
 	go heartbeater.RunForever()
 }
 
-func startManager(c *Config) (manager *fmfm.Manager) {
+func openDecisionJournal(c *Config) {
+	err := decision.Open(c.DecisionLogDir(),
+		c.DecisionLog.MaxSize, c.DecisionLog.MaxBackups)
+	if err != nil {
+		log.Fatalf("failed to open decision journal, error(%s)", err.Error())
+	}
+}
+
+func watchMetricFiles(c *Config) {
+	metrics.WatchFile("grade", c.GradeInfoFile)
+	metrics.WatchFile("hitcount", c.HitcountHistoryFile)
+}
+
+// startElector :
+//
+// leader.lock_file 이 설정되어 있으면 active-standby 모드로 leader 선출 시작,
+// 설정되어 있지 않으면 nil 반환, 항상 leader
+func startElector(c *Config) *leader.Elector {
+	if c.Leader.LockFile == "" {
+		return nil
+	}
+	id := c.Leader.ID
+	if id == "" {
+		id = leader.DefaultID()
+	}
+	el, err := leader.NewElector(c.Leader.LockFile, id,
+		time.Duration(c.Leader.LeaseSec)*time.Second,
+		time.Duration(c.Leader.RenewSec)*time.Second)
+	if err != nil {
+		log.Fatalf("failed to create leader elector, error(%s)", err.Error())
+	}
+	el.Elect(time.Now())
+	cilog.Infof("started leader election, %+v", el.Status())
+	go el.RunForever()
+	return el
+}
+
+func startManager(c *Config, el *leader.Elector) (manager *fmfm.Manager) {
 	watcher := fmfm.NewWatcher(
 		c.GradeInfoFile, c.HitcountHistoryFile,
 		c.Watcher.FireInitialEvent, c.Watcher.EventTimeoutSec,
@@ -123,6 +231,10 @@ func startManager(c *Config) (manager *fmfm.Manager) {
 		newTailer(c),
 	)
 	runner.SetupRuns = fmfm.ToSetupRuns(c.Runner.SetupRuns)
+	runner.Inventory().SetTTL(time.Duration(c.Runner.InventoryTTLSec) * time.Second)
+	if el != nil {
+		runner.IsLeader = el.IsLeader
+	}
 
 	manager = fmfm.NewManager(watcher, runner)
 
@@ -132,76 +244,194 @@ func startManager(c *Config) (manager *fmfm.Manager) {
 
 func newTailer(c *Config) (tlr *tailer.Tailer) {
 	tlr = tailer.NewTailer()
+	configureTailer(tlr, c)
+	return tlr
+}
+
+// configureTailer : 재시작하지 않고 바꿀 수 있는 tailer 설정
+func configureTailer(tlr *tailer.Tailer, c *Config) {
 	tlr.SetWatchDir(c.WatchDir)
 	tlr.SetWatchIPString(c.WatchIPString)
 	tlr.SetWatchTermMin(c.WatchTermMin)
 	tlr.SetWatchHitBase(c.WatchHitBase)
-	return tlr
 }
 
 func newRemover(c *Config) (rmr *remover.Remover) {
 	rmr = remover.NewRemover()
-	for _, s := range c.Servers.Destinations {
-		rmr.Servers.Add(s)
+	if err := configureRemover(rmr, c); err != nil {
+		log.Fatalf("can not configure remover. %s", err.Error())
+	}
+	rmr.SetSleepSec(c.Remover.RemoverSleepSec)
+	rmr.SetGradeInfoFile(c.GradeInfoFile)
+	rmr.SetHitcountHistoryFile(c.HitcountHistoryFile)
+
+	rmr.SetPins(remover.NewPinsAt(
+		storePath(c, c.Remover.PinsPath, remover.DefaultPinsPath)))
+	if err := rmr.Pins().Load(); err != nil {
+		log.Fatalf("can not load remover pins, error(%s)", err.Error())
 	}
+	return rmr
+}
+
+// configureRemover : 재시작하지 않고 바꿀 수 있는 remover 설정
+//
+// 서버는 membership 의 목록으로, source 경로 목록은 새로 만들어서 바꿈
+func configureRemover(rmr *remover.Remover, c *Config) error {
+	sourcePath := common.NewSourceDirs()
 	for _, s := range c.SourceDirs {
-		rmr.SourcePath.Add(s)
+		sourcePath.Add(s)
 	}
-	rmr.SetSleepSec(c.Remover.RemoverSleepSec)
 	if err := rmr.SetDiskUsageLimitPercent(
 		c.Remover.StorageUsageLimitPercent); err != nil {
-		log.Fatalf("can not configure remover. storage_usage_limit_percent"+
-			", error(%s)", err.Error())
+		return errors.New(fmt.Sprintf(
+			"storage_usage_limit_percent, error(%s)", err.Error()))
 	}
-	rmr.SetGradeInfoFile(c.GradeInfoFile)
-	rmr.SetHitcountHistoryFile(c.HitcountHistoryFile)
+	if err := rmr.SetMaxCopyCount(c.MaxCopyCount); err != nil {
+		return errors.New(fmt.Sprintf("max_copy_count, error(%s)", err.Error()))
+	}
+	rmr.SetServers(membership.Destinations())
+	rmr.SourcePath = sourcePath
 	rmr.SetIgnorePrefixes(c.Ignore.Prefixes)
-	rmr.Tail.SetWatchDir(c.WatchDir)
-	rmr.Tail.SetWatchIPString(c.WatchIPString)
-	rmr.Tail.SetWatchTermMin(c.WatchTermMin)
-	rmr.Tail.SetWatchHitBase(c.WatchHitBase)
-
-	return rmr
+	configureTailer(rmr.Tail, c)
+	return nil
 }
 
 func newTasker(c *Config) (tskr *tasker.Tasker) {
 	tskr = tasker.NewTasker()
-	for _, s := range c.Servers.Sources {
-		tskr.SrcServers.Add(s)
-	}
-	for _, s := range c.Servers.Destinations {
-		tskr.DstServers.Add(s)
+	if err := configureTasker(tskr, c); err != nil {
+		log.Fatalf("can not configure tasker. %s", err.Error())
 	}
 	tskr.SetSleepSec(c.Tasker.TaskerSleepSec)
-	tskr.SetTaskTimeout(time.Duration(c.Tasker.TaskTimeout) * time.Second)
 	tskr.SetHitcountHistoryFile(c.HitcountHistoryFile)
 	tskr.SetGradeInfoFile(c.GradeInfoFile)
-	tskr.SetTaskCopySpeed(c.Tasker.TaskCopySpeedBPS)
-	tskr.SetIgnorePrefixes(c.Ignore.Prefixes)
-	tskr.Tail.SetWatchDir(c.WatchDir)
-	tskr.Tail.SetWatchIPString(c.WatchIPString)
-	tskr.Tail.SetWatchTermMin(c.WatchTermMin)
-	tskr.Tail.SetWatchHitBase(c.WatchHitBase)
-
-	for _, s := range c.SourceDirs {
-		tskr.SourcePath.Add(s)
-	}
+	tskr.Tasks().SetStore(newTaskStore(c))
+	tskr.History().SetStore(newHistoryStore(c))
 
 	tskr.InitTasks()
 	return tskr
 }
 
-func startHttpServer(c *Config, m *fmfm.Manager) {
+// newTaskStore : tasker.store_backend, tasker.store_path 의 task store
+func newTaskStore(c *Config) tasker.TaskStore {
+	store, err := tasker.NewTaskStore(
+		tasker.ToStoreBackend(c.Tasker.StoreBackend), c.Tasker.StorePath)
+	if err != nil {
+		log.Fatalf("can not create task store. %s", err.Error())
+	}
+	return store
+}
+
+// storePath :
+//
+// leveldb 저장 경로, path 가 있으면 tasker.store_backend 와 상관없이 path
+//
+// path 가 비어있으면 tasker.store_backend 를 따름,
+// memory 이면 저장하지 않도록 빈 문자열, 그렇지 않으면 def
+func storePath(c *Config, path, def string) string {
+	if path != "" {
+		return path
+	}
+	if tasker.ToStoreBackend(c.Tasker.StoreBackend) == tasker.Memory {
+		return ""
+	}
+	return def
+}
+
+// newHistoryStore : tasker.store_backend, tasker.history_path 의 task 기록 store
+func newHistoryStore(c *Config) tasker.HistoryStore {
+	store, err := tasker.NewHistoryStore(
+		tasker.ToStoreBackend(c.Tasker.StoreBackend), c.Tasker.HistoryPath)
+	if err != nil {
+		log.Fatalf("can not create task history store. %s", err.Error())
+	}
+	return store
+}
+
+// configureTasker : 재시작하지 않고 바꿀 수 있는 tasker 설정
+//
+// src, dst 서버는 membership 의 목록으로, source 경로 목록은 새로 만들어서 바꿈,
+// 목록에서 빠진 서버의 task 는 tasker 가 다음 주기에 지움
+func configureTasker(tskr *tasker.Tasker, c *Config) error {
+	sourcePath := common.NewSourceDirs()
+	for _, s := range c.SourceDirs {
+		sourcePath.Add(s)
+	}
+	placement, err := tasker.NewPlacementStrategy(
+		tasker.ToPlacement(c.Tasker.PlacementStrategy),
+		c.Tasker.PlacementHotGrade, c.Remover.StorageUsageLimitPercent)
+	if err != nil {
+		return errors.New(fmt.Sprintf("placement_strategy, error(%s)", err.Error()))
+	}
+	if err := tskr.SetMaxCopyCount(c.MaxCopyCount); err != nil {
+		return errors.New(fmt.Sprintf("max_copy_count, error(%s)", err.Error()))
+	}
+	if err := tskr.History().SetRetention(
+		time.Duration(c.Tasker.HistoryRetention) * time.Hour); err != nil {
+		return errors.New(fmt.Sprintf("history_retention_hours, error(%s)", err.Error()))
+	}
+	if err := tskr.Failures().SetBackoff(
+		time.Duration(c.Tasker.RetryBackoffBase)*time.Second,
+		time.Duration(c.Tasker.RetryBackoffMax)*time.Second); err != nil {
+		return errors.New(fmt.Sprintf("retry_backoff, error(%s)", err.Error()))
+	}
+	if err := tskr.Failures().SetQuarantineLimit(c.Tasker.QuarantineLimit); err != nil {
+		return errors.New(fmt.Sprintf("quarantine_timeouts, error(%s)", err.Error()))
+	}
+	tskr.SetServers(membership.Sources(), membership.Destinations())
+	tskr.SourcePath = sourcePath
+	tskr.SetPlacementStrategy(placement)
+	tskr.SetTaskTimeout(time.Duration(c.Tasker.TaskTimeout) * time.Second)
+	tskr.SetTaskCopySpeed(c.Tasker.TaskCopySpeedBPS)
+	tskr.SetIgnorePrefixes(c.Ignore.Prefixes)
+	configureTailer(tskr.Tail, c)
+	return nil
+}
+
+func startHttpServer(c *Config, m *fmfm.Manager, rl *reloader, el *leader.Elector) {
 	h := api.NewAPIHandler(m)
+	h.SetReloader(rl.reload)
+	if el != nil {
+		h.SetLeader(el)
+	}
+	h.SetAuth(c.API.auth())
+	tlsConfig, err := c.API.tlsConfig()
+	if err != nil {
+		log.Fatalf("failed to load api tls, error(%s)", err.Error())
+	}
+	if tlsConfig == nil && (c.API.Token != "" || c.API.HMACSecret != "") {
+		cilog.Warningf("api.token or api.hmac_secret is set without api.tls, requests are not encrypted")
+	}
 	router := api.NewRouter(h)
 	s := &http.Server{
 		Addr:         c.ListenAddr,
 		Handler:      router,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 5 * time.Second,
+		TLSConfig:    tlsConfig,
 	}
+	// shutdown 할 때 task event stream 이 끝나기를 기다리지 않음
+	s.RegisterOnShutdown(h.CloseStreams)
+
+	done := make(chan bool, 1)
+	go shutdownOnSignal(m, s,
+		time.Duration(c.ShutdownTimeoutSec)*time.Second, done)
 
-	err := s.ListenAndServe()
+	if tlsConfig != nil {
+		// 인증서는 TLSConfig 에 있음
+		err = s.ListenAndServeTLS("", "")
+	} else {
+		err = s.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		stopped := <-done
+		if el != nil && stopped {
+			// standby 가 lease 가 끝나기를 기다리지 않고 leader 가 되도록 함,
+			// runner 가 멈추지 않았으면 standby 는 lease 가 끝난 뒤에 leader 가 됨
+			el.Resign()
+		}
+		cilog.Infof("ended main process")
+		return
+	}
 	if err != nil {
 		log.Fatalf("failed to start, error(%s)", err.Error())
 	}
@@ -0,0 +1,387 @@
+package membership
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cilog"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// role : 서버 구성에서 서버의 역할, API 경로의 값과 같음
+const (
+	SourceRole      = "sources"
+	DestinationRole = "destinations"
+)
+
+// error
+var (
+	ErrInvalidRole = errors.New("invalid role")
+	ErrExist       = errors.New("server already exists")
+	ErrNotExist    = errors.New("server does not exist")
+)
+
+// Server : 서버 구성에 있는 서버
+//
+// Slots : 동시에 할당할 수 있는 task 개수
+//
+// Drain : drain 모드 여부, destination 만 사용
+//
+// Added : 설정 파일에 없지만 API 로 추가한 서버인 지 여부
+type Server struct {
+	Addr  string `json:"addr"`
+	Slots int    `json:"slots"`
+	Drain bool   `json:"drain"`
+	Added bool   `json:"added"`
+}
+
+func (s Server) String() string {
+	return fmt.Sprintf("addr(%s), slots(%d), drain(%t), added(%t)",
+		s.Addr, s.Slots, s.Drain, s.Added)
+}
+
+// change : API 로 바꾼 서버 구성
+//
+// Added : 추가한 서버, Slots 사용
+//
+// Removed : 설정 파일에 있지만 API 로 뺀 서버
+//
+// Drain : drain 모드로 바꾼 destination
+//
+// Mtime : 바꾼 시간, unix time
+type change struct {
+	Role    string `json:"role"`
+	Addr    string `json:"addr"`
+	Slots   int    `json:"slots,omitempty"`
+	Added   bool   `json:"added,omitempty"`
+	Removed bool   `json:"removed,omitempty"`
+	Drain   bool   `json:"drain,omitempty"`
+	Mtime   int64  `json:"mtime"`
+}
+
+func (c change) key() string {
+	return c.Role + "/" + c.Addr
+}
+
+// 바꾼 내용이 없어서 저장할 필요가 없는 지 여부
+func (c change) empty() bool {
+	return !c.Added && !c.Removed && !c.Drain
+}
+
+// configured : 설정 파일의 서버 목록, role 별
+//
+// changes : API 로 바꾼 서버 구성, leveldb 에 저장해서 재시작해도 남음
+//
+// 서버 구성 = 설정 파일의 서버 목록 + 추가한 서버 - 뺀 서버
+var configured map[string][]Server
+var changes map[string]change
+var where string
+var opened bool
+var db *leveldb.DB
+var mutex *sync.RWMutex
+var mlogger common.MLogger
+
+func init() {
+	configured = map[string][]Server{SourceRole: {}, DestinationRole: {}}
+	changes = make(map[string]change)
+	where = ""
+	mutex = &sync.RWMutex{}
+
+	mlogger = common.MLogger{
+		Logger: cilog.StdLogger(),
+		Mod:    "membership"}
+}
+
+func isValidRole(role string) bool {
+	return role == SourceRole || role == DestinationRole
+}
+
+// DefaultPath : API 로 바꾼 서버 구성 leveldb 기본 저장 경로
+const DefaultPath = ".servers/servers.db"
+
+// Open :
+//
+// w 의 repository 를 열고, API 로 바꾼 서버 구성 load,
+// w 가 비어있으면 저장하지 않고 API 로 바꾼 서버 구성은 재시작하면 없어짐
+func Open(w string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if db != nil {
+		db.Close()
+		db = nil
+	}
+	changes = make(map[string]change)
+	if w == "" {
+		where, opened = w, true
+		return nil
+	}
+	d, err := leveldb.OpenFile(w, nil)
+	if err != nil {
+		mlogger.Errorf("failed to open servers repository(%s), error(%s)",
+			w, err.Error())
+		return err
+	}
+	where, db, opened = w, d, true
+
+	iter := db.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		c := change{}
+		if err := json.Unmarshal(iter.Value(), &c); err != nil {
+			mlogger.Errorf("failed to load server change(%s), error(%s)",
+				iter.Value(), err.Error())
+			continue
+		}
+		changes[c.key()] = c
+	}
+	mlogger.Infof("loaded server changes(%d)", len(changes))
+	return nil
+}
+
+// Close : repository 닫기
+func Close() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if db != nil {
+		db.Close()
+		db = nil
+	}
+	opened = false
+}
+
+// Release :
+//
+// 서버 구성과 repository 모두 지우기,
+// 상위 directory 가 비었으면 상위 directory 도 지움
+func Release() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if db != nil {
+		db.Close()
+		db = nil
+	}
+	if where != "" {
+		os.RemoveAll(where)
+		os.Remove(filepath.Dir(where))
+	}
+	opened = false
+	configured = map[string][]Server{SourceRole: {}, DestinationRole: {}}
+	changes = make(map[string]change)
+}
+
+// SetConfigured : 설정 파일의 source, destination 서버 목록 설정
+//
+// 시작할 때와 설정 파일을 다시 읽을 때 호출됨
+func SetConfigured(sources, destinations []Server) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	configured = map[string][]Server{
+		SourceRole:      append([]Server{}, sources...),
+		DestinationRole: append([]Server{}, destinations...),
+	}
+}
+
+// Sources : source 서버 목록을 Addr 큰 순서로 반환
+func Sources() []Server {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return list(SourceRole)
+}
+
+// Destinations : destination 서버 목록을 Addr 큰 순서로 반환
+func Destinations() []Server {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return list(DestinationRole)
+}
+
+// Addrs : source, destination 서버의 중복없는 주소 목록 반환
+func Addrs() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	m := make(map[string]bool)
+	for _, s := range list(SourceRole) {
+		m[s.Addr] = true
+	}
+	for _, s := range list(DestinationRole) {
+		m[s.Addr] = true
+	}
+	al := make([]string, 0, len(m))
+	for a := range m {
+		al = append(al, a)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(al)))
+	return al
+}
+
+// list : 설정 파일의 서버 목록에 API 로 바꾼 서버 구성을 반영한 목록
+func list(role string) []Server {
+	m := make(map[string]Server)
+	for _, s := range configured[role] {
+		m[s.Addr] = s
+	}
+	for _, c := range changes {
+		if c.Role != role {
+			continue
+		}
+		if c.Removed {
+			delete(m, c.Addr)
+			continue
+		}
+		s, ok := m[c.Addr]
+		if c.Added {
+			s = Server{Addr: c.Addr, Slots: c.Slots, Added: true}
+		} else if !ok {
+			// 설정 파일에서 빠진 서버의 drain 모드는 무시
+			continue
+		}
+		s.Drain = c.Drain
+		m[c.Addr] = s
+	}
+	sl := make([]Server, 0, len(m))
+	for _, s := range m {
+		sl = append(sl, s)
+	}
+	sort.Slice(sl, func(i, j int) bool {
+		return sl[i].Addr > sl[j].Addr
+	})
+	return sl
+}
+
+func find(role, addr string) (Server, bool) {
+	for _, s := range list(role) {
+		if s.Addr == addr {
+			return s, true
+		}
+	}
+	return Server{}, false
+}
+
+// save : 바꾼 서버 구성 저장, 바꾼 내용이 없으면 지움
+func save(c change) error {
+	if !opened {
+		return errors.New("servers repository is not opened")
+	}
+	if c.empty() {
+		if db != nil {
+			if err := db.Delete([]byte(c.key()), nil); err != nil {
+				return err
+			}
+		}
+		delete(changes, c.key())
+		return nil
+	}
+	c.Mtime = time.Now().Unix()
+	v, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if db != nil {
+		if err := db.Put([]byte(c.key()), v, nil); err != nil {
+			return err
+		}
+	}
+	changes[c.key()] = c
+	return nil
+}
+
+// Add :
+//
+// 서버 추가, 이미 있는 서버면 ErrExist 반환
+//
+// slots : 동시에 할당할 수 있는 task 개수, 1 보다 작으면 error,
+// destination 은 0 가능, task 에 할당되지 않은 경우에만 선택함
+func Add(role, addr string, slots int) (Server, error) {
+	if !isValidRole(role) {
+		return Server{}, ErrInvalidRole
+	}
+	if slots < 0 || (slots == 0 && role == SourceRole) {
+		return Server{}, errors.New(fmt.Sprintf("invalid slots(%d)", slots))
+	}
+	if _, err := common.SplitHostPort(addr); err != nil {
+		return Server{}, err
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if _, ok := find(role, addr); ok {
+		return Server{}, ErrExist
+	}
+	c := change{Role: role, Addr: addr, Slots: slots, Added: true}
+	if err := save(c); err != nil {
+		mlogger.Errorf("failed to add server, role(%s), addr(%s), error(%s)",
+			role, addr, err.Error())
+		return Server{}, err
+	}
+	s, _ := find(role, addr)
+	mlogger.Infof("added %s server(%s)", role, s)
+	return s, nil
+}
+
+// Remove : 서버 빼기, 없는 서버면 ErrNotExist 반환
+func Remove(role, addr string) error {
+	if !isValidRole(role) {
+		return ErrInvalidRole
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if _, ok := find(role, addr); !ok {
+		return ErrNotExist
+	}
+	c := change{Role: role, Addr: addr}
+	for _, s := range configured[role] {
+		if s.Addr == addr {
+			c.Removed = true
+		}
+	}
+	if err := save(c); err != nil {
+		mlogger.Errorf("failed to remove server, role(%s), addr(%s), error(%s)",
+			role, addr, err.Error())
+		return err
+	}
+	mlogger.Infof("removed %s server(%s)", role, addr)
+	return nil
+}
+
+// SetDrain :
+//
+// destination 의 drain 모드 설정, 없는 서버면 ErrNotExist 반환
+//
+// drain 모드인 destination 은 새 task 의 destination 으로 선택되지 않고,
+// remover 의 삭제 대상에서 빠짐, 진행 중인 task 는 그대로 끝남
+func SetDrain(addr string, drain bool) (Server, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if _, ok := find(DestinationRole, addr); !ok {
+		return Server{}, ErrNotExist
+	}
+	c := change{Role: DestinationRole, Addr: addr}
+	if o, ok := changes[c.key()]; ok {
+		c = o
+	}
+	c.Drain = drain
+	if err := save(c); err != nil {
+		mlogger.Errorf("failed to set drain(%t), addr(%s), error(%s)",
+			drain, addr, err.Error())
+		return Server{}, err
+	}
+	s, _ := find(DestinationRole, addr)
+	mlogger.Infof("set drain(%t), destination server(%s)", drain, s)
+	return s, nil
+}
@@ -0,0 +1,80 @@
+package membership
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMembership(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.db")
+	assert.Nil(t, Open(path))
+	defer Release()
+
+	SetConfigured(
+		[]Server{{Addr: "127.0.0.1:8081", Slots: 1}},
+		[]Server{{Addr: "127.0.0.3:18083", Slots: 2}, {Addr: "127.0.0.4:18084", Slots: 1}})
+
+	_, err := Add("others", "127.0.0.5:18085", 1)
+	assert.Equal(t, ErrInvalidRole, err)
+	_, err = Add(DestinationRole, "127.0.0.5", 1)
+	assert.NotNil(t, err)
+	_, err = Add(SourceRole, "127.0.0.5:18085", 0)
+	assert.NotNil(t, err)
+	_, err = Add(DestinationRole, "127.0.0.5:18085", -1)
+	assert.NotNil(t, err)
+	_, err = Add(DestinationRole, "127.0.0.3:18083", 1)
+	assert.Equal(t, ErrExist, err)
+
+	s, err := Add(DestinationRole, "127.0.0.5:18085", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, Server{Addr: "127.0.0.5:18085", Slots: 3, Added: true}, s)
+
+	assert.Equal(t, ErrNotExist, Remove(SourceRole, "127.0.0.2:8082"))
+	assert.Nil(t, Remove(DestinationRole, "127.0.0.4:18084"))
+
+	_, err = SetDrain("127.0.0.4:18084", true)
+	assert.Equal(t, ErrNotExist, err)
+	s, err = SetDrain("127.0.0.3:18083", true)
+	assert.Nil(t, err)
+	assert.Equal(t, Server{Addr: "127.0.0.3:18083", Slots: 2, Drain: true}, s)
+
+	expected := []Server{
+		{Addr: "127.0.0.5:18085", Slots: 3, Added: true},
+		{Addr: "127.0.0.3:18083", Slots: 2, Drain: true},
+	}
+	assert.Equal(t, expected, Destinations())
+	assert.Equal(t, []Server{{Addr: "127.0.0.1:8081", Slots: 1}}, Sources())
+	assert.Equal(t, []string{"127.0.0.5:18085", "127.0.0.3:18083", "127.0.0.1:8081"}, Addrs())
+
+	// 재시작 후에도 API 로 바꾼 서버 구성이 남음
+	Close()
+	assert.Nil(t, Open(path))
+	assert.Equal(t, expected, Destinations())
+
+	// 설정 파일의 서버 목록이 바뀌어도 API 로 바꾼 서버 구성은 그대로 반영됨
+	SetConfigured(
+		[]Server{{Addr: "127.0.0.1:8081", Slots: 1}},
+		[]Server{{Addr: "127.0.0.3:18083", Slots: 1}, {Addr: "127.0.0.4:18084", Slots: 1},
+			{Addr: "127.0.0.6:18086", Slots: 1}})
+	assert.Equal(t, []Server{
+		{Addr: "127.0.0.6:18086", Slots: 1},
+		{Addr: "127.0.0.5:18085", Slots: 3, Added: true},
+		{Addr: "127.0.0.3:18083", Slots: 1, Drain: true},
+	}, Destinations())
+
+	// drain 해제, 뺀 서버 다시 추가
+	s, err = SetDrain("127.0.0.3:18083", false)
+	assert.Nil(t, err)
+	assert.False(t, s.Drain)
+	_, err = Add(DestinationRole, "127.0.0.4:18084", 2)
+	assert.Nil(t, err)
+	assert.Nil(t, Remove(DestinationRole, "127.0.0.5:18085"))
+	assert.Equal(t, []Server{
+		{Addr: "127.0.0.6:18086", Slots: 1},
+		{Addr: "127.0.0.4:18084", Slots: 2, Added: true},
+		{Addr: "127.0.0.3:18083", Slots: 1},
+	}, Destinations())
+	assert.Equal(t, 1, len(changes))
+}
@@ -0,0 +1,258 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cfm/heartbeater"
+	"github.com/castisdev/cfm/tasker"
+)
+
+// ContentType : prometheus text format content type
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// TaskAgeBuckets : task 나이(초) histogram 의 bucket 경계값
+var TaskAgeBuckets = []float64{60, 300, 900, 1800, 3600, 7200, 21600, 86400}
+
+// runDuration : run 한 번에 걸린 시간
+//
+// last : 마지막으로 실행했을 때 걸린 시간(초)
+//
+// sum, count : 전체 실행 시간의 합(초), 실행 횟수
+type runDuration struct {
+	last  float64
+	sum   float64
+	count uint64
+}
+
+var runs map[string]*runDuration
+var diskUsages map[string]common.DiskUsage
+var files map[string]string
+var mutex *sync.Mutex
+
+func init() {
+	mutex = &sync.Mutex{}
+	Release()
+}
+
+// Release : 모은 값 모두 지우기
+func Release() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	runs = make(map[string]*runDuration)
+	diskUsages = make(map[string]common.DiskUsage)
+	files = make(map[string]string)
+}
+
+// ObserveRun : run 이름과 시작 시간으로 run 실행 시간 기록
+func ObserveRun(run string, start time.Time) {
+	d := time.Since(start).Seconds()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	rd, ok := runs[run]
+	if !ok {
+		rd = &runDuration{}
+		runs[run] = rd
+	}
+	rd.last = d
+	rd.sum += d
+	rd.count++
+}
+
+// SetDiskUsage : 서버의 disk 사용량 기록
+func SetDiskUsage(addr string, du common.DiskUsage) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	diskUsages[addr] = du
+}
+
+// WatchFile :
+//
+// mtime 을 보고할 파일 등록
+//
+// name : grade, hitcount 와 같은 파일 구분 이름
+func WatchFile(name string, path string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	files[name] = path
+}
+
+// Write :
+//
+// task 목록, heartbeat 목록과 기록해 둔 값들을
+// prometheus text format 으로 w 에 씀
+func Write(w io.Writer, tl []tasker.Task, hl []heartbeater.HBHost, now time.Time) {
+	writeTasks(w, tl, now)
+	writeHeartbeats(w, hl)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	writeDiskUsages(w)
+	writeRuns(w)
+	writeFiles(w)
+}
+
+func writeTasks(w io.Writer, tl []tasker.Task, now time.Time) {
+	counts := map[tasker.Status]int{
+		tasker.READY: 0, tasker.WORKING: 0, tasker.DONE: 0, tasker.TIMEOUT: 0,
+	}
+	buckets := make([]uint64, len(TaskAgeBuckets))
+	sum := float64(0)
+	for _, t := range tl {
+		counts[t.Status]++
+		age := float64(now.Unix() - int64(t.Ctime))
+		if age < 0 {
+			age = 0
+		}
+		sum += age
+		for i, le := range TaskAgeBuckets {
+			if age <= le {
+				buckets[i]++
+			}
+		}
+	}
+
+	writeHeader(w, "cfm_tasks", "gauge", "number of tasks by status")
+	for _, s := range []tasker.Status{tasker.READY, tasker.WORKING, tasker.DONE, tasker.TIMEOUT} {
+		writeSample(w, "cfm_tasks", counts[s], "status", s.String())
+	}
+
+	writeHeader(w, "cfm_task_age_seconds", "histogram", "age of tasks since created")
+	for i, le := range TaskAgeBuckets {
+		writeSample(w, "cfm_task_age_seconds_bucket", buckets[i],
+			"le", formatFloat(le))
+	}
+	writeSample(w, "cfm_task_age_seconds_bucket", len(tl), "le", "+Inf")
+	writeSample(w, "cfm_task_age_seconds_sum", formatFloat(sum))
+	writeSample(w, "cfm_task_age_seconds_count", len(tl))
+}
+
+func writeHeartbeats(w io.Writer, hl []heartbeater.HBHost) {
+	sort.Slice(hl, func(i, j int) bool {
+		return hl[i].Addr < hl[j].Addr
+	})
+	writeHeader(w, "cfm_heartbeat_up", "gauge",
+		"heartbeat status of server, 1 if ok")
+	for _, h := range hl {
+		up := 0
+		if h.Status == heartbeater.OK {
+			up = 1
+		}
+		writeSample(w, "cfm_heartbeat_up", up, "server", h.Addr)
+	}
+	writeHeader(w, "cfm_heartbeat_mtime_seconds", "gauge",
+		"unix time when heartbeat of server was checked last")
+	for _, h := range hl {
+		writeSample(w, "cfm_heartbeat_mtime_seconds", int64(h.Mtime), "server", h.Addr)
+	}
+}
+
+func writeDiskUsages(w io.Writer) {
+	addrs := make([]string, 0, len(diskUsages))
+	for addr := range diskUsages {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	writeHeader(w, "cfm_disk_total_bytes", "gauge", "total disk size of server")
+	for _, addr := range addrs {
+		writeSample(w, "cfm_disk_total_bytes", uint64(diskUsages[addr].TotalSize),
+			"server", addr)
+	}
+	writeHeader(w, "cfm_disk_used_bytes", "gauge", "used disk size of server")
+	for _, addr := range addrs {
+		writeSample(w, "cfm_disk_used_bytes", uint64(diskUsages[addr].UsedSize),
+			"server", addr)
+	}
+	writeHeader(w, "cfm_disk_avail_bytes", "gauge", "available disk size of server")
+	for _, addr := range addrs {
+		writeSample(w, "cfm_disk_avail_bytes", uint64(diskUsages[addr].AvailSize),
+			"server", addr)
+	}
+	writeHeader(w, "cfm_disk_used_percent", "gauge", "used disk percent of server")
+	for _, addr := range addrs {
+		writeSample(w, "cfm_disk_used_percent", diskUsages[addr].UsedPercent,
+			"server", addr)
+	}
+}
+
+func writeRuns(w io.Writer) {
+	names := make([]string, 0, len(runs))
+	for name := range runs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeHeader(w, "cfm_run_last_duration_seconds", "gauge",
+		"duration of the last run")
+	for _, name := range names {
+		writeSample(w, "cfm_run_last_duration_seconds",
+			formatFloat(runs[name].last), "run", name)
+	}
+	writeHeader(w, "cfm_run_duration_seconds", "summary", "duration of runs")
+	for _, name := range names {
+		writeSample(w, "cfm_run_duration_seconds_sum",
+			formatFloat(runs[name].sum), "run", name)
+		writeSample(w, "cfm_run_duration_seconds_count",
+			runs[name].count, "run", name)
+	}
+}
+
+func writeFiles(w io.Writer) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeHeader(w, "cfm_file_mtime_seconds", "gauge",
+		"unix time when file was modified, not reported if file does not exist")
+	for _, name := range names {
+		fi, err := os.Stat(files[name])
+		if err != nil {
+			continue
+		}
+		writeSample(w, "cfm_file_mtime_seconds", fi.ModTime().Unix(),
+			"file", name, "path", files[name])
+	}
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+// writeSample : labels 는 이름, 값 순서로 넘김
+func writeSample(w io.Writer, name string, value interface{}, labels ...string) {
+	if len(labels) == 0 {
+		fmt.Fprintf(w, "%s %v\n", name, value)
+		return
+	}
+	ls := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		ls = append(ls, fmt.Sprintf(`%s="%s"`, labels[i], escapeLabel(labels[i+1])))
+	}
+	fmt.Fprintf(w, "%s{%s} %v\n", name, strings.Join(ls, ","), value)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(f float64) string {
+	return fmt.Sprintf("%g", f)
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cfm/heartbeater"
+	"github.com/castisdev/cfm/tasker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveRun(t *testing.T) {
+	Release()
+	defer Release()
+
+	ObserveRun("MAKEFMM", time.Now().Add(-2*time.Second))
+	ObserveRun("MAKEFMM", time.Now().Add(-1*time.Second))
+	ObserveRun("RUNTASKER", time.Now())
+
+	rd := runs["MAKEFMM"]
+	assert.Equal(t, uint64(2), rd.count)
+	assert.True(t, rd.last >= 1 && rd.last < 2)
+	assert.True(t, rd.sum >= 3)
+	assert.Equal(t, uint64(1), runs["RUNTASKER"].count)
+}
+
+func TestWrite(t *testing.T) {
+	Release()
+	defer Release()
+
+	now := time.Now()
+	tl := []tasker.Task{
+		{ID: 1, Status: tasker.READY, Ctime: tasker.TaskTime(now.Unix() - 10)},
+		{ID: 2, Status: tasker.WORKING, Ctime: tasker.TaskTime(now.Unix() - 400)},
+		{ID: 3, Status: tasker.WORKING, Ctime: tasker.TaskTime(now.Unix() - 100000)},
+	}
+	hl := []heartbeater.HBHost{
+		{Host: common.Host{IP: "127.0.0.2", Port: 8082, Addr: "127.0.0.2:8082"},
+			Status: heartbeater.NOTOK, Mtime: heartbeater.HBTime(200)},
+		{Host: common.Host{IP: "127.0.0.1", Port: 8081, Addr: "127.0.0.1:8081"},
+			Status: heartbeater.OK, Mtime: heartbeater.HBTime(100)},
+	}
+	SetDiskUsage("127.0.0.1:8081", common.DiskUsage{
+		TotalSize: 1000, UsedSize: 600, AvailSize: 400, FreeSize: 400, UsedPercent: 60})
+	ObserveRun("RUNREMOVER", now)
+
+	dir := "testmetrics"
+	defer os.RemoveAll(dir)
+	os.MkdirAll(dir, os.FileMode(0755))
+	grade := filepath.Join(dir, "grade")
+	f, _ := os.Create(grade)
+	f.Close()
+	mtime := time.Unix(1500000000, 0)
+	os.Chtimes(grade, mtime, mtime)
+	WatchFile("grade", grade)
+	WatchFile("hitcount", filepath.Join(dir, "notexist"))
+
+	var b bytes.Buffer
+	Write(&b, tl, hl, now)
+	out := b.String()
+
+	for _, l := range []string{
+		"# TYPE cfm_tasks gauge",
+		`cfm_tasks{status="ready"} 1`,
+		`cfm_tasks{status="working"} 2`,
+		`cfm_tasks{status="done"} 0`,
+		`cfm_tasks{status="timeout"} 0`,
+		"# TYPE cfm_task_age_seconds histogram",
+		`cfm_task_age_seconds_bucket{le="60"} 1`,
+		`cfm_task_age_seconds_bucket{le="300"} 1`,
+		`cfm_task_age_seconds_bucket{le="900"} 2`,
+		`cfm_task_age_seconds_bucket{le="86400"} 2`,
+		`cfm_task_age_seconds_bucket{le="+Inf"} 3`,
+		"cfm_task_age_seconds_sum 100410",
+		"cfm_task_age_seconds_count 3",
+		`cfm_heartbeat_up{server="127.0.0.1:8081"} 1`,
+		`cfm_heartbeat_up{server="127.0.0.2:8082"} 0`,
+		`cfm_heartbeat_mtime_seconds{server="127.0.0.1:8081"} 100`,
+		`cfm_disk_total_bytes{server="127.0.0.1:8081"} 1000`,
+		`cfm_disk_used_bytes{server="127.0.0.1:8081"} 600`,
+		`cfm_disk_avail_bytes{server="127.0.0.1:8081"} 400`,
+		`cfm_disk_used_percent{server="127.0.0.1:8081"} 60`,
+		"# TYPE cfm_run_duration_seconds summary",
+		`cfm_run_duration_seconds_count{run="RUNREMOVER"} 1`,
+		`cfm_file_mtime_seconds{file="grade",path="` + grade + `"} 1500000000`,
+	} {
+		assert.Contains(t, out, l+"\n")
+	}
+	// 없는 파일은 보고하지 않음
+	assert.NotContains(t, out, `file="hitcount"`)
+	// heartbeat 는 서버 주소 순서로
+	assert.True(t, strings.Index(out, `cfm_heartbeat_up{server="127.0.0.1:8081"}`) <
+		strings.Index(out, `cfm_heartbeat_up{server="127.0.0.2:8082"}`))
+}
+
+func TestEscapeLabel(t *testing.T) {
+	assert.Equal(t, `a\\b\"c\nd`, escapeLabel("a\\b\"c\nd"))
+}
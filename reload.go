@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/castisdev/cfm/api"
+	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cfm/fmfm"
+	"github.com/castisdev/cfm/heartbeater"
+	"github.com/castisdev/cfm/membership"
+	"github.com/castisdev/cfm/tasker"
+	"github.com/castisdev/cilog"
+)
+
+// configItem : 설정 항목
+//
+// live : 재시작하지 않고 반영할 수 있는 지 여부
+//
+// value : 바뀌었는 지 비교할 설정 값
+type configItem struct {
+	key   string
+	live  bool
+	value func(c *Config) interface{}
+}
+
+var configItems = []configItem{
+	{"source_dirs", true, func(c *Config) interface{} { return c.SourceDirs }},
+	{"hitcount_history_file", false, func(c *Config) interface{} { return c.HitcountHistoryFile }},
+	{"grade_info_file", false, func(c *Config) interface{} { return c.GradeInfoFile }},
+	{"log_dir", false, func(c *Config) interface{} { return c.LogDir }},
+	{"log_level", false, func(c *Config) interface{} { return c.LogLevel }},
+	{"servers.sources", true, func(c *Config) interface{} { return c.Servers.Sources }},
+	{"servers.destinations", true, func(c *Config) interface{} { return c.Servers.Destinations }},
+	{"servers.heartbeat_timeout_sec", true, func(c *Config) interface{} { return c.Servers.HeartbeatTimeoutSec }},
+	{"servers.heartbeat_interval_sec", true, func(c *Config) interface{} { return c.Servers.HeartbeatSec }},
+	{"servers.default_source_slots", true, func(c *Config) interface{} { return c.Servers.DefaultSourceSlots }},
+	{"servers.default_destination_slots", true, func(c *Config) interface{} { return c.Servers.DefaultDestinationSlots }},
+	{"servers.source_slots", true, func(c *Config) interface{} { return c.Servers.SourceSlots }},
+	{"servers.destination_slots", true, func(c *Config) interface{} { return c.Servers.DestinationSlots }},
+	{"servers.store_path", false, func(c *Config) interface{} { return c.Servers.StorePath }},
+	{"watch_dir", true, func(c *Config) interface{} { return c.WatchDir }},
+	{"watch_ip_string", true, func(c *Config) interface{} { return c.WatchIPString }},
+	{"watch_term_min", true, func(c *Config) interface{} { return c.WatchTermMin }},
+	{"watch_hit_base", true, func(c *Config) interface{} { return c.WatchHitBase }},
+	{"max_copy_count", true, func(c *Config) interface{} { return c.MaxCopyCount }},
+	{"enable_coredump", false, func(c *Config) interface{} { return c.EnableCoreDump }},
+	{"listen_addr", false, func(c *Config) interface{} { return c.ListenAddr }},
+	{"shutdown_timeout_sec", false, func(c *Config) interface{} { return c.ShutdownTimeoutSec }},
+	{"remover.remover_sleep_sec", false, func(c *Config) interface{} { return c.Remover.RemoverSleepSec }},
+	{"remover.storage_usage_limit_percent", true, func(c *Config) interface{} { return c.Remover.StorageUsageLimitPercent }},
+	{"remover.pins_path", false, func(c *Config) interface{} { return c.Remover.PinsPath }},
+	{"tasker.tasker_sleep_sec", false, func(c *Config) interface{} { return c.Tasker.TaskerSleepSec }},
+	{"tasker.task_timeout_sec", true, func(c *Config) interface{} { return c.Tasker.TaskTimeout }},
+	{"tasker.task_copy_speed_bps", true, func(c *Config) interface{} { return c.Tasker.TaskCopySpeedBPS }},
+	{"tasker.placement_strategy", true, func(c *Config) interface{} { return c.Tasker.PlacementStrategy }},
+	{"tasker.placement_hot_grade", true, func(c *Config) interface{} { return c.Tasker.PlacementHotGrade }},
+	{"tasker.history_retention_hours", true, func(c *Config) interface{} { return c.Tasker.HistoryRetention }},
+	{"tasker.retry_backoff_base_sec", true, func(c *Config) interface{} { return c.Tasker.RetryBackoffBase }},
+	{"tasker.retry_backoff_max_sec", true, func(c *Config) interface{} { return c.Tasker.RetryBackoffMax }},
+	{"tasker.quarantine_timeouts", true, func(c *Config) interface{} { return c.Tasker.QuarantineLimit }},
+	{"tasker.store_backend", false, func(c *Config) interface{} { return c.Tasker.StoreBackend }},
+	{"tasker.store_path", false, func(c *Config) interface{} { return c.Tasker.StorePath }},
+	{"tasker.history_path", false, func(c *Config) interface{} { return c.Tasker.HistoryPath }},
+	{"ignore.prefixes", true, func(c *Config) interface{} { return c.Ignore.Prefixes }},
+	{"watcher", false, func(c *Config) interface{} { return c.Watcher }},
+	{"runner.between_events_run_interval_sec", false, func(c *Config) interface{} { return c.Runner.BetweenEventsRunSec }},
+	{"runner.periodic_run_interval_sec", false, func(c *Config) interface{} { return c.Runner.PeriodicRunSec }},
+	{"runner.setup_runs", true, func(c *Config) interface{} { return c.Runner.SetupRuns }},
+	{"runner.inventory_ttl_sec", true, func(c *Config) interface{} { return c.Runner.InventoryTTLSec }},
+	{"decision_log", false, func(c *Config) interface{} { return c.DecisionLog }},
+	{"leader", false, func(c *Config) interface{} { return c.Leader }},
+	{"agent.parallelism", true, func(c *Config) interface{} { return c.Agent.Parallelism }},
+	{"agent.timeout_sec", true, func(c *Config) interface{} { return c.Agent.TimeoutSec }},
+	{"agent.retry_count", true, func(c *Config) interface{} { return c.Agent.RetryCount }},
+	{"agent.retry_backoff_ms", true, func(c *Config) interface{} { return c.Agent.RetryBackoffMs }},
+	{"agent.tls", true, func(c *Config) interface{} { return c.Agent.TLS }},
+	{"agent.token", true, func(c *Config) interface{} { return c.Agent.Token }},
+	{"api", false, func(c *Config) interface{} { return c.API }},
+}
+
+// diffConfig :
+//
+// 바뀐 설정 중 재시작하지 않고 반영할 수 있는 설정은 applied,
+// 재시작해야 반영되는 설정은 notApplied 로 반환
+//
+// applied 는 마지막으로 반영한 설정(cur)과,
+// notApplied 는 시작할 때 읽은 설정(started)과 비교함
+func diffConfig(started, cur, c *Config) (applied, notApplied []string) {
+	applied = make([]string, 0)
+	notApplied = make([]string, 0)
+	for _, item := range configItems {
+		if item.live {
+			if !reflect.DeepEqual(item.value(cur), item.value(c)) {
+				applied = append(applied, item.key)
+			}
+		} else if !reflect.DeepEqual(item.value(started), item.value(c)) {
+			notApplied = append(notApplied, item.key)
+		}
+	}
+	return applied, notApplied
+}
+
+// reloader : 설정 파일을 다시 읽어서 재시작하지 않고 반영
+//
+// SIGHUP 또는 POST /admin/reload 로 실행됨
+//
+// started : 시작할 때 읽은 설정
+//
+// cur : 마지막으로 반영한 설정
+type reloader struct {
+	mutex   *sync.Mutex
+	cfgFile string
+	started *Config
+	cur     *Config
+	mgr     *fmfm.Manager
+}
+
+func newReloader(cfgFile string, c *Config, mgr *fmfm.Manager) *reloader {
+	return &reloader{
+		mutex:   &sync.Mutex{},
+		cfgFile: cfgFile,
+		started: c,
+		cur:     c,
+		mgr:     mgr,
+	}
+}
+
+// reloadOnSignal : SIGHUP 을 받을 때마다 설정 다시 읽기
+func (rl *reloader) reloadOnSignal() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	for range sigCh {
+		cilog.Infof("received SIGHUP, reload config(%s)", rl.cfgFile)
+		rl.reload()
+	}
+}
+
+// reload :
+//
+// 설정 파일을 다시 읽고, 검사한 후 바뀐 설정을 반영
+//
+// 	- membership : 설정 파일의 서버 목록을 바꾸고, API 로 바꾼 서버 구성은 그대로 둠
+//
+// 	- heartbeater : 추가된 서버는 heartbeat 대상에 넣고, 빠진 서버는 뺌
+//
+// 	- agent : 다음 파일 목록, disk 사용량, 삭제, heartbeat 요청부터 반영
+//
+// 	- remover, tasker, tailer, runner.setup_runs : runner 가 run 사이에 반영
+//
+// 반영하기 전에 agent, remover, tasker 설정으로 만들 값을 모두 만들어서 검사하고,
+// 잘못된 설정이면 아무것도 반영하지 않고 error 반환
+//
+// runner 에 반영하지 못하면 membership, heartbeater, agent, runner 를 마지막으로 반영한 설정으로 되돌림
+func (rl *reloader) reload() (api.ReloadReport, error) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	res := api.ReloadReport{Applied: []string{}, NotApplied: []string{}}
+	c, err := ReadConfig(rl.cfgFile)
+	if err != nil {
+		cilog.Errorf("failed to reload config, error(%s)", err.Error())
+		return res, err
+	}
+	if err := ValidationConfig(*c); err != nil {
+		cilog.Errorf("failed to reload config, error(%s)", err.Error())
+		return res, err
+	}
+
+	res.Applied, res.NotApplied = diffConfig(rl.started, rl.cur, c)
+	if len(res.NotApplied) > 0 {
+		cilog.Warningf("changed config(%v), restart to apply", res.NotApplied)
+	}
+	if len(res.Applied) == 0 {
+		cilog.Infof("reloaded config, nothing to apply")
+		return res, nil
+	}
+
+	agent, err := newAgentSettings(c)
+	if err != nil {
+		cilog.Errorf("failed to reload config, error(%s)", err.Error())
+		return res, err
+	}
+	if err := checkRunner(c); err != nil {
+		cilog.Errorf("failed to reload config, error(%s)", err.Error())
+		return res, err
+	}
+
+	prev := rl.cur
+	membership.SetConfigured(c.Servers.members())
+	applyHeartbeater(c)
+	agent.apply()
+	if err := rl.mgr.Reload(func(r *fmfm.Runner) error {
+		if err := applyRunner(r, c); err != nil {
+			if rerr := applyRunner(r, prev); rerr != nil {
+				cilog.Errorf("failed to roll back runner config, error(%s)", rerr.Error())
+			}
+			return err
+		}
+		return nil
+	}); err != nil {
+		cilog.Errorf("failed to apply config(%v), roll back, error(%s)", res.Applied, err.Error())
+		membership.SetConfigured(prev.Servers.members())
+		applyHeartbeater(prev)
+		applyAgent(prev)
+		return res, err
+	}
+	rl.cur = c
+	cilog.Infof("reloaded config, applied(%v)", res.Applied)
+	return res, nil
+}
+
+// applyHeartbeater : heartbeat 대상 서버와 heartbeat 설정 반영
+func applyHeartbeater(c *Config) {
+	heartbeater.Sync(membership.Addrs())
+	heartbeater.SetTimoutSec(c.Servers.HeartbeatTimeoutSec)
+	heartbeater.SetHeartbeatSec(c.Servers.HeartbeatSec)
+}
+
+// agentSettings : 설정으로 만든 CiMonitoringAgent 요청 방법
+type agentSettings struct {
+	fanOut common.FanOut
+	client *common.AgentClient
+}
+
+// newAgentSettings :
+//
+// CiMonitoringAgent 에 동시에 요청하는 서버 수, 제한 시간, 다시 요청하는 방법, TLS, token 으로
+// 반영할 FanOut, AgentClient 를 만들고 검사
+func newAgentSettings(c *Config) (agentSettings, error) {
+	f := c.Agent.fanOut()
+	if err := f.Validate(); err != nil {
+		return agentSettings{}, errors.New(fmt.Sprintf("agent fan-out, error(%s)", err.Error()))
+	}
+	cfg, err := c.Agent.agentClient()
+	if err != nil {
+		return agentSettings{}, errors.New(fmt.Sprintf("agent client, error(%s)", err.Error()))
+	}
+	client, err := common.NewAgentClient(cfg)
+	if err != nil {
+		return agentSettings{}, errors.New(fmt.Sprintf("agent client, error(%s)", err.Error()))
+	}
+	return agentSettings{fanOut: f, client: client}, nil
+}
+
+// apply : 다음 파일 목록, disk 사용량, 삭제, heartbeat 요청부터 반영
+func (s agentSettings) apply() {
+	common.SetFanOut(s.fanOut)
+	common.SetAgentClient(s.client)
+}
+
+// applyAgent : CiMonitoringAgent 에 요청하는 설정 반영, 잘못된 설정이면 반영하지 않음
+func applyAgent(c *Config) {
+	s, err := newAgentSettings(c)
+	if err != nil {
+		cilog.Errorf("failed to set agent, error(%s)", err.Error())
+		return
+	}
+	s.apply()
+}
+
+// checkRunner :
+//
+// runner 에 반영하기 전에 remover, tasker 설정으로 만들 값을 검사,
+// remover, tasker 에 설정하는 값의 범위는 ValidationConfig 에서 검사함
+func checkRunner(c *Config) error {
+	if _, err := tasker.NewPlacementStrategy(
+		tasker.ToPlacement(c.Tasker.PlacementStrategy),
+		c.Tasker.PlacementHotGrade, c.Remover.StorageUsageLimitPercent); err != nil {
+		return errors.New(fmt.Sprintf("placement_strategy, error(%s)", err.Error()))
+	}
+	return nil
+}
+
+// applyRunner : runner 의 remover, tasker, tailer, setup runs, inventory 에 설정 반영
+func applyRunner(r *fmfm.Runner, c *Config) error {
+	if err := configureRemover(r.Remover(), c); err != nil {
+		return err
+	}
+	if err := configureTasker(r.Tasker(), c); err != nil {
+		return err
+	}
+	configureTailer(r.Tailer(), c)
+	r.SetupRuns = fmfm.ToSetupRuns(c.Runner.SetupRuns)
+	r.Inventory().SetTTL(time.Duration(c.Runner.InventoryTTLSec) * time.Second)
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/castisdev/cfm/fmfm"
+	"github.com/castisdev/cfm/heartbeater"
+	"github.com/castisdev/cfm/membership"
+	"github.com/castisdev/cfm/remover"
+	"github.com/castisdev/cfm/tailer"
+	"github.com/castisdev/cfm/tasker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffConfig(t *testing.T) {
+	started := &Config{ListenAddr: "127.0.0.1:8080", MaxCopyCount: 1,
+		Ignore: Ignore{Prefixes: []string{"M64"}}}
+	cur := &Config{ListenAddr: "127.0.0.1:8080", MaxCopyCount: 2,
+		Ignore: Ignore{Prefixes: []string{"M64"}}}
+	c := &Config{ListenAddr: "127.0.0.1:7888", MaxCopyCount: 2,
+		Ignore: Ignore{Prefixes: []string{"M64", "MN1"}}}
+
+	applied, notApplied := diffConfig(started, cur, c)
+	assert.Equal(t, []string{"ignore.prefixes"}, applied)
+	assert.Equal(t, []string{"listen_addr"}, notApplied)
+
+	applied, notApplied = diffConfig(c, c, c)
+	assert.Equal(t, []string{}, applied)
+	assert.Equal(t, []string{}, notApplied)
+}
+
+// serveReload : manager 대신 reload 요청을 받아서 runner 에 반영
+func serveReload(mgr *fmfm.Manager, r *fmfm.Runner) {
+	go func() {
+		for req := range mgr.ReloadCh {
+			req.RespCh <- req.Apply(r)
+			close(req.RespCh)
+		}
+	}()
+}
+
+func TestReload(t *testing.T) {
+	dir := "testconfig"
+	file := "cfm.yml"
+	cfgFile := filepath.Join(dir, file)
+	defer deletefile(dir, "")
+	defer heartbeater.Release()
+
+	writeconfigfile(dir, file, []byte(`
+listen_addr: 127.0.0.1:7888
+ignore:
+  prefixes:
+  - M64
+servers:
+  sources:
+  - 127.0.0.1:18881
+  destinations:
+  - 127.0.0.1:18882
+`))
+	c, err := ReadConfig(cfgFile)
+	assert.Nil(t, err)
+	startHeartbeater(c)
+
+	r := fmfm.NewRunner(0, 0, remover.NewRemover(), tasker.NewTasker(), tailer.NewTailer())
+	assert.Nil(t, applyRunner(r, c))
+	mgr := fmfm.NewManager(nil, r)
+	defer close(mgr.ReloadCh)
+	serveReload(mgr, r)
+	rl := newReloader(cfgFile, c, mgr)
+
+	// destination 추가, ignore.prefixes, setup_runs, listen_addr 변경
+	writeconfigfile(dir, file, []byte(`
+listen_addr: 127.0.0.1:7889
+ignore:
+  prefixes:
+  - M64
+  - MN1
+runner:
+  setup_runs:
+    periodicRuns: [runRemover]
+servers:
+  sources:
+  - 127.0.0.1:18881
+  destinations:
+  - 127.0.0.1:18882
+  - 127.0.0.1:18883
+  destination_slots:
+  - addr: 127.0.0.1:18883
+    slots: 2
+`))
+	res, err := rl.reload()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"servers.destinations", "servers.destination_slots",
+		"ignore.prefixes", "runner.setup_runs"}, res.Applied)
+	assert.Equal(t, []string{"listen_addr"}, res.NotApplied)
+
+	assert.Equal(t, 2, len(*r.Tasker().DstServers))
+	assert.Equal(t, "127.0.0.1:18883", (*r.Tasker().DstServers)[0].Addr)
+	assert.Equal(t, 2, len(*r.Remover().Servers))
+	assert.Equal(t, []fmfm.RUN{fmfm.RunRemover}, r.SetupRuns[fmfm.PeriodicRuns])
+	_, found := heartbeater.Get("127.0.0.1:18883")
+	assert.True(t, found)
+
+	// 다시 읽어도 바뀐 설정이 없으면 반영할 것 없음,
+	// 재시작해야 반영되는 설정은 계속 보고
+	res, err = rl.reload()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, res.Applied)
+	assert.Equal(t, []string{"listen_addr"}, res.NotApplied)
+
+	// 잘못된 설정이면 반영하지 않음
+	writeconfigfile(dir, file, []byte(`
+max_copy_count: 0
+servers:
+  destinations:
+  - 127.0.0.1:18884
+`))
+	_, err = rl.reload()
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, len(*r.Tasker().DstServers))
+	_, found = heartbeater.Get("127.0.0.1:18884")
+	assert.False(t, found)
+}
+
+func TestReloadRollback(t *testing.T) {
+	dir := "testconfig"
+	file := "cfm.yml"
+	cfgFile := filepath.Join(dir, file)
+	defer deletefile(dir, "")
+	defer heartbeater.Release()
+
+	writeconfigfile(dir, file, []byte(`
+servers:
+  sources:
+  - 127.0.0.1:18881
+  destinations:
+  - 127.0.0.1:18882
+`))
+	c, err := ReadConfig(cfgFile)
+	assert.Nil(t, err)
+	defer membership.Release()
+	membership.SetConfigured(c.Servers.members())
+	startHeartbeater(c)
+
+	r := fmfm.NewRunner(0, 0, remover.NewRemover(), tasker.NewTasker(), tailer.NewTailer())
+	assert.Nil(t, applyRunner(r, c))
+	mgr := fmfm.NewManager(nil, r)
+	defer close(mgr.ReloadCh)
+	// runner 가 반영하지 못함
+	go func() {
+		for req := range mgr.ReloadCh {
+			req.RespCh <- errors.New("failed to apply")
+			close(req.RespCh)
+		}
+	}()
+	rl := newReloader(cfgFile, c, mgr)
+
+	writeconfigfile(dir, file, []byte(`
+servers:
+  sources:
+  - 127.0.0.1:18881
+  destinations:
+  - 127.0.0.1:18882
+  - 127.0.0.1:18883
+`))
+	_, err = rl.reload()
+	assert.NotNil(t, err)
+
+	// membership, heartbeater 는 이전 설정으로 되돌리고, 마지막으로 반영한 설정은 그대로
+	assert.Equal(t, []string{"127.0.0.1:18882"}, addrs(membership.Destinations()))
+	_, found := heartbeater.Get("127.0.0.1:18883")
+	assert.False(t, found)
+	assert.Equal(t, c, rl.cur)
+}
+
+func addrs(sl []membership.Server) []string {
+	al := make([]string, 0, len(sl))
+	for _, s := range sl {
+		al = append(al, s.Addr)
+	}
+	return al
+}
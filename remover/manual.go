@@ -0,0 +1,111 @@
+package remover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cfm/decision"
+)
+
+// RemoveFile :
+//
+// API 로 요청받은 파일을 서버에 삭제 요청
+//
+// remover 의 삭제와 같은 안전 검사를 한 후 삭제 요청함
+//
+// 	- 서버 목록에 없는 서버, 서버에 없는 파일이면 not.found.in.the.server
+//
+// 	- ignore.prefix 로 시작하는 파일이면 ignore.prefix
+//
+// 	- 고정한 파일이면 pinned
+//
+// 	- drain 모드인 서버면 draining
+//
+// 	- SAN 에 없는 파일이면 not.found.in.the.source.paths
+//
+// 	- 다른 서버에 파일이 없으면 last.copy,
+// 	  서버 파일 목록은 inventory 에 cache 된 목록을 사용하고,
+// 	  파일 목록을 구하지 못한 서버는 파일이 없는 것으로 봄
+//
+// 	- 삭제 요청이 실패하면 request.failed
+//
+// 삭제하지 못하면 이유와 error 를 반환, 삭제 요청하면 manual 을 반환
+func (rmr *Remover) RemoveFile(addr, fileName string) (string, error) {
+	var server *common.Host
+	for _, s := range *rmr.Servers {
+		if s.Addr == addr {
+			server = s
+		}
+	}
+	if server == nil {
+		return decision.NotFoundInTheServer, errors.New(fmt.Sprintf(
+			"server(%s) not found", addr))
+	}
+
+	fm := common.NewFileMetaWith(fileName, 0)
+	reason, err := rmr.checkForManualDelete(server, fm)
+	if err != nil {
+		rmrlogger.Infof("[%s] ignored manual delete by %s, file(%s), error(%s)",
+			server, reason, fileName, err.Error())
+		rmr.record(fm, server, decision.SKIP, reason)
+		return reason, err
+	}
+
+	if err := common.GetAgentClient().DeleteFile(context.Background(), server, fileName); err != nil {
+		rmrlogger.Errorf("[%s] failed to request to delete manually, file(%s), error(%s)",
+			server, fileName, err.Error())
+		rmr.record(fm, server, decision.SKIP, decision.RequestFailed)
+		return decision.RequestFailed, err
+	}
+	rmrlogger.Infof("[%s] requested to delete manually, file(%s)", server, fileName)
+	rmr.record(fm, server, decision.DELETE, decision.Manual)
+	rmr.inventory.Removed(server.Addr, fileName)
+	return decision.Manual, nil
+}
+
+// checkForManualDelete : 삭제할 수 없으면 이유와 error 반환
+func (rmr *Remover) checkForManualDelete(server *common.Host,
+	fm *common.FileMeta) (string, error) {
+	if common.IsPrefix(fm.Name, rmr.ignorePrefixes) {
+		return decision.IgnorePrefix, errors.New("file has ignore prefix")
+	}
+	if rmr.pins.IsPinned(fm.Name) {
+		return decision.Pinned, errors.New("file is pinned")
+	}
+	if rmr.draining[server.Addr] {
+		return decision.Draining, errors.New("server is draining")
+	}
+	if _, exists := rmr.SourcePath.IsExistOnSource(fm.Name); !exists {
+		return decision.NotFoundInTheSourcePaths, errors.New("file not found in the source paths")
+	}
+
+	found := false
+	copies := 0
+	lists, rep := rmr.inventory.FileLists(*rmr.Servers)
+	for _, s := range *rmr.Servers {
+		fl, ok := lists[s.Addr]
+		if !ok {
+			rmrlogger.Errorf("[%s] failed to get file list, error(%v)", s, rep.Failed[s.Addr])
+			continue
+		}
+		for _, f := range fl.Files {
+			if f != fm.Name {
+				continue
+			}
+			copies++
+			if s.Addr == server.Addr {
+				found = true
+			}
+			break
+		}
+	}
+	if !found {
+		return decision.NotFoundInTheServer, errors.New("file not found in the server")
+	}
+	if copies <= 1 {
+		return decision.LastCopy, errors.New("file is the last copy in the servers")
+	}
+	return "", nil
+}
@@ -0,0 +1,71 @@
+package remover
+
+import (
+	"testing"
+	"time"
+
+	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cfm/decision"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoveFile(t *testing.T) {
+	rmr := NewRemover()
+	rmr.Servers = common.NewHosts()
+	s1 := "127.0.0.1:18881"
+	s2 := "127.0.0.2:18882"
+	s3 := "127.0.0.3:18883"
+	du := common.DiskUsage{
+		TotalSize: 1000, UsedSize: 600,
+		FreeSize: 400, AvailSize: 400, UsedPercent: 60,
+	}
+	rmr.Servers.Add(s1)
+	rmr.Servers.Add(s2)
+	rmr.Servers.Add(s3)
+	rmr.inventory.SetTTL(time.Minute)
+	cfw1 := cfw(s1, du, []string{"A.mpg", "B.mpg", "C.mpg", "D.mpg", "E.mpg"})
+	cfw1.Start()
+	defer cfw1.Close()
+	cfw2 := cfw(s2, du, []string{"B.mpg", "C.mpg", "D.mpg", "E.mpg"})
+	cfw2.Start()
+	defer cfw2.Close()
+	// s3 는 파일 목록을 구할 수 없음
+
+	base := "testsourcefolder"
+	rmr.SourcePath.Add(base)
+	for _, f := range []string{"A.mpg", "B.mpg", "D.mpg", "E.mpg"} {
+		createfile(base, f)
+	}
+	defer deletefile(base, "")
+
+	rmr.SetIgnorePrefixes([]string{"E"})
+	defer rmr.Pins().Release()
+	rmr.Pins().Add("D.mpg")
+
+	for _, tc := range []struct {
+		addr, fileName, reason string
+	}{
+		{"127.0.0.9:18889", "B.mpg", decision.NotFoundInTheServer},
+		{s2, "A.mpg", decision.NotFoundInTheServer},
+		{s3, "B.mpg", decision.NotFoundInTheServer},
+		// 다른 서버에 없는 파일
+		{s1, "A.mpg", decision.LastCopy},
+		// SAN 에 없는 파일
+		{s1, "C.mpg", decision.NotFoundInTheSourcePaths},
+		{s1, "D.mpg", decision.Pinned},
+		{s1, "E.mpg", decision.IgnorePrefix},
+	} {
+		reason, err := rmr.RemoveFile(tc.addr, tc.fileName)
+		assert.NotNil(t, err, tc.fileName)
+		assert.Equal(t, tc.reason, reason, tc.fileName)
+	}
+
+	reason, err := rmr.RemoveFile(s2, "B.mpg")
+	assert.Nil(t, err)
+	assert.Equal(t, decision.Manual, reason)
+
+	// 삭제 요청한 파일은 inventory 에 반영되어 남은 copy 로 검사함
+	reason, err = rmr.RemoveFile(s1, "B.mpg")
+	assert.NotNil(t, err)
+	assert.Equal(t, decision.LastCopy, reason)
+}
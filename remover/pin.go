@@ -0,0 +1,204 @@
+package remover
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Pin : 삭제하지 않도록 고정한 파일
+//
+// Ctime : 고정한 시간, unix time
+type Pin struct {
+	FileName string `json:"file_name"`
+	Ctime    int64  `json:"ctime"`
+}
+
+// String : pin to string
+func (p Pin) String() string {
+	return fmt.Sprintf("fileName(%s), ctime(%s)",
+		p.FileName, time.Unix(p.Ctime, 0).Format(time.RFC3339))
+}
+
+// Pins : 삭제하지 않도록 고정한 파일 목록
+//
+// remover 는 고정한 파일을 중복 파일 삭제, disk 용량 확보를 위한 삭제,
+// API 로 요청받은 삭제 대상에서 제외함
+//
+// 목록은 where 의 leveldb 에 저장해서 재시작해도 남음,
+// where 가 비어있으면 저장하지 않음
+type Pins struct {
+	mutex *sync.RWMutex
+	m     map[string]Pin
+	where string
+	db    *leveldb.DB
+}
+
+// DefaultPinsPath : 고정한 파일 목록 leveldb 기본 저장 경로
+const DefaultPinsPath = ".pins/pins.db"
+
+// NewPins : 저장하지 않는 Pins 생성
+func NewPins() *Pins {
+	return NewPinsAt("")
+}
+
+// NewPinsAt : where 의 leveldb 에 저장하는 Pins 생성, repository 는 처음 사용할 때 열림
+func NewPinsAt(where string) *Pins {
+	return &Pins{
+		mutex: &sync.RWMutex{},
+		m:     make(map[string]Pin),
+		where: where,
+	}
+}
+
+func (ps *Pins) open() error {
+	if ps.db != nil || ps.where == "" {
+		return nil
+	}
+	db, err := leveldb.OpenFile(ps.where, nil)
+	if err != nil {
+		rmrlogger.Errorf("failed to open pins repository(%s), error(%s)",
+			ps.where, err.Error())
+		return err
+	}
+	ps.db = db
+	return nil
+}
+
+// Load : repository 에서 고정한 파일 목록 load
+func (ps *Pins) Load() error {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	if err := ps.open(); err != nil {
+		return err
+	}
+	if ps.db == nil {
+		return nil
+	}
+	iter := ps.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		p := Pin{}
+		if err := json.Unmarshal(iter.Value(), &p); err != nil {
+			rmrlogger.Errorf("failed to load pin(%s), error(%s)", iter.Value(), err.Error())
+			continue
+		}
+		ps.m[p.FileName] = p
+	}
+	rmrlogger.Infof("loaded pins(%d)", len(ps.m))
+	return nil
+}
+
+// Add : 파일 고정, 이미 고정한 파일이면 고정한 정보를 그대로 반환
+func (ps *Pins) Add(fileName string) (Pin, error) {
+	if fileName == "" {
+		return Pin{}, errors.New("empty file name")
+	}
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	if p, ok := ps.m[fileName]; ok {
+		return p, nil
+	}
+	if err := ps.open(); err != nil {
+		return Pin{}, err
+	}
+	p := Pin{FileName: fileName, Ctime: time.Now().Unix()}
+	v, err := json.Marshal(p)
+	if err != nil {
+		return Pin{}, err
+	}
+	if ps.db != nil {
+		if err := ps.db.Put([]byte(fileName), v, nil); err != nil {
+			return Pin{}, err
+		}
+	}
+	ps.m[fileName] = p
+	rmrlogger.Infof("pinned file(%s)", p)
+	return p, nil
+}
+
+// Remove : 파일 고정 해제, 고정한 파일이 아니면 false 반환
+func (ps *Pins) Remove(fileName string) (bool, error) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	p, ok := ps.m[fileName]
+	if !ok {
+		return false, nil
+	}
+	if err := ps.open(); err != nil {
+		return false, err
+	}
+	if ps.db != nil {
+		if err := ps.db.Delete([]byte(fileName), nil); err != nil {
+			return false, err
+		}
+	}
+	delete(ps.m, fileName)
+	rmrlogger.Infof("unpinned file(%s)", p)
+	return true, nil
+}
+
+// IsPinned : 고정한 파일인지 여부
+func (ps *Pins) IsPinned(fileName string) bool {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	_, ok := ps.m[fileName]
+	return ok
+}
+
+// GetList : 고정한 파일 목록을 파일 이름 순으로 반환
+func (ps *Pins) GetList() []Pin {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	pl := make([]Pin, 0, len(ps.m))
+	for _, p := range ps.m {
+		pl = append(pl, p)
+	}
+	sort.Slice(pl, func(i, j int) bool {
+		return pl[i].FileName < pl[j].FileName
+	})
+	return pl
+}
+
+// Close : repository 닫기, 다시 사용하면 repository 가 다시 열림
+func (ps *Pins) Close() {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	if ps.db != nil {
+		ps.db.Close()
+		ps.db = nil
+	}
+}
+
+// Release :
+//
+// 목록과 repository 모두 지우기,
+// 상위 directory 가 비었으면 상위 directory 도 지움
+func (ps *Pins) Release() {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	if ps.db != nil {
+		ps.db.Close()
+		ps.db = nil
+	}
+	if ps.where != "" {
+		os.RemoveAll(ps.where)
+		os.Remove(filepath.Dir(ps.where))
+	}
+	ps.m = make(map[string]Pin)
+}
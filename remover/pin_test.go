@@ -0,0 +1,59 @@
+package remover
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPins(t *testing.T) {
+	where := filepath.Join(t.TempDir(), "pins.db")
+	ps := NewPinsAt(where)
+	defer ps.Release()
+
+	_, err := ps.Add("")
+	assert.NotNil(t, err)
+
+	pb, err := ps.Add("B.mpg")
+	assert.Nil(t, err)
+	assert.Equal(t, "B.mpg", pb.FileName)
+	assert.NotEqual(t, int64(0), pb.Ctime)
+	_, err = ps.Add("A.mpg")
+	assert.Nil(t, err)
+
+	// 이미 고정한 파일은 고정한 정보를 그대로 반환
+	p, err := ps.Add("B.mpg")
+	assert.Nil(t, err)
+	assert.Equal(t, pb, p)
+
+	assert.True(t, ps.IsPinned("A.mpg"))
+	assert.False(t, ps.IsPinned("C.mpg"))
+	pl := ps.GetList()
+	assert.Equal(t, 2, len(pl))
+	assert.Equal(t, "A.mpg", pl[0].FileName)
+	assert.Equal(t, "B.mpg", pl[1].FileName)
+
+	removed, err := ps.Remove("A.mpg")
+	assert.Nil(t, err)
+	assert.True(t, removed)
+	removed, err = ps.Remove("A.mpg")
+	assert.Nil(t, err)
+	assert.False(t, removed)
+
+	// 재시작 후에도 고정한 파일 목록이 남음
+	ps.Close()
+	lps := NewPinsAt(where)
+	assert.Nil(t, lps.Load())
+	assert.Equal(t, []Pin{pb}, lps.GetList())
+	lps.Close()
+
+	// 저장하지 않는 목록은 재시작하면 없어짐
+	mps := NewPins()
+	_, err = mps.Add("A.mpg")
+	assert.Nil(t, err)
+	assert.True(t, mps.IsPinned("A.mpg"))
+	mps = NewPins()
+	assert.Nil(t, mps.Load())
+	assert.Empty(t, mps.GetList())
+}
@@ -1,11 +1,16 @@
 package remover
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"sort"
 	"time"
 
 	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cfm/decision"
+	"github.com/castisdev/cfm/membership"
+	"github.com/castisdev/cfm/metrics"
 	"github.com/castisdev/cfm/tailer"
 	"github.com/castisdev/cilog"
 )
@@ -24,6 +29,21 @@ type DServer struct {
 	Du common.DiskUsage
 }
 
+// PlannedDelete : plan 모드에서 구한, 삭제 요청할 파일
+//
+// Reason : 삭제 이유
+//
+// 	- duplicated : 목표 copy 수보다 많은 서버에 중복된 파일
+//
+// 	- free.disk.space : disk 용량 확보를 위해 지우는 파일
+type PlannedDelete struct {
+	Server   string `json:"server"`
+	FileName string `json:"file_name"`
+	Grade    int32  `json:"grade"`
+	Size     int64  `json:"size"`
+	Reason   string `json:"reason"`
+}
+
 var rmrlogger common.MLogger
 
 func init() {
@@ -35,6 +55,12 @@ func init() {
 // Servers : 파일 삭제 대상 서버 리스트
 // SourcePath : 파일 삭제 시 Source 에 없는 파일이면 삭제 대상에서 제외하기 위해 사용
 // Tail :: LB EventLog 를 tailing 하며 SAN 에서 Hit 되는 파일 목록 추출
+// maxCopyCount : 파일별 최대 배포 서버 개수, 중복 파일 삭제 시 목표 copy 수 만큼 남김
+// dryRun : plan 모드, 삭제 요청하지 않고 planned 에 추가
+// pins : 삭제하지 않도록 고정한 파일 목록
+// draining : drain 모드인 서버, 삭제 대상에서 제외
+//
+// inventory : 서버별 파일 목록 cache, runner 가 tasker 와 함께 사용하도록 설정함
 type Remover struct {
 	sleepSec              uint
 	diskUsageLimitPercent uint
@@ -44,6 +70,12 @@ type Remover struct {
 	gradeInfoFile         string
 	hitcountHistoryFile   string
 	ignorePrefixes        []string
+	maxCopyCount          int
+	dryRun                bool
+	planned               []PlannedDelete
+	pins                  *Pins
+	draining              map[string]bool
+	inventory             *common.Inventory
 }
 
 func NewRemover() *Remover {
@@ -53,9 +85,46 @@ func NewRemover() *Remover {
 		Servers:               common.NewHosts(),
 		SourcePath:            common.NewSourceDirs(),
 		Tail:                  tailer.NewTailer(),
+		maxCopyCount:          1,
+		pins:                  NewPins(),
+		draining:              make(map[string]bool),
+		inventory:             common.NewInventory(0),
 	}
 }
 
+// Pins is to get pinned files
+func (rmr *Remover) Pins() *Pins {
+	return rmr.pins
+}
+
+// SetPins : 고정한 파일 목록을 ps 로 바꾸고, 이전 목록의 repository 는 닫음
+func (rmr *Remover) SetPins(ps *Pins) {
+	old := rmr.pins
+	rmr.pins = ps
+	old.Close()
+}
+
+// SetServers :
+//
+// membership 의 destination 서버 목록으로 삭제 대상 서버 목록을 새로 만들어서 바꿈
+//
+// drain 모드인 서버는 삭제 대상에서 제외
+func (rmr *Remover) SetServers(dstl []membership.Server) {
+	servers := common.NewHosts()
+	draining := make(map[string]bool)
+	for _, s := range dstl {
+		if err := servers.Add(s.Addr); err != nil {
+			rmrlogger.Errorf("failed to add server(%s), error(%s)", s, err.Error())
+			continue
+		}
+		if s.Drain {
+			draining[s.Addr] = true
+		}
+	}
+	rmr.Servers = servers
+	rmr.draining = draining
+}
+
 // SetDiskUsageLimitPercent is to set the limitation of disk used size
 // min is 0, max is 100
 func (rmr *Remover) SetDiskUsageLimitPercent(limit uint) error {
@@ -96,6 +165,27 @@ func (rmr *Remover) SetSleepSec(s uint) {
 	rmrlogger.Infof("set sleepSec(%d)", s)
 }
 
+// SetMaxCopyCount :
+func (rmr *Remover) SetMaxCopyCount(n int) error {
+	if n < 1 {
+		return errors.New("max copy count must be greater than 0")
+	}
+	rmr.maxCopyCount = n
+	rmrlogger.Infof("set max copy count(%d)", n)
+	return nil
+}
+
+// MaxCopyCount :
+func (rmr *Remover) MaxCopyCount() int {
+	return rmr.maxCopyCount
+}
+
+// SetInventory : 서버의 파일 목록을 구할 때 사용할 cache,
+// 삭제 요청에 성공한 파일은 cache 된 목록에서 뺌
+func (rmr *Remover) SetInventory(inv *common.Inventory) {
+	rmr.inventory = inv
+}
+
 // RunForever is to run tasker as go routine
 func (rmr *Remover) RunForever() {
 	for {
@@ -171,36 +261,99 @@ func (rmr *Remover) runWithInfo(
 	rmr.requestRemoveFilesForFreeDiskSpace(servers, serverFileMetaMap, risingHitFileMap)
 }
 
+// Plan :
+//
+// runWithInfo 와 같은 방법으로 삭제할 파일을 구하지만,
+// 서버에 삭제 요청을 하지 않고, 삭제 요청할 파일 목록과 이유를 반환
+//
+// 서버의 파일 목록, disk 사용량은 조회함
+//
+// 전달받은 file meta 정보는 바꾸지 않음
+func (rmr *Remover) Plan(
+	fileMetaMap FileMetaPtrMap,
+	duplicatedFileMap FileMetaPtrMap,
+	risingHitFileMap map[string]int) []PlannedDelete {
+
+	p := *rmr
+	p.dryRun = true
+	p.planned = make([]PlannedDelete, 0)
+	fmm, dupfmm := cloneFileMetas(fileMetaMap, duplicatedFileMap)
+	p.runWithInfo(fmm, dupfmm, risingHitFileMap)
+	return p.planned
+}
+
+// cloneFileMetas :
+// file meta map 복사,
+// 복사한 duplicatedFileMap 의 file meta 는 복사한 fileMetaMap 의 file meta 를 가리킴
+func cloneFileMetas(fileMetaMap FileMetaPtrMap,
+	duplicatedFileMap FileMetaPtrMap) (FileMetaPtrMap, FileMetaPtrMap) {
+	fmm := make(FileMetaPtrMap, len(fileMetaMap))
+	for fn, fm := range fileMetaMap {
+		fmm[fn] = fm.Clone()
+	}
+	dupfmm := make(FileMetaPtrMap, len(duplicatedFileMap))
+	for fn, fm := range duplicatedFileMap {
+		if cfm, ok := fmm[fn]; ok {
+			dupfmm[fn] = cfm
+		} else {
+			dupfmm[fn] = fm.Clone()
+		}
+	}
+	return fmm, dupfmm
+}
+
+// addPlannedDelete : plan 모드에서 삭제 요청할 파일 추가
+func (rmr *Remover) addPlannedDelete(server *common.Host, fm *common.FileMeta,
+	reason string) {
+	rmr.planned = append(rmr.planned, PlannedDelete{
+		Server:   server.Addr,
+		FileName: fm.Name,
+		Grade:    fm.Grade,
+		Size:     fm.Size,
+		Reason:   reason,
+	})
+	rmrlogger.Debugf("[%s] planned to delete by %s, file(%s)", server, reason, fm)
+}
+
+// record : 파일에 대한 결정 기록, plan 모드에서는 기록하지 않음
+func (rmr *Remover) record(fm *common.FileMeta, server *common.Host,
+	action decision.Action, reason string) {
+	if rmr.dryRun {
+		return
+	}
+	decision.Record(decision.New("remover", fm, server.Addr, action, reason))
+}
+
 // getServerFileMetas :
 // 전체 파일 meta map 중에
 // 서버 별로 있는 파일에 대한 meta map을 구해서 반환
+// 서버 파일 목록은 inventory 에 cache 된 목록을 사용하고, 나머지 서버는 동시에 요청해서 구함,
 // 서버 파일 목록을 구하다 에러가 난 경우, 해당 서버의 목록은 비어있게 됨
 func (rmr *Remover) getServerFileMetas(allfmm FileMetaPtrMap) ServerFileMetaPtrMap {
 	sfmm := make(ServerFileMetaPtrMap)
+	lists, rep := rmr.inventory.FileLists(*rmr.Servers)
 	for _, server := range *rmr.Servers {
-		sfms, err := selectFileMetas(server, allfmm)
-		sfmm[server.Addr] = sfms
-		if err != nil {
-			rmrlogger.Errorf("[%s] failed to get server file meatas, erorr(%s)",
-				server, err.Error())
+		l, ok := lists[server.Addr]
+		if !ok {
+			sfmm[server.Addr] = make(FileMetaPtrMap)
+			rmrlogger.Errorf("[%s] failed to get server file meatas, erorr(%v)",
+				server, rep.Failed[server.Addr])
+			continue
 		}
+		sfmm[server.Addr] = selectServerFileMetas(server, l.Files, allfmm)
 	}
+	rmrlogger.Infof("collected server file lists, cached(%d), %s",
+		len(lists)-rep.Succeeded(), rep)
 	return sfmm
 }
 
-// selectFileMetas :
-// param 으로 받은 file meta들 중에,
-// server에 있는 file들의 file meta pointer만 모아놓은 map을 반환함
-func selectFileMetas(server *common.Host,
-	fileMetaMap FileMetaPtrMap) (FileMetaPtrMap, error) {
+// selectServerFileMetas :
+// server 의 파일 목록(fl) 중에
+// param 으로 받은 file meta 에 있는 file들의 file meta pointer만 모아놓은 map을 반환함
+func selectServerFileMetas(server *common.Host, fl []string,
+	fileMetaMap FileMetaPtrMap) FileMetaPtrMap {
 
 	sfm := make(FileMetaPtrMap)
-
-	fl := make([]string, 0, 10000)
-	err := common.GetRemoteFileList(server, &fl)
-	if err != nil {
-		return sfm, err
-	}
 	for _, filename := range fl {
 		// 예외처리 : 아직 해당 서버의 파일 내용이 반영이 안된 상황 등으로 인해서
 		// 서버에 있지만 전체 파일 목록에서 찾을 수 없으면 제외
@@ -233,7 +386,7 @@ func selectFileMetas(server *common.Host,
 
 		sfm[filename] = fm
 	}
-	return sfm, nil
+	return sfm
 }
 
 // requestRemoveDuplicatedFiles:
@@ -246,6 +399,10 @@ func selectFileMetas(server *common.Host,
 //
 // - ingnore prefix 를 갖는 파일 제외
 //
+// - 고정한 파일 제외
+//
+// - drain 모드인 서버 제외
+//
 // - SAN 에 없는 파일 제외
 func (rmr *Remover) requestRemoveDuplicatedFiles(duplicatedFileMap FileMetaPtrMap,
 	ssfms ServerFileMetaPtrMap) {
@@ -273,30 +430,54 @@ func (rmr *Remover) requestRemoveDuplicatedFiles(duplicatedFileMap FileMetaPtrMa
 			// 제외 대상 파일 처리
 			if common.IsPrefix(fm.Name, rmr.ignorePrefixes) {
 				rmrlogger.Debugf("[%s] ignored by ignore.prefix, file(%s)", server, fm.Name)
+				rmr.record(fm, server, decision.SKIP, decision.IgnorePrefix)
+				continue
+			}
+			// 고정한 파일 제외
+			if rmr.pins.IsPinned(fm.Name) {
+				rmrlogger.Debugf("[%s] ignored by pinned, file(%s)", server, fm.Name)
+				rmr.record(fm, server, decision.SKIP, decision.Pinned)
+				continue
+			}
+			// drain 모드인 서버 제외
+			if rmr.draining[server.Addr] {
+				rmrlogger.Debugf("[%s] ignored by draining, file(%s)", server, fm.Name)
+				rmr.record(fm, server, decision.SKIP, decision.Draining)
 				continue
 			}
 			// SAN 에 없는 파일이면 삭제 대상에서 제외
 			if _, exists := rmr.SourcePath.IsExistOnSource(fm.Name); exists != true {
 				rmrlogger.Debugf("[%s] ignored by not.found.in.the.source.paths, file(%s)", server, fm.Name)
+				rmr.record(fm, server, decision.SKIP, decision.NotFoundInTheSourcePaths)
 				continue
 			}
-			// 중복된 파일이 아니면 제외
-			if fm.ServerCount < 2 {
-				rmrlogger.Debugf("[%s] ignored by one.copy.left.in.the.servers, file(%s)",
-					server, dfn)
+			// 목표 copy 수 보다 많이 중복된 파일이 아니면 제외
+			target := fm.TargetCopyCountWithMax(rmr.maxCopyCount)
+			if fm.ServerCount <= target {
+				rmrlogger.Debugf("[%s] ignored by target.copies(%d).left.in.the.servers, file(%s)",
+					server, target, dfn)
+				rmr.record(fm, server, decision.SKIP, decision.TargetCopiesLeftInTheServers)
 				continue
 			}
-			if err := common.DeleteFileOnRemote(server, fm.Name); err != nil {
-				rmrlogger.Errorf("[%s] failed to request to delete duplicated"+
-					", file(%s), error(%s)",
-					server, fm, err.Error())
-				continue
+			if rmr.dryRun {
+				rmr.addPlannedDelete(server, fm, fmt.Sprintf(
+					"duplicated, copies(%d) > target.copies(%d)", fm.ServerCount, target))
+			} else {
+				if err := common.GetAgentClient().DeleteFile(context.Background(), server, fm.Name); err != nil {
+					rmrlogger.Errorf("[%s] failed to request to delete duplicated"+
+						", file(%s), error(%s)",
+						server, fm, err.Error())
+					rmr.record(fm, server, decision.SKIP, decision.RequestFailed)
+					continue
+				}
+				rmrlogger.Infof("[%s] requested to delete duplicated, file(%s)", server, fm)
+				rmr.record(fm, server, decision.DELETE, decision.Duplicated)
+				rmr.inventory.Removed(server.Addr, fm.Name)
 			}
-			rmrlogger.Infof("[%s] requested to delete duplicated, file(%s)", server, fm)
 			// 현재 server에 delete 요청 성공한 파일에 대해서
 			// file meta 정보에서 현재 서버 정보 삭제
 			// - file meta 정보를 다시 읽지 않고 현재 file meta 정보를 가지고,
-			// 	 copy수가 하나가 될 때까지만 삭제요청을 하기 위해서 현재 file meta 정보에 반영
+			// 	 copy수가 목표 copy 수가 될 때까지만 삭제요청을 하기 위해서 현재 file meta 정보에 반영
 			if fm.ServerIPs[server.IP] > 0 {
 				fm.ServerIPs[server.IP]--
 
@@ -337,15 +518,20 @@ func (rmr *Remover) updateFileMetasForDuplicatedFiles(duplicatedFileMap FileMeta
 
 // findServersOutOfDiskSpace
 // 서버 중에 disk 용량이 충분하지 않는 서버 구함
+//
+// disk 사용량은 서버들에 동시에 요청해서 구함, 구하지 못한 서버는 제외
 func (rmr *Remover) findServersOutOfDiskSpace(serverList *common.Hosts) []DServer {
 	s := make([]DServer, 0, len(*serverList))
+	dus, rep := common.GetRemoteDiskUsages(*serverList)
+	rmrlogger.Infof("collected server disk usages, %s", rep)
 	for _, server := range *serverList {
-		du := new(common.DiskUsage)
-		err := common.GetRemoteDiskUsage(server, du)
-		if err != nil {
-			rmrlogger.Errorf("[%s] failed to get disk usage, error(%s)", server, err.Error())
+		du, ok := dus[server.Addr]
+		if !ok {
+			rmrlogger.Errorf("[%s] failed to get disk usage, error(%s)",
+				server, rep.Failed[server.Addr].Error())
 			continue
 		}
+		metrics.SetDiskUsage(server.Addr, du)
 		// limit used size 까지 사용하지 않았으면 ignored
 		limitUsedSize := du.GetLimitUsedSize(rmr.diskUsageLimitPercent)
 		if du.UsedSize <= limitUsedSize {
@@ -359,7 +545,7 @@ func (rmr *Remover) findServersOutOfDiskSpace(serverList *common.Hosts) []DServe
 			", limitPercent(%d), diskUsage(%s)",
 			server, du.UsedSize-limitUsedSize, du.UsedSize, limitUsedSize, rmr.diskUsageLimitPercent, du)
 
-		ds := DServer{server, *du}
+		ds := DServer{server, du}
 		s = append(s, ds)
 	}
 
@@ -373,10 +559,16 @@ func (rmr *Remover) findServersOutOfDiskSpace(serverList *common.Hosts) []DServe
 // - ingnore prefix 를 갖는 파일 제외
 //
 // - SAN 에 없는 파일 제외
+//
+// - drain 모드인 서버 제외
 func (rmr *Remover) requestRemoveFilesForFreeDiskSpace(servers []DServer,
 	ssfmm ServerFileMetaPtrMap, rhitfmm map[string]int) {
 
 	for _, server := range servers {
+		if rmr.draining[server.Addr] {
+			rmrlogger.Infof("[%s] ignored by draining, not enough disk space", server)
+			continue
+		}
 		fileListToDelete := rmr.getFileListToDeleteForFreeDiskSpace(server,
 			ssfmm, rhitfmm)
 		if len(fileListToDelete) == 0 {
@@ -398,31 +590,39 @@ func (rmr *Remover) requestRemoveFilesForFreeDiskSpace(servers []DServer,
 					server, fm)
 				continue
 			}
-			if err := common.DeleteFileOnRemote(server.Host, fm.Name); err != nil {
+			if rmr.dryRun {
+				rmr.addPlannedDelete(server.Host, fm, fmt.Sprintf(
+					"free.disk.space, over.used(%s)",
+					server.Du.GetOverUsedSize(rmr.diskUsageLimitPercent)))
+			} else if err := common.GetAgentClient().DeleteFile(context.Background(), server.Host, fm.Name); err != nil {
 				rmrlogger.Errorf("[%s] failed to request to delete, file(%s), error(%s)",
 					server, fm, err.Error())
+				rmr.record(fm, server.Host, decision.SKIP, decision.RequestFailed)
+				continue
 			} else {
 				rmrlogger.Infof("[%s] requested to delete, file(%s)", server, fm)
-				deletingSize = deletingSize + common.Disksize(fm.Size)
-				// 현재 server에 delete 요청 성공한 파일에 대해서
-				// file meta 정보에서 현재 서버 정보 삭제
-				// file meta 정보를 다시 읽지 않고
-				// 현재 file meta 정보에 반영 최신 정보를 반영함.
-				// 현재는 file meta 정보를 매번 다시 읽고,
-				// 제일 마지막에 이 함수를 부르고 있어서
-				// test 코드에서 검증용으로 사용할 빼고는 필요없는 코드임
-				{
-					if fm.ServerIPs[server.IP] > 0 {
-						fm.ServerIPs[server.IP]--
-
-						if fm.ServerCount > 0 {
-							fm.ServerCount--
-						}
+				rmr.record(fm, server.Host, decision.DELETE, decision.FreeDiskSpace)
+				rmr.inventory.Removed(server.Addr, fm.Name)
+			}
+			deletingSize = deletingSize + common.Disksize(fm.Size)
+			// 현재 server에 delete 요청 성공한 파일에 대해서
+			// file meta 정보에서 현재 서버 정보 삭제
+			// file meta 정보를 다시 읽지 않고
+			// 현재 file meta 정보에 반영 최신 정보를 반영함.
+			// 현재는 file meta 정보를 매번 다시 읽고,
+			// 제일 마지막에 이 함수를 부르고 있어서
+			// test 코드에서 검증용으로 사용할 빼고는 필요없는 코드임
+			{
+				if fm.ServerIPs[server.IP] > 0 {
+					fm.ServerIPs[server.IP]--
+
+					if fm.ServerCount > 0 {
+						fm.ServerCount--
 					}
 				}
 			}
 		}
-		if deletingSize > 0 {
+		if deletingSize > 0 && !rmr.dryRun {
 			rmrlogger.Infof("[%s] requested to delete files for free disk space(%s / %s)",
 				server, deletingSize, server.Du.GetOverUsedSize(rmr.diskUsageLimitPercent))
 		}
@@ -439,6 +639,8 @@ func (rmr *Remover) requestRemoveFilesForFreeDiskSpace(servers []DServer,
 //
 // - ingnore prefix 를 갖는 파일 제외
 //
+// - 고정한 파일 제외
+//
 // - SAN 에 없는 파일 제외
 //
 // - 낮은 등급 순으로 disk 여유 용량이 확보될 때까지
@@ -493,30 +695,42 @@ func (rmr *Remover) checkForDelete(fm *common.FileMeta, server DServer,
 	if fm.ServerCount <= 0 {
 		rmrlogger.Debugf("[%s] ignored by not.found.in.the.server, file(%s)",
 			server, fm)
+		rmr.record(fm, server.Host, decision.SKIP, decision.NotFoundInTheServer)
 		return false
 	}
 	// 예외처리
 	if n, exist := fm.ServerIPs[server.IP]; !exist || n <= 0 {
 		rmrlogger.Debugf("[%s] ignored by not.found.in.the.server, file(%s)",
 			server, fm)
+		rmr.record(fm, server.Host, decision.SKIP, decision.NotFoundInTheServer)
 		return false
 	}
 	// 제외 대상 파일 처리
 	if common.IsPrefix(fm.Name, rmr.ignorePrefixes) {
 		rmrlogger.Debugf("[%s] ignored by ignore.prefix, file(%s)",
 			server, fm)
+		rmr.record(fm, server.Host, decision.SKIP, decision.IgnorePrefix)
+		return false
+	}
+	// 고정한 파일 제외
+	if rmr.pins.IsPinned(fm.Name) {
+		rmrlogger.Debugf("[%s] ignored by pinned, file(%s)",
+			server, fm)
+		rmr.record(fm, server.Host, decision.SKIP, decision.Pinned)
 		return false
 	}
 	// SAN 에 없는 파일이면 삭제 대상에서 제외
 	if _, exists := rmr.SourcePath.IsExistOnSource(fm.Name); exists != true {
 		rmrlogger.Debugf("[%s] ignored by not.found.in.the.source.paths, file(%s)",
 			server, fm)
+		rmr.record(fm, server.Host, decision.SKIP, decision.NotFoundInTheSourcePaths)
 		return false
 	}
 	// 급 hit 상승 파일 목록에 속하는 파일이면 삭제 대상에 제외
 	if _, exists := rhitfmm[fm.Name]; exists {
 		rmrlogger.Debugf("[%s] ignored by rising.hit.file, file(%s)",
 			server, fm)
+		rmr.record(fm, server.Host, decision.SKIP, decision.RisingHitFile)
 		return false
 	}
 
@@ -1,6 +1,7 @@
 package remover
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,6 +15,8 @@ import (
 	"time"
 
 	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cfm/decision"
+	"github.com/castisdev/cfm/metrics"
 	"github.com/castisdev/cfm/tailer"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
@@ -333,9 +336,19 @@ func Test_findServersOutOfDiskSpace(t *testing.T) {
 
 	rmr := NewRemover()
 
+	metrics.Release()
+	defer metrics.Release()
+
 	rmr.SetDiskUsageLimitPercent(55)
 	dservers := rmr.findServersOutOfDiskSpace(hosts)
 
+	// 조회한 모든 서버의 disk 사용량이 metrics 에 기록됨
+	var b bytes.Buffer
+	metrics.Write(&b, nil, nil, time.Now())
+	assert.Contains(t, b.String(), `cfm_disk_used_percent{server="127.0.0.1:18881"} 60`)
+	assert.Contains(t, b.String(), `cfm_disk_used_percent{server="127.0.0.1:18882"} 50`)
+	assert.Contains(t, b.String(), `cfm_disk_total_bytes{server="127.0.0.1:18883"} 3000`)
+
 	assert.Equal(t, 1, len(dservers))
 
 	if len(dservers) < 1 {
@@ -359,41 +372,22 @@ func TestSetDiskUsageLimitPercent(t *testing.T) {
 	assert.Nil(t, rmr.SetDiskUsageLimitPercent(50))
 }
 
-func Test_selectFileMetas(t *testing.T) {
+func Test_selectServerFileMetas(t *testing.T) {
 	hosts := common.NewHosts()
 	vs1 := "127.0.0.1:18881"
 	files1 := []string{"A.mpg", "B.mpg", "C.mpg", "D.mpg"}
-	d1 := common.DiskUsage{
-		TotalSize: 1000, UsedSize: 600,
-		FreeSize: 400, AvailSize: 400, UsedPercent: 60,
-	}
 
 	vs2 := "127.0.0.2:18882"
 	files2 := []string{"B.mpg", "C.mpg", "E.mpg"}
-	d2 := common.DiskUsage{
-		TotalSize: 1000, UsedSize: 600,
-		FreeSize: 400, AvailSize: 400, UsedPercent: 60,
-	}
 
 	hosts.Add(vs1)
 	hosts.Add(vs2)
 
-	cfw1 := cfw(vs1, d1, files1)
-	cfw1.Start()
-	defer cfw1.Close()
-
-	cfw2 := cfw(vs2, d2, files2)
-	cfw2.Start()
-	defer cfw2.Close()
-
 	allfmm, _ := makeFileMetaMap()
 
 	// sort 되어서 hosts[0]은 vs2가 됨
 	host2 := (*hosts)[0]
-	host2fmm, err := selectFileMetas(host2, allfmm)
-	if err != nil {
-		assert.Error(t, err)
-	}
+	host2fmm := selectServerFileMetas(host2, files2, allfmm)
 	t.Logf("server:%s -> %s", host2, host2fmm)
 	assert.Equal(t, 3, len(host2fmm))
 	assert.Contains(t, host2fmm, "B.mpg")
@@ -402,10 +396,7 @@ func Test_selectFileMetas(t *testing.T) {
 
 	// sort 되어서 hosts[1]은 vs2가 됨
 	host1 := (*hosts)[1]
-	host1fmm, err := selectFileMetas(host1, allfmm)
-	if err != nil {
-		assert.Error(t, err)
-	}
+	host1fmm := selectServerFileMetas(host1, files1, allfmm)
 	t.Logf("server:%s -> %s", host1, host1fmm)
 	assert.Equal(t, 4, len(host1fmm))
 	assert.Contains(t, host1fmm, "A.mpg")
@@ -415,28 +406,17 @@ func Test_selectFileMetas(t *testing.T) {
 
 }
 
-func Test_selectFileMetasS1(t *testing.T) {
+func Test_selectServerFileMetasS1(t *testing.T) {
 	hosts := common.NewHosts()
 	vs1 := "127.0.0.1:18881"
 	files1 := []string{"A.mpg", "B.mpg", "C.mpg", "D.mpg"}
-	d1 := common.DiskUsage{
-		TotalSize: 1000, UsedSize: 600,
-		FreeSize: 400, AvailSize: 400, UsedPercent: 60,
-	}
 
 	hosts.Add(vs1)
 
-	cfw1 := cfw(vs1, d1, files1)
-	cfw1.Start()
-	defer cfw1.Close()
-
 	allfmm, _ := makeFileMetaMap()
 
 	host1 := (*hosts)[0]
-	host1fmm, err := selectFileMetas(host1, allfmm)
-	if err != nil {
-		assert.Error(t, err)
-	}
+	host1fmm := selectServerFileMetas(host1, files1, allfmm)
 	t.Logf("server:%s -> %s", host1, host1fmm)
 	assert.Equal(t, len(host1fmm), 4)
 	assert.Contains(t, host1fmm, "A.mpg")
@@ -445,29 +425,18 @@ func Test_selectFileMetasS1(t *testing.T) {
 	assert.Contains(t, host1fmm, "D.mpg")
 }
 
-func Test_selectFileMetasS2(t *testing.T) {
+func Test_selectServerFileMetasS2(t *testing.T) {
 	hosts := common.NewHosts()
 
 	vs2 := "127.0.0.2:18882"
 	files2 := []string{"B.mpg", "C.mpg", "E.mpg"}
-	d2 := common.DiskUsage{
-		TotalSize: 1000, UsedSize: 600,
-		FreeSize: 400, AvailSize: 400, UsedPercent: 60,
-	}
 
 	hosts.Add(vs2)
 
-	cfw2 := cfw(vs2, d2, files2)
-	cfw2.Start()
-	defer cfw2.Close()
-
 	allfmm, _ := makeFileMetaMap()
 
 	host2 := (*hosts)[0]
-	host2fmm, err := selectFileMetas(host2, allfmm)
-	if err != nil {
-		assert.Error(t, err)
-	}
+	host2fmm := selectServerFileMetas(host2, files2, allfmm)
 	t.Logf("server:%s -> %s", host2, host2fmm)
 	assert.Equal(t, 3, len(host2fmm))
 	assert.Contains(t, host2fmm, "B.mpg")
@@ -475,29 +444,18 @@ func Test_selectFileMetasS2(t *testing.T) {
 	assert.Contains(t, host2fmm, "E.mpg")
 }
 
-func Test_selectFileMetasS3(t *testing.T) {
+func Test_selectServerFileMetasS3(t *testing.T) {
 	hosts := common.NewHosts()
 
 	vs2 := "127.0.0.2:18882"
 	files2 := []string{"B.mpg", "E.mpg"}
-	d2 := common.DiskUsage{
-		TotalSize: 1000, UsedSize: 600,
-		FreeSize: 400, AvailSize: 400, UsedPercent: 60,
-	}
 
 	hosts.Add(vs2)
 
-	cfw2 := cfw(vs2, d2, files2)
-	cfw2.Start()
-	defer cfw2.Close()
-
 	allfmm, _ := makeFileMetaMap()
 
 	host2 := (*hosts)[0]
-	host2fmm, err := selectFileMetas(host2, allfmm)
-	if err != nil {
-		assert.Error(t, err)
-	}
+	host2fmm := selectServerFileMetas(host2, files2, allfmm)
 	t.Logf("server:%s -> %s", host2, host2fmm)
 
 	// Server2 에 B.mpg, C.mpg, E.mpg, F.mpg 가 있다고 meta 가 만들어져있지만,
@@ -508,29 +466,18 @@ func Test_selectFileMetasS3(t *testing.T) {
 	assert.Contains(t, host2fmm, "E.mpg")
 }
 
-func Test_selectFileMetasSNull(t *testing.T) {
+func Test_selectServerFileMetasSNull(t *testing.T) {
 	hosts := common.NewHosts()
 
 	vs2 := "127.0.0.2:18882"
 	files2 := []string{}
-	d2 := common.DiskUsage{
-		TotalSize: 1000, UsedSize: 600,
-		FreeSize: 400, AvailSize: 400, UsedPercent: 60,
-	}
 
 	hosts.Add(vs2)
 
-	cfw2 := cfw(vs2, d2, files2)
-	cfw2.Start()
-	defer cfw2.Close()
-
 	allfmm, _ := makeFileMetaMap()
 
 	host2 := (*hosts)[0]
-	host2fmm, err := selectFileMetas(host2, allfmm)
-	if err != nil {
-		assert.Error(t, err)
-	}
+	host2fmm := selectServerFileMetas(host2, files2, allfmm)
 	t.Logf("server:%s -> %s", host2, host2fmm)
 
 	// Server2 에 B.mpg, C.mpg, E.mpg 가 있다고 meta 가 만들어져있지만,
@@ -1026,6 +973,53 @@ func Test_requestRemoveDuplicatedFilesIgnorePrefix(t *testing.T) {
 	assert.Equal(t, 1, allfmm["E.mpg"].ServerCount)
 }
 
+// 목표 copy 수가 2 인 중복 파일은
+// 서버 수가 2 가 될 때까지만 삭제 요청을 함
+func Test_requestRemoveDuplicatedFilesWithTargetCopyCount(t *testing.T) {
+	vs := []string{"127.0.0.1:18881", "127.0.0.2:18882", "127.0.0.3:18883"}
+	du := common.DiskUsage{
+		TotalSize: 1000, UsedSize: 600,
+		FreeSize: 400, AvailSize: 400, UsedPercent: 60,
+	}
+	for _, v := range vs {
+		s := cfw(v, du, []string{"C.mpg"})
+		s.Start()
+		defer s.Close()
+	}
+
+	base := "testsourcefolder"
+	createfile(base, "C.mpg")
+	defer deletefile(base, "")
+
+	makeMetas := func() (FileMetaPtrMap, FileMetaPtrMap) {
+		fm := common.NewFileMetaWith("C.mpg", 1)
+		fm.Size = 100
+		fm.TargetCopyCount = 2
+		fm.ServerCount = 3
+		fm.ServerIPs = map[string]int{"127.0.0.1": 1, "127.0.0.2": 1, "127.0.0.3": 1}
+		return FileMetaPtrMap{"C.mpg": fm}, FileMetaPtrMap{"C.mpg": fm}
+	}
+
+	rmr := NewRemover()
+	rmr.SourcePath.Add(base)
+	for _, v := range vs {
+		rmr.Servers.Add(v)
+	}
+
+	// 최대 copy 수가 1 이면 하나만 남김
+	allfmm, dupfmm := makeMetas()
+	rmr.requestRemoveDuplicatedFiles(dupfmm, rmr.getServerFileMetas(allfmm))
+	assert.Equal(t, 1, dupfmm["C.mpg"].ServerCount)
+
+	// 최대 copy 수가 3 이면 목표 copy 수 2 만큼 남김
+	assert.Nil(t, rmr.SetMaxCopyCount(3))
+	allfmm, dupfmm = makeMetas()
+	rmr.requestRemoveDuplicatedFiles(dupfmm, rmr.getServerFileMetas(allfmm))
+	assert.Equal(t, 2, dupfmm["C.mpg"].ServerCount)
+
+	assert.NotNil(t, rmr.SetMaxCopyCount(0))
+}
+
 func Test_checkForDeleteServerFile(t *testing.T) {
 	rmr := NewRemover()
 	rmr.Servers = common.NewHosts()
@@ -1122,6 +1116,51 @@ func Test_checkForDeleteServerFile(t *testing.T) {
 			}
 		}
 	}
+
+	// 고정한 파일은 삭제 대상에서 제외
+	defer rmr.Pins().Release()
+	rmr.Pins().Add("B.mpg")
+	for _, server := range servers {
+		assert.Equal(t, false, rmr.checkForDelete(allfmm["B.mpg"], server, rhitfmm))
+	}
+}
+
+// 고정한 중복 파일은 삭제 요청하지 않음
+func Test_requestRemoveDuplicatedFilesPinned(t *testing.T) {
+	rmr := NewRemover()
+	rmr.Servers = common.NewHosts()
+	s1 := "127.0.0.1:18881"
+	s2 := "127.0.0.2:18882"
+	du := common.DiskUsage{
+		TotalSize: 1000, UsedSize: 600,
+		FreeSize: 400, AvailSize: 400, UsedPercent: 60,
+	}
+	rmr.Servers.Add(s1)
+	rmr.Servers.Add(s2)
+	cfw1 := cfw(s1, du, []string{"A.mpg", "B.mpg", "C.mpg", "D.mpg"})
+	cfw1.Start()
+	defer cfw1.Close()
+	cfw2 := cfw(s2, du, []string{"B.mpg", "C.mpg", "E.mpg"})
+	cfw2.Start()
+	defer cfw2.Close()
+
+	base := "testsourcefolder"
+	rmr.SourcePath.Add(base)
+	for _, f := range []string{"A.mpg", "B.mpg", "C.mpg", "D.mpg", "E.mpg"} {
+		createfile(base, f)
+	}
+	defer deletefile(base, "")
+
+	defer rmr.Pins().Release()
+	rmr.Pins().Add("B.mpg")
+
+	allfmm, dupfmm := makeFileMetaMap()
+	ssfmm := rmr.getServerFileMetas(allfmm)
+	rmr.requestRemoveDuplicatedFiles(dupfmm, ssfmm)
+
+	// B.mpg 는 고정한 파일이라서 그대로 2 개, C.mpg 는 삭제 요청
+	assert.Equal(t, 2, dupfmm["B.mpg"].ServerCount)
+	assert.Equal(t, 1, dupfmm["C.mpg"].ServerCount)
 }
 
 func Test_getFileListToDeleteForFreeDiskSpace(t *testing.T) {
@@ -1584,6 +1623,116 @@ func Test_runWithInfo(t *testing.T) {
 
 }
 
+func TestPlan(t *testing.T) {
+	rmr := NewRemover()
+	rmr.Servers = common.NewHosts()
+	s1 := "127.0.0.1:18881"
+	files1 := []string{"A.mpg", "B.mpg", "C.mpg", "D.mpg"}
+	d1 := common.DiskUsage{
+		TotalSize: 1000, UsedSize: 750,
+		FreeSize: 250, AvailSize: 250, UsedPercent: 75,
+	}
+	s2 := "127.0.0.2:18882"
+	files2 := []string{"B.mpg", "C.mpg", "E.mpg", "F.mpg"}
+	d2 := common.DiskUsage{
+		TotalSize: 1000, UsedSize: 600,
+		FreeSize: 400, AvailSize: 400, UsedPercent: 60,
+	}
+	rmr.Servers.Add(s1)
+	rmr.Servers.Add(s2)
+
+	cfw1 := cfw(s1, d1, files1)
+	cfw1.Start()
+	defer cfw1.Close()
+	cfw2 := cfw(s2, d2, files2)
+	cfw2.Start()
+	defer cfw2.Close()
+
+	base := "testsourcefolder"
+	rmr.SourcePath.Add(base)
+	for _, f1 := range files1 {
+		createfile(base, f1)
+	}
+	for _, f2 := range files2 {
+		createfile(base, f2)
+	}
+	deletefile(base, "C.mpg")
+	defer deletefile(base, "")
+
+	ignores := []string{"E"}
+	rmr.SetIgnorePrefixes(ignores)
+
+	rmr.SetDiskUsageLimitPercent(55)
+
+	rhfiles := []string{"F.mpg"}
+	rhitfmm := makeRisingHitFileMap(rhfiles)
+	allfmm, dupfmm := makeFileMetaMap()
+
+	jdir := "testdecision"
+	defer deletefile(jdir, "")
+	assert.Nil(t, decision.Open(jdir, 1024*1024, 1))
+	defer decision.Close()
+
+	planned := rmr.Plan(allfmm, dupfmm, rhitfmm)
+
+	// runWithInfo 의 1st call 에서 삭제 요청하는 파일과 같음
+	// B.mpg 는 S2 에서 중복 파일로 삭제
+	// S1 에서는 disk 용량 부족으로 낮은 등급 순으로 D.mpg, B.mpg 삭제
+	assert.Equal(t, 3, len(planned))
+	assert.Equal(t, s2, planned[0].Server)
+	assert.Equal(t, "B.mpg", planned[0].FileName)
+	assert.Equal(t, "duplicated, copies(2) > target.copies(1)", planned[0].Reason)
+	assert.Equal(t, s1, planned[1].Server)
+	assert.Equal(t, "D.mpg", planned[1].FileName)
+	assert.Contains(t, planned[1].Reason, "free.disk.space")
+	assert.Equal(t, s1, planned[2].Server)
+	assert.Equal(t, "B.mpg", planned[2].FileName)
+	assert.Contains(t, planned[2].Reason, "free.disk.space")
+
+	// plan 모드에서는 file meta 정보가 바뀌지 않음
+	orgfmm, orgdupfmm := makeFileMetaMap()
+	assert.Equal(t, orgfmm, allfmm)
+	assert.Equal(t, orgdupfmm, dupfmm)
+	assert.False(t, rmr.dryRun)
+
+	// 다시 plan 을 구해도 같은 결과
+	assert.Equal(t, planned, rmr.Plan(allfmm, dupfmm, rhitfmm))
+
+	// plan 모드에서는 결정 기록을 남기지 않음
+	dl, err := decision.Find("B.mpg")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(dl))
+
+	// 실제 실행하면 plan 대로 삭제됨
+	rmr.runWithInfo(allfmm, dupfmm, rhitfmm)
+	assert.Equal(t, 1, allfmm["A.mpg"].ServerIPs["127.0.0.1"])
+	assert.Equal(t, 0, allfmm["B.mpg"].ServerIPs["127.0.0.1"])
+	assert.Equal(t, 0, allfmm["B.mpg"].ServerIPs["127.0.0.2"])
+	assert.Equal(t, 0, allfmm["D.mpg"].ServerIPs["127.0.0.1"])
+
+	// 삭제한 파일과 제외한 파일의 결정 기록
+	// B.mpg 는 S2 에서 중복 파일로, S1 에서 disk 용량 부족으로 삭제됨
+	dl, err = decision.Find("B.mpg")
+	assert.Nil(t, err)
+	deletes := make([]decision.Decision, 0)
+	for _, d := range dl {
+		assert.Equal(t, "remover", d.Module)
+		if d.Action == decision.DELETE {
+			deletes = append(deletes, d)
+		}
+	}
+	assert.Equal(t, 2, len(deletes))
+	assert.Equal(t, s2, deletes[0].Server)
+	assert.Equal(t, decision.Duplicated, deletes[0].Reason)
+	assert.Equal(t, s1, deletes[1].Server)
+	assert.Equal(t, decision.FreeDiskSpace, deletes[1].Reason)
+	dl, err = decision.Find("E.mpg")
+	assert.Nil(t, err)
+	assert.NotEqual(t, 0, len(dl))
+	assert.Equal(t, decision.SKIP, dl[0].Action)
+	assert.Equal(t, decision.IgnorePrefix, dl[0].Reason)
+}
+
 func Test_run(t *testing.T) {
 	rmr := NewRemover()
 	rmr.Servers = common.NewHosts()
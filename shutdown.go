@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/castisdev/cfm/decision"
+	"github.com/castisdev/cfm/fmfm"
+	"github.com/castisdev/cfm/membership"
+	"github.com/castisdev/cilog"
+)
+
+// shutdownOnSignal :
+//
+// SIGTERM, SIGINT 을 받으면 shutdown 하고, runner 가 멈췄는 지 여부를 done 에 보냄
+func shutdownOnSignal(mgr *fmfm.Manager, srv *http.Server,
+	timeout time.Duration, done chan<- bool) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-sigCh
+	cilog.Infof("received %s, shutdown, timeout(%s)", sig, timeout)
+	stopped, err := shutdown(mgr, srv, timeout)
+	done <- stopped
+	if err != nil {
+		cilog.Errorf("failed to shutdown gracefully, error(%s)", err.Error())
+		return
+	}
+	cilog.Infof("shutdown gracefully")
+}
+
+// shutdown :
+//
+// 	- http server 를 shutdown, 새 API 요청을 받지 않고 처리 중인 API 요청이 끝날 때까지 기다림
+//
+// 	- manager 에 STOP 을 보내고, runner 가 실행 중인 RUN 을 끝낼 때까지 기다림
+//
+// 	- task, task 기록, 고정한 파일 목록, 서버 구성 repository 를 닫음
+//
+// http server 와 runner 는 각각 timeout 동안 기다림
+//
+// API 요청이 끝나지 않으면 http server 를 강제로 닫고,
+// API 요청이나 runner 가 timeout 안에 끝나지 않으면 manager 의 repository 를 닫지 않고 error 반환
+//
+// stopped : runner 가 timeout 안에 멈췄는 지 여부
+func shutdown(mgr *fmfm.Manager, srv *http.Server,
+	timeout time.Duration) (stopped bool, rerr error) {
+	sctx, scancel := context.WithTimeout(context.Background(), timeout)
+	defer scancel()
+
+	if err := srv.Shutdown(sctx); err != nil {
+		cilog.Errorf("failed to shutdown http server, error(%s)", err.Error())
+		srv.Close()
+		rerr = err
+	}
+
+	// http server 를 기다리느라 runner 를 기다릴 시간이 없어지지 않도록 따로 기다림
+	rctx, rcancel := context.WithTimeout(context.Background(), timeout)
+	defer rcancel()
+
+	stoppedCh := make(chan struct{})
+	go func() {
+		mgr.Stop()
+		close(stoppedCh)
+	}()
+
+	select {
+	case <-stoppedCh:
+		stopped = true
+		if rerr != nil {
+			cilog.Errorf("API requests did not finish in %s, tasks repository is not closed", timeout)
+			break
+		}
+		if err := mgr.Close(); err != nil {
+			cilog.Errorf("failed to close tasks repository, error(%s)", err.Error())
+			rerr = err
+		}
+	case <-rctx.Done():
+		cilog.Errorf("runner did not stop in %s, tasks repository is not closed", timeout)
+		if rerr == nil {
+			rerr = errors.New("runner did not stop in time")
+		}
+	}
+	membership.Close()
+	decision.Close()
+	return stopped, rerr
+}
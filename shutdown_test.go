@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/castisdev/cfm/fmfm"
+	"github.com/castisdev/cfm/remover"
+	"github.com/castisdev/cfm/tailer"
+	"github.com/castisdev/cfm/tasker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdown(t *testing.T) {
+	dir := "testshutdown"
+	writeconfigfile(dir, "grade", []byte(""))
+	writeconfigfile(dir, "hitcount", []byte(""))
+	defer deletefile(dir, "")
+
+	fmfm.TestInotifyFunc = func() bool { return false }
+	w := fmfm.NewWatcher(filepath.Join(dir, "grade"), filepath.Join(dir, "hitcount"),
+		false, 0, 1)
+	tskr := tasker.NewTasker()
+	defer tskr.Tasks().Release()
+	defer tskr.History().Release()
+	tskr.Tasks().CreateTask(&tasker.Task{SrcIP: "127.0.0.1", FilePath: "/data2/A.mpg"})
+
+	r := fmfm.NewRunner(0, 0, remover.NewRemover(), tskr, tailer.NewTailer())
+	mgr := fmfm.NewManager(w, r)
+	go mgr.Manage()
+
+	srv := &http.Server{Addr: "127.0.0.1:0"}
+	served := make(chan error)
+	go func() { served <- srv.ListenAndServe() }()
+	time.Sleep(100 * time.Millisecond)
+
+	stopped, err := shutdown(mgr, srv, 5*time.Second)
+	assert.Nil(t, err)
+	assert.True(t, stopped)
+	assert.Equal(t, http.ErrServerClosed, <-served)
+	_, open := <-mgr.CMDCh
+	assert.False(t, open)
+
+	// 멈춘 manager 에 보낸 요청은 ErrStopped
+	_, err = mgr.GetTaskerPlan()
+	assert.Equal(t, fmfm.ErrStopped, err)
+	_, err = mgr.GetRemoverPlan()
+	assert.Equal(t, fmfm.ErrStopped, err)
+	assert.Equal(t, fmfm.ErrStopped, mgr.Reload(func(r *fmfm.Runner) error { return nil }))
+
+	// 닫은 repository 에서 task 를 다시 load 할 수 있음
+	ts := tasker.NewTasks()
+	ts.LoadTasks()
+	assert.Equal(t, 1, len(ts.GetTaskList()))
+	ts.Close()
+}
+
+func TestShutdownRunnerTimeout(t *testing.T) {
+	dir := "testshutdowntimeout"
+	writeconfigfile(dir, "grade", []byte(""))
+	writeconfigfile(dir, "hitcount", []byte(""))
+	defer deletefile(dir, "")
+
+	fmfm.TestInotifyFunc = func() bool { return false }
+	w := fmfm.NewWatcher(filepath.Join(dir, "grade"), filepath.Join(dir, "hitcount"),
+		false, 0, 1)
+	tskr := tasker.NewTasker()
+	defer tskr.Tasks().Release()
+	defer tskr.History().Release()
+
+	r := fmfm.NewRunner(0, 0, remover.NewRemover(), tskr, tailer.NewTailer())
+	mgr := fmfm.NewManager(w, r)
+	go mgr.Manage()
+
+	// runner 가 끝나지 않는 요청을 처리 중
+	block := make(chan struct{})
+	reloaded := make(chan error)
+	go func() {
+		reloaded <- mgr.Reload(func(r *fmfm.Runner) error {
+			<-block
+			return nil
+		})
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// http server 는 바로 끝나고, runner 는 따로 timeout 동안 기다림
+	srv := &http.Server{Addr: "127.0.0.1:0"}
+	start := time.Now()
+	stopped, err := shutdown(mgr, srv, 200*time.Millisecond)
+	assert.NotNil(t, err)
+	assert.False(t, stopped)
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+
+	close(block)
+	assert.Nil(t, <-reloaded)
+}
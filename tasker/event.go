@@ -0,0 +1,150 @@
+package tasker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TaskEvent type
+//
+// TaskCreated, TaskUpdated, TaskDeleted : task 하나가 만들어지거나, 상태가 바뀌거나, 지워짐
+//
+// TaskReset : load, import, 전체 삭제로 task 목록이 한꺼번에 바뀜, GET /tasks 로 다시 조회해야 함
+const (
+	TaskCreated = "created"
+	TaskUpdated = "updated"
+	TaskDeleted = "deleted"
+	TaskReset   = "reset"
+)
+
+// maxTaskEvents : 남겨두는 최근 event 개수
+const maxTaskEvents = 1024
+
+// TaskEvent : task 목록 변경 event
+//
+// ID : 바뀐 후의 task 목록 version, GET /tasks 의 ETag 와 같은 값(따옴표 제외)
+//
+// Seq : event 마다 1 씩 커지는 번호
+//
+// Task : 바뀐 후의 task, 지워진 경우 지우기 전의 task, TaskReset 이면 nil
+type TaskEvent struct {
+	ID   string `json:"id"`
+	Seq  uint64 `json:"-"`
+	Type string `json:"type"`
+	Task *Task  `json:"task,omitempty"`
+}
+
+// taskEvents : 최근 task 변경 event 목록과 기다리는 쪽에 알리는 channel
+//
+// wait 은 event 가 추가될 때마다 닫히고 새로 만들어짐
+type taskEvents struct {
+	mutex *sync.Mutex
+	log   []TaskEvent
+	wait  chan struct{}
+}
+
+func newTaskEvents() *taskEvents {
+	return &taskEvents{
+		mutex: &sync.Mutex{},
+		log:   make([]TaskEvent, 0),
+		wait:  make(chan struct{}),
+	}
+}
+
+func (e *taskEvents) publish(ev TaskEvent) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.log = append(e.log, ev)
+	if len(e.log) > maxTaskEvents {
+		e.log = append([]TaskEvent{}, e.log[len(e.log)-maxTaskEvents:]...)
+	}
+	close(e.wait)
+	e.wait = make(chan struct{})
+}
+
+// since :
+//
+// after 보다 Seq 가 큰 event 목록과 다음 event 가 추가되면 닫히는 channel 반환
+//
+// after 다음 event 가 이미 지워졌으면 ok 는 false
+func (e *taskEvents) since(after, current uint64) (el []TaskEvent, ok bool, wait <-chan struct{}) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	el = make([]TaskEvent, 0)
+	if after == current {
+		return el, true, e.wait
+	}
+	if after > current {
+		return el, false, e.wait
+	}
+	if len(e.log) == 0 || e.log[0].Seq > after+1 {
+		return el, false, e.wait
+	}
+	for _, ev := range e.log {
+		if ev.Seq > after {
+			el = append(el, ev)
+		}
+	}
+	return el, true, e.wait
+}
+
+// token : task 목록 version 을 나타내는 문자열, ETag 와 event ID 로 사용
+//
+// 재시작하면 epoch 가 바뀌므로 이전 process 의 token 과 겹치지 않음
+func (tasks *Tasks) token(seq uint64) string {
+	return fmt.Sprintf("%x-%x", tasks.epoch, seq)
+}
+
+// parseToken : token 의 seq 반환, 형식이 잘못되었거나 다른 process 의 token 이면 false
+func (tasks *Tasks) parseToken(t string) (uint64, bool) {
+	t = strings.Trim(strings.TrimPrefix(strings.TrimSpace(t), "W/"), `"`)
+	sl := strings.Split(t, "-")
+	if len(sl) != 2 || sl[0] != fmt.Sprintf("%x", tasks.epoch) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(sl[1], 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return seq, true
+}
+
+// Events :
+//
+// after 이후의 task 변경 event 목록을 순서대로 반환,
+// 다음 event 가 추가되면 닫히는 channel 도 함께 반환해서 long-poll, stream 에 사용
+//
+// after : GET /tasks 의 ETag 나 마지막으로 받은 event 의 ID,
+// 비어있으면 지금부터의 event 를 받기 위해 빈 목록 반환
+//
+// next : 다음 Events 호출에 after 로 사용할 값
+//
+// 최근 event 만 남겨두므로 after 다음 event 가 이미 지워졌거나,
+// after 가 잘못되었거나 재시작 전의 값이면 ok 는 false,
+// 이 때는 GET /tasks 로 다시 조회한 후 next 부터 받아야 함
+func (tasks *Tasks) Events(after string) (el []TaskEvent, next string, ok bool, wait <-chan struct{}) {
+	tasks.mutex.RLock()
+	defer tasks.mutex.RUnlock()
+
+	seq := tasks.version
+	if after != "" {
+		var valid bool
+		if seq, valid = tasks.parseToken(after); !valid {
+			_, _, wait = tasks.events.since(tasks.version, tasks.version)
+			return make([]TaskEvent, 0), tasks.token(tasks.version), false, wait
+		}
+	}
+	el, ok, wait = tasks.events.since(seq, tasks.version)
+	if !ok {
+		return el, tasks.token(tasks.version), false, wait
+	}
+	next = tasks.token(seq)
+	if len(el) > 0 {
+		next = el[len(el)-1].ID
+	}
+	return el, next, true, wait
+}
@@ -0,0 +1,77 @@
+package tasker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func types(el []TaskEvent) []string {
+	tl := make([]string, 0)
+	for _, ev := range el {
+		tl = append(tl, ev.Type)
+	}
+	return tl
+}
+
+func TestTasks_Events(t *testing.T) {
+	tasks := NewTasksWithStore(NewMemoryStore())
+
+	// after 가 없으면 지금부터
+	el, start, ok, wait := tasks.Events("")
+	assert.True(t, ok)
+	assert.Empty(t, el)
+	assert.Equal(t, tasks.ETag(), `"`+start+`"`)
+
+	t1 := tasks.CreateTask(&Task{FileName: "A.mpg", DstAddr: "127.0.0.1:8081"})
+	select {
+	case <-wait:
+	default:
+		assert.Fail(t, "wait channel is not closed")
+	}
+	t2 := tasks.CreateTask(&Task{FileName: "B.mpg", DstAddr: "127.0.0.2:8082"})
+	assert.Nil(t, tasks.UpdateStatus(t1.ID, WORKING))
+	assert.Nil(t, tasks.DeleteTask(t2.ID))
+
+	el, next, ok, _ := tasks.Events(start)
+	assert.True(t, ok)
+	assert.Equal(t, []string{TaskCreated, TaskCreated, TaskUpdated, TaskDeleted}, types(el))
+	assert.Equal(t, t1.ID, el[0].Task.ID)
+	assert.Equal(t, READY, el[0].Task.Status)
+	assert.Equal(t, WORKING, el[2].Task.Status)
+	assert.Equal(t, t2.ID, el[3].Task.ID)
+	assert.Equal(t, el[3].ID, next)
+	assert.Equal(t, tasks.ETag(), `"`+next+`"`)
+
+	// GET /tasks 의 ETag 로도 이어서 받을 수 있음
+	el, _, ok, _ = tasks.Events(`"` + el[1].ID + `"`)
+	assert.True(t, ok)
+	assert.Equal(t, []string{TaskUpdated, TaskDeleted}, types(el))
+
+	el, n, ok, _ := tasks.Events(next)
+	assert.True(t, ok)
+	assert.Empty(t, el)
+	assert.Equal(t, next, n)
+
+	// 전체 삭제는 reset
+	tasks.DeleteAllTask()
+	el, _, ok, _ = tasks.Events(next)
+	assert.True(t, ok)
+	assert.Equal(t, []string{TaskReset}, types(el))
+	assert.Nil(t, el[0].Task)
+
+	// 잘못되었거나 재시작 전의 after 는 ok 가 false
+	for _, after := range []string{"invalid", "1-1", next + "0"} {
+		_, n, ok, _ = tasks.Events(after)
+		assert.False(t, ok, after)
+		assert.Equal(t, tasks.ETag(), `"`+n+`"`, after)
+	}
+
+	// 오래된 event 는 지워짐
+	for i := 0; i < maxTaskEvents; i++ {
+		tasks.CreateTask(&Task{FileName: "C.mpg"})
+	}
+	_, _, ok, _ = tasks.Events(start)
+	assert.False(t, ok)
+	tasks.Release()
+}
@@ -0,0 +1,190 @@
+package tasker
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Failure : (file, dst) 별 task timeout 기록
+//
+// Count : 연속으로 timeout 된 횟수, done 이 되면 기록이 지워짐
+//
+// LastFailed : 마지막으로 timeout 된 시간
+//
+// RetryAfter : 이 시간 이후에 다시 task 를 만들 수 있음
+//
+// Quarantined : timeout 횟수가 quarantine 기준을 넘어서,
+// API 로 지우기 전까지 task 를 만들지 않음
+type Failure struct {
+	FileName    string   `json:"file_name"`
+	DstAddr     string   `json:"dst_addr"`
+	Count       int      `json:"count"`
+	LastFailed  TaskTime `json:"last_failed"`
+	RetryAfter  TaskTime `json:"retry_after"`
+	Quarantined bool     `json:"quarantined"`
+}
+
+// String : failure to string
+func (f Failure) String() string {
+	return fmt.Sprintf(
+		"fileName(%s), dstAddr(%s), count(%d), lastFailed(%s)"+
+			", retryAfter(%s), quarantined(%t)",
+		f.FileName, f.DstAddr, f.Count, f.LastFailed, f.RetryAfter, f.Quarantined)
+}
+
+type failureKey struct {
+	fileName string
+	dstAddr  string
+}
+
+// Failures : (file, dst) 별 timeout 기록
+//
+// timeout 된 (file, dst) 는 backoffBase * 2^(count-1) 동안(최대 backoffMax)
+// task 를 다시 만들지 않고,
+// quarantineLimit 번 timeout 되면 quarantine 목록에 넣음
+//
+// 기록은 memory 에만 있어서 재시작하면 지워짐
+type Failures struct {
+	mutex           *sync.RWMutex
+	m               map[failureKey]*Failure
+	backoffBase     time.Duration
+	backoffMax      time.Duration
+	quarantineLimit int
+}
+
+// NewFailures is constructor of Failures
+func NewFailures() *Failures {
+	return &Failures{
+		mutex:           &sync.RWMutex{},
+		m:               make(map[failureKey]*Failure),
+		backoffBase:     time.Minute,
+		backoffMax:      time.Hour,
+		quarantineLimit: 5,
+	}
+}
+
+// SetBackoff : 첫 timeout 후 기다리는 시간과 최대로 기다리는 시간
+func (fs *Failures) SetBackoff(base, max time.Duration) error {
+	if base <= 0 || max < base {
+		return errors.New(fmt.Sprintf("invalid backoff, base(%s), max(%s)", base, max))
+	}
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	fs.backoffBase, fs.backoffMax = base, max
+	tskrlogger.Infof("set retry backoff base(%s), max(%s)", base, max)
+	return nil
+}
+
+// SetQuarantineLimit : quarantine 목록에 넣는 timeout 횟수
+func (fs *Failures) SetQuarantineLimit(n int) error {
+	if n < 1 {
+		return errors.New(fmt.Sprintf("invalid quarantine limit(%d), must be greater than 0", n))
+	}
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	fs.quarantineLimit = n
+	tskrlogger.Infof("set quarantine limit(%d)", n)
+	return nil
+}
+
+// backoff : count 번 timeout 된 후 기다리는 시간
+func (fs *Failures) backoff(count int) time.Duration {
+	d := fs.backoffBase
+	for i := 1; i < count && d < fs.backoffMax; i++ {
+		d *= 2
+	}
+	if d > fs.backoffMax {
+		d = fs.backoffMax
+	}
+	return d
+}
+
+// fail : (file, dst) 의 timeout 기록
+func (fs *Failures) fail(fileName, dstAddr string, now time.Time) Failure {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	k := failureKey{fileName, dstAddr}
+	f, ok := fs.m[k]
+	if !ok {
+		f = &Failure{FileName: fileName, DstAddr: dstAddr}
+		fs.m[k] = f
+	}
+	f.Count++
+	f.LastFailed = TaskTime(now.Unix())
+	f.RetryAfter = TaskTime(now.Add(fs.backoff(f.Count)).Unix())
+	if f.Count >= fs.quarantineLimit {
+		f.Quarantined = true
+	}
+	return *f
+}
+
+// succeed : (file, dst) 의 timeout 기록 지우기
+func (fs *Failures) succeed(fileName, dstAddr string) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	delete(fs.m, failureKey{fileName, dstAddr})
+}
+
+// allowed : (file, dst) 의 task 를 now 에 만들 수 있는지
+func (fs *Failures) allowed(fileName, dstAddr string, now time.Time) bool {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	f, ok := fs.m[failureKey{fileName, dstAddr}]
+	if !ok {
+		return true
+	}
+	return !f.Quarantined && TaskTime(now.Unix()) >= f.RetryAfter
+}
+
+// GetList : timeout 기록 목록, quarantined 이면 quarantine 된 기록만
+//
+// 파일 이름, dst 순으로 sort 해서 반환
+func (fs *Failures) GetList(quarantined bool) []Failure {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+
+	fl := make([]Failure, 0)
+	for _, f := range fs.m {
+		if quarantined && !f.Quarantined {
+			continue
+		}
+		fl = append(fl, *f)
+	}
+	sort.Slice(fl, func(i, j int) bool {
+		if fl[i].FileName != fl[j].FileName {
+			return fl[i].FileName < fl[j].FileName
+		}
+		return fl[i].DstAddr < fl[j].DstAddr
+	})
+	return fl
+}
+
+// Clear :
+// quarantine 된 기록 중 fileName, dstAddr 가 같은 기록 지우기
+//
+// 빈 값인 조건은 사용하지 않음, 지운 개수를 반환
+func (fs *Failures) Clear(fileName, dstAddr string) int {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	cnt := 0
+	for k, f := range fs.m {
+		if !f.Quarantined ||
+			(fileName != "" && fileName != k.fileName) ||
+			(dstAddr != "" && dstAddr != k.dstAddr) {
+			continue
+		}
+		delete(fs.m, k)
+		tskrlogger.Infof("cleared quarantine(%s)", f)
+		cnt++
+	}
+	return cnt
+}
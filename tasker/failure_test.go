@@ -0,0 +1,91 @@
+package tasker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailures_SetBackoff(t *testing.T) {
+	fs := NewFailures()
+	assert.NotNil(t, fs.SetBackoff(0, time.Minute))
+	assert.NotNil(t, fs.SetBackoff(time.Minute, time.Second))
+	assert.Nil(t, fs.SetBackoff(time.Second, time.Minute))
+	assert.NotNil(t, fs.SetQuarantineLimit(0))
+	assert.Nil(t, fs.SetQuarantineLimit(1))
+}
+
+func TestFailures_backoff(t *testing.T) {
+	fs := NewFailures()
+	fs.SetBackoff(10*time.Second, 60*time.Second)
+
+	assert.Equal(t, 10*time.Second, fs.backoff(1))
+	assert.Equal(t, 20*time.Second, fs.backoff(2))
+	assert.Equal(t, 40*time.Second, fs.backoff(3))
+	assert.Equal(t, 60*time.Second, fs.backoff(4))
+	assert.Equal(t, 60*time.Second, fs.backoff(100))
+}
+
+func TestFailures(t *testing.T) {
+	fs := NewFailures()
+	fs.SetBackoff(10*time.Second, 60*time.Second)
+	fs.SetQuarantineLimit(3)
+
+	now := time.Now()
+	d1 := "127.0.0.1:18081"
+	d2 := "127.0.0.2:18082"
+	assert.True(t, fs.allowed("A.mpg", d1, now))
+
+	// 첫 timeout 후 10초 동안 task 를 만들지 않음
+	f := fs.fail("A.mpg", d1, now)
+	assert.Equal(t, 1, f.Count)
+	assert.False(t, f.Quarantined)
+	assert.False(t, fs.allowed("A.mpg", d1, now))
+	assert.False(t, fs.allowed("A.mpg", d1, now.Add(9*time.Second)))
+	assert.True(t, fs.allowed("A.mpg", d1, now.Add(10*time.Second)))
+	// 다른 dst, 다른 파일은 상관없음
+	assert.True(t, fs.allowed("A.mpg", d2, now))
+	assert.True(t, fs.allowed("B.mpg", d1, now))
+
+	// 두 번째 timeout 후 20초
+	f = fs.fail("A.mpg", d1, now)
+	assert.Equal(t, 2, f.Count)
+	assert.False(t, fs.allowed("A.mpg", d1, now.Add(19*time.Second)))
+	assert.True(t, fs.allowed("A.mpg", d1, now.Add(20*time.Second)))
+
+	// done 이 되면 기록이 지워짐
+	fs.succeed("A.mpg", d1)
+	assert.True(t, fs.allowed("A.mpg", d1, now))
+	assert.Equal(t, 0, len(fs.GetList(false)))
+
+	// 3 번 timeout 되면 quarantine
+	fs.fail("A.mpg", d1, now)
+	fs.fail("A.mpg", d1, now)
+	f = fs.fail("A.mpg", d1, now)
+	assert.True(t, f.Quarantined)
+	assert.False(t, fs.allowed("A.mpg", d1, now.Add(24*time.Hour)))
+	fs.fail("B.mpg", d2, now)
+
+	assert.Equal(t, 2, len(fs.GetList(false)))
+	ql := fs.GetList(true)
+	assert.Equal(t, 1, len(ql))
+	assert.Equal(t, "A.mpg", ql[0].FileName)
+	assert.Equal(t, d1, ql[0].DstAddr)
+
+	// quarantine 된 기록만 지움
+	assert.Equal(t, 0, fs.Clear("B.mpg", ""))
+	assert.Equal(t, 0, fs.Clear("A.mpg", d2))
+	assert.Equal(t, 1, fs.Clear("A.mpg", d1))
+	assert.True(t, fs.allowed("A.mpg", d1, now))
+	assert.Equal(t, 1, len(fs.GetList(false)))
+
+	fs.fail("C.mpg", d1, now)
+	fs.fail("C.mpg", d1, now)
+	fs.fail("C.mpg", d1, now)
+	fs.fail("D.mpg", d2, now)
+	fs.fail("D.mpg", d2, now)
+	fs.fail("D.mpg", d2, now)
+	assert.Equal(t, 2, fs.Clear("", ""))
+	assert.Equal(t, 1, len(fs.GetList(false)))
+}
@@ -0,0 +1,370 @@
+package tasker
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TaskRecord : 끝난 task 기록
+//
+// Status : 끝난 상태, done 또는 timeout
+//
+// Size : 배포 파일 크기(byte)
+//
+// Ctime : task 를 만든 시간
+//
+// Wtime : working 상태가 된 시간, working 상태가 된 적 없으면 0
+//
+// Dtime : task 가 끝난 시간
+//
+// WaitSec : 만든 후 working 상태가 될 때까지 걸린 시간(초)
+//
+// WorkSec : working 상태가 된 후(working 상태가 된 적이 없으면 만든 후) 끝날 때까지 걸린 시간(초)
+//
+// TotalSec : 만든 후 끝날 때까지 걸린 시간(초)
+type TaskRecord struct {
+	ID       int64    `json:"id,string"`
+	Status   Status   `json:"status"`
+	FilePath string   `json:"file_path"`
+	FileName string   `json:"file_name"`
+	Grade    int32    `json:"grade"`
+	Size     int64    `json:"size"`
+	SrcAddr  string   `json:"src_addr"`
+	DstAddr  string   `json:"dst_addr"`
+	Ctime    TaskTime `json:"ctime"`
+	Wtime    TaskTime `json:"wtime"`
+	Dtime    TaskTime `json:"dtime"`
+	WaitSec  int64    `json:"wait_sec"`
+	WorkSec  int64    `json:"work_sec"`
+	TotalSec int64    `json:"total_sec"`
+}
+
+// newTaskRecord : task 가 dtime 에 status 상태로 끝난 기록
+func newTaskRecord(t Task, status Status, dtime TaskTime) TaskRecord {
+	tr := TaskRecord{
+		ID:       t.ID,
+		Status:   status,
+		FilePath: t.FilePath,
+		FileName: t.FileName,
+		Grade:    t.Grade,
+		Size:     t.Size,
+		SrcAddr:  t.SrcAddr,
+		DstAddr:  t.DstAddr,
+		Ctime:    t.Ctime,
+		Wtime:    t.Wtime,
+		Dtime:    dtime,
+		TotalSec: int64(dtime - t.Ctime),
+	}
+	if t.Wtime != 0 {
+		tr.WaitSec = int64(t.Wtime - t.Ctime)
+		tr.WorkSec = int64(dtime - t.Wtime)
+	} else {
+		tr.WorkSec = tr.TotalSec
+	}
+	return tr
+}
+
+// String : task record to string
+func (tr TaskRecord) String() string {
+	return fmt.Sprintf(
+		"id(%d), status(%s), fileName(%s), size(%d), srcAddr(%s), dstAddr(%s)"+
+			", ctime(%s), wtime(%s), dtime(%s), workSec(%d)",
+		tr.ID, tr.Status, tr.FileName, tr.Size, tr.SrcAddr, tr.DstAddr,
+		tr.Ctime, tr.Wtime, tr.Dtime, tr.WorkSec)
+}
+
+// HistoryFilter : task 기록 조회 조건, 빈 값인 조건은 사용하지 않음
+//
+// From, To : 끝난 시간 범위, From <= Dtime <= To
+type HistoryFilter struct {
+	FileName string
+	SrcAddr  string
+	DstAddr  string
+	Status   Status
+	From     TaskTime
+	To       TaskTime
+}
+
+func (f HistoryFilter) match(tr TaskRecord) bool {
+	if f.FileName != "" && f.FileName != tr.FileName {
+		return false
+	}
+	if f.SrcAddr != "" && f.SrcAddr != tr.SrcAddr {
+		return false
+	}
+	if f.DstAddr != "" && f.DstAddr != tr.DstAddr {
+		return false
+	}
+	if f.Status != 0 && f.Status != tr.Status {
+		return false
+	}
+	return true
+}
+
+// DstStat : destination 서버별 task 기록 통계
+//
+// Bytes, WorkSec : done task 의 파일 크기 합, 작업 시간 합
+//
+// ThroughputBPS : done task 의 배포 속도(bps), Bytes * 8 / WorkSec
+type DstStat struct {
+	DstAddr       string `json:"dst_addr"`
+	Done          int    `json:"done"`
+	Timeout       int    `json:"timeout"`
+	Bytes         int64  `json:"bytes"`
+	WorkSec       int64  `json:"work_sec"`
+	ThroughputBPS int64  `json:"throughput_bps"`
+}
+
+// DstStats : task 기록으로 destination 서버별 통계를 구해서 주소 순으로 반환
+func DstStats(rl []TaskRecord) []DstStat {
+	m := make(map[string]*DstStat)
+	for _, tr := range rl {
+		s, ok := m[tr.DstAddr]
+		if !ok {
+			s = &DstStat{DstAddr: tr.DstAddr}
+			m[tr.DstAddr] = s
+		}
+		switch tr.Status {
+		case DONE:
+			s.Done++
+			s.Bytes += tr.Size
+			s.WorkSec += tr.WorkSec
+		case TIMEOUT:
+			s.Timeout++
+		}
+	}
+	sl := make([]DstStat, 0, len(m))
+	for _, s := range m {
+		if s.WorkSec > 0 {
+			s.ThroughputBPS = s.Bytes * 8 / s.WorkSec
+		}
+		sl = append(sl, *s)
+	}
+	sort.Slice(sl, func(i, j int) bool {
+		return sl[i].DstAddr < sl[j].DstAddr
+	})
+	return sl
+}
+
+// HistoryStore : task 기록을 저장하는 곳
+//
+// SaveRecord : task 기록 저장
+//
+// DeleteRecordsBefore : 끝난 시간이 t 보다 이전인 task 기록을 지우고 지운 개수 반환
+//
+// LoadRecords : 끝난 시간이 from ~ to 인 task 기록을 끝난 시간, ID 순으로 반환,
+// to 가 0 이면 끝난 시간의 상한 없음
+//
+// Close : 닫기, 닫은 후에 사용하면 다시 열림
+//
+// Remove : 저장된 기록을 모두 지움, 지운 후에도 사용할 수 있음
+type HistoryStore interface {
+	SaveRecord(tr *TaskRecord) error
+	DeleteRecordsBefore(t TaskTime) (int, error)
+	LoadRecords(from, to TaskTime) ([]TaskRecord, error)
+	Close() error
+	Remove() error
+}
+
+// DefaultHistoryPath : task 기록 leveldb 기본 저장 경로
+const DefaultHistoryPath = ".history/tasks.db"
+
+// NewHistoryStore :
+//
+// backend 가 Memory 이면 저장하지 않고, 나머지 backend 는 path 의 leveldb 에 저장
+//
+// path : 저장 경로, 비어있으면 DefaultHistoryPath, Memory 는 사용하지 않음
+func NewHistoryStore(b StoreBackend, path string) (HistoryStore, error) {
+	if path == "" {
+		path = DefaultHistoryPath
+	}
+	switch b {
+	case LevelDB, File:
+		return newRepositoryAt(path), nil
+	case Memory:
+		return NewMemoryHistoryStore(), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("invalid store backend(%d)", b))
+	}
+}
+
+// MemoryHistoryStore : 저장하지 않는 HistoryStore
+type MemoryHistoryStore struct {
+	mutex   *sync.Mutex
+	records map[string]TaskRecord
+}
+
+// NewMemoryHistoryStore is constructor of MemoryHistoryStore
+func NewMemoryHistoryStore() *MemoryHistoryStore {
+	return &MemoryHistoryStore{
+		mutex:   &sync.Mutex{},
+		records: make(map[string]TaskRecord),
+	}
+}
+
+// SaveRecord : task 기록 저장
+func (s *MemoryHistoryStore) SaveRecord(tr *TaskRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.records[string(recordKey(tr.Dtime, tr.ID))] = *tr
+	return nil
+}
+
+// DeleteRecordsBefore : 끝난 시간이 t 보다 이전인 task 기록 삭제
+func (s *MemoryHistoryStore) DeleteRecordsBefore(t TaskTime) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cnt := 0
+	for k, tr := range s.records {
+		if tr.Dtime < t {
+			delete(s.records, k)
+			cnt++
+		}
+	}
+	return cnt, nil
+}
+
+// LoadRecords : 끝난 시간이 from ~ to 인 task 기록을 끝난 시간 순으로 반환
+func (s *MemoryHistoryStore) LoadRecords(from, to TaskTime) ([]TaskRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys := make([]string, 0, len(s.records))
+	for k, tr := range s.records {
+		if tr.Dtime < from || (to != 0 && tr.Dtime > to) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	rl := make([]TaskRecord, 0, len(keys))
+	for _, k := range keys {
+		rl = append(rl, s.records[k])
+	}
+	return rl, nil
+}
+
+// Close : 아무것도 하지 않음, 저장된 기록은 남아있음
+func (s *MemoryHistoryStore) Close() error {
+	return nil
+}
+
+// Remove : 저장된 기록을 모두 지움
+func (s *MemoryHistoryStore) Remove() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.records = make(map[string]TaskRecord)
+	return nil
+}
+
+// History : 끝난 task 를 저장하는 기록
+//
+// tasks store 와 다른 곳에 저장하고,
+// retention 보다 오래된 기록은 지움
+type History struct {
+	mutex     *sync.Mutex
+	store     HistoryStore
+	retention time.Duration
+}
+
+// NewHistory is constructor of History
+//
+// store 를 열지 않음, 처음 사용할 때 열림
+func NewHistory(store HistoryStore) *History {
+	return &History{
+		mutex:     &sync.Mutex{},
+		store:     store,
+		retention: 7 * 24 * time.Hour,
+	}
+}
+
+// SetStore : 기록을 저장하는 곳을 바꿈, 이전 store 는 닫음
+//
+// 이미 있는 기록은 새 store 로 옮기지 않음
+func (h *History) SetStore(store HistoryStore) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.store.Close()
+	h.store = store
+}
+
+// SetRetention : 기록을 남기는 기간
+func (h *History) SetRetention(d time.Duration) error {
+	if d <= 0 {
+		return errors.New(fmt.Sprintf("invalid retention(%s), must be greater than 0", d))
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.retention = d
+	tskrlogger.Infof("set task history retention(%s)", d)
+	return nil
+}
+
+// Add :
+// task 기록 추가 후, now 기준으로 retention 보다 오래된 기록은 지움
+func (h *History) Add(rl []TaskRecord, now time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for i := range rl {
+		if err := h.store.SaveRecord(&rl[i]); err != nil {
+			tskrlogger.Errorf("[%d] failed to archive task(%s), error(%s)",
+				rl[i].ID, rl[i], err.Error())
+			continue
+		}
+		tskrlogger.Debugf("[%d] archived task(%s)", rl[i].ID, rl[i])
+	}
+
+	before := TaskTime(now.Add(-h.retention).Unix())
+	n, err := h.store.DeleteRecordsBefore(before)
+	if err != nil {
+		tskrlogger.Errorf("failed to delete task history before(%s), error(%s)",
+			before, err.Error())
+		return
+	}
+	if n > 0 {
+		tskrlogger.Infof("deleted task history(%d) before(%s)", n, before)
+	}
+}
+
+// Find : 조건에 맞는 task 기록을 끝난 시간 순으로 반환
+func (h *History) Find(f HistoryFilter) ([]TaskRecord, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	rl, err := h.store.LoadRecords(f.From, f.To)
+	if err != nil {
+		return nil, err
+	}
+	fl := make([]TaskRecord, 0, len(rl))
+	for _, tr := range rl {
+		if f.match(tr) {
+			fl = append(fl, tr)
+		}
+	}
+	return fl, nil
+}
+
+// Close : store 닫기
+func (h *History) Close() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.store.Close()
+}
+
+// Release : 기록 모두 지우기
+func (h *History) Release() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.store.Remove()
+}
@@ -0,0 +1,117 @@
+package tasker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTaskRecord(t *testing.T) {
+	task := Task{ID: 1, FileName: "A.mpg", Size: 1000,
+		SrcAddr: "127.0.0.1:8081", DstAddr: "127.0.0.2:8082",
+		Ctime: 100, Wtime: 110, Mtime: 150}
+
+	tr := newTaskRecord(task, DONE, task.Mtime)
+	assert.Equal(t, DONE, tr.Status)
+	assert.Equal(t, int64(1000), tr.Size)
+	assert.Equal(t, TaskTime(150), tr.Dtime)
+	assert.Equal(t, int64(10), tr.WaitSec)
+	assert.Equal(t, int64(40), tr.WorkSec)
+	assert.Equal(t, int64(50), tr.TotalSec)
+
+	// working 상태가 된 적 없으면 만든 후 끝날 때까지가 작업 시간
+	task.Wtime = 0
+	tr = newTaskRecord(task, TIMEOUT, 200)
+	assert.Equal(t, TIMEOUT, tr.Status)
+	assert.Equal(t, int64(0), tr.WaitSec)
+	assert.Equal(t, int64(100), tr.WorkSec)
+	assert.Equal(t, int64(100), tr.TotalSec)
+}
+
+func TestDstStats(t *testing.T) {
+	d1 := "127.0.0.1:18081"
+	d2 := "127.0.0.2:18082"
+	rl := []TaskRecord{
+		{ID: 1, Status: DONE, DstAddr: d2, Size: 1000, WorkSec: 10},
+		{ID: 2, Status: DONE, DstAddr: d2, Size: 3000, WorkSec: 10},
+		{ID: 3, Status: TIMEOUT, DstAddr: d2, Size: 5000, WorkSec: 100},
+		{ID: 4, Status: TIMEOUT, DstAddr: d1, Size: 5000, WorkSec: 100},
+	}
+	sl := DstStats(rl)
+	assert.Equal(t, 2, len(sl))
+	assert.Equal(t, DstStat{DstAddr: d1, Timeout: 1}, sl[0])
+	assert.Equal(t, DstStat{DstAddr: d2, Done: 2, Timeout: 1,
+		Bytes: 4000, WorkSec: 20, ThroughputBPS: 1600}, sl[1])
+
+	assert.Equal(t, 0, len(DstStats([]TaskRecord{})))
+}
+
+func TestHistory(t *testing.T) {
+	for _, b := range []StoreBackend{LevelDB, Memory} {
+		store, err := NewHistoryStore(b, filepath.Join("testhistory", b.String(), "tasks.db"))
+		assert.Nil(t, err)
+		testHistory(t, NewHistory(store))
+	}
+	_, err := os.Stat("testhistory/leveldb")
+	assert.True(t, os.IsNotExist(err))
+	os.Remove("testhistory")
+
+	_, err = NewHistoryStore(StoreBackend(0), "")
+	assert.NotNil(t, err)
+	s, err := NewHistoryStore(File, "")
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultHistoryPath, s.(*Repository).where)
+}
+
+func testHistory(t *testing.T, h *History) {
+	defer h.Release()
+
+	assert.NotNil(t, h.SetRetention(0))
+	assert.Nil(t, h.SetRetention(time.Hour))
+
+	now := time.Now()
+	base := TaskTime(now.Unix())
+	h.Add([]TaskRecord{
+		{ID: 3, Status: DONE, FileName: "A.mpg", SrcAddr: "s1", DstAddr: "d1", Dtime: base - 30},
+		{ID: 1, Status: TIMEOUT, FileName: "B.mpg", SrcAddr: "s1", DstAddr: "d2", Dtime: base - 20},
+		{ID: 2, Status: DONE, FileName: "A.mpg", SrcAddr: "s2", DstAddr: "d2", Dtime: base - 10},
+	}, now)
+
+	// 끝난 시간 순으로 반환
+	rl, err := h.Find(HistoryFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(rl))
+	assert.Equal(t, int64(3), rl[0].ID)
+	assert.Equal(t, int64(1), rl[1].ID)
+	assert.Equal(t, int64(2), rl[2].ID)
+
+	rl, _ = h.Find(HistoryFilter{FileName: "A.mpg"})
+	assert.Equal(t, 2, len(rl))
+	rl, _ = h.Find(HistoryFilter{SrcAddr: "s1", DstAddr: "d2"})
+	assert.Equal(t, 1, len(rl))
+	assert.Equal(t, int64(1), rl[0].ID)
+	rl, _ = h.Find(HistoryFilter{Status: DONE})
+	assert.Equal(t, 2, len(rl))
+	rl, _ = h.Find(HistoryFilter{From: base - 20, To: base - 20})
+	assert.Equal(t, 1, len(rl))
+	assert.Equal(t, int64(1), rl[0].ID)
+	rl, _ = h.Find(HistoryFilter{From: base - 20})
+	assert.Equal(t, 2, len(rl))
+	rl, _ = h.Find(HistoryFilter{To: base - 20})
+	assert.Equal(t, 2, len(rl))
+
+	// retention 보다 오래된 기록은 다음 Add 할 때 지워짐
+	h.Add([]TaskRecord{}, now.Add(time.Hour).Add(-15*time.Second))
+	rl, _ = h.Find(HistoryFilter{})
+	assert.Equal(t, 1, len(rl))
+	assert.Equal(t, int64(2), rl[0].ID)
+
+	// Release 하면 모두 지워짐
+	h.Release()
+	rl, err = h.Find(HistoryFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(rl))
+}
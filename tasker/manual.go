@@ -0,0 +1,298 @@
+package tasker
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cfm/decision"
+	"github.com/castisdev/cfm/heartbeater"
+)
+
+// ManualTask : API 로 요청받은 배포 task
+//
+// tasker 가 다음 주기에 risingHit, grade 순서의 파일보다 먼저 task 를 만듬
+//
+// SrcAddr, DstAddr : 빈 값이면 tasker 가 선택함
+//
+// Priority : 값이 큰 요청부터 task 를 만듬, 같으면 먼저 요청받은 순서
+type ManualTask struct {
+	ID       int64    `json:"id,string"`
+	FileName string   `json:"file_name"`
+	SrcAddr  string   `json:"src_addr"`
+	DstAddr  string   `json:"dst_addr"`
+	Priority int      `json:"priority"`
+	Ctime    TaskTime `json:"ctime"`
+}
+
+// String : manual task to string
+func (mt ManualTask) String() string {
+	return fmt.Sprintf(
+		"id(%d), fileName(%s), srcAddr(%s), dstAddr(%s), priority(%d), ctime(%s)",
+		mt.ID, mt.FileName, mt.SrcAddr, mt.DstAddr, mt.Priority, mt.Ctime)
+}
+
+// ManualTasks : task 를 만들기 전의 manual task 목록
+//
+// 목록은 memory 에만 있어서 재시작하면 지워짐
+//
+// lastID : 마지막으로 정한 ID
+type ManualTasks struct {
+	mutex  *sync.Mutex
+	m      map[int64]*ManualTask
+	lastID int64
+}
+
+// NewManualTasks is constructor of ManualTasks
+func NewManualTasks() *ManualTasks {
+	return &ManualTasks{
+		mutex: &sync.Mutex{},
+		m:     make(map[int64]*ManualTask),
+	}
+}
+
+// add : manual task 추가, ID 와 Ctime 을 정함
+func (mts *ManualTasks) add(mt ManualTask) ManualTask {
+	mts.mutex.Lock()
+	defer mts.mutex.Unlock()
+
+	now := time.Now()
+	mt.ID = mts.nextID(now)
+	mt.Ctime = TaskTime(now.Unix())
+	mts.m[mt.ID] = &mt
+	return mt
+}
+
+// nextID :
+//
+// now 의 nano time 을 ID 로 사용하고,
+// 마지막 ID 보다 크지 않으면(같은 nano time, 시간이 뒤로 바뀐 경우) 마지막 ID + 1 사용
+func (mts *ManualTasks) nextID(now time.Time) int64 {
+	id := now.UnixNano()
+	if id <= mts.lastID {
+		id = mts.lastID + 1
+	}
+	mts.lastID = id
+	return id
+}
+
+// remove : manual task 지우기
+func (mts *ManualTasks) remove(id int64) {
+	mts.mutex.Lock()
+	defer mts.mutex.Unlock()
+
+	delete(mts.m, id)
+}
+
+// GetList :
+// manual task 목록을 높은 priority 순서로 반환
+//
+// priority 가 같으면 먼저 요청받은 순서
+func (mts *ManualTasks) GetList() []ManualTask {
+	mts.mutex.Lock()
+	defer mts.mutex.Unlock()
+
+	ml := make([]ManualTask, 0, len(mts.m))
+	for _, mt := range mts.m {
+		ml = append(ml, *mt)
+	}
+	sort.Slice(ml, func(i, j int) bool {
+		if ml[i].Priority != ml[j].Priority {
+			return ml[i].Priority > ml[j].Priority
+		}
+		return ml[i].ID < ml[j].ID
+	})
+	return ml
+}
+
+// AddManualTask :
+//
+// 요청을 검사한 후 manual task 목록에 추가
+//
+// 	- 파일이 source path 에 있어야 함
+//
+// 	- src 를 지정하면 src 서버 목록에 있고, heartbeat 가 OK 여야 함
+//
+// 	- dst 를 지정하면 dst 서버 목록에 있고, drain 모드가 아니고, heartbeat 가 OK 여야 함
+func (tskr *Tasker) AddManualTask(mt ManualTask) (ManualTask, error) {
+	if mt.FileName == "" {
+		return mt, errors.New("empty file name")
+	}
+	if _, exists := tskr.SourcePath.IsExistOnSource(mt.FileName); !exists {
+		return mt, errors.New(fmt.Sprintf(
+			"file(%s) not found in the source paths", mt.FileName))
+	}
+	if mt.SrcAddr != "" {
+		if _, found := tskr.SrcServers.getHostStatus(mt.SrcAddr); !found {
+			return mt, errors.New(fmt.Sprintf("src(%s) not found", mt.SrcAddr))
+		}
+		if !heartbeatOK(mt.SrcAddr) {
+			return mt, errors.New(fmt.Sprintf("src(%s) heartbeat is not ok", mt.SrcAddr))
+		}
+	}
+	if mt.DstAddr != "" {
+		if _, found := tskr.DstServers.getHostStatus(mt.DstAddr); !found {
+			return mt, errors.New(fmt.Sprintf("dst(%s) not found", mt.DstAddr))
+		}
+		if tskr.DstServers.isDraining(mt.DstAddr) {
+			return mt, errors.New(fmt.Sprintf("dst(%s) is draining", mt.DstAddr))
+		}
+		if !heartbeatOK(mt.DstAddr) {
+			return mt, errors.New(fmt.Sprintf("dst(%s) heartbeat is not ok", mt.DstAddr))
+		}
+	}
+
+	mt = tskr.manual.add(mt)
+	tskrlogger.Infof("[%d] added manual task(%s)", mt.ID, mt)
+	return mt, nil
+}
+
+func heartbeatOK(addr string) bool {
+	h, ok := heartbeater.Get(addr)
+	return ok && h.Status == heartbeater.OK
+}
+
+// makeManualTasks :
+//
+// manual task 목록으로 높은 priority 순서로 배포 task 생성
+//
+// 	- source path 에 없는 파일, dst 서버에 이미 있거나 task 가 있는 파일,
+// 	  지정한 dst 가 drain 모드인 요청은 지움
+//
+// 	- 지정한 src, dst 에 남은 slot 이 없으면 다음 주기에 다시 검사
+//
+// 	- dst 를 지정하지 않으면 placement 로 선택
+//
+// 만든 task 는 taskfiles, taskdsts 에 반영하고, 요청은 목록에서 지움
+//
+// plan 모드이면 task 를 만들지 않고 planned 에 추가하고, 요청을 지우지 않음
+//
+// src 서버가 남아있지 않으면 false return
+func (tskr *Tasker) makeManualTasks(fileMetaMap FileMetaPtrMap,
+	taskfiles FileFreqMap, dstfiles ServerFileFreqMap, taskdsts FileDstsMap) bool {
+
+	for _, mt := range tskr.manual.GetList() {
+		fmm, ok := fileMetaMap[mt.FileName]
+		if !ok {
+			fmm = common.NewFileMetaWith(mt.FileName, 0)
+		}
+
+		if !tskr.updateFileMetaForSrcFilePath(fmm) {
+			tskrlogger.Infof("[%d] ignored manual task by not.found.in.the.source.paths, "+
+				"manual task(%s)", mt.ID, mt)
+			tskr.record(fmm, mt.DstAddr, decision.SKIP, decision.NotFoundInTheSourcePaths)
+			tskr.removeManualTask(mt)
+			continue
+		}
+
+		// src 서버가 남아있지 않으면 중지
+		if tskr.SrcServers.getSelectableCount() == 0 {
+			return false
+		}
+		if mt.SrcAddr != "" && !tskr.SrcServers.isSelectable(mt.SrcAddr) {
+			tskrlogger.Debugf("[%d] delayed manual task, no slot in src(%s)", mt.ID, mt.SrcAddr)
+			continue
+		}
+
+		// dst 서버 선택
+		// 	- 지정한 dst 는 backoff, quarantine 중이어도 선택
+		var dst *DstHost
+		if mt.DstAddr != "" {
+			for _, d := range *tskr.DstServers {
+				if d.Addr == mt.DstAddr {
+					dst = d
+				}
+			}
+			if dst == nil {
+				tskrlogger.Infof("[%d] ignored manual task by no.dst.server(%s), "+
+					"manual task(%s)", mt.ID, mt.DstAddr, mt)
+				tskr.record(fmm, mt.DstAddr, decision.SKIP, decision.NoDstServer)
+				tskr.removeManualTask(mt)
+				continue
+			}
+			if fmm.ServerIPs[dst.IP] > 0 || dstfiles[dst.Addr][fmm.Name] > 0 {
+				tskrlogger.Infof("[%d] ignored manual task by found.in.the.servers, "+
+					"manual task(%s)", mt.ID, mt)
+				tskr.record(fmm, mt.DstAddr, decision.SKIP, decision.FoundInTheServers)
+				tskr.removeManualTask(mt)
+				continue
+			}
+			if taskdsts[fmm.Name][mt.DstAddr] {
+				tskrlogger.Infof("[%d] ignored manual task by found.in.the.tasks, "+
+					"manual task(%s)", mt.ID, mt)
+				tskr.record(fmm, mt.DstAddr, decision.SKIP, decision.FoundInTheTasks)
+				tskr.removeManualTask(mt)
+				continue
+			}
+			if dst.draining {
+				tskrlogger.Infof("[%d] ignored manual task by draining, "+
+					"manual task(%s)", mt.ID, mt)
+				tskr.record(fmm, mt.DstAddr, decision.SKIP, decision.Draining)
+				tskr.removeManualTask(mt)
+				continue
+			}
+			if !dst.isSelectable() {
+				tskrlogger.Debugf("[%d] delayed manual task, no slot in dst(%s)",
+					mt.ID, mt.DstAddr)
+				continue
+			}
+		} else {
+			excluded := tskr.getDstsToExclude(fmm, dstfiles, taskdsts)
+			d, found := tskr.placement.Select(fmm, excluded)
+			if !found {
+				tskrlogger.Debugf("[%d] delayed manual task, no.dst.server.for.placement(%s)",
+					mt.ID, tskr.placement.Placement())
+				continue
+			}
+			dst = &d
+		}
+		tskr.DstServers.selectDestinationServer(dst.Addr)
+
+		// src 서버 선택
+		var src SrcHost
+		if mt.SrcAddr != "" {
+			src, _ = tskr.SrcServers.selectSourceServerByAddr(mt.SrcAddr)
+		} else {
+			src, _ = tskr.SrcServers.selectSourceServer()
+		}
+
+		task := &Task{
+			FilePath:  fmm.SrcFilePath,
+			FileName:  fmm.Name,
+			SrcIP:     src.IP,
+			DstIP:     dst.IP,
+			Grade:     fmm.Grade,
+			CopySpeed: tskr.taskCopySpeed,
+			SrcAddr:   src.Addr,
+			DstAddr:   dst.Addr,
+			Size:      fmm.Size,
+			Priority:  mt.Priority,
+		}
+		if tskr.dryRun {
+			tskr.addPlannedTaskWithReason(task, fmm,
+				fmt.Sprintf("manual(%d), priority(%d)", mt.ID, mt.Priority))
+		} else {
+			t := tskr.tasks.CreateTask(task)
+			tskrlogger.Infof("[%d] created task(%s) for manual task(%s)", t.ID, t, mt)
+			tskr.record(fmm, dst.Addr, decision.TASK, decision.Manual)
+			tskr.manual.remove(mt.ID)
+		}
+		taskfiles[fmm.Name]++
+		if taskdsts[fmm.Name] == nil {
+			taskdsts[fmm.Name] = make(map[string]bool)
+		}
+		taskdsts[fmm.Name][dst.Addr] = true
+	}
+	return true
+}
+
+// removeManualTask : task 를 만들 수 없는 요청 지우기, plan 모드에서는 지우지 않음
+func (tskr *Tasker) removeManualTask(mt ManualTask) {
+	if tskr.dryRun {
+		return
+	}
+	tskr.manual.remove(mt.ID)
+}
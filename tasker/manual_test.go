@@ -0,0 +1,132 @@
+package tasker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cfm/heartbeater"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManualTasks_GetList(t *testing.T) {
+	mts := NewManualTasks()
+	m1 := mts.add(ManualTask{FileName: "A.mpg", Priority: 1})
+	m2 := mts.add(ManualTask{FileName: "B.mpg", Priority: 5})
+	m3 := mts.add(ManualTask{FileName: "C.mpg", Priority: 1})
+
+	ml := mts.GetList()
+	assert.Equal(t, 3, len(ml))
+	assert.Equal(t, m2.ID, ml[0].ID)
+	assert.Equal(t, m1.ID, ml[1].ID)
+	assert.Equal(t, m3.ID, ml[2].ID)
+	assert.NotEqual(t, TaskTime(0), ml[0].Ctime)
+
+	mts.remove(m2.ID)
+	assert.Equal(t, 2, len(mts.GetList()))
+}
+
+func TestManualTasks_nextID(t *testing.T) {
+	mts := NewManualTasks()
+	now := time.Now()
+
+	// 같은 시간이거나 시간이 뒤로 바뀌어도 ID 는 커짐
+	id1 := mts.nextID(now)
+	id2 := mts.nextID(now)
+	id3 := mts.nextID(now.Add(-time.Hour))
+	assert.Equal(t, now.UnixNano(), id1)
+	assert.Equal(t, id1+1, id2)
+	assert.Equal(t, id2+1, id3)
+}
+
+// manual task 는 risingHit, grade 순서의 파일보다 먼저 만들어짐
+func Test_runWithInfoWithManualTasks(t *testing.T) {
+	tskr := NewTasker()
+
+	s1 := "127.0.0.1:8097"
+	d1 := "127.0.0.1:18097"
+	d2 := "127.0.0.1:18098"
+	d3 := "127.0.0.1:18099"
+	du := common.DiskUsage{
+		TotalSize: 1000, UsedSize: 600,
+		FreeSize: 400, AvailSize: 400, UsedPercent: 60,
+	}
+	tskr.SrcServers.Add(s1)
+	tskr.DstServers.Add(d1)
+	tskr.DstServers.Add(d2)
+	tskr.DstServers.Add(d3)
+	for _, addr := range []string{s1, d1, d2} {
+		s := cfw(addr, du, []string{})
+		s.Start()
+		defer s.Close()
+	}
+
+	base := "testsourcefolder"
+	tskr.SourcePath.Add(base)
+	createfile(base, "A.mpg")
+	createfile(base, "B.mpg")
+	createfile(base, "C.mpg")
+	defer deletefile(base, "")
+	allfmm := FileMetaPtrMap{
+		"A.mpg": common.NewFileMetaWith("A.mpg", 1),
+		"B.mpg": common.NewFileMetaWith("B.mpg", 2),
+	}
+
+	defer heartbeater.Release()
+	for _, addr := range []string{s1, d1, d2, d3} {
+		heartbeater.Add(addr)
+	}
+	heartbeater.Heartbeat()
+
+	ts := NewTasks()
+	tskr.tasks = ts
+	defer tskr.tasks.Release()
+	defer tskr.history.Release()
+
+	// source path 에 없는 파일, 없거나 heartbeat 가 OK 가 아닌 서버
+	for _, mt := range []ManualTask{
+		{},
+		{FileName: "X.mpg"},
+		{FileName: "B.mpg", SrcAddr: d1},
+		{FileName: "B.mpg", DstAddr: "127.0.0.1:19999"},
+		{FileName: "B.mpg", DstAddr: d3},
+	} {
+		_, err := tskr.AddManualTask(mt)
+		assert.NotNil(t, err, mt.String())
+	}
+
+	// grade 정보에 없는 파일도 manual task 로 요청할 수 있음
+	mc, err := tskr.AddManualTask(ManualTask{FileName: "C.mpg", Priority: 1})
+	assert.Nil(t, err)
+	mb, err := tskr.AddManualTask(ManualTask{FileName: "B.mpg", DstAddr: d2, Priority: 5})
+	assert.Nil(t, err)
+
+	// plan 모드에서는 manual task 목록을 지우지 않음
+	pl := tskr.Plan(allfmm, map[string]int{})
+	assert.Equal(t, 1, len(pl))
+	assert.Equal(t, "B.mpg", pl[0].FileName)
+	assert.Equal(t, d2, pl[0].DstAddr)
+	assert.Contains(t, pl[0].Reason, "priority(5)")
+	assert.Equal(t, 2, len(tskr.manual.GetList()))
+
+	// src slot 이 하나라서 priority 가 높은 B.mpg task 만 만들어짐
+	tskr.runWithInfo(allfmm, map[string]int{})
+	tl := ts.GetTaskList()
+	assert.Equal(t, 1, len(tl))
+	assert.Equal(t, "B.mpg", tl[0].FileName)
+	assert.Equal(t, d2, tl[0].DstAddr)
+	assert.Equal(t, 5, tl[0].Priority)
+	ml := tskr.manual.GetList()
+	assert.Equal(t, 1, len(ml))
+	assert.Equal(t, mc.ID, ml[0].ID)
+	assert.NotEqual(t, mb.ID, ml[0].ID)
+
+	// B.mpg task 가 끝나면, grade 가 높은 A.mpg 보다 C.mpg task 가 먼저 만들어짐
+	ts.UpdateStatus(tl[0].ID, DONE)
+	tskr.runWithInfo(allfmm, map[string]int{})
+	tl = ts.GetTaskList()
+	assert.Equal(t, 1, len(tl))
+	assert.Equal(t, "C.mpg", tl[0].FileName)
+	assert.Equal(t, 1, tl[0].Priority)
+	assert.Equal(t, 0, len(tskr.manual.GetList()))
+}
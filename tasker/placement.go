@@ -0,0 +1,273 @@
+package tasker
+
+import (
+	"container/ring"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/castisdev/cfm/common"
+)
+
+// Placement : 배포 task 의 destination 서버 선택 방식
+type Placement int
+
+// Placement const
+//
+// RoundRobin : destination 서버를 돌아가며 선택 (기본값)
+//
+// MostFreeDisk : 남은 disk 용량이 가장 많은 destination 서버 선택
+//
+// LeastLoaded : 할당된 task 수가 가장 적은 destination 서버 선택
+//
+// GradeAware : 등급이 높은 파일은 남은 disk 용량이 가장 많은 서버,
+// 나머지 파일은 파일이 들어갈 수 있는 가장 적은 용량이 남은 서버 선택
+const (
+	_ Placement = iota
+	RoundRobin
+	MostFreeDisk
+	LeastLoaded
+	GradeAware
+)
+
+func (p Placement) String() string {
+	m := map[Placement]string{
+		RoundRobin:   "roundrobin",
+		MostFreeDisk: "mostfreedisk",
+		LeastLoaded:  "leastloaded",
+		GradeAware:   "gradeaware",
+	}
+	return m[p]
+}
+
+// ToPlacement : 설정 문자열을 Placement 로 변환, 잘못된 이름이면 0 반환
+func ToPlacement(p string) Placement {
+	m := map[string]Placement{
+		"roundrobin":   RoundRobin,
+		"mostfreedisk": MostFreeDisk,
+		"leastloaded":  LeastLoaded,
+		"gradeaware":   GradeAware,
+	}
+	return m[strings.ToLower(p)]
+}
+
+// PlacementStrategy :
+// 배포 task 의 destination 서버를 고르는 방법
+//
+// Prepare : tasker 가 task 를 만들기 전에 한 번 호출됨,
+// 배포에 할당 가능한 destination 서버 목록과 현재 task 목록을 받음
+//
+// Select : 배포할 파일의 copy 마다 호출됨,
+// excluded 에 있는 destination 서버(addr)는 선택하지 않음,
+// 선택된 destination 서버와 선택 여부를 반환
+type PlacementStrategy interface {
+	Placement() Placement
+	Prepare(dsts []DstHost, curtasks []Task)
+	Select(fm *common.FileMeta, excluded map[string]bool) (DstHost, bool)
+}
+
+// NewPlacementStrategy :
+//
+// hotGrade : GradeAware 에서 사용, 이 값보다 작거나 같은 등급의 파일은
+// 남은 disk 용량이 가장 많은 서버에 배포
+//
+// diskUsageLimitPercent : MostFreeDisk, GradeAware 에서 사용,
+// 배포 후 disk 사용량이 이 값을 넘게 되는 서버는 선택하지 않음
+func NewPlacementStrategy(p Placement, hotGrade int32,
+	diskUsageLimitPercent uint) (PlacementStrategy, error) {
+	if diskUsageLimitPercent > 100 {
+		return nil, errors.New("disk usage limit percent must not be greater than 100")
+	}
+	switch p {
+	case RoundRobin:
+		return newRoundRobinPlacement(), nil
+	case LeastLoaded:
+		return &leastLoadedPlacement{}, nil
+	case MostFreeDisk, GradeAware:
+		return &freeDiskPlacement{
+			placement:             p,
+			hotGrade:              hotGrade,
+			diskUsageLimitPercent: diskUsageLimitPercent,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown placement(%d)", p)
+	}
+}
+
+// roundRobinPlacement :
+// destination 서버를 ring 으로 만들어서 돌아가며 선택
+type roundRobinPlacement struct {
+	dstRing *ring.Ring
+}
+
+func newRoundRobinPlacement() *roundRobinPlacement {
+	return &roundRobinPlacement{}
+}
+
+func (p *roundRobinPlacement) Placement() Placement {
+	return RoundRobin
+}
+
+func (p *roundRobinPlacement) Prepare(dsts []DstHost, curtasks []Task) {
+	p.dstRing = newDstRing(dsts)
+}
+
+func (p *roundRobinPlacement) Select(fm *common.FileMeta,
+	excluded map[string]bool) (DstHost, bool) {
+	if p.dstRing == nil {
+		return DstHost{}, false
+	}
+	for i := 0; i < p.dstRing.Len(); i++ {
+		dst := p.dstRing.Value.(DstHost)
+		p.dstRing = p.dstRing.Next()
+		if !excluded[dst.Addr] {
+			return dst, true
+		}
+	}
+	return DstHost{}, false
+}
+
+// leastLoadedPlacement :
+// 현재 task 목록과 이번에 만든 task 를 합쳐서
+// task 수가 가장 적은 destination 서버 선택
+//
+// task 수가 같으면 destination 서버 목록 순서대로 선택
+type leastLoadedPlacement struct {
+	dsts  []DstHost
+	loads map[string]int
+}
+
+func (p *leastLoadedPlacement) Placement() Placement {
+	return LeastLoaded
+}
+
+func (p *leastLoadedPlacement) Prepare(dsts []DstHost, curtasks []Task) {
+	p.dsts = dsts
+	p.loads = make(map[string]int)
+	for _, task := range curtasks {
+		p.loads[task.DstAddr]++
+	}
+}
+
+func (p *leastLoadedPlacement) Select(fm *common.FileMeta,
+	excluded map[string]bool) (DstHost, bool) {
+	selected := -1
+	for i, dst := range p.dsts {
+		if excluded[dst.Addr] {
+			continue
+		}
+		if selected < 0 || p.loads[dst.Addr] < p.loads[p.dsts[selected].Addr] {
+			selected = i
+		}
+	}
+	if selected < 0 {
+		return DstHost{}, false
+	}
+	dst := p.dsts[selected]
+	p.loads[dst.Addr]++
+	return dst, true
+}
+
+// dstSpace : destination 서버의 disk 사용량과 이번에 배포하기로 한 파일 크기 합
+type dstSpace struct {
+	dst     DstHost
+	du      common.DiskUsage
+	planned common.Disksize
+}
+
+// free : 사용 제한량까지 남은 용량
+func (s *dstSpace) free(limitPercent uint) common.Disksize {
+	limitUsedSize := s.du.GetLimitUsedSize(limitPercent)
+	used := s.du.UsedSize + s.planned
+	if used >= limitUsedSize {
+		return 0
+	}
+	return limitUsedSize - used
+}
+
+// freeDiskPlacement :
+// destination 서버의 disk 사용량을 구해서 선택
+//
+// MostFreeDisk : 파일이 들어갈 수 있는 서버 중, 남은 용량이 가장 많은 서버 선택
+//
+// GradeAware : 등급이 hotGrade 보다 높거나 rising hit 파일이면 MostFreeDisk 와 같고,
+// 그렇지 않으면 파일이 들어갈 수 있는 서버 중, 남은 용량이 가장 적은 서버 선택
+//
+// disk 사용량을 구하지 못한 서버는 선택하지 않음
+type freeDiskPlacement struct {
+	placement             Placement
+	hotGrade              int32
+	diskUsageLimitPercent uint
+	spaces                []*dstSpace
+}
+
+func (p *freeDiskPlacement) Placement() Placement {
+	return p.placement
+}
+
+func (p *freeDiskPlacement) Prepare(dsts []DstHost, curtasks []Task) {
+	p.spaces = make([]*dstSpace, 0, len(dsts))
+	hosts := make([]*common.Host, 0, len(dsts))
+	for i := range dsts {
+		hosts = append(hosts, &dsts[i].Host)
+	}
+	dus, rep := common.GetRemoteDiskUsages(hosts)
+	for _, dst := range dsts {
+		du, ok := dus[dst.Addr]
+		if !ok {
+			tskrlogger.Errorf("[%s] failed to get disk usage, error(%s)",
+				dst, rep.Failed[dst.Addr].Error())
+			continue
+		}
+		tskrlogger.Debugf("[%s] got disk usage(%s)", dst, du)
+		p.spaces = append(p.spaces, &dstSpace{dst: dst, du: du})
+	}
+}
+
+func (p *freeDiskPlacement) Select(fm *common.FileMeta,
+	excluded map[string]bool) (DstHost, bool) {
+	size := common.Disksize(0)
+	if fm.Size > 0 {
+		size = common.Disksize(fm.Size)
+	}
+	mostFree := p.placement == MostFreeDisk ||
+		fm.RisingHit > 0 || fm.Grade <= p.hotGrade
+
+	var selected *dstSpace
+	for _, s := range p.spaces {
+		if excluded[s.dst.Addr] {
+			continue
+		}
+		free := s.free(p.diskUsageLimitPercent)
+		if free < size {
+			continue
+		}
+		if selected == nil {
+			selected = s
+			continue
+		}
+		sfree := selected.free(p.diskUsageLimitPercent)
+		if (mostFree && free > sfree) || (!mostFree && free < sfree) {
+			selected = s
+		}
+	}
+	if selected == nil {
+		return DstHost{}, false
+	}
+	selected.planned += size
+	return selected.dst, true
+}
+
+// newDstRing :
+// dst server list 를 Ring 으로 만들어서 return
+//
+// dst server가 없는 경우, nil return 됨
+func newDstRing(dstlist []DstHost) *ring.Ring {
+	dstRing := ring.New(len(dstlist))
+	for _, d := range dstlist {
+		dstRing.Value = d
+		dstRing = dstRing.Next()
+		tskrlogger.Debugf("[%s] added to available destination servers", d.Addr)
+	}
+	return dstRing
+}
@@ -0,0 +1,166 @@
+package tasker
+
+import (
+	"testing"
+
+	"github.com/castisdev/cfm/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeDstHostList(addrs ...string) []DstHost {
+	dsts := NewDstHosts()
+	for _, addr := range addrs {
+		dsts.Add(addr)
+	}
+	dl := make([]DstHost, 0, len(addrs))
+	for _, d := range *dsts {
+		d.Status = OK
+		dl = append(dl, *d)
+	}
+	return dl
+}
+
+func TestToPlacement(t *testing.T) {
+	assert.Equal(t, RoundRobin, ToPlacement("roundrobin"))
+	assert.Equal(t, MostFreeDisk, ToPlacement("mostFreeDisk"))
+	assert.Equal(t, LeastLoaded, ToPlacement("LEASTLOADED"))
+	assert.Equal(t, GradeAware, ToPlacement("gradeaware"))
+	assert.Equal(t, Placement(0), ToPlacement("random"))
+
+	_, err := NewPlacementStrategy(ToPlacement("random"), 0, 90)
+	assert.NotNil(t, err)
+	_, err = NewPlacementStrategy(MostFreeDisk, 0, 101)
+	assert.NotNil(t, err)
+	p, err := NewPlacementStrategy(GradeAware, 10, 90)
+	assert.Nil(t, err)
+	assert.Equal(t, GradeAware, p.Placement())
+}
+
+func Test_roundRobinPlacement(t *testing.T) {
+	p := newRoundRobinPlacement()
+
+	// dst 서버가 없으면 선택되지 않음
+	p.Prepare(nil, nil)
+	_, found := p.Select(common.NewFileMetaWith("A.mpg", 1), nil)
+	assert.False(t, found)
+
+	// sort 된 순서대로 돌아가며 선택됨
+	p.Prepare(makeDstHostList("127.0.0.1:18081", "127.0.0.2:18082"), nil)
+	d, _ := p.Select(common.NewFileMetaWith("A.mpg", 1), nil)
+	assert.Equal(t, "127.0.0.2:18082", d.Addr)
+	d, _ = p.Select(common.NewFileMetaWith("B.mpg", 2), nil)
+	assert.Equal(t, "127.0.0.1:18081", d.Addr)
+	d, _ = p.Select(common.NewFileMetaWith("C.mpg", 3), nil)
+	assert.Equal(t, "127.0.0.2:18082", d.Addr)
+
+	// 제외된 서버는 건너뜀
+	excluded := map[string]bool{"127.0.0.1:18081": true}
+	d, _ = p.Select(common.NewFileMetaWith("D.mpg", 4), excluded)
+	assert.Equal(t, "127.0.0.2:18082", d.Addr)
+	d, _ = p.Select(common.NewFileMetaWith("D.mpg", 4), excluded)
+	assert.Equal(t, "127.0.0.2:18082", d.Addr)
+	// 모든 서버가 제외되면 선택되지 않음
+	excluded["127.0.0.2:18082"] = true
+	_, found = p.Select(common.NewFileMetaWith("D.mpg", 4), excluded)
+	assert.False(t, found)
+}
+
+func Test_leastLoadedPlacement(t *testing.T) {
+	p, _ := NewPlacementStrategy(LeastLoaded, 0, 100)
+
+	dl := makeDstHostList("127.0.0.1:18081", "127.0.0.2:18082", "127.0.0.3:18083")
+	curtasks := []Task{
+		{FileName: "A.mpg", DstAddr: "127.0.0.3:18083"},
+		{FileName: "B.mpg", DstAddr: "127.0.0.3:18083"},
+		{FileName: "C.mpg", DstAddr: "127.0.0.2:18082"},
+	}
+	p.Prepare(dl, curtasks)
+
+	// task 가 없는 d1 이 먼저 선택되고,
+	// 그 다음은 task 수가 1 로 같은 d2, d1 순으로 선택됨
+	d, _ := p.Select(common.NewFileMetaWith("D.mpg", 1), nil)
+	assert.Equal(t, "127.0.0.1:18081", d.Addr)
+	d, _ = p.Select(common.NewFileMetaWith("E.mpg", 2), nil)
+	assert.Equal(t, "127.0.0.2:18082", d.Addr)
+	d, _ = p.Select(common.NewFileMetaWith("F.mpg", 3), nil)
+	assert.Equal(t, "127.0.0.1:18081", d.Addr)
+	// 모든 서버의 task 수가 2 이면, 목록 순서대로 d3 선택
+	d, _ = p.Select(common.NewFileMetaWith("G.mpg", 4), nil)
+	assert.Equal(t, "127.0.0.3:18083", d.Addr)
+	// 제외된 서버는 task 수가 적어도 선택되지 않음
+	d, _ = p.Select(common.NewFileMetaWith("H.mpg", 5),
+		map[string]bool{"127.0.0.1:18081": true})
+	assert.Equal(t, "127.0.0.2:18082", d.Addr)
+}
+
+func Test_freeDiskPlacement(t *testing.T) {
+	d1 := "127.0.0.1:19081"
+	d1du := common.DiskUsage{
+		TotalSize: 1000, UsedSize: 800,
+		FreeSize: 200, AvailSize: 200, UsedPercent: 80,
+	}
+	d2 := "127.0.0.2:19082"
+	d2du := common.DiskUsage{
+		TotalSize: 1000, UsedSize: 500,
+		FreeSize: 500, AvailSize: 500, UsedPercent: 50,
+	}
+	d3 := "127.0.0.3:19083"
+	d3du := common.DiskUsage{
+		TotalSize: 1000, UsedSize: 300,
+		FreeSize: 700, AvailSize: 700, UsedPercent: 30,
+	}
+	cfw1 := cfw(d1, d1du, []string{})
+	cfw1.Start()
+	defer cfw1.Close()
+	cfw2 := cfw(d2, d2du, []string{})
+	cfw2.Start()
+	defer cfw2.Close()
+	cfw3 := cfw(d3, d3du, []string{})
+	cfw3.Start()
+	defer cfw3.Close()
+
+	// d4 는 disk 사용량을 구할 수 없어서 선택되지 않음
+	dl := makeDstHostList(d1, d2, d3, "127.0.0.4:19084")
+
+	fm := func(name string, grade int32, size int64) *common.FileMeta {
+		m := common.NewFileMetaWith(name, grade)
+		m.Size = size
+		return m
+	}
+
+	// 제한 90% : d1 100, d2 400, d3 600 만큼 배포 가능
+	p, _ := NewPlacementStrategy(MostFreeDisk, 0, 90)
+	p.Prepare(dl, nil)
+	d, found := p.Select(fm("A.mpg", 1, 300), nil)
+	assert.True(t, found)
+	assert.Equal(t, d3, d.Addr)
+	// d3 300, d2 400 남음
+	d, _ = p.Select(fm("B.mpg", 2, 300), nil)
+	assert.Equal(t, d2, d.Addr)
+	// d3 300, d2 100, d1 100 남음
+	d, _ = p.Select(fm("C.mpg", 3, 200), nil)
+	assert.Equal(t, d3, d.Addr)
+	// 들어갈 수 있는 서버가 없음
+	_, found = p.Select(fm("D.mpg", 4, 200), nil)
+	assert.False(t, found)
+
+	// hotGrade 가 2 이면,
+	// 등급 1,2 는 남은 용량이 가장 많은 서버,
+	// 나머지는 들어갈 수 있는 가장 적게 남은 서버 선택
+	p, _ = NewPlacementStrategy(GradeAware, 2, 90)
+	p.Prepare(dl, nil)
+	d, _ = p.Select(fm("A.mpg", 1, 100), nil)
+	assert.Equal(t, d3, d.Addr)
+	d, _ = p.Select(fm("E.mpg", 5, 100), nil)
+	assert.Equal(t, d1, d.Addr)
+	d, _ = p.Select(fm("F.mpg", 6, 100), nil)
+	assert.Equal(t, d2, d.Addr)
+	// rising hit 파일은 등급에 상관없이 남은 용량이 가장 많은 서버
+	rh := fm("G.mpg", 7, 100)
+	rh.RisingHit = 10
+	d, _ = p.Select(rh, nil)
+	assert.Equal(t, d3, d.Addr)
+	// 제외된 서버는 남은 용량이 많아도 선택되지 않음
+	d, _ = p.Select(fm("H.mpg", 1, 100), map[string]bool{d3: true})
+	assert.Equal(t, d2, d.Addr)
+}
@@ -0,0 +1,130 @@
+package tasker
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TaskFilter : task 목록 조회 조건, 빈 값인 조건은 사용하지 않음
+//
+// Statuses : 상태 중 하나인 task
+//
+// Since : mtime 이 Since 보다 크거나 같은 task
+//
+// Sort : 정렬 기준(id, ctime, mtime, priority, grade, file_name), 앞에 - 를 붙이면 내림차순,
+// 비어있으면 id, 기준 값이 같으면 id 순서
+//
+// Offset, Limit : 정렬한 목록에서 Offset 번째부터 Limit 개, Limit 이 0 이면 끝까지
+type TaskFilter struct {
+	FileName string
+	SrcAddr  string
+	DstAddr  string
+	Statuses []Status
+	Since    TaskTime
+	Sort     string
+	Offset   int
+	Limit    int
+}
+
+// taskSortKeys : TaskFilter.Sort 로 사용할 수 있는 정렬 기준과 비교 함수
+var taskSortKeys = map[string]func(a, b *Task) bool{
+	"id":        func(a, b *Task) bool { return a.ID < b.ID },
+	"ctime":     func(a, b *Task) bool { return a.Ctime < b.Ctime },
+	"mtime":     func(a, b *Task) bool { return a.Mtime < b.Mtime },
+	"priority":  func(a, b *Task) bool { return a.Priority < b.Priority },
+	"grade":     func(a, b *Task) bool { return a.Grade < b.Grade },
+	"file_name": func(a, b *Task) bool { return a.FileName < b.FileName },
+}
+
+// Validate : 조회 조건 검사
+func (f TaskFilter) Validate() error {
+	if _, ok := taskSortKeys[strings.TrimPrefix(f.Sort, "-")]; f.Sort != "" && !ok {
+		return errors.New(fmt.Sprintf("invalid sort(%s)", f.Sort))
+	}
+	if f.Offset < 0 {
+		return errors.New(fmt.Sprintf("invalid offset(%d), must not be negative", f.Offset))
+	}
+	if f.Limit < 0 {
+		return errors.New(fmt.Sprintf("invalid limit(%d), must not be negative", f.Limit))
+	}
+	return nil
+}
+
+func (f TaskFilter) match(t *Task) bool {
+	if f.FileName != "" && f.FileName != t.FileName {
+		return false
+	}
+	if f.SrcAddr != "" && f.SrcAddr != t.SrcAddr {
+		return false
+	}
+	if f.DstAddr != "" && f.DstAddr != t.DstAddr {
+		return false
+	}
+	if f.Since != 0 && t.Mtime < f.Since {
+		return false
+	}
+	if len(f.Statuses) == 0 {
+		return true
+	}
+	for _, s := range f.Statuses {
+		if s == t.Status {
+			return true
+		}
+	}
+	return false
+}
+
+// less : Sort 기준으로 a 가 b 보다 앞인 지 여부
+func (f TaskFilter) less(a, b *Task) bool {
+	key, desc := f.Sort, false
+	if strings.HasPrefix(key, "-") {
+		key, desc = key[1:], true
+	}
+	lessFn, ok := taskSortKeys[key]
+	if !ok {
+		lessFn = taskSortKeys["id"]
+	}
+	if lessFn(a, b) {
+		return !desc
+	}
+	if lessFn(b, a) {
+		return desc
+	}
+	return a.ID < b.ID
+}
+
+// Find :
+//
+// 조건에 맞는 task 를 정렬해서 Offset, Limit 만큼 반환
+//
+// 조건에 맞는 전체 task 개수와 조회한 시점의 ETag 도 함께 반환
+func (tasks *Tasks) Find(f TaskFilter) (tl []Task, total int, etag string) {
+	tasks.mutex.RLock()
+	defer tasks.mutex.RUnlock()
+
+	ml := make([]*Task, 0)
+	for _, t := range tasks.TaskMap {
+		if f.match(t) {
+			ml = append(ml, t)
+		}
+	}
+	sort.Slice(ml, func(i, j int) bool {
+		return f.less(ml[i], ml[j])
+	})
+
+	total = len(ml)
+	tl = make([]Task, 0)
+	if f.Offset >= total {
+		return tl, total, tasks.etag()
+	}
+	ml = ml[f.Offset:]
+	if f.Limit > 0 && f.Limit < len(ml) {
+		ml = ml[:f.Limit]
+	}
+	for _, t := range ml {
+		tl = append(tl, *t)
+	}
+	return tl, total, tasks.etag()
+}
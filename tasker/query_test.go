@@ -0,0 +1,92 @@
+package tasker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTasks_Find(t *testing.T) {
+	tasks := NewTasksWithStore(NewMemoryStore())
+
+	d1, d2 := "127.0.0.1:8081", "127.0.0.2:8082"
+	t1 := tasks.CreateTask(&Task{FileName: "C.mpg", DstAddr: d1, Priority: 1})
+	t2 := tasks.CreateTask(&Task{FileName: "A.mpg", DstAddr: d2, Priority: 3})
+	t3 := tasks.CreateTask(&Task{FileName: "B.mpg", DstAddr: d1, Priority: 3})
+	t4 := tasks.CreateTask(&Task{FileName: "D.mpg", DstAddr: d1})
+	assert.Nil(t, tasks.UpdateStatus(t4.ID, WORKING))
+	t4, _ = tasks.FindTaskByID(t4.ID)
+	for _, id := range []int64{t1.ID, t2.ID, t3.ID} {
+		tasks.TaskMap[id].Mtime = 100
+	}
+
+	tl, total, _ := tasks.Find(TaskFilter{})
+	assert.Equal(t, 4, total)
+	assert.Equal(t, tasks.GetTaskList(), tl)
+
+	// destination 서버의 ready task
+	tl, total, _ = tasks.Find(TaskFilter{DstAddr: d1, Statuses: []Status{READY}})
+	assert.Equal(t, 2, total)
+	assert.Equal(t, []int64{t1.ID, t3.ID}, ids(tl))
+
+	tl, _, _ = tasks.Find(TaskFilter{Statuses: []Status{READY, WORKING}, FileName: "D.mpg"})
+	assert.Equal(t, []int64{t4.ID}, ids(tl))
+	tl, _, _ = tasks.Find(TaskFilter{Since: 101})
+	assert.Equal(t, []int64{t4.ID}, ids(tl))
+
+	// 정렬, 기준 값이 같으면 id 순서
+	tl, _, _ = tasks.Find(TaskFilter{Sort: "-priority"})
+	assert.Equal(t, []int64{t2.ID, t3.ID, t1.ID, t4.ID}, ids(tl))
+	tl, _, _ = tasks.Find(TaskFilter{Sort: "file_name"})
+	assert.Equal(t, []int64{t2.ID, t3.ID, t1.ID, t4.ID}, ids(tl))
+	tl, _, _ = tasks.Find(TaskFilter{Sort: "-id"})
+	assert.Equal(t, []int64{t4.ID, t3.ID, t2.ID, t1.ID}, ids(tl))
+
+	// pagination
+	tl, total, _ = tasks.Find(TaskFilter{Sort: "file_name", Offset: 1, Limit: 2})
+	assert.Equal(t, 4, total)
+	assert.Equal(t, []int64{t3.ID, t1.ID}, ids(tl))
+	tl, total, _ = tasks.Find(TaskFilter{Offset: 4})
+	assert.Equal(t, 4, total)
+	assert.Equal(t, []Task{}, tl)
+
+	assert.NotNil(t, TaskFilter{Sort: "size"}.Validate())
+	assert.NotNil(t, TaskFilter{Offset: -1}.Validate())
+	assert.NotNil(t, TaskFilter{Limit: -1}.Validate())
+	assert.Nil(t, TaskFilter{Sort: "-mtime", Limit: 10}.Validate())
+}
+
+func TestTasks_ETag(t *testing.T) {
+	tasks := NewTasksWithStore(NewMemoryStore())
+
+	e0 := tasks.ETag()
+	assert.Equal(t, e0, tasks.ETag())
+
+	t1 := tasks.CreateTask(&Task{FileName: "A.mpg", DstAddr: "127.0.0.1:8081"})
+	e1 := tasks.ETag()
+	assert.NotEqual(t, e0, e1)
+
+	// 이미 있는 task 를 반환하면 바뀌지 않음
+	tasks.CreateTask(&Task{FileName: "A.mpg", DstAddr: "127.0.0.1:8081"})
+	assert.Equal(t, e1, tasks.ETag())
+
+	assert.Nil(t, tasks.UpdateStatus(t1.ID, WORKING))
+	e2 := tasks.ETag()
+	assert.NotEqual(t, e1, e2)
+	_, _, e := tasks.Find(TaskFilter{})
+	assert.Equal(t, e2, e)
+
+	assert.Nil(t, tasks.DeleteTask(t1.ID))
+	assert.NotEqual(t, e2, tasks.ETag())
+
+	// 재시작하면 version 이 같아도 ETag 가 다름
+	assert.NotEqual(t, e0, NewTasksWithStore(NewMemoryStore()).ETag())
+}
+
+func ids(tl []Task) []int64 {
+	il := make([]int64, 0, len(tl))
+	for _, t := range tl {
+		il = append(il, t.ID)
+	}
+	return il
+}
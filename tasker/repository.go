@@ -2,17 +2,27 @@ package tasker
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/castisdev/cfm/common"
 	"github.com/castisdev/cilog"
 	"github.com/syndtr/goleveldb/leveldb"
+	lverrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
+// lastIDKey : 마지막으로 만든 task 의 ID 를 저장하는 key, task 의 key 는 ID
+const lastIDKey = "last_id"
+
 // Repository
 // leveldb의 단순 wrapper이다.
+//
+// TaskStore, HistoryStore 의 leveldb 구현
 type Repository struct {
 	where  string
 	logger common.MLogger
@@ -21,8 +31,12 @@ type Repository struct {
 }
 
 func newRepository() *Repository {
+	return newRepositoryAt(".repository/tasks.db")
+}
+
+func newRepositoryAt(where string) *Repository {
 	r := &Repository{
-		where: ".repository/tasks.db",
+		where: where,
 		logger: common.MLogger{
 			Logger: cilog.StdLogger(),
 			Mod:    "repository"},
@@ -31,15 +45,36 @@ func newRepository() *Repository {
 	return r
 }
 
-func (r *Repository) remove() error {
+// Remove :
+//
+// repository 를 닫고 leveldb directory 와 읽지 못한 record 파일을 지움,
+// 상위 directory 가 비었으면 상위 directory 도 지움
+//
+// 지운 후에 사용하면 새로 만듬
+func (r *Repository) Remove() error {
 	if r.isOpen {
-		r.close()
+		r.Close()
+	}
+	if err := os.RemoveAll(r.where); err != nil {
+		return err
+	}
+	if err := os.Remove(r.corruptedFile()); err != nil && !os.IsNotExist(err) {
+		return err
 	}
-	return os.RemoveAll(filepath.Dir(r.where))
+	os.Remove(filepath.Dir(r.where))
+	return nil
 }
 
+// open :
+//
+// leveldb 파일이 깨졌으면 복구해서 열고, 복구하지 못한 record 는 버려짐
 func (r *Repository) open() error {
 	db, err := leveldb.OpenFile(r.where, nil)
+	if lverrors.IsCorrupted(err) {
+		r.logger.Errorf("tasks repository(%s) is corrupted, recover, error(%s)",
+			r.where, err.Error())
+		db, err = leveldb.RecoverFile(r.where, nil)
+	}
 	if err != nil {
 		r.logger.Errorf("failed to open tasks repository")
 		return err
@@ -49,7 +84,8 @@ func (r *Repository) open() error {
 	return nil
 }
 
-func (r *Repository) close() error {
+// Close : repository 닫기, 닫은 후에 사용하면 다시 열림
+func (r *Repository) Close() error {
 	if !r.isOpen {
 		return nil
 	}
@@ -62,13 +98,18 @@ func (r *Repository) close() error {
 	return nil
 }
 
-func (r *Repository) saveTask(t *Task) error {
+// SaveTask : task 저장
+func (r *Repository) SaveTask(t *Task) error {
 	if !r.isOpen {
 		if err := r.open(); err != nil {
 			return err
 		}
 	}
 	tv, err := json.Marshal(t)
+	if err != nil {
+		r.logger.Errorf("[%d] failed to marshal task, error(%s)", t.ID, err.Error())
+		return err
+	}
 	err = r.db.Put([]byte(strconv.FormatInt(t.ID, 10)), []byte(tv), nil)
 	if err != nil {
 		r.logger.Errorf("[%d] failed to save task", t.ID)
@@ -77,7 +118,8 @@ func (r *Repository) saveTask(t *Task) error {
 	return nil
 }
 
-func (r *Repository) deleteTask(id int64) error {
+// DeleteTask : task 삭제
+func (r *Repository) DeleteTask(id int64) error {
 	if !r.isOpen {
 		if err := r.open(); err != nil {
 			return err
@@ -91,25 +133,219 @@ func (r *Repository) deleteTask(id int64) error {
 	return nil
 }
 
-func (r *Repository) loadTasks() ([]Task, error) {
+// DeleteTasks : task 여러 개를 batch 로 한 번에 삭제
+func (r *Repository) DeleteTasks(ids []int64) error {
+	if !r.isOpen {
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+	batch := new(leveldb.Batch)
+	for _, id := range ids {
+		batch.Delete([]byte(strconv.FormatInt(id, 10)))
+	}
+	if err := r.db.Write(batch, nil); err != nil {
+		r.logger.Errorf("failed to delete tasks(%d)", len(ids))
+		return err
+	}
+	return nil
+}
+
+// SaveLastID : 마지막 ID 저장
+func (r *Repository) SaveLastID(id int64) error {
+	if !r.isOpen {
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+	return r.db.Put([]byte(lastIDKey), []byte(strconv.FormatInt(id, 10)), nil)
+}
+
+// LoadLastID : 저장된 마지막 ID 반환, 저장된 적이 없으면 0
+func (r *Repository) LoadLastID() (int64, error) {
+	if !r.isOpen {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	v, err := r.db.Get([]byte(lastIDKey), nil)
+	if err == leveldb.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(v), 10, 64)
+}
+
+// corruptedRecord : 읽지 못해서 repository 에서 뺀 record
+type corruptedRecord struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Error string `json:"error"`
+	Mtime int64  `json:"mtime"`
+}
+
+// corruptedFile : 읽지 못한 record 를 옮겨두는 파일, repository 와 같은 directory
+func (r *Repository) corruptedFile() string {
+	return r.where + ".corrupted"
+}
+
+// LoadTasks :
+//
+// 읽지 못한 record 는 건너뛰고, corruptedFile 에 옮긴 후 repository 에서 지움
+//
+// load 한 task 목록과 건너뛴 record 개수 반환
+func (r *Repository) LoadTasks() ([]Task, int, error) {
 	tasks := make([]Task, 0)
 	if !r.isOpen {
 		if err := r.open(); err != nil {
-			return tasks, err
+			return tasks, 0, err
 		}
 	}
 
 	iter := r.db.NewIterator(nil, nil)
 	defer iter.Release()
 
+	corrupted := make([]corruptedRecord, 0)
 	for iter.Next() {
-		value := iter.Value()
+		key, value := string(iter.Key()), iter.Value()
+		if key == lastIDKey {
+			continue
+		}
 		t := Task{}
-		if err := json.Unmarshal(value, &t); err != nil {
-			r.logger.Errorf("failed to load task, %s", value)
-			return tasks, err
+		err := json.Unmarshal(value, &t)
+		if err == nil && strconv.FormatInt(t.ID, 10) != key {
+			err = errors.New(fmt.Sprintf("key does not match id(%d)", t.ID))
+		}
+		if err != nil {
+			r.logger.Errorf("failed to load task, key(%s), value(%s), error(%s)",
+				key, value, err.Error())
+			corrupted = append(corrupted, corruptedRecord{
+				Key: key, Value: string(value), Error: err.Error(),
+				Mtime: time.Now().Unix()})
+			continue
 		}
 		tasks = append(tasks, t)
 	}
-	return tasks, nil
+	if err := iter.Error(); err != nil {
+		return tasks, len(corrupted), err
+	}
+	if len(corrupted) > 0 {
+		if err := r.quarantine(corrupted); err != nil {
+			r.logger.Errorf("failed to quarantine corrupted tasks(%d), error(%s)",
+				len(corrupted), err.Error())
+		}
+	}
+	return tasks, len(corrupted), nil
+}
+
+// quarantine : 읽지 못한 record 를 corruptedFile 에 JSON lines 로 덧붙이고 repository 에서 지움
+func (r *Repository) quarantine(cl []corruptedRecord) error {
+	f, err := os.OpenFile(r.corruptedFile(),
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, os.FileMode(0644))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	batch := new(leveldb.Batch)
+	for _, c := range cl {
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+		batch.Delete([]byte(c.Key))
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := r.db.Write(batch, nil); err != nil {
+		return err
+	}
+	r.logger.Infof("quarantined corrupted tasks(%d) to %s", len(cl), r.corruptedFile())
+	return nil
+}
+
+// recordKey : 끝난 시간, ID 순서로 정렬되는 task 기록 key
+func recordKey(dtime TaskTime, id int64) []byte {
+	return []byte(fmt.Sprintf("%020d.%020d", dtime, id))
+}
+
+// SaveRecord : task 기록 저장
+func (r *Repository) SaveRecord(tr *TaskRecord) error {
+	if !r.isOpen {
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+	tv, err := json.Marshal(tr)
+	if err != nil {
+		r.logger.Errorf("[%d] failed to marshal task record, error(%s)", tr.ID, err.Error())
+		return err
+	}
+	err = r.db.Put(recordKey(tr.Dtime, tr.ID), tv, nil)
+	if err != nil {
+		r.logger.Errorf("[%d] failed to save task record", tr.ID)
+		return err
+	}
+	return nil
+}
+
+// DeleteRecordsBefore : 끝난 시간이 t 보다 이전인 task 기록 삭제
+func (r *Repository) DeleteRecordsBefore(t TaskTime) (int, error) {
+	if !r.isOpen {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	iter := r.db.NewIterator(&util.Range{Limit: recordKey(t, 0)}, nil)
+	defer iter.Release()
+
+	batch := new(leveldb.Batch)
+	cnt := 0
+	for iter.Next() {
+		batch.Delete(append([]byte{}, iter.Key()...))
+		cnt++
+	}
+	if err := iter.Error(); err != nil {
+		return 0, err
+	}
+	if cnt == 0 {
+		return 0, nil
+	}
+	if err := r.db.Write(batch, nil); err != nil {
+		r.logger.Errorf("failed to delete task records before(%s)", t)
+		return 0, err
+	}
+	return cnt, nil
+}
+
+// LoadRecords : 끝난 시간이 from ~ to 인 task 기록을 끝난 시간 순으로 load
+//
+// to 가 0 이면 끝난 시간의 상한 없음
+func (r *Repository) LoadRecords(from, to TaskTime) ([]TaskRecord, error) {
+	rl := make([]TaskRecord, 0)
+	if !r.isOpen {
+		if err := r.open(); err != nil {
+			return rl, err
+		}
+	}
+	rg := &util.Range{Start: recordKey(from, 0)}
+	if to != 0 {
+		rg.Limit = recordKey(to+1, 0)
+	}
+	iter := r.db.NewIterator(rg, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		value := iter.Value()
+		tr := TaskRecord{}
+		if err := json.Unmarshal(value, &tr); err != nil {
+			r.logger.Errorf("failed to load task record, %s", value)
+			return rl, err
+		}
+		rl = append(rl, tr)
+	}
+	return rl, iter.Error()
 }
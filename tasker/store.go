@@ -0,0 +1,500 @@
+package tasker
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TaskStore : task 목록을 저장하는 곳
+//
+// SaveTask : task 저장, ID 가 같은 task 가 있으면 바꿈
+//
+// DeleteTask : task 삭제
+//
+// DeleteTasks : task 여러 개를 한 번에 삭제
+//
+// LoadTasks : 저장된 task 목록과 읽지 못해서 건너뛴 task 개수 반환
+//
+// SaveLastID : 마지막으로 만든 task 의 ID 저장
+//
+// LoadLastID : 저장된 마지막 ID 반환, 저장된 적이 없으면 0
+//
+// Close : 닫기, 닫은 후에 사용하면 다시 열림
+//
+// Remove : 저장된 task 를 모두 지움, 지운 후에도 사용할 수 있음
+type TaskStore interface {
+	SaveTask(t *Task) error
+	DeleteTask(id int64) error
+	DeleteTasks(ids []int64) error
+	LoadTasks() ([]Task, int, error)
+	SaveLastID(id int64) error
+	LoadLastID() (int64, error)
+	Close() error
+	Remove() error
+}
+
+// StoreBackend : TaskStore 구현 종류
+type StoreBackend int
+
+// StoreBackend const
+//
+// LevelDB : leveldb directory 에 저장 (기본값)
+//
+// File : 파일 하나에 한 줄에 task 하나씩 JSON lines 로 저장
+//
+// Memory : 저장하지 않음, 재시작하면 task 목록이 없어짐, test 에서 사용
+const (
+	_ StoreBackend = iota
+	LevelDB
+	File
+	Memory
+)
+
+func (b StoreBackend) String() string {
+	m := map[StoreBackend]string{
+		LevelDB: "leveldb",
+		File:    "file",
+		Memory:  "memory",
+	}
+	return m[b]
+}
+
+// ToStoreBackend : 설정 문자열을 StoreBackend 로 변환, 잘못된 이름이면 0 반환
+func ToStoreBackend(b string) StoreBackend {
+	m := map[string]StoreBackend{
+		"leveldb": LevelDB,
+		"file":    File,
+		"memory":  Memory,
+	}
+	return m[strings.ToLower(b)]
+}
+
+// DefaultStorePath : backend 별 기본 저장 경로, Memory 는 빈 문자열
+func DefaultStorePath(b StoreBackend) string {
+	switch b {
+	case LevelDB:
+		return ".repository/tasks.db"
+	case File:
+		return ".repository/tasks.jsonl"
+	default:
+		return ""
+	}
+}
+
+// NewTaskStore :
+//
+// path : 저장 경로, 비어있으면 DefaultStorePath, Memory 는 사용하지 않음
+func NewTaskStore(b StoreBackend, path string) (TaskStore, error) {
+	if path == "" {
+		path = DefaultStorePath(b)
+	}
+	switch b {
+	case LevelDB:
+		return newRepositoryAt(path), nil
+	case File:
+		return NewFileStore(path), nil
+	case Memory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("invalid store backend(%d)", b))
+	}
+}
+
+// MemoryStore : 저장하지 않는 TaskStore
+type MemoryStore struct {
+	mutex  *sync.Mutex
+	tasks  map[int64]Task
+	lastID int64
+}
+
+// NewMemoryStore is constructor of MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mutex: &sync.Mutex{},
+		tasks: make(map[int64]Task),
+	}
+}
+
+// SaveTask : task 저장
+func (s *MemoryStore) SaveTask(t *Task) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.tasks[t.ID] = *t
+	return nil
+}
+
+// DeleteTask : task 삭제
+func (s *MemoryStore) DeleteTask(id int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.tasks, id)
+	return nil
+}
+
+// DeleteTasks : task 여러 개 삭제
+func (s *MemoryStore) DeleteTasks(ids []int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, id := range ids {
+		delete(s.tasks, id)
+	}
+	return nil
+}
+
+// LoadTasks : 저장된 task 목록을 ID 순서로 반환
+func (s *MemoryStore) LoadTasks() ([]Task, int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return sortedTasks(s.tasks), 0, nil
+}
+
+// SaveLastID : 마지막 ID 저장
+func (s *MemoryStore) SaveLastID(id int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastID = id
+	return nil
+}
+
+// LoadLastID : 저장된 마지막 ID 반환
+func (s *MemoryStore) LoadLastID() (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.lastID, nil
+}
+
+// Close : 아무것도 하지 않음, 저장된 task 는 남아있음
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// Remove : 저장된 task 와 마지막 ID 를 모두 지움
+func (s *MemoryStore) Remove() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.tasks = make(map[int64]Task)
+	s.lastID = 0
+	return nil
+}
+
+// FileStore :
+//
+// 파일 하나에 한 줄에 task 하나씩 JSON lines 로 저장하는 TaskStore
+//
+// 바뀔 때마다 임시 파일에 전체 task 목록을 쓰고 rename 하므로,
+// 쓰는 중에 죽어도 파일이 깨지지 않음
+//
+// 마지막 ID 는 저장 파일 뒤에 .seq 를 붙인 파일에 저장
+//
+// 여러 process 가 같은 파일을 함께 사용할 수 없음
+type FileStore struct {
+	mutex  *sync.Mutex
+	path   string
+	tasks  map[int64]Task
+	loaded bool
+}
+
+// NewFileStore is constructor of FileStore
+func NewFileStore(path string) *FileStore {
+	return &FileStore{
+		mutex: &sync.Mutex{},
+		path:  path,
+	}
+}
+
+// corruptedFile : 읽지 못한 줄을 옮겨두는 파일, 저장 파일과 같은 directory
+func (s *FileStore) corruptedFile() string {
+	return s.path + ".corrupted"
+}
+
+// seqFile : 마지막 ID 를 저장하는 파일, 저장 파일과 같은 directory
+func (s *FileStore) seqFile() string {
+	return s.path + ".seq"
+}
+
+// load :
+//
+// 저장 파일을 읽어서 task 목록을 만듬, 이미 읽었으면 아무것도 하지 않음
+//
+// 읽지 못한 줄은 건너뛰고, corruptedFile 에 옮긴 후 저장 파일에서 지움,
+// 건너뛴 줄 개수 반환
+func (s *FileStore) load() (int, error) {
+	if s.loaded {
+		return 0, nil
+	}
+	tasks := make(map[int64]Task)
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		s.tasks, s.loaded = tasks, true
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	corrupted := make([]corruptedRecord, 0)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for ln := 1; sc.Scan(); ln++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		t := Task{}
+		err := json.Unmarshal(sc.Bytes(), &t)
+		if err == nil && t.ID == 0 {
+			err = errors.New("empty id")
+		}
+		if err != nil {
+			tskrlogger.Errorf("failed to load task, file(%s), line(%d), value(%s), error(%s)",
+				s.path, ln, sc.Bytes(), err.Error())
+			corrupted = append(corrupted, corruptedRecord{
+				Key: fmt.Sprintf("line %d", ln), Value: sc.Text(), Error: err.Error(),
+				Mtime: time.Now().Unix()})
+			continue
+		}
+		tasks[t.ID] = t
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	s.tasks, s.loaded = tasks, true
+
+	if len(corrupted) > 0 {
+		if err := s.quarantine(corrupted); err != nil {
+			tskrlogger.Errorf("failed to quarantine corrupted tasks(%d), error(%s)",
+				len(corrupted), err.Error())
+		}
+	}
+	return len(corrupted), nil
+}
+
+// quarantine : 읽지 못한 줄을 corruptedFile 에 JSON lines 로 덧붙이고, 저장 파일을 다시 씀
+func (s *FileStore) quarantine(cl []corruptedRecord) error {
+	f, err := os.OpenFile(s.corruptedFile(),
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, os.FileMode(0644))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, c := range cl {
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := s.write(); err != nil {
+		return err
+	}
+	tskrlogger.Infof("quarantined corrupted tasks(%d) to %s", len(cl), s.corruptedFile())
+	return nil
+}
+
+// write : task 목록을 ID 순서로 저장 파일에 씀
+func (s *FileStore) write() error {
+	return writeFileAtomic(s.path, func(enc *json.Encoder) error {
+		for _, t := range sortedTasks(s.tasks) {
+			if err := enc.Encode(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeFileAtomic : 같은 directory 의 임시 파일에 쓰고, path 로 rename
+func writeFileAtomic(path string, write func(enc *json.Encoder) error) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := write(json.NewEncoder(f)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// SaveTask : task 저장
+func (s *FileStore) SaveTask(t *Task) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := s.load(); err != nil {
+		return err
+	}
+	o, exists := s.tasks[t.ID]
+	s.tasks[t.ID] = *t
+	if err := s.write(); err != nil {
+		if exists {
+			s.tasks[t.ID] = o
+		} else {
+			delete(s.tasks, t.ID)
+		}
+		tskrlogger.Errorf("[%d] failed to save task, error(%s)", t.ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// DeleteTask : task 삭제
+func (s *FileStore) DeleteTask(id int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := s.load(); err != nil {
+		return err
+	}
+	o, exists := s.tasks[id]
+	if !exists {
+		return nil
+	}
+	delete(s.tasks, id)
+	if err := s.write(); err != nil {
+		s.tasks[id] = o
+		tskrlogger.Errorf("[%d] failed to delete task, error(%s)", id, err.Error())
+		return err
+	}
+	return nil
+}
+
+// DeleteTasks : task 여러 개를 지우고 저장 파일을 한 번만 다시 씀
+func (s *FileStore) DeleteTasks(ids []int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := s.load(); err != nil {
+		return err
+	}
+	deleted := make(map[int64]Task, len(ids))
+	for _, id := range ids {
+		if o, exists := s.tasks[id]; exists {
+			deleted[id] = o
+			delete(s.tasks, id)
+		}
+	}
+	if len(deleted) == 0 {
+		return nil
+	}
+	if err := s.write(); err != nil {
+		for id, o := range deleted {
+			s.tasks[id] = o
+		}
+		tskrlogger.Errorf("failed to delete tasks(%d), error(%s)", len(deleted), err.Error())
+		return err
+	}
+	return nil
+}
+
+// LoadTasks : 저장 파일을 다시 읽어서 task 목록을 ID 순서로 반환
+func (s *FileStore) LoadTasks() ([]Task, int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.loaded = false
+	skipped, err := s.load()
+	if err != nil {
+		return make([]Task, 0), 0, err
+	}
+	return sortedTasks(s.tasks), skipped, nil
+}
+
+// SaveLastID : 마지막 ID 를 seqFile 에 저장
+func (s *FileStore) SaveLastID(id int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return writeFileAtomic(s.seqFile(), func(enc *json.Encoder) error {
+		return enc.Encode(id)
+	})
+}
+
+// LoadLastID : seqFile 에 저장된 마지막 ID 반환, 파일이 없으면 0
+func (s *FileStore) LoadLastID() (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b, err := ioutil.ReadFile(s.seqFile())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	if err := json.Unmarshal(b, &id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Close : 읽은 task 목록을 버림, 다시 사용하면 저장 파일을 다시 읽음
+func (s *FileStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.tasks, s.loaded = nil, false
+	return nil
+}
+
+// Remove :
+//
+// 저장 파일, 마지막 ID 파일, 읽지 못한 줄을 옮겨둔 파일을 지움,
+// 상위 directory 가 비었으면 상위 directory 도 지움
+func (s *FileStore) Remove() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.tasks, s.loaded = nil, false
+	for _, p := range []string{s.path, s.seqFile(), s.corruptedFile()} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	os.Remove(filepath.Dir(s.path))
+	return nil
+}
+
+func sortedTasks(m map[int64]Task) []Task {
+	tl := make([]Task, 0, len(m))
+	for _, t := range m {
+		tl = append(tl, t)
+	}
+	sort.Slice(tl, func(i, j int) bool {
+		return tl[i].ID < tl[j].ID
+	})
+	return tl
+}
@@ -0,0 +1,98 @@
+package tasker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToStoreBackend(t *testing.T) {
+	assert.Equal(t, LevelDB, ToStoreBackend("leveldb"))
+	assert.Equal(t, File, ToStoreBackend("File"))
+	assert.Equal(t, Memory, ToStoreBackend("MEMORY"))
+	assert.Equal(t, StoreBackend(0), ToStoreBackend("bolt"))
+
+	_, err := NewTaskStore(StoreBackend(0), "")
+	assert.NotNil(t, err)
+	s, err := NewTaskStore(LevelDB, "")
+	assert.Nil(t, err)
+	assert.Equal(t, ".repository/tasks.db", s.(*Repository).where)
+	s, err = NewTaskStore(File, "store/tasks.jsonl")
+	assert.Nil(t, err)
+	assert.Equal(t, "store/tasks.jsonl", s.(*FileStore).path)
+}
+
+func TestTaskStore(t *testing.T) {
+	for _, b := range []StoreBackend{LevelDB, File, Memory} {
+		path := filepath.Join("teststore", b.String(), "tasks")
+		store, err := NewTaskStore(b, path)
+		assert.Nil(t, err)
+
+		tasks := NewTasksWithStore(store)
+		t1 := tasks.CreateTask(&Task{FilePath: "/data2/A.mpg", FileName: "A.mpg"})
+		t2 := tasks.CreateTask(&Task{FilePath: "/data2/B.mpg", FileName: "B.mpg"})
+		t3 := tasks.CreateTask(&Task{FilePath: "/data2/C.mpg", FileName: "C.mpg"})
+		assert.Nil(t, tasks.UpdateStatus(t2.ID, DONE), b)
+		t2, _ = tasks.FindTaskByID(t2.ID)
+		assert.Nil(t, tasks.DeleteTask(t3.ID), b)
+		// 여러 개를 한 번에 지움, 없는 id 는 건너뜀
+		t4 := tasks.CreateTask(&Task{FilePath: "/data2/E.mpg", FileName: "E.mpg"})
+		t5 := tasks.CreateTask(&Task{FilePath: "/data2/F.mpg", FileName: "F.mpg"})
+		assert.Equal(t, 2, tasks.DeleteTasks([]int64{t4.ID, t5.ID, t3.ID}), b)
+		assert.Nil(t, tasks.Close(), b)
+
+		// 닫은 후 다시 load 해도 task 목록이 남아있음
+		loaded := NewTasksWithStore(store)
+		loaded.LoadTasks()
+		assert.Equal(t, []Task{t1, t2}, loaded.GetTaskList(), b)
+
+		// 지운 후에도 사용할 수 있고, directory 가 남지 않음
+		loaded.Release()
+		_, err = os.Stat("teststore/" + b.String())
+		assert.True(t, os.IsNotExist(err), b)
+		loaded.CreateTask(&Task{FilePath: "/data2/D.mpg", FileName: "D.mpg"})
+		loaded.LoadTasks()
+		assert.Equal(t, 1, len(loaded.TaskMap), b)
+		loaded.Release()
+	}
+	os.Remove("teststore")
+}
+
+func TestFileStore_Corrupted(t *testing.T) {
+	path := filepath.Join("teststore", "tasks.jsonl")
+	assert.Nil(t, os.MkdirAll("teststore", os.FileMode(0755)))
+	defer os.RemoveAll("teststore")
+
+	lines := []string{
+		`{"id":"2","status":"ready","file_name":"B.mpg"}`,
+		`{invalid`,
+		`{"id":"1","status":"working","file_name":"A.mpg"}`,
+		`{"id":"3","status":"unknown","file_name":"C.mpg"}`,
+	}
+	assert.Nil(t, ioutil.WriteFile(path,
+		[]byte(strings.Join(lines, "\n")+"\n"), os.FileMode(0644)))
+
+	s := NewFileStore(path)
+	tl, skipped, err := s.LoadTasks()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, skipped)
+	assert.Equal(t, []Task{
+		{ID: 1, Status: WORKING, FileName: "A.mpg"},
+		{ID: 2, Status: READY, FileName: "B.mpg"},
+	}, tl)
+
+	b, err := ioutil.ReadFile(s.corruptedFile())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, strings.Count(string(b), "\n"))
+	assert.Contains(t, string(b), `"key":"line 2"`)
+	assert.Contains(t, string(b), `"key":"line 4"`)
+
+	// 읽지 못한 줄은 저장 파일에서 지워짐
+	_, skipped, err = NewFileStore(path).LoadTasks()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, skipped)
+}
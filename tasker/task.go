@@ -1,8 +1,11 @@
 package tasker
 
 import (
+	"bufio"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"sort"
 	"sync"
 	"time"
@@ -91,6 +94,12 @@ type TaskTime int64
 // SrcAddr : Source 서버 IP, Port
 //
 // DstAddr : Destination 서버 IP, Port
+//
+// Wtime : working 상태가 된 시간, task 기록의 작업 시간 계산에 사용
+//
+// Size : 배포 파일 크기(byte), task 기록의 배포 속도 계산에 사용
+//
+// Priority : API 로 요청받은 manual task 의 priority, 값이 클수록 급한 task
 type Task struct {
 	ID        int64    `json:"id,string"`
 	Ctime     TaskTime `json:"ctime"`
@@ -104,6 +113,9 @@ type Task struct {
 	CopySpeed string   `json:"copy_speed"`
 	SrcAddr   string   `json:"src_addr"`
 	DstAddr   string   `json:"dst_addr"`
+	Wtime     TaskTime `json:"wtime"`
+	Size      int64    `json:"size"`
+	Priority  int      `json:"priority"`
 }
 
 // NewTaskFrom : param으로 받은 task로부터 cloning한 새로운 task반환
@@ -121,6 +133,9 @@ func NewTaskFrom(t Task) *Task {
 		CopySpeed: t.CopySpeed,
 		SrcAddr:   t.SrcAddr,
 		DstAddr:   t.DstAddr,
+		Wtime:     t.Wtime,
+		Size:      t.Size,
+		Priority:  t.Priority,
 	}
 }
 
@@ -149,18 +164,148 @@ func (task Task) String() string {
 // https://golang.org/doc/faq#atomic_maps
 //
 // https://blog.golang.org/go-maps-in-action
+//
+// lastID : 마지막으로 만든 task 의 ID, store 에 저장해서 재시작해도 작아지지 않음
+//
+// index : (file, dst) 별 task ID, 같은 (file, dst) 의 진행 중인 task 가 둘 이상 생기지 않게 함
+//
+// epoch, version : task 목록이 바뀔 때마다 version 이 커짐, ETag 로 사용
+//
+// events : 최근 task 변경 event 목록, Events 로 조회
 type Tasks struct {
-	mutex      *sync.RWMutex
-	TaskMap    map[int64]*Task
-	repository *Repository
+	mutex   *sync.RWMutex
+	TaskMap map[int64]*Task
+	store   TaskStore
+	lastID  int64
+	index   map[string]int64
+	epoch   int64
+	version uint64
+	events  *taskEvents
 }
 
 // NewTasks is constructor of Tasks
+//
+// .repository/tasks.db 의 leveldb 에 저장함
 func NewTasks() *Tasks {
+	return NewTasksWithStore(newRepository())
+}
+
+// NewTasksWithStore : store 에 저장하는 Tasks 생성
+func NewTasksWithStore(store TaskStore) *Tasks {
 	return &Tasks{
-		&sync.RWMutex{},
-		make(map[int64]*Task),
-		newRepository()}
+		mutex:   &sync.RWMutex{},
+		TaskMap: make(map[int64]*Task),
+		store:   store,
+		index:   make(map[string]int64),
+		epoch:   time.Now().UnixNano(),
+		events:  newTaskEvents(),
+	}
+}
+
+// changed : task 목록이 바뀜, lock 을 잡고 호출해야 함
+//
+// version 을 올리고 typ event 를 추가함, t 는 복사해서 event 에 넣음
+func (tasks *Tasks) changed(typ string, t *Task) {
+	tasks.version++
+	ev := TaskEvent{ID: tasks.token(tasks.version), Seq: tasks.version, Type: typ}
+	if t != nil {
+		c := *t
+		ev.Task = &c
+	}
+	tasks.events.publish(ev)
+}
+
+// ETag : task 목록의 version, task 목록이 바뀌면 달라짐, 재시작해도 겹치지 않음
+func (tasks *Tasks) ETag() string {
+	tasks.mutex.RLock()
+	defer tasks.mutex.RUnlock()
+
+	return tasks.etag()
+}
+
+func (tasks *Tasks) etag() string {
+	return "\"" + tasks.token(tasks.version) + "\""
+}
+
+func uniqueKey(fileName, dstAddr string) string {
+	return fileName + "@" + dstAddr
+}
+
+// findActive :
+//
+// (file, dst) 가 같은 진행 중인(ready, working) task 찾기,
+// file, dst 중 하나라도 비어있으면 찾지 않음
+func (tasks *Tasks) findActive(fileName, dstAddr string) (*Task, bool) {
+	if fileName == "" || dstAddr == "" {
+		return nil, false
+	}
+	id, ok := tasks.index[uniqueKey(fileName, dstAddr)]
+	if !ok {
+		return nil, false
+	}
+	t, ok := tasks.TaskMap[id]
+	if !ok || (t.Status != READY && t.Status != WORKING) {
+		return nil, false
+	}
+	return t, true
+}
+
+// indexTask : (file, dst) 가 같은 진행 중인 다른 task 가 없으면 index 에 추가
+func (tasks *Tasks) indexTask(t *Task) {
+	if t.FileName == "" || t.DstAddr == "" {
+		return
+	}
+	if _, ok := tasks.findActive(t.FileName, t.DstAddr); ok {
+		return
+	}
+	tasks.index[uniqueKey(t.FileName, t.DstAddr)] = t.ID
+}
+
+// unindexTask : index 가 지우는 task 를 가리키고 있으면 index 에서 지움
+func (tasks *Tasks) unindexTask(t *Task) {
+	k := uniqueKey(t.FileName, t.DstAddr)
+	if tasks.index[k] == t.ID {
+		delete(tasks.index, k)
+	}
+}
+
+// nextID :
+//
+// now 의 nano time 을 ID 로 사용하고,
+// 마지막 ID 보다 크지 않으면(같은 nano time, 시간이 뒤로 바뀐 경우) 마지막 ID + 1 사용
+//
+// 마지막 ID 를 store 에 저장해서 재시작 후에도 ID 가 작아지지 않음
+func (tasks *Tasks) nextID(now time.Time) int64 {
+	id := now.UnixNano()
+	if id <= tasks.lastID {
+		id = tasks.lastID + 1
+	}
+	tasks.setLastID(id)
+	return id
+}
+
+// setLastID : id 가 마지막 ID 보다 크면 마지막 ID 를 바꾸고 store 에 저장
+func (tasks *Tasks) setLastID(id int64) {
+	if id <= tasks.lastID {
+		return
+	}
+	tasks.lastID = id
+	if err := tasks.store.SaveLastID(id); err != nil {
+		tskrlogger.Errorf("failed to save last task id(%d), error(%s)", id, err.Error())
+	}
+}
+
+// SetStore :
+//
+// task 를 저장하는 곳을 바꿈, 이전 store 는 닫음
+//
+// LoadTasks 전에 호출해야 함, 이미 있는 task 는 새 store 로 옮기지 않음
+func (tasks *Tasks) SetStore(store TaskStore) {
+	tasks.mutex.Lock()
+	defer tasks.mutex.Unlock()
+
+	tasks.store.Close()
+	tasks.store = store
 }
 
 // GetTaskList :
@@ -239,7 +384,11 @@ func (tasks *Tasks) UpdateStatus(id int64, s Status) error {
 		// success : READY, WORKING-> WORKING
 		task.Status = s
 		task.Mtime = TaskTime(time.Now().Unix())
-		tasks.repository.saveTask(task)
+		if task.Wtime == 0 {
+			task.Wtime = task.Mtime
+		}
+		tasks.changed(TaskUpdated, task)
+		tasks.store.SaveTask(task)
 		return nil
 
 	case DONE:
@@ -247,7 +396,8 @@ func (tasks *Tasks) UpdateStatus(id int64, s Status) error {
 		// success : READY, WORKING, DONE, TIMEOUT -> DONE
 		task.Status = s
 		task.Mtime = TaskTime(time.Now().Unix())
-		tasks.repository.saveTask(task)
+		tasks.changed(TaskUpdated, task)
+		tasks.store.SaveTask(task)
 		return nil
 
 	case TIMEOUT:
@@ -255,7 +405,8 @@ func (tasks *Tasks) UpdateStatus(id int64, s Status) error {
 		// success : READY, WORKING, DONE, TIMEOUT -> TIMEOUT
 		task.Status = s
 		task.Mtime = TaskTime(time.Now().Unix())
-		tasks.repository.saveTask(task)
+		tasks.changed(TaskUpdated, task)
+		tasks.store.SaveTask(task)
 		return nil
 
 	default:
@@ -265,37 +416,134 @@ func (tasks *Tasks) UpdateStatus(id int64, s Status) error {
 }
 
 // LoadTasks :
-// repository 에서 task list load
+// store 에서 task list load
+//
+// 읽지 못한 task 는 건너뛰고, store 의 .corrupted 파일로 옮김,
+// 나머지 task 는 그대로 load 함
 //
-// load 중에 repository 에러가 나는 경우,
+// 가지고 있던 task list 는 store 의 task list 로 바뀜,
+// active-standby 모드에서 leader 가 되었을 때 이전 leader 가 저장한 task list 를 다시 load 함
 //
-// 잘못된 repository라고 repostiroy는 초기화함
+// store 를 열지 못하면 빈 task list 로 시작함
 func (tasks *Tasks) LoadTasks() {
 	tasks.mutex.Lock()
 	defer tasks.mutex.Unlock()
-	tl, err := tasks.repository.loadTasks()
+	tl, skipped, err := tasks.store.LoadTasks()
 	if err != nil {
-		tasks.repository.remove()
-		return
+		tskrlogger.Errorf("failed to load tasks, error(%s)", err.Error())
+	}
+	lastID, err := tasks.store.LoadLastID()
+	if err != nil {
+		tskrlogger.Errorf("failed to load last task id, error(%s)", err.Error())
 	}
+	sort.Slice(tl, func(i, j int) bool {
+		return tl[i].ID < tl[j].ID
+	})
+	tasks.TaskMap = make(map[int64]*Task)
+	tasks.index = make(map[string]int64)
 	for _, t := range tl {
-		tasks.TaskMap[t.ID] = NewTaskFrom(t)
+		task := NewTaskFrom(t)
+		tasks.TaskMap[t.ID] = task
+		tasks.indexTask(task)
+		if t.ID > lastID {
+			lastID = t.ID
+		}
+	}
+	tasks.setLastID(lastID)
+	tasks.changed(TaskReset, nil)
+	tskrlogger.Infof("loaded tasks(%d), skipped corrupted tasks(%d), last id(%d)",
+		len(tl), skipped, tasks.lastID)
+}
+
+// Export : task 목록을 ID 순서로 한 줄에 task 하나씩 JSON lines 로 씀
+//
+// 쓴 task 개수 반환
+func (tasks Tasks) Export(w io.Writer) (int, error) {
+	enc := json.NewEncoder(w)
+	tl := tasks.GetTaskList()
+	for i, t := range tl {
+		if err := enc.Encode(t); err != nil {
+			return i, err
+		}
+	}
+	return len(tl), nil
+}
+
+// Import :
+//
+// Export 로 쓴 JSON lines 를 읽어서 task 목록과 store 에 추가,
+// ID 가 같은 task 가 있으면 바꿈
+//
+// 잘못된 줄이 있으면 아무것도 추가하지 않고 줄 번호와 error 반환
+//
+// 추가한 task 개수 반환
+func (tasks *Tasks) Import(r io.Reader) (int, error) {
+	tl := make([]Task, 0)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for ln := 1; sc.Scan(); ln++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		t := Task{}
+		if err := json.Unmarshal(sc.Bytes(), &t); err != nil {
+			return 0, errors.New(fmt.Sprintf("line(%d), error(%s)", ln, err.Error()))
+		}
+		if t.ID == 0 || t.FileName == "" || t.Status == 0 {
+			return 0, errors.New(fmt.Sprintf(
+				"line(%d), error(id, file_name, status must not be empty)", ln))
+		}
+		tl = append(tl, t)
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+
+	tasks.mutex.Lock()
+	defer tasks.mutex.Unlock()
+	if len(tl) > 0 {
+		defer tasks.changed(TaskReset, nil)
+	}
+	for i, t := range tl {
+		task := NewTaskFrom(t)
+		if err := tasks.store.SaveTask(task); err != nil {
+			return i, err
+		}
+		if o, ok := tasks.TaskMap[task.ID]; ok {
+			tasks.unindexTask(o)
+		}
+		tasks.TaskMap[task.ID] = task
+		tasks.indexTask(task)
+		tasks.setLastID(task.ID)
 	}
+	return len(tl), nil
 }
 
 // CreateTask is to create task
+//
+// file, dst 가 같은 진행 중인(ready, working) task 가 있으면
+// 새로 만들지 않고 있는 task 를 반환, 이 때 task 는 바꾸지 않음
 func (tasks *Tasks) CreateTask(task *Task) Task {
+	tasks.mutex.Lock()
+	defer tasks.mutex.Unlock()
 
-	task.ID = time.Now().UnixNano()
-	task.Ctime = TaskTime(time.Now().Unix())
-	task.Mtime = TaskTime(time.Now().Unix())
+	if t, ok := tasks.findActive(task.FileName, task.DstAddr); ok {
+		tskrlogger.Infof("[%d] task already exists, file(%s), dst(%s)",
+			t.ID, t.FileName, t.DstAddr)
+		return *t
+	}
+
+	now := time.Now()
+	task.ID = tasks.nextID(now)
+	task.Ctime = TaskTime(now.Unix())
+	task.Mtime = TaskTime(now.Unix())
 	task.Status = READY
 
-	tasks.mutex.Lock()
-	defer tasks.mutex.Unlock()
 	tasks.TaskMap[task.ID] = task
+	tasks.indexTask(task)
+	tasks.changed(TaskCreated, task)
 
-	tasks.repository.saveTask(task)
+	tasks.store.SaveTask(task)
 	return *task
 }
 
@@ -305,13 +553,15 @@ func (tasks *Tasks) DeleteTask(id int64) error {
 	tasks.mutex.Lock()
 	defer tasks.mutex.Unlock()
 
-	_, exists := tasks.TaskMap[id]
+	task, exists := tasks.TaskMap[id]
 	if !exists {
 		return fmt.Errorf("not found, task(%d) to delete", id)
 	}
 
+	tasks.unindexTask(task)
 	delete(tasks.TaskMap, id)
-	tasks.repository.deleteTask(id)
+	tasks.changed(TaskDeleted, task)
+	tasks.store.DeleteTask(id)
 	return nil
 }
 
@@ -322,35 +572,50 @@ func (tasks *Tasks) DeleteTasks(ids []int64) int {
 	tasks.mutex.Lock()
 	defer tasks.mutex.Unlock()
 
-	var cnt int = 0
+	deleted := make([]int64, 0, len(ids))
 	for _, id := range ids {
-		_, exists := tasks.TaskMap[id]
+		task, exists := tasks.TaskMap[id]
 		if exists {
+			tasks.unindexTask(task)
 			delete(tasks.TaskMap, id)
-			tasks.repository.deleteTask(id)
-			cnt++
+			tasks.changed(TaskDeleted, task)
+			deleted = append(deleted, id)
 		}
 	}
-	return cnt
+	if len(deleted) > 0 {
+		tasks.store.DeleteTasks(deleted)
+	}
+	return len(deleted)
 }
 
 // DeleteAllTask
 //
-// 내부 map, repository를 모두 지우고, 새롭게 할당
+// 내부 map, store 를 모두 지움
 func (tasks *Tasks) DeleteAllTask() {
 	tasks.mutex.Lock()
 	defer tasks.mutex.Unlock()
 
-	tasks.repository.remove()
+	tasks.store.Remove()
 	tasks.TaskMap = make(map[int64]*Task)
-	tasks.repository = newRepository()
+	tasks.index = make(map[string]int64)
+	tasks.changed(TaskReset, nil)
+}
+
+// Close
+// store 닫기, 내부 map 은 그대로 둠
+func (tasks *Tasks) Close() error {
+	tasks.mutex.Lock()
+	defer tasks.mutex.Unlock()
+	return tasks.store.Close()
 }
 
 // Release
-// 내부 map, repository를 모두 지우기
+// 내부 map, store 를 모두 지우기
 func (tasks *Tasks) Release() {
 	tasks.mutex.Lock()
 	defer tasks.mutex.Unlock()
-	tasks.repository.remove()
+	tasks.store.Remove()
 	tasks.TaskMap = make(map[int64]*Task)
+	tasks.index = make(map[string]int64)
+	tasks.changed(TaskReset, nil)
 }
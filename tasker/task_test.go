@@ -1,9 +1,13 @@
 package tasker
 
 import (
+	"bytes"
+	"io/ioutil"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -166,6 +170,17 @@ func TestTasks_UpdateStatus(t *testing.T) {
 		}
 	}
 
+	// 처음 working 상태가 된 시간이 남음
+	wtime := TaskTime(100)
+	tasks.TaskMap[t1.ID].Wtime = wtime
+	assert.Nil(t, tasks.UpdateStatus(t1.ID, WORKING))
+	assert.Nil(t, tasks.UpdateStatus(t1.ID, DONE))
+	assert.Equal(t, wtime, tasks.TaskMap[t1.ID].Wtime)
+
+	t2 := tasks.CreateTask(&Task{SrcIP: "127.0.0.1", FilePath: "/data2/B.mpg", FileName: "B.mpg"})
+	assert.Equal(t, TaskTime(0), t2.Wtime)
+	assert.Nil(t, tasks.UpdateStatus(t2.ID, WORKING))
+	assert.NotEqual(t, TaskTime(0), tasks.TaskMap[t2.ID].Wtime)
 }
 
 func TestTasks_GetTaskList(t *testing.T) {
@@ -183,3 +198,133 @@ func TestTasks_GetTaskList(t *testing.T) {
 	assert.Equal(t, t2.ID, tl[1].ID)
 	assert.Equal(t, t3.ID, tl[2].ID)
 }
+
+func TestTasks_LoadTasks_Corrupted(t *testing.T) {
+	tasks := NewTasks()
+	defer tasks.Release()
+
+	t1 := tasks.CreateTask(&Task{FilePath: "/data2/A.mpg", FileName: "A.mpg"})
+	t2 := tasks.CreateTask(&Task{FilePath: "/data2/B.mpg", FileName: "B.mpg"})
+	assert.Nil(t, tasks.store.(*Repository).db.Put([]byte("1"), []byte("{invalid"), nil))
+	assert.Nil(t, tasks.Close())
+
+	// 읽지 못한 task 만 건너뛰고 나머지는 load 함
+	loaded := NewTasks()
+	loaded.LoadTasks()
+	assert.Equal(t, []Task{t1, t2}, loaded.GetTaskList())
+
+	b, err := ioutil.ReadFile(loaded.store.(*Repository).corruptedFile())
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `"key":"1"`)
+	assert.Contains(t, string(b), `{invalid`)
+
+	// 옮긴 record 는 repository 에서 지워짐
+	assert.Nil(t, loaded.Close())
+	reloaded := NewTasks()
+	reloaded.LoadTasks()
+	assert.Equal(t, 2, len(reloaded.TaskMap))
+	b2, _ := ioutil.ReadFile(reloaded.store.(*Repository).corruptedFile())
+	assert.Equal(t, b, b2)
+	reloaded.Close()
+}
+
+func TestTasks_ExportImport(t *testing.T) {
+	tasks := NewTasks()
+	defer tasks.Release()
+
+	t1 := tasks.CreateTask(&Task{FilePath: "/data2/A.mpg", FileName: "A.mpg",
+		SrcAddr: "127.0.0.1:8081", DstAddr: "127.0.0.2:8082"})
+	t2 := tasks.CreateTask(&Task{FilePath: "/data2/B.mpg", FileName: "B.mpg",
+		SrcAddr: "127.0.0.1:8081", DstAddr: "127.0.0.3:8083"})
+	tasks.UpdateStatus(t2.ID, WORKING)
+	t2, _ = tasks.FindTaskByID(t2.ID)
+
+	var b bytes.Buffer
+	n, err := tasks.Export(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 2, strings.Count(b.String(), "\n"))
+	exported := b.String()
+
+	tasks.DeleteAllTask()
+	n, err = tasks.Import(strings.NewReader(exported))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []Task{t1, t2}, tasks.GetTaskList())
+
+	// import 한 task 는 repository 에도 저장됨
+	assert.Nil(t, tasks.Close())
+	loaded := NewTasks()
+	loaded.LoadTasks()
+	assert.Equal(t, []Task{t1, t2}, loaded.GetTaskList())
+
+	// 잘못된 줄이 있으면 아무것도 추가하지 않음
+	loaded.DeleteAllTask()
+	_, err = loaded.Import(strings.NewReader(exported + "{invalid\n"))
+	assert.EqualError(t, err, "line(3), error(invalid character 'i' looking for beginning of object key string)")
+	_, err = loaded.Import(strings.NewReader(`{"id":"1","file_name":"A.mpg"}`))
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len(loaded.TaskMap))
+	loaded.Release()
+}
+
+func TestTasks_CreateTask_MonotonicID(t *testing.T) {
+	tasks := NewTasksWithStore(NewMemoryStore())
+
+	// 같은 nano time 이나 시간이 뒤로 바뀌어도 ID 가 커짐
+	now := time.Now()
+	id1 := tasks.nextID(now)
+	id2 := tasks.nextID(now)
+	id3 := tasks.nextID(now.Add(-time.Hour))
+	assert.Equal(t, id1+1, id2)
+	assert.Equal(t, id2+1, id3)
+
+	prev := int64(0)
+	for i := 0; i < 1000; i++ {
+		task := tasks.CreateTask(&Task{FilePath: "/data2/A.mpg"})
+		assert.True(t, task.ID > prev)
+		prev = task.ID
+	}
+	assert.Equal(t, 1000, len(tasks.TaskMap))
+
+	// 마지막 ID 는 store 에 저장되어, 다시 load 해도 작아지지 않음
+	tasks.DeleteTasks([]int64{prev})
+	loaded := NewTasksWithStore(tasks.store)
+	loaded.LoadTasks()
+	assert.Equal(t, prev, loaded.lastID)
+	assert.True(t, loaded.nextID(now.Add(-time.Hour)) > prev)
+}
+
+func TestTasks_CreateTask_Unique(t *testing.T) {
+	tasks := NewTasksWithStore(NewMemoryStore())
+
+	t1 := tasks.CreateTask(&Task{FileName: "A.mpg", DstAddr: "127.0.0.1:8081"})
+	t2 := tasks.CreateTask(&Task{FileName: "A.mpg", DstAddr: "127.0.0.2:8082"})
+	assert.NotEqual(t, t1.ID, t2.ID)
+
+	// 같은 (file, dst) 의 진행 중인 task 가 있으면 있는 task 반환
+	dup := &Task{FileName: "A.mpg", DstAddr: "127.0.0.1:8081", SrcAddr: "127.0.0.3:8083"}
+	assert.Equal(t, t1, tasks.CreateTask(dup))
+	assert.Equal(t, int64(0), dup.ID)
+	assert.Nil(t, tasks.UpdateStatus(t1.ID, WORKING))
+	assert.Equal(t, t1.ID, tasks.CreateTask(dup).ID)
+	assert.Equal(t, 2, len(tasks.TaskMap))
+
+	// 끝난 task 가 있으면 새로 만듬
+	assert.Nil(t, tasks.UpdateStatus(t1.ID, TIMEOUT))
+	t3 := tasks.CreateTask(&Task{FileName: "A.mpg", DstAddr: "127.0.0.1:8081"})
+	assert.NotEqual(t, t1.ID, t3.ID)
+	assert.Equal(t, t3.ID, tasks.CreateTask(&Task{FileName: "A.mpg", DstAddr: "127.0.0.1:8081"}).ID)
+
+	// 끝난 task 를 지워도 진행 중인 task 의 index 는 남음
+	assert.Nil(t, tasks.DeleteTask(t1.ID))
+	assert.Equal(t, t3.ID, tasks.CreateTask(&Task{FileName: "A.mpg", DstAddr: "127.0.0.1:8081"}).ID)
+
+	// 다시 load 해도 index 가 만들어짐
+	loaded := NewTasksWithStore(tasks.store)
+	loaded.LoadTasks()
+	assert.Equal(t, t3.ID, loaded.CreateTask(&Task{FileName: "A.mpg", DstAddr: "127.0.0.1:8081"}).ID)
+	assert.Nil(t, loaded.DeleteTask(t3.ID))
+	t4 := loaded.CreateTask(&Task{FileName: "A.mpg", DstAddr: "127.0.0.1:8081"})
+	assert.True(t, t4.ID > t3.ID)
+}
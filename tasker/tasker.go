@@ -1,13 +1,15 @@
 package tasker
 
 import (
-	"container/ring"
 	"errors"
+	"fmt"
 	"sort"
 	"time"
 
 	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cfm/decision"
 	"github.com/castisdev/cfm/heartbeater"
+	"github.com/castisdev/cfm/membership"
 	"github.com/castisdev/cfm/tailer"
 	"github.com/castisdev/cilog"
 )
@@ -32,6 +34,12 @@ type ServerFileMetaPtrMap map[string]FileMetaPtrMap
 type Freq uint64
 type FileFreqMap map[string]Freq
 
+// map: common.Host.addr -> FileFreqMap
+type ServerFileFreqMap map[string]FileFreqMap
+
+// map: file name -> (map: dst addr -> bool)
+type FileDstsMap map[string]map[string]bool
+
 // var sleepSec uint
 // var taskTimeout time.Duration
 
@@ -53,11 +61,13 @@ func (s HostStatus) String() string {
 }
 
 // SrcHost : Source Host
-// selected : task에서 src 선택되었는지 여부
+// slots : 동시에 할당할 수 있는 task 개수
+// selected : task에서 src 로 선택된 개수
 // Status : host 상태
 type SrcHost struct {
 	common.Host
-	selected bool
+	slots    int
+	selected int
 	Status   HostStatus
 }
 
@@ -65,17 +75,32 @@ type SrcHost struct {
 type SrcHosts []*SrcHost
 
 // DstHost : Destination host
-// selected : task에서 src 선택되었는지 여부
+// slots : 동시에 할당할 수 있는 task 개수,
+// 0 이면 task 에 할당되지 않은 경우에만 선택하고, 한 주기에 만드는 task 개수는 제한 없음
+// selected : task에서 dest 로 선택된 개수
 // Status : host 상태
+// draining : drain 모드 여부, 새 task 의 dest 로 선택하지 않음
 type DstHost struct {
 	common.Host
-	selected bool
+	slots    int
+	selected int
 	Status   HostStatus
+	draining bool
 }
 
 // DstHosts : Destination host sturct slice
 type DstHosts []*DstHost
 
+// clone : SrcHost 까지 복사한 새로운 SrcHosts 반환
+func (srcs *SrcHosts) clone() *SrcHosts {
+	c := make(SrcHosts, 0, len(*srcs))
+	for _, src := range *srcs {
+		s := *src
+		c = append(c, &s)
+	}
+	return &c
+}
+
 // getAllHostStatus :
 // 각 src host의 heartbeat 결과가 Status에 저장됨
 func (srcs *SrcHosts) getAllHostStatus() {
@@ -97,18 +122,18 @@ func (srcs *SrcHosts) getAllHostStatus() {
 }
 
 // setSelected :
-// src 의 selected 상태를 false로 reset하고,
+// src 의 selected 개수를 0으로 reset하고,
 //
 // task list 를 검사해서
-// task에서 사용 중인 src 의 selected 상태를 true 변경
+// task에서 사용 중인 src 의 selected 개수를 task 수만큼 증가
 func (srcs *SrcHosts) setSelected(curtasks []Task) {
 	for _, src := range *srcs {
-		src.selected = false
+		src.selected = 0
 	}
 	for _, task := range curtasks {
 		for _, src := range *srcs {
 			if src.Addr == task.SrcAddr {
-				src.selected = true
+				src.selected++
 			}
 		}
 	}
@@ -125,35 +150,83 @@ func (srcs *SrcHosts) getHostStatus(addr string) (HostStatus, bool) {
 	return NOTOK, false
 }
 
-// 상태가 OK 이고, selected가 false 인 src 서버 개수 반환
+// 상태가 OK 인 src 서버의 남은 slot 개수 합 반환
 func (srcs *SrcHosts) getSelectableCount() int {
 	cnt := 0
 	for _, src := range *srcs {
-		if src.Status == OK && !src.selected {
-			cnt++
+		if src.Status == OK && src.selected < src.slots {
+			cnt += src.slots - src.selected
 		}
 	}
 	return cnt
 }
 
+// isSelectable : addr 의 source 가 status 가 OK 이고, 남은 slot 이 있는 지 여부 반환
+func (srcs *SrcHosts) isSelectable(addr string) bool {
+	for _, src := range *srcs {
+		if src.Addr == addr {
+			return src.Status == OK && src.selected < src.slots
+		}
+	}
+	return false
+}
+
+// selectSourceServerByAddr :
+// addr 의 source 가 선택 가능하면 selected 개수를 증가시키고 반환
+func (srcs *SrcHosts) selectSourceServerByAddr(addr string) (SrcHost, bool) {
+	for _, src := range *srcs {
+		if src.Addr == addr && src.Status == OK && src.selected < src.slots {
+			src.selected++
+			return *src, true
+		}
+	}
+	return SrcHost{}, false
+}
+
 // selectSourceServer
 //
-// selected 가 false 이고
+// 남은 slot 이 있고
 //
-// status 가 OK 인 source 선택되고, selected가 true로 update
+// status 가 OK 인 source 중에 selected 개수가 가장 적은 source 가 선택되고,
+// selected 개수가 증가됨
 //
 // 선택된 source: *SrcHost와 선택 여부가 retrun 된다.
 func (srcs *SrcHosts) selectSourceServer() (SrcHost, bool) {
 
+	var selected *SrcHost
 	for _, src := range *srcs {
 
-		if src.selected != true && src.Status == OK {
-			src.selected = true
-			return *src, true
+		if src.selected < src.slots && src.Status == OK {
+			if selected == nil || src.selected < selected.selected {
+				selected = src
+			}
 		}
 
 	}
-	return SrcHost{}, false
+	if selected == nil {
+		return SrcHost{}, false
+	}
+	selected.selected++
+	return *selected, true
+}
+
+// clone : DstHost 까지 복사한 새로운 DstHosts 반환
+func (dsts *DstHosts) clone() *DstHosts {
+	c := make(DstHosts, 0, len(*dsts))
+	for _, dst := range *dsts {
+		d := *dst
+		c = append(c, &d)
+	}
+	return &c
+}
+
+// hosts : dest host 의 common.Host 목록
+func (dsts *DstHosts) hosts() []*common.Host {
+	hl := make([]*common.Host, 0, len(*dsts))
+	for _, dst := range *dsts {
+		hl = append(hl, &dst.Host)
+	}
+	return hl
 }
 
 // getAllHostStatus :
@@ -177,18 +250,18 @@ func (dsts *DstHosts) getAllHostStatus() {
 }
 
 // setSelected :
-// dest 의 selected 상태를 false로 reset하고,
+// dest 의 selected 개수를 0으로 reset하고,
 //
 // task list 를 검사해서
-// task에서 사용 중인 dest 의 selected 상태를 true 변경
+// task에서 사용 중인 dest 의 selected 개수를 task 수만큼 증가
 func (dsts *DstHosts) setSelected(curtasks []Task) {
 	for _, dst := range *dsts {
-		dst.selected = false
+		dst.selected = 0
 	}
 	for _, task := range curtasks {
 		for _, dst := range *dsts {
 			if dst.Addr == task.DstAddr {
-				dst.selected = true
+				dst.selected++
 			}
 		}
 	}
@@ -196,10 +269,10 @@ func (dsts *DstHosts) setSelected(curtasks []Task) {
 
 // getSelectableList :
 // status가 OK이고,
-// task 에 할당되지 않은 sort된 dest list 반환
+// 남은 slot 이 있고, drain 모드가 아닌 sort된 dest list 반환
 func (dsts *DstHosts) getSelectableList() (rl []DstHost) {
 	for _, dst := range *dsts {
-		if dst.Status == OK && !dst.selected {
+		if dst.isSelectable() {
 			rl = append(rl, *dst)
 		}
 	}
@@ -209,6 +282,34 @@ func (dsts *DstHosts) getSelectableList() (rl []DstHost) {
 	return rl
 }
 
+// status가 OK이고, 남은 slot 이 있고, drain 모드가 아닌 지 여부 반환
+//
+// slot 개수가 0 이면 task 에 할당되지 않은 경우 선택 가능
+func (dst *DstHost) isSelectable() bool {
+	if dst.Status != OK || dst.draining {
+		return false
+	}
+	if dst.slots == 0 {
+		return dst.selected == 0
+	}
+	return dst.selected < dst.slots
+}
+
+// selectDestinationServer :
+// 파라미터로 받은 addr 값의 destination 의 selected 개수 증가
+//
+// slot 개수가 0 이면 한 주기에 만드는 task 개수를 제한하지 않으므로 증가하지 않음
+func (dsts *DstHosts) selectDestinationServer(addr string) {
+	for _, dst := range *dsts {
+		if dst.Addr == addr {
+			if dst.slots > 0 {
+				dst.selected++
+			}
+			return
+		}
+	}
+}
+
 // destination 목록에 파라미터로 받은 addr 값의 destination이 있는 경우 status 값 반환
 // destination의 status 와 해당 addr 의 destination이 srcs 목록에 있는 지 여부 반환
 func (dsts *DstHosts) getHostStatus(addr string) (HostStatus, bool) {
@@ -220,6 +321,26 @@ func (dsts *DstHosts) getHostStatus(addr string) (HostStatus, bool) {
 	return NOTOK, false
 }
 
+// setDraining : addr 의 destination 의 drain 모드 설정
+func (dsts *DstHosts) setDraining(addr string, draining bool) {
+	for _, dst := range *dsts {
+		if dst.Addr == addr {
+			dst.draining = draining
+			return
+		}
+	}
+}
+
+// isDraining : addr 의 destination 이 drain 모드인 지 여부
+func (dsts *DstHosts) isDraining(addr string) bool {
+	for _, dst := range *dsts {
+		if dst.Addr == addr {
+			return dst.draining
+		}
+	}
+	return false
+}
+
 // NewSrcHosts is constructor of SrcHosts
 func NewSrcHosts() *SrcHosts {
 	return new(SrcHosts)
@@ -233,15 +354,28 @@ func NewDstHosts() *DstHosts {
 // Add is to add host to source servers
 // IP, Port, Addr 값 이외 selected, status값은 초기값이 들어감
 //
+// slot 개수는 1
+//
 // 서버 순서를 일정하게 유지할 수 있도록 Addr 큰 순서로 sort 함
 func (srcs *SrcHosts) Add(s string) error {
+	return srcs.AddWithSlots(s, 1)
+}
+
+// AddWithSlots is to add host with slots to source servers
+//
+// slots : 동시에 할당할 수 있는 task 개수, 1 보다 작으면 error
+func (srcs *SrcHosts) AddWithSlots(s string, slots int) error {
+
+	if slots < 1 {
+		return errors.New(fmt.Sprintf("invalid slots(%d)", slots))
+	}
 
 	host, err := common.SplitHostPort(s)
 	if err != nil {
 		return err
 	}
 
-	src := SrcHost{host, false, NOTOK}
+	src := SrcHost{host, slots, 0, NOTOK}
 	*srcs = append(*srcs, &src)
 
 	sort.Slice(*srcs, func(i, j int) bool {
@@ -254,15 +388,28 @@ func (srcs *SrcHosts) Add(s string) error {
 // Add : add destination host
 // IP, Port, Addr 값 이외 selected, status값은 초기값이 들어감
 //
+// slot 개수는 0, task 에 할당되지 않은 경우에만 선택하고, 한 주기에 만드는 task 개수는 제한 없음
+//
 // 서버 순서를 일정하게 유지할 수 있도록 Addr 큰 순서로 sort 함
 func (dests *DstHosts) Add(s string) error {
+	return dests.AddWithSlots(s, 0)
+}
+
+// AddWithSlots : add destination host with slots
+//
+// slots : 동시에 할당할 수 있는 task 개수, 0 보다 작으면 error
+func (dests *DstHosts) AddWithSlots(s string, slots int) error {
+
+	if slots < 0 {
+		return errors.New(fmt.Sprintf("invalid slots(%d)", slots))
+	}
 
 	host, err := common.SplitHostPort(s)
 	if err != nil {
 		return err
 	}
 
-	dest := DstHost{host, false, NOTOK}
+	dest := DstHost{host, slots, 0, NOTOK, false}
 	*dests = append(*dests, &dest)
 
 	sort.Slice(*dests, func(i, j int) bool {
@@ -272,10 +419,43 @@ func (dests *DstHosts) Add(s string) error {
 	return nil
 }
 
+// SetServers :
+//
+// membership 의 src, dst 서버 목록으로 새로 만들어서 바꿈
+//
+// drain 모드인 dst 는 새 task 의 dst 로 선택하지 않고,
+// 목록에서 빠진 서버의 task 는 다음 주기에 지움
+func (tskr *Tasker) SetServers(srcl, dstl []membership.Server) {
+	srcs := NewSrcHosts()
+	for _, s := range srcl {
+		if err := srcs.AddWithSlots(s.Addr, s.Slots); err != nil {
+			tskrlogger.Errorf("failed to add src(%s), error(%s)", s, err.Error())
+		}
+	}
+	dsts := NewDstHosts()
+	for _, s := range dstl {
+		if err := dsts.AddWithSlots(s.Addr, s.Slots); err != nil {
+			tskrlogger.Errorf("failed to add dst(%s), error(%s)", s, err.Error())
+			continue
+		}
+		dsts.setDraining(s.Addr, s.Drain)
+	}
+	tskr.SrcServers = srcs
+	tskr.DstServers = dsts
+}
+
 // DstServers : 파일 배포 대상 서버 리스트
 // SrcServers : 배포할 파일들을 갖고 있는 서버 리스트
 // Tail :: LB EventLog 를 tailing 하며 SAN 에서 Hit 되는 파일 목록 추출
 // SourcePath : 배포할 파일이 존재하는 경로
+// placement : 배포 task 의 destination 서버 선택 방식
+// maxCopyCount : 파일별 최대 배포 서버 개수, grade.info 파일의 TargetCopyCount 값을 제한함
+// dryRun : plan 모드, task 를 만들거나 지우지 않고 만들 task 를 planned 에 추가
+// history : 끝난(done, timeout) task 기록, 기본값은 저장하지 않는 memory store,
+// History().SetStore 로 저장하는 곳을 바꿈
+// failures : (file, dst) 별 timeout 기록, backoff, quarantine 에 사용
+// manual : API 로 요청받아서 task 를 만들기 전의 manual task 목록
+// inventory : dest 서버별 파일 목록 cache, runner 가 remover 와 함께 사용하도록 설정함
 type Tasker struct {
 	sleepSec            uint
 	taskTimeout         time.Duration
@@ -284,21 +464,49 @@ type Tasker struct {
 	DstServers          *DstHosts
 	Tail                *tailer.Tailer
 	tasks               *Tasks
+	history             *History
+	failures            *Failures
+	manual              *ManualTasks
 	gradeInfoFile       string
 	hitcountHistoryFile string
 	taskCopySpeed       string
 	ignorePrefixes      []string
+	placement           PlacementStrategy
+	maxCopyCount        int
+	dryRun              bool
+	planned             []PlannedTask
+	inventory           *common.Inventory
+}
+
+// PlannedTask : plan 모드에서 구한, 만들어질 배포 task
+//
+// Reason : task 를 만드는 이유, risingHit 또는 grade 와 현재 copy 수 / 목표 copy 수
+type PlannedTask struct {
+	FilePath string `json:"file_path"`
+	FileName string `json:"file_name"`
+	Grade    int32  `json:"grade"`
+	SrcIP    string `json:"src_ip"`
+	DstIP    string `json:"dst_ip"`
+	SrcAddr  string `json:"src_addr"`
+	DstAddr  string `json:"dst_addr"`
+	Reason   string `json:"reason"`
 }
 
 func NewTasker() *Tasker {
 	return &Tasker{
-		sleepSec:    60,
-		taskTimeout: 30 * time.Minute,
-		SourcePath:  common.NewSourceDirs(),
-		SrcServers:  NewSrcHosts(),
-		DstServers:  NewDstHosts(),
-		Tail:        tailer.NewTailer(),
-		tasks:       NewTasks(),
+		sleepSec:     60,
+		taskTimeout:  30 * time.Minute,
+		SourcePath:   common.NewSourceDirs(),
+		SrcServers:   NewSrcHosts(),
+		DstServers:   NewDstHosts(),
+		Tail:         tailer.NewTailer(),
+		tasks:        NewTasks(),
+		history:      NewHistory(NewMemoryHistoryStore()),
+		failures:     NewFailures(),
+		manual:       NewManualTasks(),
+		placement:    newRoundRobinPlacement(),
+		maxCopyCount: 1,
+		inventory:    common.NewInventory(0),
 	}
 }
 
@@ -315,10 +523,16 @@ func NewTaskerWith(
 		DstServers:          NewDstHosts(),
 		Tail:                tailer.NewTailer(),
 		tasks:               NewTasks(),
+		history:             NewHistory(NewMemoryHistoryStore()),
+		failures:            NewFailures(),
+		manual:              NewManualTasks(),
 		gradeInfoFile:       gradeInfoFile,
 		hitcountHistoryFile: hitcountHistoryFile,
 		taskCopySpeed:       taskCopySpeed,
 		ignorePrefixes:      ignorePrefixes,
+		placement:           newRoundRobinPlacement(),
+		maxCopyCount:        1,
+		inventory:           common.NewInventory(0),
 	}
 }
 
@@ -327,6 +541,30 @@ func (tskr *Tasker) Tasks() *Tasks {
 	return tskr.tasks
 }
 
+// History is to get task history
+func (tskr *Tasker) History() *History {
+	return tskr.history
+}
+
+// Close : task, task 기록 repository 닫기
+func (tskr *Tasker) Close() error {
+	err := tskr.tasks.Close()
+	if herr := tskr.history.Close(); err == nil {
+		err = herr
+	}
+	return err
+}
+
+// Failures is to get (file, dst) timeout records
+func (tskr *Tasker) Failures() *Failures {
+	return tskr.failures
+}
+
+// ManualTasks is to get manual tasks waiting to be made
+func (tskr *Tasker) ManualTasks() *ManualTasks {
+	return tskr.manual
+}
+
 // SetTaskTimeout is to set timeout for task
 func (tskr *Tasker) SetTaskTimeout(t time.Duration) error {
 
@@ -369,6 +607,37 @@ func (tskr *Tasker) SetIgnorePrefixes(p []string) {
 	tskrlogger.Infof("set ignore prefixes(%v)", p)
 }
 
+// SetPlacementStrategy :
+func (tskr *Tasker) SetPlacementStrategy(p PlacementStrategy) {
+	tskr.placement = p
+	tskrlogger.Infof("set placement strategy(%s)", p.Placement())
+}
+
+// PlacementStrategy :
+func (tskr *Tasker) PlacementStrategy() PlacementStrategy {
+	return tskr.placement
+}
+
+// SetInventory : dest 서버의 파일 목록을 구할 때 사용할 cache
+func (tskr *Tasker) SetInventory(inv *common.Inventory) {
+	tskr.inventory = inv
+}
+
+// SetMaxCopyCount :
+func (tskr *Tasker) SetMaxCopyCount(n int) error {
+	if n < 1 {
+		return errors.New("max copy count must be greater than 0")
+	}
+	tskr.maxCopyCount = n
+	tskrlogger.Infof("set max copy count(%d)", n)
+	return nil
+}
+
+// MaxCopyCount :
+func (tskr *Tasker) MaxCopyCount() int {
+	return tskr.maxCopyCount
+}
+
 // InitTasks:
 //
 // 원래 init() 함수 안에 있었는데,
@@ -437,6 +706,31 @@ func (tskr *Tasker) RunWithInfo(
 	tskr.runWithInfo(fileMetaMap, risingHitFileMap)
 }
 
+// Plan :
+//
+// runWithInfo 와 같은 방법으로 배포 task 를 구하지만,
+// task 를 만들거나 지우지 않고, 만들어질 task 목록과 이유를 반환
+//
+// 서버의 heartbeat, 파일 목록은 조회함
+//
+// 전달받은 file meta 정보와 src, dst 서버 정보는 바꾸지 않음
+func (tskr *Tasker) Plan(
+	fileMetaMap FileMetaPtrMap,
+	risingHitFileMap map[string]int) []PlannedTask {
+
+	p := *tskr
+	p.SrcServers = tskr.SrcServers.clone()
+	p.DstServers = tskr.DstServers.clone()
+	p.dryRun = true
+	p.planned = make([]PlannedTask, 0)
+	fmm := make(FileMetaPtrMap, len(fileMetaMap))
+	for fn, fm := range fileMetaMap {
+		fmm[fn] = fm.Clone()
+	}
+	p.runWithInfo(fmm, risingHitFileMap)
+	return p.planned
+}
+
 // runWithInfo :
 func (tskr *Tasker) runWithInfo(
 	fileMetaMap FileMetaPtrMap,
@@ -458,34 +752,52 @@ func (tskr *Tasker) runWithInfo(
 	// - Src 또는 Dest의 heartbeat 답을 구하지 못한 task 정리
 	curtasks = tskr.cleanTask(curtasks)
 
-	// src 할당 상태를 false로 변경
-	// task 에서 사용 중인 src 할당 상태를 true로 변경
-	// 배포에 할당 가능한 src 서버 개수 구하기
+	// src 할당 개수를 0으로 변경
+	// task 에서 사용 중인 src 할당 개수를 task 수만큼 증가
+	// 배포에 할당 가능한 src slot 개수 구하기
 	// 	- status가 OK 이고,
-	// 	- 아직 배포 task 에 할당안된 경우 할당 가능
+	// 	- 배포 task 에 할당된 개수가 slot 개수보다 작은 경우 할당 가능
 	// 배포에 할당 가능한 src 서버가 없으면 다음 주기로 넘어감
 	srccnt := tskr.getAvailableSrcServerCount(curtasks)
 	if srccnt == 0 {
 		tskrlogger.Infof("no src server is available")
+		tskr.recordStopped(fileMetaList(fileMetaMap), decision.NoSrcServer)
 		return
 	}
 
-	// dest 할당 상태를 false로 변경
-	// task 에서 사용 중인 dest 할당 상태를 true로 변경
-	// destination ip 를 round robin 으로 선택하기 위한 ring 생성
-	// 배포에 할당 가능한 dest 서버로 ring 생성
+	// dest 할당 개수를 0으로 변경
+	// task 에서 사용 중인 dest 할당 개수를 task 수만큼 증가
+	// 배포에 할당 가능한 dest 서버 list 구하기
 	// 	- status가 OK 이고,
-	// 	- 아직 배포 task 에 할당안된 경우 할당 가능
+	// 	- 배포 task 에 할당된 개수가 slot 개수보다 작은 경우 할당 가능
 	// 배포에 할당 가능한 dest 서버가 없으면 다음 주기로 넘어감
-	dstRing := tskr.getAvailableDstServerRing(curtasks)
-	if dstRing == nil {
+	// 할당 가능한 dest 서버 list 로 placement 준비
+	// 	- round robin 인 경우, ring 생성
+	// 	- disk 용량을 보는 경우, dest 서버의 disk 사용량 구하기
+	dstlist := tskr.getAvailableDstServerList(curtasks)
+	if len(dstlist) == 0 {
 		tskrlogger.Infof("no dst server is available")
+		tskr.recordStopped(fileMetaList(fileMetaMap), decision.NoDstServer)
 		return
 	}
+	tskr.placement.Prepare(dstlist, curtasks)
 
 	// 모든 dest 서버의 파일 목록 수집
 	serverfiles := make(FileFreqMap)
-	collectRemoteFileList(tskr.DstServers, serverfiles)
+	dstfiles := make(ServerFileFreqMap)
+	collectRemoteFileList(tskr.inventory, tskr.DstServers, serverfiles, dstfiles)
+
+	usedtaskfiles := getFilesInTasks(curtasks)
+	taskdsts := getDstsInTasks(curtasks)
+
+	// API 로 요청받은 manual task 를 먼저 만듬
+	// 	- 높은 priority 순서, 같으면 먼저 요청받은 순서
+	// 	- src, dst 에 남은 slot 이 없으면 다음 주기로 넘어감
+	if !tskr.makeManualTasks(fileMetaMap, usedtaskfiles, dstfiles, taskdsts) {
+		tskrlogger.Infof("stopped making task, no src is available")
+		tskr.recordStopped(fileMetaList(fileMetaMap), decision.NoSrcServer)
+		return
+	}
 
 	// 배포 대상이 되는 파일 리스트 만들어서 배포 task 만들기
 
@@ -498,29 +810,100 @@ func (tskr *Tasker) runWithInfo(
 	// - dest 서버에 이미 있는 파일 제외
 	// - ignore.prefix로 시작하는 파일 제외 (광고 파일 제외)
 	// - task 에 이미 있는 파일 제외
-	usedtaskfiles := getFilesInTasks(curtasks)
-	for _, fmm := range sortedfms {
+	// 	 dest 서버와 task 에 있는 파일 수가 목표 copy 수보다 작으면
+	// 	 목표 copy 수가 될 때까지 task 를 만듬
+	for i, fmm := range sortedfms {
 
 		if !tskr.updateFileMetaForSrcFilePath(fmm) {
 			tskrlogger.Debugf("ignored by not.found.in.the.source.paths, file(%s)", *fmm)
+			tskr.record(fmm, "", decision.SKIP, decision.NotFoundInTheSourcePaths)
 			continue
 		}
 		if !tskr.checkForTask(fmm, usedtaskfiles, serverfiles) {
 			continue
 		}
 
-		// src 서버 선택
-		// 	- status가 OK 이고,
-		// 	- 아직 배포 task 에 할당안된 경우
-		src, exists := tskr.SrcServers.selectSourceServer()
-		if exists != true {
+		if !tskr.makeTasksForFile(fmm, usedtaskfiles, serverfiles,
+			dstfiles, taskdsts) {
 			tskrlogger.Debugf("stopped making task, no src is available")
+			tskr.recordStopped(sortedfms[i+1:], decision.NoSrcServer)
 			break
 		}
+	}
+}
+
+// record : 파일에 대한 결정 기록, plan 모드에서는 기록하지 않음
+func (tskr *Tasker) record(fmm *common.FileMeta, server string,
+	action decision.Action, reason string) {
+	if tskr.dryRun {
+		return
+	}
+	decision.Record(decision.New("tasker", fmm, server, action, reason))
+}
+
+// recordStopped :
+//
+// src, dst 서버가 없어서 검사하지 못한 파일들의 결정 기록
+//
+// 	- 목표 copy 수 이상의 서버에 이미 있는 파일은 found.in.the.servers
+//
+// 	- 나머지 파일은 reason
+func (tskr *Tasker) recordStopped(fmms []FileMetaPtr, reason string) {
+	if tskr.dryRun {
+		return
+	}
+	for _, fmm := range fmms {
+		if fmm.ServerCount >= (*fmm).TargetCopyCountWithMax(tskr.maxCopyCount) {
+			tskr.record(fmm, "", decision.SKIP, decision.FoundInTheServers)
+		} else {
+			tskr.record(fmm, "", decision.SKIP, reason)
+		}
+	}
+}
+
+// makeTasksForFile :
+//
+// 목표 copy 수가 될 때까지 파일의 배포 task 생성
+//
+// 	- 파일을 이미 가지고 있거나, 같은 파일의 task 가 있는 dst 서버는 선택하지 않음
+//
+// 	- 남은 slot 이 없는 dst 서버는 선택하지 않음
+//
+// 만든 task 는 taskfiles, taskdsts 에 반영
+//
+// src 서버가 남아있지 않으면 false return
+func (tskr *Tasker) makeTasksForFile(fmm *common.FileMeta,
+	taskfiles FileFreqMap, serverfiles FileFreqMap,
+	dstfiles ServerFileFreqMap, taskdsts FileDstsMap) bool {
+
+	for n := tskr.getCopyCountToMake(fmm, taskfiles, serverfiles); n > 0; n-- {
+		// src 서버가 남아있지 않으면 중지
+		if tskr.SrcServers.getSelectableCount() == 0 {
+			tskr.record(fmm, "", decision.SKIP, decision.NoSrcServer)
+			return false
+		}
+
+		// dst 서버 선택
+		// 	- placement 에 따라 선택
+		// 	- 파일을 배포할 수 있는 dst 서버가 없으면 다음 파일로 넘어감
+		excluded := tskr.getDstsToExclude(fmm, dstfiles, taskdsts)
+		dst, found := tskr.placement.Select(fmm, excluded)
+		if !found {
+			tskrlogger.Debugf("ignored by no.dst.server.for.placement(%s), file(%s)",
+				tskr.placement.Placement(), *fmm)
+			tskr.record(fmm, "", decision.SKIP, decision.NoDstServerForPlacement)
+			return true
+		}
+		tskr.DstServers.selectDestinationServer(dst.Addr)
+
+		// src 서버 선택
+		// 	- status가 OK 이고,
+		// 	- 남은 slot 이 있는 서버 중 할당된 개수가 가장 적은 서버
+		src, _ := tskr.SrcServers.selectSourceServer()
 
 		// task 생성
-		dst := DstHost(dstRing.Value.(DstHost))
-		t := tskr.tasks.CreateTask(&Task{
+		// 	- plan 모드이면 task 를 만들지 않고 planned 에 추가
+		task := &Task{
 			FilePath:  fmm.SrcFilePath,
 			FileName:  fmm.Name,
 			SrcIP:     src.IP,
@@ -529,17 +912,115 @@ func (tskr *Tasker) runWithInfo(
 			CopySpeed: tskr.taskCopySpeed,
 			SrcAddr:   src.Addr,
 			DstAddr:   dst.Addr,
-		})
-		dstRing = dstRing.Next()
-
-		if fmm.RisingHit > 0 {
-			tskrlogger.Infof("[%d] created task(%s) for risingHit(%d), file(%s)",
-				t.ID, t, fmm.RisingHit, *fmm)
+			Size:      fmm.Size,
+		}
+		if tskr.dryRun {
+			tskr.addPlannedTask(task, fmm, taskfiles, serverfiles)
 		} else {
-			tskrlogger.Infof("[%d] created task(%s) for grade(%d), file(%s)",
-				t.ID, t, fmm.Grade, *fmm)
+			t := tskr.tasks.CreateTask(task)
+			if fmm.RisingHit > 0 {
+				tskrlogger.Infof("[%d] created task(%s) for risingHit(%d), file(%s)",
+					t.ID, t, fmm.RisingHit, *fmm)
+				tskr.record(fmm, dst.Addr, decision.TASK, decision.RisingHit)
+			} else {
+				tskrlogger.Infof("[%d] created task(%s) for grade(%d), file(%s)",
+					t.ID, t, fmm.Grade, *fmm)
+				tskr.record(fmm, dst.Addr, decision.TASK, decision.Grade)
+			}
 		}
+		taskfiles[fmm.Name]++
+		if taskdsts[fmm.Name] == nil {
+			taskdsts[fmm.Name] = make(map[string]bool)
+		}
+		taskdsts[fmm.Name][dst.Addr] = true
+	}
+	return true
+}
+
+// addPlannedTask : plan 모드에서 만들어질 task 추가
+//
+// 이유에는 task 를 만들기 전의 copy 수(서버 + task)와 목표 copy 수를 남김
+func (tskr *Tasker) addPlannedTask(task *Task, fmm *common.FileMeta,
+	taskfiles FileFreqMap, serverfiles FileFreqMap) {
+	copies := fmm.ServerCount
+	if n := int(serverfiles[fmm.Name]); n > copies {
+		copies = n
+	}
+	copies += int(taskfiles[fmm.Name])
+	target := fmm.TargetCopyCountWithMax(tskr.maxCopyCount)
+
+	var reason string
+	if fmm.RisingHit > 0 {
+		reason = fmt.Sprintf("risingHit(%d), copies(%d/%d)", fmm.RisingHit, copies, target)
+	} else {
+		reason = fmt.Sprintf("grade(%d), copies(%d/%d)", fmm.Grade, copies, target)
 	}
+	tskr.addPlannedTaskWithReason(task, fmm, reason)
+}
+
+// addPlannedTaskWithReason : plan 모드에서 만들어질 task 를 이유와 함께 추가
+func (tskr *Tasker) addPlannedTaskWithReason(task *Task, fmm *common.FileMeta,
+	reason string) {
+	tskr.planned = append(tskr.planned, PlannedTask{
+		FilePath: task.FilePath,
+		FileName: task.FileName,
+		Grade:    task.Grade,
+		SrcIP:    task.SrcIP,
+		DstIP:    task.DstIP,
+		SrcAddr:  task.SrcAddr,
+		DstAddr:  task.DstAddr,
+		Reason:   reason,
+	})
+	tskrlogger.Debugf("planned task(%s) for %s, file(%s)", task, reason, *fmm)
+}
+
+// getCopyCountToMake :
+//
+// 목표 copy 수에서 서버에 있는 파일 수와 task 에 있는 파일 수를 뺀 값
+//
+// 서버에 있는 파일 수는
+// hitcount.history 파일에서 구한 값과 서버별로 조사한 값 중 큰 값
+func (tskr *Tasker) getCopyCountToMake(fmm *common.FileMeta,
+	taskfiles FileFreqMap, serverfiles FileFreqMap) int {
+	copies := fmm.ServerCount
+	if n := int(serverfiles[fmm.Name]); n > copies {
+		copies = n
+	}
+	return fmm.TargetCopyCountWithMax(tskr.maxCopyCount) -
+		copies - int(taskfiles[fmm.Name])
+}
+
+// getDstsToExclude :
+//
+// 파일을 가지고 있거나, 같은 파일의 task 가 있거나,
+// 남은 slot 이 없는 dst 서버 addr 목록
+//
+// 	- hitcount.history file에서 구한 서버위치 정보에 있는 IP의 서버
+//
+// 	- 서버별로 조사한 파일 정보에 파일이 있는 서버
+//
+// 	- 같은 파일의 task 의 dst 서버
+//
+// 	- 이번 주기에 만든 task 로 slot 을 다 사용한 dst 서버
+//
+// 	- 같은 파일의 task 가 timeout 되어서 backoff 중이거나 quarantine 된 dst 서버
+func (tskr *Tasker) getDstsToExclude(fmm *common.FileMeta,
+	dstfiles ServerFileFreqMap, taskdsts FileDstsMap) map[string]bool {
+	dsts := make(map[string]bool)
+	now := time.Now()
+	for _, dst := range *tskr.DstServers {
+		if !dst.isSelectable() || fmm.ServerIPs[dst.IP] > 0 || dstfiles[dst.Addr][fmm.Name] > 0 ||
+			taskdsts[fmm.Name][dst.Addr] {
+			dsts[dst.Addr] = true
+			continue
+		}
+		if !tskr.failures.allowed(fmm.Name, dst.Addr, now) {
+			tskrlogger.Debugf("[%s] ignored by retry.backoff.or.quarantine, file(%s)",
+				dst.Addr, fmm.Name)
+			dsts[dst.Addr] = true
+		}
+	}
+	return dsts
 }
 
 // cleanTask :
@@ -554,23 +1035,42 @@ func (tskr *Tasker) runWithInfo(
 //
 // dest의 status가 OK가 아닌 task 삭제
 //
+// 삭제한 done, timeout task 는 history 에 기록하고,
+// (file, dst) 별 timeout 기록을 update 함
+//
 // 작업 후의 task list를 retrun
+//
+// plan 모드이면 task 를 지우지 않고, 지워질 task 를 뺀 task list를 return
 func (tskr *Tasker) cleanTask(curtasks []Task) []Task {
 
 	tl := make([]int64, 0, len(curtasks))
+	rl := make([]TaskRecord, 0)
+	done := make([]Task, 0)
+	deleted := "deleted"
+	if tskr.dryRun {
+		deleted = "planned to delete"
+	}
 
 	for _, task := range curtasks {
 
 		if task.Status == DONE {
 			tl = append(tl, task.ID)
-			tskrlogger.Infof("[%d] with stauts done, deleted task(%s) ", task.ID, task)
+			rl = append(rl, newTaskRecord(task, DONE, task.Mtime))
+			done = append(done, task)
+			tskrlogger.Infof("[%d] with stauts done, %s task(%s) ", task.ID, deleted, task)
 			continue
 		}
 
 		diff := time.Since(time.Unix(int64(task.Mtime), 0))
 		if diff > tskr.taskTimeout {
 			tl = append(tl, task.ID)
-			tskrlogger.Infof("[%d] with timeout, deleted task(%s)", task.ID, task)
+			// agent 가 timeout 으로 바꾼 task 는 바꾼 시간에 끝난 것으로 기록
+			dtime := TaskTime(time.Now().Unix())
+			if task.Status == TIMEOUT {
+				dtime = task.Mtime
+			}
+			rl = append(rl, newTaskRecord(task, TIMEOUT, dtime))
+			tskrlogger.Infof("[%d] with timeout, %s task(%s)", task.ID, deleted, task)
 			continue
 		}
 
@@ -582,7 +1082,7 @@ func (tskr *Tasker) cleanTask(curtasks []Task) []Task {
 		}
 		if !srcfound || srcstatus != OK {
 			tl = append(tl, task.ID)
-			tskrlogger.Infof("[%d] with srcHost's status NOTOK, delete task(%s)", task.ID, task)
+			tskrlogger.Infof("[%d] with srcHost's status NOTOK, %s task(%s)", task.ID, deleted, task)
 			continue
 		}
 
@@ -594,16 +1094,63 @@ func (tskr *Tasker) cleanTask(curtasks []Task) []Task {
 		}
 		if !dstfound || dststatus != OK {
 			tl = append(tl, task.ID)
-			tskrlogger.Infof("[%d] with dstHost's status NOTOK, deleted task(%s)", task.ID, task)
+			tskrlogger.Infof("[%d] with dstHost's status NOTOK, %s task(%s)", task.ID, deleted, task)
 			continue
 		}
 	}
 
+	if tskr.dryRun {
+		return tasksExcept(curtasks, tl)
+	}
+
 	tskr.tasks.DeleteTasks(tl)
+	// 배포가 끝난 파일은 dest 서버의 파일 목록을 다시 구하기 전에도 목록에 있도록 함
+	for _, task := range done {
+		tskr.inventory.Added(task.DstAddr, task.FileName)
+	}
+	tskr.history.Add(rl, time.Now())
+	tskr.updateFailures(rl, time.Now())
 
 	return tskr.tasks.GetTaskList()
 }
 
+// updateFailures :
+// 끝난 task 로 (file, dst) 별 timeout 기록 update
+//
+// done 이면 기록을 지우고, timeout 이면 timeout 횟수를 늘림
+func (tskr *Tasker) updateFailures(rl []TaskRecord, now time.Time) {
+	for _, tr := range rl {
+		switch tr.Status {
+		case DONE:
+			tskr.failures.succeed(tr.FileName, tr.DstAddr)
+		case TIMEOUT:
+			f := tskr.failures.fail(tr.FileName, tr.DstAddr, now)
+			if f.Quarantined {
+				tskrlogger.Warningf("[%s] quarantined, file(%s), failure(%s)",
+					tr.DstAddr, tr.FileName, f)
+			} else {
+				tskrlogger.Infof("[%s] backoff, file(%s), failure(%s)",
+					tr.DstAddr, tr.FileName, f)
+			}
+		}
+	}
+}
+
+// tasksExcept : task list 에서 ids 의 task 를 뺀 task list
+func tasksExcept(curtasks []Task, ids []int64) []Task {
+	except := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		except[id] = true
+	}
+	tl := make([]Task, 0, len(curtasks))
+	for _, task := range curtasks {
+		if !except[task.ID] {
+			tl = append(tl, task)
+		}
+	}
+	return tl
+}
+
 // task list 를 검사해서
 //
 // src server의 selected 상태 update 하고
@@ -616,27 +1163,6 @@ func (tskr *Tasker) getAvailableSrcServerCount(curtasks []Task) int {
 	return tskr.SrcServers.getSelectableCount()
 }
 
-// task list 를 검사해서
-//
-// dst server의 selected 상태 update 하고
-//
-// task에서 사용하지 않고, status가 ok인
-//
-// sort된 dst server list 를 Ring 으로 만들어서 return
-//
-// dst server가 없는 경우, nil return 됨
-func (tskr *Tasker) getAvailableDstServerRing(curtasks []Task) *ring.Ring {
-	dstlist := tskr.getAvailableDstServerList(curtasks)
-
-	dstRing := ring.New(len(dstlist))
-	for _, d := range dstlist {
-		dstRing.Value = d
-		dstRing = dstRing.Next()
-		tskrlogger.Debugf("[%s] added to available destination servers", d.Addr)
-	}
-	return dstRing
-}
-
 // task list 를 검사해서
 //
 // dst server의 selected 상태 update 하고
@@ -650,21 +1176,41 @@ func (tskr *Tasker) getAvailableDstServerList(curtasks []Task) []DstHost {
 }
 
 // collectRemoteFileList is to get file list on remote servers
-func collectRemoteFileList(destList *DstHosts, remoteFiles FileFreqMap) {
+//
+// inv 에 cache 된 목록이 있으면 사용하고, 나머지 서버는 동시에 요청함,
+// 목록을 구하지 못한 서버는 건너뜀
+//
+// remoteFiles : 파일별로 파일을 가지고 있는 서버 개수
+//
+// serverFiles : 서버별 파일 목록, nil 이면 구하지 않음
+func collectRemoteFileList(inv *common.Inventory, destList *DstHosts,
+	remoteFiles FileFreqMap, serverFiles ServerFileFreqMap) {
 
+	lists, rep := inv.FileLists(destList.hosts())
 	for _, dest := range *destList {
-		fl := make([]string, 0, 10000)
-		err := common.GetRemoteFileList(&dest.Host, &fl)
-		if err != nil {
-			tskrlogger.Errorf("[%s] failed to get dst server file list, error(%s)", dest, err.Error())
+		l, ok := lists[dest.Addr]
+		if !ok {
+			tskrlogger.Errorf("[%s] failed to get dst server file list, error(%v)",
+				dest, rep.Failed[dest.Addr])
 			continue
 		}
 
-		tskrlogger.Debugf("[%s] got file list", dest)
+		fl := l.Files
+		tskrlogger.Debugf("[%s] got file list, fetched(%s)", dest, l.Fetched.Format(time.RFC3339))
+		var files FileFreqMap
+		if serverFiles != nil {
+			files = make(FileFreqMap, len(fl))
+			serverFiles[dest.Addr] = files
+		}
 		for _, file := range fl {
 			remoteFiles[file]++
+			if files != nil {
+				files[file]++
+			}
 		}
 	}
+	tskrlogger.Infof("collected dst server file lists, cached(%d), %s",
+		len(lists)-rep.Succeeded(), rep)
 }
 
 // getSortedFileMetaListForTask
@@ -697,6 +1243,15 @@ func getSortedFileMetaListForTask(allfmm FileMetaPtrMap,
 	return taskfilelist
 }
 
+// fileMetaList : file meta map 을 list 로 만들기
+func fileMetaList(allfmm FileMetaPtrMap) []FileMetaPtr {
+	fl := make([]FileMetaPtr, 0, len(allfmm))
+	for _, fmm := range allfmm {
+		fl = append(fl, fmm)
+	}
+	return fl
+}
+
 func getFilesInTasks(curtasks []Task) FileFreqMap {
 	filenames := make(FileFreqMap)
 	for _, task := range curtasks {
@@ -705,6 +1260,17 @@ func getFilesInTasks(curtasks []Task) FileFreqMap {
 	return filenames
 }
 
+func getDstsInTasks(curtasks []Task) FileDstsMap {
+	dsts := make(FileDstsMap)
+	for _, task := range curtasks {
+		if dsts[task.FileName] == nil {
+			dsts[task.FileName] = make(map[string]bool)
+		}
+		dsts[task.FileName][task.DstAddr] = true
+	}
+	return dsts
+}
+
 // updateFileMetasForRisingHitsFiles :
 //
 // - risinghits map의 file들의 file meta찾아서
@@ -742,6 +1308,8 @@ func (tskr *Tasker) updateFileMetaForSrcFilePath(fmm *common.FileMeta) bool {
 //
 // - 서버에 이미 있는 파일은 제외
 //
+// 	 이미 배포 대상이 되거나 서버에 있는 파일 수가 목표 copy 수보다 작으면 제외하지 않음
+//
 // - ignore.prefix로 시작하는 파일(광고 파일)은 제외
 //
 // - source path 값이 비어있으면 제외
@@ -750,36 +1318,48 @@ func (tskr *Tasker) checkForTask(fmm *common.FileMeta,
 	serverfiles FileFreqMap) bool {
 
 	fn := fmm.Name
+	target := fmm.TargetCopyCountWithMax(tskr.maxCopyCount)
 
 	// - hitcount.history file에서 구한 서버위치 정보로
-	// 어떤 서버인가 이미 있는 파일은 제외
-	if fmm.ServerCount > 0 {
+	// 목표 copy 수 이상의 서버에 이미 있는 파일은 제외
+	if fmm.ServerCount >= target {
 		tskrlogger.Debugf("ignored by found.in.the.servers, file(%s)", fmm)
+		tskr.record(fmm, "", decision.SKIP, decision.FoundInTheServers)
 		return false
 	}
 
 	// 소스 directory에 없는 파일 제외(SAN 에 없는 파일 제외)
 	if fmm.SrcFilePath == "" {
 		tskrlogger.Debugf("ignored by not.found.in.the.source.paths, file(%s)", fmm)
+		tskr.record(fmm, "", decision.SKIP, decision.NotFoundInTheSourcePaths)
 		return false
 	}
 
 	// ignore.prefix 로 시작하는 파일 제외(광고 파일)
 	if common.IsPrefix(fn, tskr.ignorePrefixes) {
 		tskrlogger.Debugf("ignored by ignore.prefix, file(%s)", fmm)
+		tskr.record(fmm, "", decision.SKIP, decision.IgnorePrefix)
 		return false
 	}
 
+	copies := fmm.ServerCount
+	if n := int(serverfiles[fn]); n > copies {
+		copies = n
+	}
+
 	// 이미 배포 task에 사용되는 파일 제외
-	if n, using := taskfiles[fn]; using && n > 0 {
+	// - 서버에 있는 파일 수와 합쳐서 목표 copy 수 이상인 경우
+	if n, using := taskfiles[fn]; using && n > 0 && copies+int(n) >= target {
 		tskrlogger.Debugf("ignored by found.in.the.tasks, file(%s)", fmm)
+		tskr.record(fmm, "", decision.SKIP, decision.FoundInTheTasks)
 		return false
 	}
 
 	// - 서버별로 조사한 파일 정보로
-	// 어떤 서버인가 이미 이미 있는 파일은 제외
-	if n, using := serverfiles[fn]; using && n > 0 {
+	// 목표 copy 수 이상의 서버에 이미 있는 파일은 제외
+	if copies >= target {
 		tskrlogger.Debugf("ignored by found.in.the.servers, file(%s)", fmm)
+		tskr.record(fmm, "", decision.SKIP, decision.FoundInTheServers)
 		return false
 	}
 
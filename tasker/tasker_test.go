@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/castisdev/cfm/common"
+	"github.com/castisdev/cfm/decision"
 	"github.com/castisdev/cfm/heartbeater"
 	"github.com/castisdev/cfm/tailer"
 	"github.com/gorilla/mux"
@@ -460,6 +461,7 @@ func Test_cleanTask(t *testing.T) {
 	ts := NewTasks()
 	tskr.tasks = ts
 	defer tskr.tasks.Release()
+	defer tskr.history.Release()
 
 	t1 := ts.CreateTask(&Task{SrcIP: "127.0.0.1", FilePath: "/data2/A.mpg",
 		FileName: "A.mpg", SrcAddr: "127.0.0.1:8081", DstAddr: "127.0.0.1:18081"})
@@ -481,6 +483,13 @@ func Test_cleanTask(t *testing.T) {
 	assert.Equal(t, 3, len(ts.TaskMap))
 	assert.NotContains(t, ts.TaskMap, t1.ID)
 	assert.NotContains(t, ts.TaskMap, t2.ID)
+	// 삭제된 DONE task 는 기록으로 남음
+	rl, err := tskr.history.Find(HistoryFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rl))
+	for _, tr := range rl {
+		assert.Equal(t, DONE, tr.Status)
+	}
 
 	(*tskr.DstServers)[2].Status = NOTOK
 	tskr.cleanTask(ts.GetTaskList())
@@ -488,13 +497,19 @@ func Test_cleanTask(t *testing.T) {
 	// 따라서 t3(dest가 127.0.0.3:8080인 task) 삭제됨
 	assert.Equal(t, 2, len(ts.TaskMap))
 	assert.NotContains(t, ts.TaskMap, t3.ID)
+	// 끝나지 않은 task 는 기록으로 남지 않음
+	rl, err = tskr.history.Find(HistoryFilter{FileName: "C.mpg"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(rl))
 
 	tskr.SetTaskTimeout(time.Second * 1)
 	time.Sleep(time.Second * 2)
 	tskr.cleanTask(ts.GetTaskList())
 	// 2개 중 2개의 task 가 timeout으로 삭제됨
 	assert.Equal(t, 0, len(ts.TaskMap))
-
+	rl, err = tskr.history.Find(HistoryFilter{Status: TIMEOUT})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rl))
 }
 
 func Test_getAllHostStatusAndcleanTask(t *testing.T) {
@@ -665,35 +680,35 @@ func Test_setSelected(t *testing.T) {
 	tskr.tasks = ts
 	defer tskr.tasks.Release()
 
-	// Task에 사용 중이지 않는 src server의 selected 상태는 false로 바뀜
-	assert.Equal(t, false, (*tskr.SrcServers)[0].selected)
+	// Task에 사용 중이지 않는 src server의 selected 개수는 0으로 바뀜
+	assert.Equal(t, 0, (*tskr.SrcServers)[0].selected)
 
-	// Task에 사용 중이지 않는 dest server의 selected 상태는 false로 바뀜
-	assert.Equal(t, false, (*tskr.DstServers)[0].selected)
-	assert.Equal(t, false, (*tskr.DstServers)[1].selected)
-	assert.Equal(t, false, (*tskr.DstServers)[2].selected)
-	assert.Equal(t, false, (*tskr.DstServers)[3].selected)
-	assert.Equal(t, false, (*tskr.DstServers)[4].selected)
+	// Task에 사용 중이지 않는 dest server의 selected 개수는 0으로 바뀜
+	assert.Equal(t, 0, (*tskr.DstServers)[0].selected)
+	assert.Equal(t, 0, (*tskr.DstServers)[1].selected)
+	assert.Equal(t, 0, (*tskr.DstServers)[2].selected)
+	assert.Equal(t, 0, (*tskr.DstServers)[3].selected)
+	assert.Equal(t, 0, (*tskr.DstServers)[4].selected)
 
-	// Task에 사용 중이지 않는 src server의 selected 상태는 true 에서도
-	(*tskr.SrcServers)[0].selected = true
+	// Task에 사용 중이지 않는 src server의 selected 개수가 1 이어도
+	(*tskr.SrcServers)[0].selected = 1
 	tskr.SrcServers.setSelected(tskr.tasks.GetTaskList())
-	// Task에 사용 중이지 않는 src server의 selected 상태는 false로 바뀜
-	assert.Equal(t, false, (*tskr.SrcServers)[0].selected)
-
-	// Task에 사용 중이지 않는 dest server의 selected 상태는 true 에서도
-	(*tskr.DstServers)[0].selected = true
-	(*tskr.DstServers)[1].selected = true
-	(*tskr.DstServers)[2].selected = true
-	(*tskr.DstServers)[3].selected = true
-	(*tskr.DstServers)[4].selected = true
+	// Task에 사용 중이지 않는 src server의 selected 개수는 0으로 바뀜
+	assert.Equal(t, 0, (*tskr.SrcServers)[0].selected)
+
+	// Task에 사용 중이지 않는 dest server의 selected 개수가 1 이어도
+	(*tskr.DstServers)[0].selected = 1
+	(*tskr.DstServers)[1].selected = 1
+	(*tskr.DstServers)[2].selected = 1
+	(*tskr.DstServers)[3].selected = 1
+	(*tskr.DstServers)[4].selected = 1
 	tskr.DstServers.setSelected(tskr.tasks.GetTaskList())
-	// Task에 사용 중이지 않는 dest server의 selected 상태는 false로 바뀜
-	assert.Equal(t, false, (*tskr.DstServers)[0].selected)
-	assert.Equal(t, false, (*tskr.DstServers)[1].selected)
-	assert.Equal(t, false, (*tskr.DstServers)[2].selected)
-	assert.Equal(t, false, (*tskr.DstServers)[3].selected)
-	assert.Equal(t, false, (*tskr.DstServers)[4].selected)
+	// Task에 사용 중이지 않는 dest server의 selected 개수는 0으로 바뀜
+	assert.Equal(t, 0, (*tskr.DstServers)[0].selected)
+	assert.Equal(t, 0, (*tskr.DstServers)[1].selected)
+	assert.Equal(t, 0, (*tskr.DstServers)[2].selected)
+	assert.Equal(t, 0, (*tskr.DstServers)[3].selected)
+	assert.Equal(t, 0, (*tskr.DstServers)[4].selected)
 
 	t1 := ts.CreateTask(&Task{SrcIP: "127.0.0.1", FilePath: "/data2/A.mpg",
 		FileName: "A.mpg", SrcAddr: "127.0.0.1:8081", DstAddr: "127.0.0.1:18081"})
@@ -711,23 +726,23 @@ func Test_setSelected(t *testing.T) {
 	t.Log(t4)
 	t.Log(t5)
 
-	(*tskr.SrcServers)[0].selected = false
+	(*tskr.SrcServers)[0].selected = 0
 	tskr.SrcServers.setSelected(tskr.tasks.GetTaskList())
-	// Task에 사용 중이면, src server의 selected 상태가 true 로 바뀜
-	assert.Equal(t, true, (*tskr.SrcServers)[0].selected)
-
-	(*tskr.DstServers)[0].selected = false
-	(*tskr.DstServers)[1].selected = false
-	(*tskr.DstServers)[2].selected = false
-	(*tskr.DstServers)[3].selected = false
-	(*tskr.DstServers)[4].selected = false
+	// 5 개의 Task에 사용 중이면, src server의 selected 개수가 5 로 바뀜
+	assert.Equal(t, 5, (*tskr.SrcServers)[0].selected)
+
+	(*tskr.DstServers)[0].selected = 0
+	(*tskr.DstServers)[1].selected = 0
+	(*tskr.DstServers)[2].selected = 0
+	(*tskr.DstServers)[3].selected = 0
+	(*tskr.DstServers)[4].selected = 0
 	tskr.DstServers.setSelected(tskr.tasks.GetTaskList())
-	// Task에 사용 중이면 dest server의 selected 상태는 true로 바뀜
-	assert.Equal(t, true, (*tskr.DstServers)[0].selected)
-	assert.Equal(t, true, (*tskr.DstServers)[1].selected)
-	assert.Equal(t, true, (*tskr.DstServers)[2].selected)
-	assert.Equal(t, true, (*tskr.DstServers)[3].selected)
-	assert.Equal(t, true, (*tskr.DstServers)[4].selected)
+	// Task에 사용 중이면 dest server의 selected 개수가 1 로 바뀜
+	assert.Equal(t, 1, (*tskr.DstServers)[0].selected)
+	assert.Equal(t, 1, (*tskr.DstServers)[1].selected)
+	assert.Equal(t, 1, (*tskr.DstServers)[2].selected)
+	assert.Equal(t, 1, (*tskr.DstServers)[3].selected)
+	assert.Equal(t, 1, (*tskr.DstServers)[4].selected)
 
 }
 
@@ -748,8 +763,8 @@ func Test_getSelectableCount(t *testing.T) {
 	n = tskr.SrcServers.getSelectableCount()
 	assert.Equal(t, 0, n)
 
-	(*tskr.SrcServers)[0].Status = OK     // 127.0.0.1:8081
-	(*tskr.SrcServers)[0].selected = true // 127.0.0.1:8081
+	(*tskr.SrcServers)[0].Status = OK  // 127.0.0.1:8081
+	(*tskr.SrcServers)[0].selected = 1 // 127.0.0.1:8081
 	n = tskr.SrcServers.getSelectableCount()
 	assert.Equal(t, 0, n)
 
@@ -783,8 +798,8 @@ func Test_getAvailableSrcServerCount(t *testing.T) {
 	n = tskr.getAvailableSrcServerCount(tskr.tasks.GetTaskList())
 	assert.Equal(t, 0, n)
 
-	(*tskr.SrcServers)[0].Status = OK     // 127.0.0.1:8081
-	(*tskr.SrcServers)[0].selected = true // 127.0.0.1:8081
+	(*tskr.SrcServers)[0].Status = OK  // 127.0.0.1:8081
+	(*tskr.SrcServers)[0].selected = 1 // 127.0.0.1:8081
 	// 상태는 ok 이고, selected 상태가 reset 되고,
 	// task 가 만들어지지 않은 상태여서, 사용할 수 있는 src server개수가 1 return
 	n = tskr.getAvailableSrcServerCount(tskr.tasks.GetTaskList())
@@ -817,8 +832,8 @@ func Test_getSelectableList(t *testing.T) {
 	dests = tskr.DstServers.getSelectableList()
 	assert.Equal(t, 4, len(dests))
 
-	(*tskr.DstServers)[0].Status = OK     // 127.0.0.1:8081
-	(*tskr.DstServers)[0].selected = true // 127.0.0.1:8081
+	(*tskr.DstServers)[0].Status = OK  // 127.0.0.1:8081
+	(*tskr.DstServers)[0].selected = 1 // 127.0.0.1:8081
 	// selected 가 하나 있으므로, 4 return
 	dests = tskr.DstServers.getSelectableList()
 	assert.Equal(t, 4, len(dests))
@@ -842,10 +857,10 @@ func Test_getSelectableList(t *testing.T) {
 	assert.Equal(t, 2, len(dests))
 	assert.Contains(t, dests, DstHost{
 		common.Host{IP: "127.0.0.3", Port: 18083, Addr: "127.0.0.3:18083"},
-		false, OK})
+		0, 0, OK, false})
 	assert.Contains(t, dests, DstHost{
 		common.Host{IP: "127.0.0.4", Port: 18084, Addr: "127.0.0.4:18084"},
-		false, OK})
+		0, 0, OK, false})
 
 	t3 := ts.CreateTask(&Task{SrcIP: "127.0.0.1", FilePath: "/data2/C.mpg",
 		FileName: "C.mpg", SrcAddr: "127.0.0.1:8081", DstAddr: "127.0.0.3:18083"})
@@ -885,8 +900,8 @@ func Test_getAvailableDstServerList(t *testing.T) {
 	dests = tskr.getAvailableDstServerList(tskr.tasks.GetTaskList())
 	assert.Equal(t, 4, len(dests))
 
-	(*tskr.DstServers)[0].Status = OK     // 127.0.0.1:8081
-	(*tskr.DstServers)[0].selected = true // 127.0.0.1:8081
+	(*tskr.DstServers)[0].Status = OK  // 127.0.0.1:8081
+	(*tskr.DstServers)[0].selected = 1 // 127.0.0.1:8081
 	// selected 가 하나있으나,
 	// getAvailableDstServerList 함수를 호출하면, selected 상태가 task list 검사해서 update됨
 	// task 가 현재 없으므로, available list는 5개가 됨
@@ -909,12 +924,13 @@ func Test_getAvailableDstServerList(t *testing.T) {
 	// 상태가 ok 이고, task 에 사용되지 않는 dest server는 3개 return 해야하지만
 	// 127.0.0.5:18085 의 상태가 NOTOK 이므로, 2개 return
 	assert.Equal(t, 2, len(dests))
-	assert.Contains(t, dests, DstHost{
-		common.Host{IP: "127.0.0.3", Port: 18083, Addr: "127.0.0.3:18083"},
-		false, OK})
-	assert.Contains(t, dests, DstHost{
+	// sort 되어서, 127.0.0.4:18084, 127.0.0.3:18083 순으로 들어있음
+	assert.Equal(t, DstHost{
 		common.Host{IP: "127.0.0.4", Port: 18084, Addr: "127.0.0.4:18084"},
-		false, OK})
+		0, 0, OK, false}, dests[0])
+	assert.Equal(t, DstHost{
+		common.Host{IP: "127.0.0.3", Port: 18083, Addr: "127.0.0.3:18083"},
+		0, 0, OK, false}, dests[1])
 
 	t3 := ts.CreateTask(&Task{SrcIP: "127.0.0.1", FilePath: "/data2/C.mpg",
 		FileName: "C.mpg", SrcAddr: "127.0.0.1:8081", DstAddr: "127.0.0.3:18083"})
@@ -935,74 +951,6 @@ func Test_getAvailableDstServerList(t *testing.T) {
 	assert.Equal(t, 0, len(dests))
 }
 
-func Test_getAvailableDstServerRing(t *testing.T) {
-	tskr := NewTasker()
-	makePresetS1D4(tskr)
-
-	ts := NewTasks()
-	tskr.tasks = ts
-	defer tskr.tasks.Release()
-
-	// task에 사용되지 않고, 상태가 ok인 src server 개수 return
-	dests := tskr.getAvailableDstServerRing(tskr.tasks.GetTaskList())
-	assert.Equal(t, 5, dests.Len())
-
-	// 하나의 상태가 notok 이므로, 5개가 아닌 4 return
-	(*tskr.DstServers)[0].Status = NOTOK
-	dests = tskr.getAvailableDstServerRing(tskr.tasks.GetTaskList())
-	assert.Equal(t, 4, dests.Len())
-
-	(*tskr.DstServers)[0].Status = OK     // 127.0.0.1:8081
-	(*tskr.DstServers)[0].selected = true // 127.0.0.1:8081
-	// selected 가 하나있으나,
-	// getAvailableDstServerList 함수를 호출하면, selected 상태가 task list 검사해서 update됨
-	// task 가 현재 없으므로, available list는 5개가 됨
-	dests = tskr.getAvailableDstServerRing(tskr.tasks.GetTaskList())
-	assert.Equal(t, 5, dests.Len())
-
-	t1 := ts.CreateTask(&Task{SrcIP: "127.0.0.1", FilePath: "/data2/A.mpg",
-		FileName: "A.mpg", SrcAddr: "127.0.0.1:8081", DstAddr: "127.0.0.1:18081"})
-	t.Log(t1)
-	t2 := ts.CreateTask(&Task{SrcIP: "127.0.0.1", FilePath: "/data2/B.mpg",
-		FileName: "B.mpg", SrcAddr: "127.0.0.1:8081", DstAddr: "127.0.0.2:18082"})
-	t.Log(t2)
-
-	// // 127.0.0.5:18085 의 상태가 NOTOK 로 바뀐다면
-	(*tskr.DstServers)[0].Status = NOTOK // 127.0.0.5:18085
-
-	// dest server중, t1, t2에 사용되는 server의 select 상태가 true 바뀜
-	dests = tskr.getAvailableDstServerRing(tskr.tasks.GetTaskList())
-
-	// 상태가 ok 이고, task 에 사용되지 않는 dest server는 3개 return 해야하지만
-	// 127.0.0.5:18085 의 상태가 NOTOK 이므로, 2개 return
-	assert.Equal(t, 2, dests.Len())
-	// sort 되어서, 127.0.0.4:18084, 127.0.0.3:18083 순으로 들어있음
-	assert.Equal(t, dests.Value, DstHost{
-		common.Host{IP: "127.0.0.4", Port: 18084, Addr: "127.0.0.4:18084"},
-		false, OK})
-	assert.Equal(t, dests.Next().Value, DstHost{
-		common.Host{IP: "127.0.0.3", Port: 18083, Addr: "127.0.0.3:18083"},
-		false, OK})
-
-	t3 := ts.CreateTask(&Task{SrcIP: "127.0.0.1", FilePath: "/data2/C.mpg",
-		FileName: "C.mpg", SrcAddr: "127.0.0.1:8081", DstAddr: "127.0.0.3:18083"})
-	t.Log(t3)
-	t4 := ts.CreateTask(&Task{SrcIP: "127.0.0.1", FilePath: "/data2/D.mpg",
-		FileName: "D.mpg", SrcAddr: "127.0.0.1:8081", DstAddr: "127.0.0.4:18084"})
-	t.Log(t4)
-	t5 := ts.CreateTask(&Task{SrcIP: "127.0.0.1", FilePath: "/data2/E.mpg",
-		FileName: "E.mpg", SrcAddr: "127.0.0.1:8081", DstAddr: "127.0.0.5:18085"})
-	t.Log(t5)
-
-	// dest server중, t1, t2에 사용되는 server의 select 상태가 true 바뀜
-	(*tskr.DstServers)[0].Status = OK // 127.0.0.5:18085
-	// 127.0.0.5:18085 의 상태가 OK 로 바뀐 것과 상관없이
-	// 모든 dst server 가 task 에 사용 중이므로,
-	// 상태가 ok 이고, task 에 사용되지 않는 dest server는 없음
-	dests = tskr.getAvailableDstServerRing(tskr.tasks.GetTaskList())
-	assert.Nil(t, dests)
-}
-
 func TestCollectRemoteFileList(t *testing.T) {
 
 	// set dummy http server
@@ -1040,12 +988,36 @@ func TestCollectRemoteFileList(t *testing.T) {
 	dsthosts.Add("127.0.0.1:18883")
 
 	fs := make(FileFreqMap)
-	collectRemoteFileList(dsthosts, fs)
+	collectRemoteFileList(common.NewInventory(0), dsthosts, fs, nil)
 
 	assert.Equal(t, 3, len(fs))
 	assert.Equal(t, 3, int(fs["A.mpg"]))
 	assert.Equal(t, 3, int(fs["B.mpg"]))
 	assert.Equal(t, 3, int(fs["C.mpg"]))
+
+	// 서버별 파일 목록
+	fs = make(FileFreqMap)
+	sfs := make(ServerFileFreqMap)
+	collectRemoteFileList(common.NewInventory(0), dsthosts, fs, sfs)
+
+	assert.Equal(t, 3, len(sfs))
+	assert.Equal(t, 3, len(sfs["127.0.0.1:18882"]))
+	assert.Equal(t, 1, int(sfs["127.0.0.1:18882"]["B.mpg"]))
+}
+
+func Test_getDstsInTasks(t *testing.T) {
+	curtasks := []Task{
+		{FileName: "A.mpg", DstAddr: "127.0.0.1:18081"},
+		{FileName: "A.mpg", DstAddr: "127.0.0.2:18082"},
+		{FileName: "B.mpg", DstAddr: "127.0.0.2:18082"},
+	}
+	dsts := getDstsInTasks(curtasks)
+
+	assert.Equal(t, 2, len(dsts))
+	assert.Equal(t, 2, len(dsts["A.mpg"]))
+	assert.True(t, dsts["A.mpg"]["127.0.0.1:18081"])
+	assert.True(t, dsts["B.mpg"]["127.0.0.2:18082"])
+	assert.False(t, dsts["B.mpg"]["127.0.0.1:18081"])
 }
 
 func Test_getFilesInTasks(t *testing.T) {
@@ -1259,6 +1231,46 @@ func Test_checkForTask(t *testing.T) {
 	assert.Equal(t, false, tskr.checkForTask(allfmm["M.mpg"], taskfilenames, serverfiles))
 }
 
+func Test_checkForTaskWithTargetCopyCount(t *testing.T) {
+	tskr := NewTasker()
+
+	base := "testsourcefolder"
+	tskr.SourcePath.Add(base)
+	createfile(base, "A.mpg")
+	defer deletefile(base, "")
+
+	fm := common.NewFileMetaWith("A.mpg", 1)
+	fm.TargetCopyCount = 3
+	tskr.updateFileMetaForSrcFilePath(fm)
+
+	taskfiles := make(FileFreqMap)
+	serverfiles := make(FileFreqMap)
+	serverfiles["A.mpg"] = 1
+
+	// 최대 copy 수가 1 이면 서버에 있는 파일은 제외
+	assert.Equal(t, false, tskr.checkForTask(fm, taskfiles, serverfiles))
+
+	// 서버에 1 개 있으므로, 2 개 더 배포해야함
+	assert.Nil(t, tskr.SetMaxCopyCount(5))
+	assert.Equal(t, true, tskr.checkForTask(fm, taskfiles, serverfiles))
+	assert.Equal(t, 2, tskr.getCopyCountToMake(fm, taskfiles, serverfiles))
+
+	// task 에 1 개 있으므로, 1 개 더 배포해야함
+	taskfiles["A.mpg"]++
+	assert.Equal(t, true, tskr.checkForTask(fm, taskfiles, serverfiles))
+	assert.Equal(t, 1, tskr.getCopyCountToMake(fm, taskfiles, serverfiles))
+
+	// 서버와 task 에 있는 파일 수가 목표 copy 수가 되면 제외
+	taskfiles["A.mpg"]++
+	assert.Equal(t, false, tskr.checkForTask(fm, taskfiles, serverfiles))
+
+	// hitcount.history 파일에서 구한 서버 수가 목표 copy 수 이상이면 제외
+	fm.ServerCount = 3
+	assert.Equal(t, false, tskr.checkForTask(fm, make(FileFreqMap), make(FileFreqMap)))
+
+	assert.NotNil(t, tskr.SetMaxCopyCount(0))
+}
+
 func Test_getSortedFileMetaListForTask(t *testing.T) {
 	allfmm, _ := makeFileMetaMapABCDEFGHIJKLMNO()
 	rhfiles := []string{"E.mpg", "F.mpg", "J.mpg", "RH1.mpg", "M.mpg", "H.mpg", "O.mpg", "RH2.mpg"}
@@ -1307,9 +1319,9 @@ func Test_selectSourceServer(t *testing.T) {
 	for i := 0; i < 3; i++ {
 		srcs.selectSourceServer()
 	}
-	assert.Equal(t, true, (*srcs)[0].selected)
-	assert.Equal(t, false, (*srcs)[1].selected)
-	assert.Equal(t, false, (*srcs)[2].selected)
+	assert.Equal(t, 1, (*srcs)[0].selected)
+	assert.Equal(t, 0, (*srcs)[1].selected)
+	assert.Equal(t, 0, (*srcs)[2].selected)
 
 	// 1,2 번도 Status 값이 OK 이므로, select 됨
 	(*srcs)[1].Status = OK
@@ -1320,13 +1332,13 @@ func Test_selectSourceServer(t *testing.T) {
 
 	// sort 된 상태에서 "127.0.0.3:18001" 부터 선택됨
 	assert.Equal(t, "127.0.0.3:18001", (*srcs)[0].Addr)
-	assert.Equal(t, true, (*srcs)[0].selected)
+	assert.Equal(t, 1, (*srcs)[0].selected)
 
 	assert.Equal(t, "127.0.0.2:18001", (*srcs)[1].Addr)
-	assert.Equal(t, true, (*srcs)[1].selected)
+	assert.Equal(t, 1, (*srcs)[1].selected)
 
 	assert.Equal(t, "127.0.0.1:18001", (*srcs)[2].Addr)
-	assert.Equal(t, true, (*srcs)[2].selected)
+	assert.Equal(t, 1, (*srcs)[2].selected)
 
 	// 이미 3개의 src 를 모두 사용했으모로 src 가 없어야 한다.
 	_, found := srcs.selectSourceServer()
@@ -1337,6 +1349,82 @@ func Test_selectSourceServer(t *testing.T) {
 	assert.Equal(t, false, found)
 }
 
+func Test_selectSourceServerWithSlots(t *testing.T) {
+	srcs := new(SrcHosts)
+
+	assert.NotNil(t, srcs.AddWithSlots("127.0.0.1:18001", 0))
+	srcs.AddWithSlots("127.0.0.1:18001", 3)
+	srcs.AddWithSlots("127.0.0.2:18001", 1)
+	(*srcs)[0].Status = OK // 127.0.0.2:18001
+	(*srcs)[1].Status = OK // 127.0.0.1:18001
+
+	// 남은 slot 의 합
+	assert.Equal(t, 4, srcs.getSelectableCount())
+
+	// 할당된 개수가 가장 적은 src 부터 선택됨
+	s, _ := srcs.selectSourceServer()
+	assert.Equal(t, "127.0.0.2:18001", s.Addr)
+	s, _ = srcs.selectSourceServer()
+	assert.Equal(t, "127.0.0.1:18001", s.Addr)
+	s, _ = srcs.selectSourceServer()
+	assert.Equal(t, "127.0.0.1:18001", s.Addr)
+	s, _ = srcs.selectSourceServer()
+	assert.Equal(t, "127.0.0.1:18001", s.Addr)
+	_, found := srcs.selectSourceServer()
+	assert.Equal(t, false, found)
+	assert.Equal(t, 0, srcs.getSelectableCount())
+
+	// task list 로 할당 개수를 다시 구함
+	curtasks := []Task{
+		{SrcAddr: "127.0.0.1:18001"},
+		{SrcAddr: "127.0.0.1:18001"},
+	}
+	srcs.setSelected(curtasks)
+	assert.Equal(t, 2, (*srcs)[1].selected)
+	assert.Equal(t, 2, srcs.getSelectableCount())
+}
+
+func Test_getSelectableListWithSlots(t *testing.T) {
+	dsts := NewDstHosts()
+	dsts.AddWithSlots("127.0.0.1:18081", 2)
+	dsts.AddWithSlots("127.0.0.2:18082", 1)
+	(*dsts)[0].Status = OK // 127.0.0.2:18082
+	(*dsts)[1].Status = OK // 127.0.0.1:18081
+
+	curtasks := []Task{
+		{DstAddr: "127.0.0.1:18081"},
+		{DstAddr: "127.0.0.2:18082"},
+	}
+	// 127.0.0.1:18081 은 slot 이 하나 남음
+	dsts.setSelected(curtasks)
+	dl := dsts.getSelectableList()
+	assert.Equal(t, 1, len(dl))
+	assert.Equal(t, "127.0.0.1:18081", dl[0].Addr)
+
+	dsts.selectDestinationServer("127.0.0.1:18081")
+	assert.Equal(t, 0, len(dsts.getSelectableList()))
+
+	// slot 이 0 이면 task 에 할당되지 않은 경우에만 선택되고,
+	// 한 주기에 선택하는 개수는 제한 없음
+	assert.NotNil(t, dsts.AddWithSlots("127.0.0.3:18083", -1))
+	dsts.AddWithSlots("127.0.0.3:18083", 0)
+	dsts.AddWithSlots("127.0.0.4:18084", 0)
+	(*dsts)[0].Status = OK // 127.0.0.4:18084
+	(*dsts)[1].Status = OK // 127.0.0.3:18083
+	curtasks = append(curtasks, Task{DstAddr: "127.0.0.4:18084"})
+	dsts.setSelected(curtasks)
+	dl = dsts.getSelectableList()
+	assert.Equal(t, 2, len(dl))
+	assert.Equal(t, "127.0.0.3:18083", dl[0].Addr)
+	assert.Equal(t, "127.0.0.1:18081", dl[1].Addr)
+
+	dsts.selectDestinationServer("127.0.0.3:18083")
+	dsts.selectDestinationServer("127.0.0.3:18083")
+	dl = dsts.getSelectableList()
+	assert.Equal(t, 2, len(dl))
+	assert.Equal(t, "127.0.0.3:18083", dl[0].Addr)
+}
+
 func Test_runWithInfo(t *testing.T) {
 	tskr := NewTasker()
 	makePresetS4D5(tskr)
@@ -1469,7 +1557,7 @@ func Test_runWithInfo(t *testing.T) {
 	defer deletefile(base, "")
 
 	serverfs := make(FileFreqMap)
-	collectRemoteFileList(tskr.DstServers, serverfs)
+	collectRemoteFileList(common.NewInventory(0), tskr.DstServers, serverfs, nil)
 	assert.Equal(t, 10, len(serverfs))
 	assert.Equal(t, 1, int(serverfs["A.mpg"]))
 	assert.Equal(t, 2, int(serverfs["B.mpg"]))
@@ -1804,6 +1892,325 @@ func Test_runWithInfo(t *testing.T) {
 	assertTask(t, tskr.tasks, "M.mpg", s4, d1)
 }
 
+func Test_runWithInfoWithTargetCopyCount(t *testing.T) {
+	tskr := NewTasker()
+	tskr.SetMaxCopyCount(3)
+
+	s1 := "127.0.0.1:8091"
+	s2 := "127.0.0.2:8092"
+	s3 := "127.0.0.3:8093"
+	d1 := "127.0.0.1:18091"
+	d2 := "127.0.0.2:18092"
+	d3 := "127.0.0.3:18093"
+	du := common.DiskUsage{
+		TotalSize: 1000, UsedSize: 600,
+		FreeSize: 400, AvailSize: 400, UsedPercent: 60,
+	}
+	for _, addr := range []string{s1, s2, s3} {
+		tskr.SrcServers.Add(addr)
+		s := cfw(addr, du, []string{})
+		s.Start()
+		defer s.Close()
+	}
+	dfiles := map[string][]string{d1: {"X.mpg"}, d2: {}, d3: {}}
+	for _, addr := range []string{d1, d2, d3} {
+		tskr.DstServers.Add(addr)
+		s := cfw(addr, du, dfiles[addr])
+		s.Start()
+		defer s.Close()
+	}
+
+	base := "testsourcefolder"
+	tskr.SourcePath.Add(base)
+	createfile(base, "X.mpg")
+	createfile(base, "Y.mpg")
+	defer deletefile(base, "")
+
+	// X : 목표 copy 수 5, 최대 copy 수 3 으로 제한됨, d1 에 이미 있음
+	// Y : 목표 copy 수 2
+	allfmm := make(FileMetaPtrMap)
+	allfmm["X.mpg"] = common.NewFileMetaWith("X.mpg", 1)
+	allfmm["X.mpg"].TargetCopyCount = 5
+	allfmm["Y.mpg"] = common.NewFileMetaWith("Y.mpg", 2)
+	allfmm["Y.mpg"].TargetCopyCount = 2
+
+	defer heartbeater.Release()
+	for _, addr := range []string{s1, s2, s3, d1, d2, d3} {
+		heartbeater.Add(addr)
+	}
+	heartbeater.Heartbeat()
+
+	ts := NewTasks()
+	tskr.tasks = ts
+	defer tskr.tasks.Release()
+
+	tskr.runWithInfo(allfmm, map[string]int{})
+
+	// X 는 d1 을 제외한 d2, d3 에 배포되고,
+	// Y 는 남은 src 서버 1 개로 1 개만 배포됨
+	tl := tskr.tasks.GetTaskList()
+	assert.Equal(t, 3, len(tl))
+	xdsts := make(map[string]bool)
+	ycnt := 0
+	for _, task := range tl {
+		switch task.FileName {
+		case "X.mpg":
+			xdsts[task.DstAddr] = true
+		case "Y.mpg":
+			ycnt++
+		}
+	}
+	assert.Equal(t, map[string]bool{d2: true, d3: true}, xdsts)
+	assert.Equal(t, 1, ycnt)
+}
+
+// src, dst 서버의 남은 slot 을 모두 채워서 task 를 만듬
+func Test_runWithInfoWithSlots(t *testing.T) {
+	tskr := NewTasker()
+
+	s1 := "127.0.0.1:8095"
+	d1 := "127.0.0.1:18095"
+	d2 := "127.0.0.2:18096"
+	du := common.DiskUsage{
+		TotalSize: 1000, UsedSize: 600,
+		FreeSize: 400, AvailSize: 400, UsedPercent: 60,
+	}
+	tskr.SrcServers.AddWithSlots(s1, 4)
+	tskr.DstServers.AddWithSlots(d1, 2)
+	tskr.DstServers.AddWithSlots(d2, 1)
+	for _, addr := range []string{s1, d1, d2} {
+		s := cfw(addr, du, []string{})
+		s.Start()
+		defer s.Close()
+	}
+
+	base := "testsourcefolder"
+	tskr.SourcePath.Add(base)
+	allfmm := make(FileMetaPtrMap)
+	for i, fn := range []string{"A.mpg", "B.mpg", "C.mpg", "D.mpg", "E.mpg"} {
+		createfile(base, fn)
+		allfmm[fn] = common.NewFileMetaWith(fn, int32(i+1))
+	}
+	defer deletefile(base, "")
+
+	defer heartbeater.Release()
+	for _, addr := range []string{s1, d1, d2} {
+		heartbeater.Add(addr)
+	}
+	heartbeater.Heartbeat()
+
+	ts := NewTasks()
+	tskr.tasks = ts
+	defer tskr.tasks.Release()
+
+	// d1 에 이미 task 가 하나 있음
+	ts.CreateTask(&Task{FilePath: "/data2/X.mpg", FileName: "X.mpg",
+		SrcAddr: s1, DstAddr: d1})
+
+	tskr.runWithInfo(allfmm, map[string]int{})
+
+	// src slot 은 3 개 남았지만, dst slot 이 2 개만 남아서
+	// 등급이 높은 A, B 만 task 가 만들어짐
+	tl := tskr.tasks.GetTaskList()
+	assert.Equal(t, 3, len(tl))
+	assertTask(t, tskr.tasks, "A.mpg", s1, d2)
+	assertTask(t, tskr.tasks, "B.mpg", s1, d1)
+	_, found := tskr.tasks.FindTaskByFileName("C.mpg")
+	assert.Equal(t, false, found)
+}
+
+// timeout 된 (file, dst) 는 backoff 동안 task 를 다시 만들지 않고,
+// 여러 번 timeout 되면 quarantine 을 지울 때까지 task 를 만들지 않음
+func Test_runWithInfoWithRetryBackoff(t *testing.T) {
+	tskr := NewTasker()
+
+	s1 := "127.0.0.1:8096"
+	d1 := "127.0.0.1:18096"
+	du := common.DiskUsage{
+		TotalSize: 1000, UsedSize: 600,
+		FreeSize: 400, AvailSize: 400, UsedPercent: 60,
+	}
+	tskr.SrcServers.Add(s1)
+	tskr.DstServers.Add(d1)
+	for _, addr := range []string{s1, d1} {
+		s := cfw(addr, du, []string{})
+		s.Start()
+		defer s.Close()
+	}
+
+	base := "testsourcefolder"
+	tskr.SourcePath.Add(base)
+	createfile(base, "A.mpg")
+	defer deletefile(base, "")
+	allfmm := FileMetaPtrMap{"A.mpg": common.NewFileMetaWith("A.mpg", 1)}
+
+	defer heartbeater.Release()
+	for _, addr := range []string{s1, d1} {
+		heartbeater.Add(addr)
+	}
+	heartbeater.Heartbeat()
+
+	ts := NewTasks()
+	tskr.tasks = ts
+	defer tskr.tasks.Release()
+	defer tskr.history.Release()
+	tskr.SetTaskTimeout(time.Minute)
+	tskr.failures.SetBackoff(time.Second, time.Second)
+	tskr.failures.SetQuarantineLimit(2)
+
+	timeout := func() {
+		task, found := ts.FindTaskByFileName("A.mpg")
+		assert.True(t, found)
+		ts.TaskMap[task.ID].Status = TIMEOUT
+		ts.TaskMap[task.ID].Mtime = TaskTime(time.Now().Add(-time.Hour).Unix())
+	}
+	found := func() bool {
+		_, found := ts.FindTaskByFileName("A.mpg")
+		return found
+	}
+
+	tskr.runWithInfo(allfmm, map[string]int{})
+	assert.True(t, found())
+
+	// timeout 되어 지워지고, backoff 동안 다시 만들지 않음
+	timeout()
+	tskr.runWithInfo(allfmm, map[string]int{})
+	assert.False(t, found())
+	fl := tskr.failures.GetList(false)
+	assert.Equal(t, 1, len(fl))
+	assert.Equal(t, 1, fl[0].Count)
+
+	// backoff 가 지나면 다시 만듬
+	time.Sleep(1100 * time.Millisecond)
+	tskr.runWithInfo(allfmm, map[string]int{})
+	assert.True(t, found())
+
+	// 두 번째 timeout 으로 quarantine 되어 backoff 가 지나도 만들지 않음
+	timeout()
+	tskr.runWithInfo(allfmm, map[string]int{})
+	time.Sleep(1100 * time.Millisecond)
+	tskr.runWithInfo(allfmm, map[string]int{})
+	assert.False(t, found())
+	assert.Equal(t, 1, len(tskr.failures.GetList(true)))
+
+	// quarantine 을 지우면 다시 만듬
+	assert.Equal(t, 1, tskr.failures.Clear("A.mpg", d1))
+	tskr.runWithInfo(allfmm, map[string]int{})
+	assert.True(t, found())
+
+	// done 이 되면 timeout 기록이 지워짐
+	task, _ := ts.FindTaskByFileName("A.mpg")
+	ts.UpdateStatus(task.ID, DONE)
+	tskr.failures.fail("A.mpg", d1, time.Now().Add(-time.Hour))
+	tskr.runWithInfo(allfmm, map[string]int{})
+	assert.Equal(t, 0, len(tskr.failures.GetList(false)))
+}
+
+// plan 모드에서는 task 를 만들거나 지우지 않고, 만들어질 task 목록만 구함
+func TestPlan(t *testing.T) {
+	tskr := NewTasker()
+
+	s1 := "127.0.0.1:8097"
+	d1 := "127.0.0.1:18097"
+	d2 := "127.0.0.2:18098"
+	du := common.DiskUsage{
+		TotalSize: 1000, UsedSize: 600,
+		FreeSize: 400, AvailSize: 400, UsedPercent: 60,
+	}
+	tskr.SrcServers.AddWithSlots(s1, 4)
+	tskr.DstServers.AddWithSlots(d1, 2)
+	tskr.DstServers.AddWithSlots(d2, 1)
+	for _, addr := range []string{s1, d1, d2} {
+		s := cfw(addr, du, []string{})
+		s.Start()
+		defer s.Close()
+	}
+
+	base := "testsourcefolder"
+	tskr.SourcePath.Add(base)
+	allfmm := make(FileMetaPtrMap)
+	for i, fn := range []string{"A.mpg", "B.mpg", "C.mpg"} {
+		createfile(base, fn)
+		allfmm[fn] = common.NewFileMetaWith(fn, int32(i+1))
+	}
+	defer deletefile(base, "")
+
+	defer heartbeater.Release()
+	for _, addr := range []string{s1, d1, d2} {
+		heartbeater.Add(addr)
+	}
+	heartbeater.Heartbeat()
+
+	ts := NewTasks()
+	tskr.tasks = ts
+	defer tskr.tasks.Release()
+
+	jdir := "testdecision"
+	defer deletefile(jdir, "")
+	assert.Nil(t, decision.Open(jdir, 1024*1024, 1))
+	defer decision.Close()
+
+	// d1 에 task 가 하나 있고,
+	// d2 에 done task 가 하나 있음, 실제 실행하면 정리됨
+	ts.CreateTask(&Task{FilePath: "/data2/X.mpg", FileName: "X.mpg",
+		SrcAddr: s1, DstAddr: d1})
+	done := ts.CreateTask(&Task{FilePath: "/data2/Y.mpg", FileName: "Y.mpg",
+		SrcAddr: s1, DstAddr: d2})
+	ts.UpdateStatus(done.ID, DONE)
+
+	planned := tskr.Plan(allfmm, map[string]int{})
+
+	// dst slot 이 2 개 남아서 등급이 높은 A, B 만 task 가 만들어질 예정
+	assert.Equal(t, 2, len(planned))
+	assert.Equal(t, "A.mpg", planned[0].FileName)
+	assert.Equal(t, s1, planned[0].SrcAddr)
+	assert.Equal(t, d2, planned[0].DstAddr)
+	assert.Equal(t, "grade(1), copies(0/1)", planned[0].Reason)
+	assert.Equal(t, "B.mpg", planned[1].FileName)
+	assert.Equal(t, s1, planned[1].SrcAddr)
+	assert.Equal(t, d1, planned[1].DstAddr)
+	assert.Equal(t, "grade(2), copies(0/1)", planned[1].Reason)
+
+	// task, file meta, 서버 정보는 바뀌지 않음
+	assert.Equal(t, 2, len(tskr.tasks.GetTaskList()))
+	_, found := tskr.tasks.FindTaskByID(done.ID)
+	assert.Equal(t, true, found)
+	assert.Equal(t, "", allfmm["A.mpg"].SrcFilePath)
+	for _, src := range *tskr.SrcServers {
+		assert.Equal(t, 0, src.selected)
+	}
+	for _, dst := range *tskr.DstServers {
+		assert.Equal(t, 0, dst.selected)
+	}
+	assert.False(t, tskr.dryRun)
+
+	// plan 모드에서는 결정 기록을 남기지 않음
+	dl, err := decision.Find("A.mpg")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(dl))
+
+	// 실제 실행하면 plan 대로 task 가 만들어짐
+	tskr.runWithInfo(allfmm, map[string]int{})
+	tl := tskr.tasks.GetTaskList()
+	assert.Equal(t, 3, len(tl))
+	assertTask(t, tskr.tasks, "A.mpg", s1, d2)
+	assertTask(t, tskr.tasks, "B.mpg", s1, d1)
+
+	// task 를 만든 파일과 만들지 못한 파일의 결정 기록
+	dl, err = decision.Find("A.mpg")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(dl))
+	assert.Equal(t, "tasker", dl[0].Module)
+	assert.Equal(t, decision.TASK, dl[0].Action)
+	assert.Equal(t, decision.Grade, dl[0].Reason)
+	assert.Equal(t, d2, dl[0].Server)
+	dl, err = decision.Find("C.mpg")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(dl))
+	assert.Equal(t, decision.SKIP, dl[0].Action)
+	assert.Equal(t, decision.NoDstServerForPlacement, dl[0].Reason)
+}
+
 func Test_run(t *testing.T) {
 	tskr := NewTasker()
 	makePresetS4D5(tskr)
@@ -1937,7 +2344,7 @@ func Test_run(t *testing.T) {
 	defer deletefile(base, "")
 
 	serverfs := make(FileFreqMap)
-	collectRemoteFileList(tskr.DstServers, serverfs)
+	collectRemoteFileList(common.NewInventory(0), tskr.DstServers, serverfs, nil)
 	assert.Equal(t, 10, len(serverfs))
 	assert.Equal(t, 1, int(serverfs["A.mpg"]))
 	assert.Equal(t, 2, int(serverfs["B.mpg"]))
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/castisdev/cfm/tasker"
+)
+
+// tasksCommand :
+//
+// task repository 를 JSON lines 로 export, import 하는 subcommand
+//
+// 	cfm tasks export [file] : file 이 없으면 stdout 으로 씀
+//
+// 	cfm tasks import [file] : file 이 없으면 stdin 에서 읽음
+//
+// 설정 파일의 tasker.store_backend, tasker.store_path 의 task store 를 사용,
+// cfm 이 실행 중이면 store 를 함께 사용하게 되므로 멈춘 후 실행해야 함
+//
+// 성공하면 0, 실패하면 1 반환
+func tasksCommand(cfgFile string, args []string) int {
+	if len(args) < 1 || len(args) > 2 ||
+		(args[0] != "export" && args[0] != "import") {
+		fmt.Fprintln(os.Stderr, "usage: cfm tasks export|import [file]")
+		return 1
+	}
+	file := ""
+	if len(args) == 2 {
+		file = args[1]
+	}
+
+	c, err := ReadConfig(cfgFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read config, error(%s)\n", err.Error())
+		return 1
+	}
+	store, err := tasker.NewTaskStore(
+		tasker.ToStoreBackend(c.Tasker.StoreBackend), c.Tasker.StorePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create task store, error(%s)\n", err.Error())
+		return 1
+	}
+	tasks := tasker.NewTasksWithStore(store)
+	tasks.LoadTasks()
+	defer tasks.Close()
+
+	var n int
+	if args[0] == "export" {
+		n, err = exportTasks(tasks, file)
+	} else {
+		n, err = importTasks(tasks, file)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to %s tasks, error(%s)\n", args[0], err.Error())
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "%sed tasks(%d)\n", args[0], n)
+	return 0
+}
+
+func exportTasks(tasks *tasker.Tasks, file string) (int, error) {
+	var w io.Writer = os.Stdout
+	if file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		w = f
+	}
+	return tasks.Export(w)
+}
+
+func importTasks(tasks *tasker.Tasks, file string) (int, error) {
+	var r io.Reader = os.Stdin
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return tasks.Import(r)
+}